@@ -0,0 +1,110 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/immesys/bw2bc/cmd/utils"
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/dnsdisc"
+)
+
+// dnsTree implements the dnstree subcommand, which creates and signs a DNS node
+// list from a set of nodes and writes out the TXT records to publish.
+func dnsTree(args []string) {
+	var (
+		flags       = flag.NewFlagSet("dnstree", flag.ExitOnError)
+		nodesFile   = flags.String("nodes", "", "JSON file containing the enode URLs to publish")
+		links       = flags.String("links", "", "comma separated URLs of other trees to link to")
+		domain      = flags.String("domain", "", "domain the tree will be published at")
+		seq         = flags.Uint("seq", 1, "sequence number of the tree")
+		nodeKeyFile = flags.String("signkey", "", "private key filename used to sign the tree")
+		nodeKeyHex  = flags.String("signkeyhex", "", "private key as hex used to sign the tree (for testing)")
+		output      = flags.String("out", "", "file to write the TXT records to as JSON (default stdout)")
+
+		signKey *ecdsa.PrivateKey
+		err     error
+	)
+	flags.Parse(args)
+
+	switch {
+	case *domain == "":
+		utils.Fatalf("Use -domain to specify the domain of the tree")
+	case *nodeKeyFile == "" && *nodeKeyHex == "":
+		utils.Fatalf("Use -signkey or -signkeyhex to specify a signing key")
+	case *nodeKeyFile != "" && *nodeKeyHex != "":
+		utils.Fatalf("Options -signkey and -signkeyhex are mutually exclusive")
+	case *nodeKeyFile != "":
+		if signKey, err = crypto.LoadECDSA(*nodeKeyFile); err != nil {
+			utils.Fatalf("-signkey: %v", err)
+		}
+	case *nodeKeyHex != "":
+		if signKey, err = crypto.HexToECDSA(*nodeKeyHex); err != nil {
+			utils.Fatalf("-signkeyhex: %v", err)
+		}
+	}
+	// Assemble the node set and the tree links
+	var nodes []*discover.Node
+	if *nodesFile != "" {
+		blob, err := ioutil.ReadFile(*nodesFile)
+		if err != nil {
+			utils.Fatalf("-nodes: %v", err)
+		}
+		var urls []string
+		if err := json.Unmarshal(blob, &urls); err != nil {
+			utils.Fatalf("-nodes: %v", err)
+		}
+		for _, url := range urls {
+			node, err := discover.ParseNode(url)
+			if err != nil {
+				utils.Fatalf("-nodes: invalid node %q: %v", url, err)
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	var linkURLs []string
+	if *links != "" {
+		linkURLs = strings.Split(*links, ",")
+	}
+	// Create and sign the tree, and write out its records
+	tree, err := dnsdisc.MakeTree(*seq, nodes, linkURLs)
+	if err != nil {
+		utils.Fatalf("Failed to create tree: %v", err)
+	}
+	url, err := tree.Sign(signKey, *domain)
+	if err != nil {
+		utils.Fatalf("Failed to sign tree: %v", err)
+	}
+	records, err := json.MarshalIndent(tree.ToTXT(*domain), "", "  ")
+	if err != nil {
+		utils.Fatalf("Failed to encode records: %v", err)
+	}
+	if *output == "" {
+		fmt.Println(string(records))
+	} else if err := ioutil.WriteFile(*output, append(records, '\n'), 0644); err != nil {
+		utils.Fatalf("-out: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Tree URL: %s (%d nodes, %d links)\n", url, len(nodes), len(linkURLs))
+}
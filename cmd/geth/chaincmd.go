@@ -29,6 +29,7 @@ import (
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/console"
 	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/chainarchive"
 	"github.com/immesys/bw2bc/core/state"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/ethdb"
@@ -38,6 +39,26 @@ import (
 	"gopkg.in/urfave/cli.v1"
 )
 
+var (
+	archiveFlag = cli.BoolFlag{
+		Name:  "archive",
+		Usage: "Write a segmented, checksummed chain archive instead of raw RLP",
+	}
+	archiveAppendFlag = cli.BoolFlag{
+		Name:  "append",
+		Usage: "Append the block range to an existing chain archive (implies --archive)",
+	}
+	archiveCompressionFlag = cli.StringFlag{
+		Name:  "compression",
+		Usage: "Chain archive segment compression (snappy, gzip, none)",
+		Value: "snappy",
+	}
+	archiveVerifyOnlyFlag = cli.BoolFlag{
+		Name:  "verify-only",
+		Usage: "Verify the integrity of chain archives without importing them",
+	}
+)
+
 var (
 	initCommand = cli.Command{
 		Action:    utils.MigrateFlags(initGenesis),
@@ -65,14 +86,20 @@ It expects the genesis file as argument.`,
 			utils.DataDirFlag,
 			utils.CacheFlag,
 			utils.LightModeFlag,
+			archiveVerifyOnlyFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
 The import command imports blocks from an RLP-encoded form. The form can be one file
-with several RLP-encoded blocks, or several files can be used.
+with several RLP-encoded blocks, or several files can be used. Files may also be
+gzip compressed or chain archives created with "export --archive"; the format is
+detected from the file contents.
 
 If only one file is used, import error will result in failure. If several files are used, 
-processing will proceed even if an individual RLP-file import failure occurs.`,
+processing will proceed even if an individual RLP-file import failure occurs.
+
+With --verify-only, chain archives are checked for checksum, linkage and body
+integrity without touching the database.`,
 	}
 	exportCommand = cli.Command{
 		Action:    utils.MigrateFlags(exportChain),
@@ -83,13 +110,22 @@ processing will proceed even if an individual RLP-file import failure occurs.`,
 			utils.DataDirFlag,
 			utils.CacheFlag,
 			utils.LightModeFlag,
+			archiveFlag,
+			archiveAppendFlag,
+			archiveCompressionFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
 Requires a first argument of the file to write to.
 Optional second and third arguments control the first and
 last block to write. In this mode, the file will be appended
-if already existing.`,
+if already existing.
+
+With --archive, the blocks are written into a chain archive: a file carrying
+the genesis hash and network id of the chain, split into compressed segments
+with keccak256 checksums. With --append, the range is added to the end of an
+existing archive; if no range is given, the archive is extended from its last
+block up to the current head.`,
 	}
 	removedbCommand = cli.Command{
 		Action:    utils.MigrateFlags(removeDB),
@@ -159,7 +195,10 @@ func importChain(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
 		utils.Fatalf("This command requires an argument.")
 	}
-	stack := makeFullNode(ctx)
+	if ctx.GlobalBool(archiveVerifyOnlyFlag.Name) {
+		return verifyArchives(ctx)
+	}
+	stack, cfg := makeConfigNode(ctx)
 	chain, chainDb := utils.MakeChain(ctx, stack)
 	defer chainDb.Close()
 
@@ -182,12 +221,12 @@ func importChain(ctx *cli.Context) error {
 	start := time.Now()
 
 	if len(ctx.Args()) == 1 {
-		if err := utils.ImportChain(chain, ctx.Args().First()); err != nil {
+		if err := utils.ImportChain(chain, cfg.Eth.NetworkId, ctx.Args().First()); err != nil {
 			utils.Fatalf("Import error: %v", err)
 		}
 	} else {
 		for _, arg := range ctx.Args() {
-			if err := utils.ImportChain(chain, arg); err != nil {
+			if err := utils.ImportChain(chain, cfg.Eth.NetworkId, arg); err != nil {
 				log.Error("Import error", "file", arg, "err", err)
 			}
 		}
@@ -236,10 +275,38 @@ func importChain(ctx *cli.Context) error {
 	return nil
 }
 
+// verifyArchives checks the integrity of every chain archive passed on the
+// command line, without opening the chain database.
+func verifyArchives(ctx *cli.Context) error {
+	failed := false
+	for _, arg := range ctx.Args() {
+		start := time.Now()
+		manifest, err := utils.VerifyArchive(arg)
+		if err != nil {
+			log.Error("Archive verification failed", "file", arg, "err", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: OK\n", arg)
+		fmt.Printf("  genesis:     %x\n", manifest.GenesisHash)
+		fmt.Printf("  network id:  %d\n", manifest.NetworkId)
+		fmt.Printf("  blocks:      #%d - #%d (%d)\n", manifest.First, manifest.Last, manifest.Blocks())
+		fmt.Printf("  segments:    %d (%v)\n", len(manifest.Segments), manifest.Compression)
+		fmt.Printf("  verified in: %v\n\n", time.Since(start))
+	}
+	if failed {
+		utils.Fatalf("Verification failed")
+	}
+	return nil
+}
+
 func exportChain(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
 		utils.Fatalf("This command requires an argument.")
 	}
+	if ctx.GlobalBool(archiveFlag.Name) || ctx.GlobalBool(archiveAppendFlag.Name) {
+		return exportArchive(ctx)
+	}
 	stack := makeFullNode(ctx)
 	chain, _ := utils.MakeChain(ctx, stack)
 	start := time.Now()
@@ -249,16 +316,8 @@ func exportChain(ctx *cli.Context) error {
 	if len(ctx.Args()) < 3 {
 		err = utils.ExportChain(chain, fp)
 	} else {
-		// This can be improved to allow for numbers larger than 9223372036854775807
-		first, ferr := strconv.ParseInt(ctx.Args().Get(1), 10, 64)
-		last, lerr := strconv.ParseInt(ctx.Args().Get(2), 10, 64)
-		if ferr != nil || lerr != nil {
-			utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
-		}
-		if first < 0 || last < 0 {
-			utils.Fatalf("Export error: block number must be greater than 0\n")
-		}
-		err = utils.ExportAppendChain(chain, fp, uint64(first), uint64(last))
+		first, last := parseExportRange(ctx)
+		err = utils.ExportAppendChain(chain, fp, first, last)
 	}
 
 	if err != nil {
@@ -268,6 +327,69 @@ func exportChain(ctx *cli.Context) error {
 	return nil
 }
 
+// exportArchive writes (or extends) a chain archive with a range of the local
+// canonical chain.
+func exportArchive(ctx *cli.Context) error {
+	compression, err := chainarchive.ParseCompression(ctx.GlobalString(archiveCompressionFlag.Name))
+	if err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	stack, cfg := makeConfigNode(ctx)
+	chain, chainDb := utils.MakeChain(ctx, stack)
+	defer chainDb.Close()
+
+	var (
+		fp        = ctx.Args().First()
+		appending = ctx.GlobalBool(archiveAppendFlag.Name)
+		first     = uint64(0)
+		last      = chain.CurrentBlock().NumberU64()
+	)
+	switch {
+	case len(ctx.Args()) >= 3:
+		first, last = parseExportRange(ctx)
+	case appending && common.FileExist(fp):
+		// No explicit range, continue the archive up to the current head
+		archive, err := chainarchive.Open(fp)
+		if err != nil {
+			utils.Fatalf("Export error: %v\n", err)
+		}
+		if manifest := archive.Manifest(); len(manifest.Segments) > 0 {
+			first = manifest.Last + 1
+		}
+		archive.Close()
+
+		if first > last {
+			fmt.Printf("Archive already up to date with head #%d\n", last)
+			return nil
+		}
+	}
+	header := chainarchive.Header{
+		NetworkId:   cfg.Eth.NetworkId,
+		Compression: compression,
+	}
+	start := time.Now()
+	if err := utils.ExportArchive(chain, fp, header, first, last, appending); err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	fmt.Printf("Export done in %v", time.Since(start))
+	return nil
+}
+
+// parseExportRange parses the optional first and last block arguments of the
+// export command.
+func parseExportRange(ctx *cli.Context) (uint64, uint64) {
+	// This can be improved to allow for numbers larger than 9223372036854775807
+	first, ferr := strconv.ParseInt(ctx.Args().Get(1), 10, 64)
+	last, lerr := strconv.ParseInt(ctx.Args().Get(2), 10, 64)
+	if ferr != nil || lerr != nil {
+		utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
+	}
+	if first < 0 || last < 0 {
+		utils.Fatalf("Export error: block number must be greater than 0\n")
+	}
+	return uint64(first), uint64(last)
+}
+
 func removeDB(ctx *cli.Context) error {
 	stack, _ := makeConfigNode(ctx)
 
@@ -67,6 +67,8 @@ var (
 		utils.EthashDatasetsInMemoryFlag,
 		utils.EthashDatasetsOnDiskFlag,
 		utils.TxPoolNoLocalsFlag,
+		utils.TxPoolJournalFlag,
+		utils.TxPoolRejournalFlag,
 		utils.TxPoolPriceLimitFlag,
 		utils.TxPoolPriceBumpFlag,
 		utils.TxPoolAccountSlotsFlag,
@@ -82,6 +84,8 @@ var (
 		utils.LightKDFFlag,
 		utils.CacheFlag,
 		utils.TrieCacheGenFlag,
+		utils.SnapshotFlag,
+		utils.TxLookupLimitFlag,
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
 		utils.MaxPendingPeersFlag,
@@ -94,6 +98,18 @@ var (
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
 		utils.NetrestrictFlag,
+		utils.BanDurationFlag,
+		utils.MaxFaultsFlag,
+		utils.InboundRatioFlag,
+		utils.OutboundRatioFlag,
+		utils.SubnetPeersFlag,
+		utils.ReservedPeersFlag,
+		utils.DNSDiscoveryFlag,
+		utils.CapabilitiesFlag,
+		utils.MaxIngressRateFlag,
+		utils.MaxEgressRateFlag,
+		utils.PeerIngressRateFlag,
+		utils.PeerEgressRateFlag,
 		utils.NodeKeyFileFlag,
 		utils.NodeKeyHexFlag,
 		utils.DevModeFlag,
@@ -109,6 +125,14 @@ var (
 		utils.GpoBlocksFlag,
 		utils.GpoPercentileFlag,
 		utils.ExtraDataFlag,
+		utils.MinerGasTargetFlag,
+		utils.MinerGasLimitFlag,
+		utils.MinerRecommitFlag,
+		utils.MinerOrderingFlag,
+		utils.MinerPriorityFlag,
+		utils.MinerSenderGasFlag,
+		utils.StratumAddrFlag,
+		utils.StratumDifficultyFlag,
 		configFileFlag,
 	}
 
@@ -145,6 +169,8 @@ func init() {
 		exportCommand,
 		removedbCommand,
 		dumpCommand,
+		// See statecmd.go:
+		stateCommand,
 		// See monitorcmd.go:
 		monitorCommand,
 		// See accountcmd.go:
@@ -0,0 +1,111 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/immesys/bw2bc/cmd/utils"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	stateCommand = cli.Command{
+		Name:     "state",
+		Usage:    "Export and import state snapshots",
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+State snapshots contain every account, contract code and storage slot of a
+single block, allowing a new node to start from that block instead of syncing
+the chain from its peers.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "export",
+				Usage:     "Export the state of a block into a snapshot file",
+				ArgsUsage: "<blockNum> <filename>",
+				Action:    utils.MigrateFlags(exportState),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+				},
+				Description: `
+    geth state export 1200000 state.snap.gz
+
+writes the given canonical block along with its entire state and the headers
+leading up to it into the file.
+The snapshot is gzip compressed if the file name ends with ".gz".`,
+			},
+			{
+				Name:      "import",
+				Usage:     "Bootstrap the local chain from a snapshot file",
+				ArgsUsage: "<filename>",
+				Action:    utils.MigrateFlags(importState),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+				},
+				Description: `
+    geth state import state.snap.gz
+
+verifies the header chain contained in the snapshot, rebuilds the state trie
+and checks its root against the enclosed block header, then marks the node as
+fast synced to that block. The
+local chain must be empty (i.e. contain only the genesis block).`,
+			},
+		},
+	}
+)
+
+func exportState(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		utils.Fatalf("This command requires a block number and a file name.")
+	}
+	number, err := strconv.ParseUint(ctx.Args().First(), 10, 64)
+	if err != nil {
+		utils.Fatalf("Invalid block number: %v", err)
+	}
+	stack := makeFullNode(ctx)
+	chain, chainDb := utils.MakeChain(ctx, stack)
+	defer chainDb.Close()
+
+	start := time.Now()
+	if err := utils.ExportState(chain, chainDb, ctx.Args().Get(1), number); err != nil {
+		utils.Fatalf("State export error: %v", err)
+	}
+	fmt.Printf("State export done in %v\n", time.Since(start))
+	return nil
+}
+
+func importState(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires a file name.")
+	}
+	stack := makeFullNode(ctx)
+	chain, chainDb := utils.MakeChain(ctx, stack)
+	defer chainDb.Close()
+
+	start := time.Now()
+	if err := utils.ImportState(chain, chainDb, ctx.Args().First()); err != nil {
+		utils.Fatalf("State import error: %v", err)
+	}
+	fmt.Printf("State import done in %v\n", time.Since(start))
+	return nil
+}
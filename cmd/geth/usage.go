@@ -96,6 +96,8 @@ var AppHelpFlagGroups = []flagGroup{
 		Name: "TRANSACTION POOL",
 		Flags: []cli.Flag{
 			utils.TxPoolNoLocalsFlag,
+			utils.TxPoolJournalFlag,
+			utils.TxPoolRejournalFlag,
 			utils.TxPoolPriceLimitFlag,
 			utils.TxPoolPriceBumpFlag,
 			utils.TxPoolAccountSlotsFlag,
@@ -110,6 +112,8 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			utils.CacheFlag,
 			utils.TrieCacheGenFlag,
+			utils.SnapshotFlag,
+			utils.TxLookupLimitFlag,
 		},
 	},
 	{
@@ -152,6 +156,18 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
 			utils.NetrestrictFlag,
+			utils.BanDurationFlag,
+			utils.MaxFaultsFlag,
+			utils.InboundRatioFlag,
+			utils.OutboundRatioFlag,
+			utils.SubnetPeersFlag,
+			utils.ReservedPeersFlag,
+			utils.DNSDiscoveryFlag,
+			utils.CapabilitiesFlag,
+			utils.MaxIngressRateFlag,
+			utils.MaxEgressRateFlag,
+			utils.PeerIngressRateFlag,
+			utils.PeerEgressRateFlag,
 			utils.NodeKeyFileFlag,
 			utils.NodeKeyHexFlag,
 		},
@@ -165,6 +181,14 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TargetGasLimitFlag,
 			utils.GasPriceFlag,
 			utils.ExtraDataFlag,
+			utils.MinerGasTargetFlag,
+			utils.MinerGasLimitFlag,
+			utils.MinerRecommitFlag,
+			utils.MinerOrderingFlag,
+			utils.MinerPriorityFlag,
+			utils.MinerSenderGasFlag,
+			utils.StratumAddrFlag,
+			utils.StratumDifficultyFlag,
 		},
 	},
 	{
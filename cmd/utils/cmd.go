@@ -18,6 +18,8 @@
 package utils
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -25,9 +27,14 @@ import (
 	"os/signal"
 	"runtime"
 	"strings"
+	"time"
 
+	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/chainarchive"
+	"github.com/immesys/bw2bc/core/state"
 	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/ethdb"
 	"github.com/immesys/bw2bc/internal/debug"
 	"github.com/immesys/bw2bc/log"
 	"github.com/immesys/bw2bc/node"
@@ -38,6 +45,24 @@ const (
 	importBatchSize = 2500
 )
 
+// gzipMagic is the header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// stateMagic is the marker every state snapshot file starts with.
+var stateMagic = []byte("BW2STATE")
+
+// stateSnapshotVersion is the version of the state snapshot file format.
+const stateSnapshotVersion = 2
+
+// stateSnapshotHeader opens a state snapshot file and identifies the block whose
+// state is contained within. It is followed by the canonical headers between the
+// genesis and the block (exclusive) and finally by the account stream.
+type stateSnapshotHeader struct {
+	Version uint64
+	Genesis common.Hash
+	Block   *types.Block
+}
+
 // Fatalf formats a message to standard error and exits the program.
 // The message is also printed to standard output if standard error
 // is redirected to a different file.
@@ -80,7 +105,10 @@ func StartNode(stack *node.Node) {
 	}()
 }
 
-func ImportChain(chain *core.BlockChain, fn string) error {
+// ImportChain imports the blocks of a plain (optionally gzipped) RLP export or
+// of a chain archive. Archives must have been exported from a chain with the
+// same genesis and network id.
+func ImportChain(chain *core.BlockChain, networkId uint64, fn string) error {
 	// Watch for Ctrl-C while the import is running.
 	// If a signal is received, the import will stop at the next batch.
 	interrupt := make(chan os.Signal, 1)
@@ -110,14 +138,58 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 	}
 	defer fh.Close()
 
-	var reader io.Reader = fh
-	if strings.HasSuffix(fn, ".gz") {
-		if reader, err = gzip.NewReader(reader); err != nil {
+	// Detect the file format from its contents rather than its extension
+	reader := bufio.NewReader(fh)
+	prefix, _ := reader.Peek(8)
+
+	var next func() (*types.Block, error)
+	switch {
+	case chainarchive.IsArchive(prefix):
+		archive, err := chainarchive.Open(fn)
+		if err != nil {
 			return err
 		}
-	}
+		defer archive.Close()
 
-	stream := rlp.NewStream(reader, 0)
+		manifest := archive.Manifest()
+		if genesis := chain.Genesis().Hash(); manifest.GenesisHash != genesis {
+			return fmt.Errorf("archive genesis mismatch: have %x, want %x", manifest.GenesisHash, genesis)
+		}
+		if manifest.NetworkId != networkId {
+			return fmt.Errorf("archive network id mismatch: have %d, want %d", manifest.NetworkId, networkId)
+		}
+		log.Info("Importing chain archive", "first", manifest.First, "last", manifest.Last, "segments", len(manifest.Segments), "compression", manifest.Compression)
+
+		it := archive.Iterator()
+		next = func() (*types.Block, error) {
+			if it.Next() {
+				return it.Block(), nil
+			}
+			if err := it.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+
+	default:
+		var stream *rlp.Stream
+		if bytes.HasPrefix(prefix, gzipMagic) {
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				return err
+			}
+			stream = rlp.NewStream(gz, 0)
+		} else {
+			stream = rlp.NewStream(reader, 0)
+		}
+		next = func() (*types.Block, error) {
+			block := new(types.Block)
+			if err := stream.Decode(block); err != nil {
+				return nil, err
+			}
+			return block, nil
+		}
+	}
 
 	// Run actual the import.
 	blocks := make(types.Blocks, importBatchSize)
@@ -129,8 +201,8 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 		}
 		i := 0
 		for ; i < importBatchSize; i++ {
-			var b types.Block
-			if err := stream.Decode(&b); err == io.EOF {
+			b, err := next()
+			if err == io.EOF {
 				break
 			} else if err != nil {
 				return fmt.Errorf("at block %d: %v", n, err)
@@ -140,7 +212,7 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 				i--
 				continue
 			}
-			blocks[i] = &b
+			blocks[i] = b
 			n++
 		}
 		if i == 0 {
@@ -214,3 +286,252 @@ func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, las
 	log.Info("Exported blockchain to", "file", fn)
 	return nil
 }
+
+// ExportArchive writes the blocks [first, last] of the canonical chain into a
+// chain archive. If appending, the archive must already exist, belong to the
+// same chain and end right before first.
+func ExportArchive(blockchain *core.BlockChain, fn string, header chainarchive.Header, first uint64, last uint64, appending bool) error {
+	if first > last {
+		return fmt.Errorf("export failed: first (%d) is greater than last (%d)", first, last)
+	}
+	if head := blockchain.CurrentBlock().NumberU64(); last > head {
+		return fmt.Errorf("export failed: last (%d) is beyond the chain head (%d)", last, head)
+	}
+	header.GenesisHash = blockchain.Genesis().Hash()
+
+	var (
+		archive *chainarchive.Writer
+		err     error
+	)
+	if appending && common.FileExist(fn) {
+		archive, err = chainarchive.Append(fn, header)
+	} else {
+		archive, err = chainarchive.Create(fn, header)
+	}
+	if err != nil {
+		return err
+	}
+	if next, ok := archive.Next(); ok && next != first {
+		archive.Abort()
+		return fmt.Errorf("export failed: archive ends at #%d, cannot append from #%d", next-1, first)
+	}
+	log.Info("Exporting chain archive", "file", fn, "first", first, "last", last, "compression", archive.Header().Compression)
+
+	var (
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for nr := first; nr <= last; nr++ {
+		block := blockchain.GetBlockByNumber(nr)
+		if block == nil {
+			archive.Abort()
+			return fmt.Errorf("export failed on #%d: not found", nr)
+		}
+		if err := archive.Write(block); err != nil {
+			archive.Abort()
+			return err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting chain archive", "exported", nr-first+1, "total", last-first+1, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	log.Info("Exported chain archive", "file", fn, "blocks", last-first+1, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// VerifyArchive checks the integrity of a chain archive without importing it,
+// returning its manifest if all segments check out.
+func VerifyArchive(fn string) (*chainarchive.Manifest, error) {
+	archive, err := chainarchive.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	manifest := archive.Manifest()
+	log.Info("Verifying chain archive", "file", fn, "first", manifest.First, "last", manifest.Last, "segments", len(manifest.Segments))
+	if err := archive.Verify(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ExportState writes the entire state of the given canonical block, along with
+// the block itself, into a portable snapshot file. The file is gzip compressed
+// if its name ends with ".gz".
+func ExportState(blockchain *core.BlockChain, chainDb ethdb.Database, fn string, number uint64) error {
+	block := blockchain.GetBlockByNumber(number)
+	if block == nil {
+		return fmt.Errorf("block #%d not found", number)
+	}
+	if !blockchain.HasBlockAndState(block.Hash()) {
+		return fmt.Errorf("state of block #%d [%x…] not available", number, block.Hash().Bytes()[:4])
+	}
+	log.Info("Exporting state snapshot", "file", fn, "number", number, "hash", block.Hash(), "root", block.Root())
+
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var (
+		writer io.Writer = fh
+		gz     *gzip.Writer
+	)
+	if strings.HasSuffix(fn, ".gz") {
+		gz = gzip.NewWriter(writer)
+		writer = gz
+	}
+	buffered := bufio.NewWriter(writer)
+
+	header := &stateSnapshotHeader{
+		Version: stateSnapshotVersion,
+		Genesis: blockchain.Genesis().Hash(),
+		Block:   block,
+	}
+	if _, err := buffered.Write(stateMagic); err != nil {
+		return err
+	}
+	if err := rlp.Encode(buffered, header); err != nil {
+		return err
+	}
+	// Include the header chain leading up to the block so the importer can verify
+	// it and compute the total difficulty on its own.
+	for i := uint64(1); i < number; i++ {
+		h := blockchain.GetHeaderByNumber(i)
+		if h == nil {
+			return fmt.Errorf("header #%d not found", i)
+		}
+		if err := rlp.Encode(buffered, h); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	stats, err := state.Export(state.NewDatabase(chainDb), block.Root(), buffered)
+	if err != nil {
+		return err
+	}
+	// Flush and close every layer explicitly, any failure here would leave a
+	// truncated or corrupt snapshot behind.
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	log.Info("Exported state snapshot", "accounts", stats.Accounts, "slots", stats.Slots, "codes", stats.Codes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// ImportState verifies the header chain contained in a snapshot file, rebuilds
+// the state and checks it against the root of the enclosed block, then marks the
+// local chain as fast synced to that block. The local chain must not contain anything beyond the
+// genesis block.
+func ImportState(blockchain *core.BlockChain, chainDb ethdb.Database, fn string) error {
+	log.Info("Importing state snapshot", "file", fn)
+	fh, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = bufio.NewReader(fh)
+	if prefix, _ := reader.(*bufio.Reader).Peek(len(gzipMagic)); bytes.Equal(prefix, gzipMagic) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = bufio.NewReader(gz)
+	}
+	prefix := make([]byte, len(stateMagic))
+	if _, err := io.ReadFull(reader, prefix); err != nil || !bytes.Equal(prefix, stateMagic) {
+		return fmt.Errorf("not a state snapshot")
+	}
+	stream := rlp.NewStream(reader, 0)
+
+	header := new(stateSnapshotHeader)
+	if err := stream.Decode(header); err != nil {
+		return fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if header.Version != stateSnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	if genesis := blockchain.Genesis().Hash(); header.Genesis != genesis {
+		return fmt.Errorf("snapshot genesis mismatch: have %x, want %x", header.Genesis, genesis)
+	}
+	if head := blockchain.CurrentBlock().NumberU64(); head > 0 {
+		return fmt.Errorf("local chain not empty: head at #%d", head)
+	}
+	block := header.Block
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return fmt.Errorf("snapshot block transaction root mismatch: have %x, want %x", hash, block.TxHash())
+	}
+	if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+		return fmt.Errorf("snapshot block uncle hash mismatch: have %x, want %x", hash, block.UncleHash())
+	}
+	// Import and verify the header chain up to and including the block, the total
+	// difficulty is accumulated by the header chain instead of trusting the file.
+	log.Info("Importing header chain", "number", block.Number(), "hash", block.Hash())
+	headers := make([]*types.Header, 0, importBatchSize)
+	for i := uint64(1); i <= block.NumberU64(); i++ {
+		if i < block.NumberU64() {
+			h := new(types.Header)
+			if err := stream.Decode(h); err != nil {
+				return fmt.Errorf("invalid snapshot header #%d: %v", i, err)
+			}
+			headers = append(headers, h)
+		} else {
+			headers = append(headers, block.Header())
+		}
+		if len(headers) == importBatchSize || i == block.NumberU64() {
+			if n, err := blockchain.InsertHeaderChain(headers, 100); err != nil {
+				return fmt.Errorf("invalid snapshot header #%d: %v", headers[n].Number, err)
+			}
+			headers = headers[:0]
+		}
+	}
+	hash, number := block.Hash(), block.NumberU64()
+	if blockchain.GetTd(hash, number) == nil {
+		return fmt.Errorf("total difficulty of block #%d [%x…] unknown", number, hash.Bytes()[:4])
+	}
+	log.Info("Rebuilding state trie", "number", block.Number(), "hash", block.Hash(), "root", block.Root())
+
+	// Rebuild the state and make sure it's both correct and complete. The header
+	// stream reads straight from the buffered reader, so accounts follow directly.
+	start := time.Now()
+	root, stats, err := state.Import(chainDb, reader)
+	if err != nil {
+		return err
+	}
+	if root != block.Root() {
+		return fmt.Errorf("state root mismatch: have %x, want %x", root, block.Root())
+	}
+	if err := state.VerifyComplete(state.NewDatabase(chainDb), root); err != nil {
+		return fmt.Errorf("imported state incomplete: %v", err)
+	}
+	log.Info("Imported state snapshot", "accounts", stats.Accounts, "slots", stats.Slots, "codes", stats.Codes, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// Store the pivot block body and mark the chain as fast synced up to it. The
+	// header chain insertion already wrote the total difficulty and canonical hashes.
+	if err := core.WriteBlock(chainDb, block); err != nil {
+		return err
+	}
+	if err := core.WriteHeadFastBlockHash(chainDb, hash); err != nil {
+		return err
+	}
+	if err := core.WriteHeadBlockHash(chainDb, hash); err != nil {
+		return err
+	}
+	return blockchain.FastSyncCommitHead(hash)
+}
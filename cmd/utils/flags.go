@@ -27,11 +27,15 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/immesys/bw2bc/accounts"
 	"github.com/immesys/bw2bc/accounts/keystore"
 	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/consensus"
+	"github.com/immesys/bw2bc/consensus/clique"
 	"github.com/immesys/bw2bc/consensus/ethash"
+	"github.com/immesys/bw2bc/consensus/transition"
 	"github.com/immesys/bw2bc/core"
 	"github.com/immesys/bw2bc/core/state"
 	"github.com/immesys/bw2bc/core/vm"
@@ -45,6 +49,7 @@ import (
 	"github.com/immesys/bw2bc/les"
 	"github.com/immesys/bw2bc/log"
 	"github.com/immesys/bw2bc/metrics"
+	"github.com/immesys/bw2bc/miner"
 	"github.com/immesys/bw2bc/node"
 	"github.com/immesys/bw2bc/p2p"
 	"github.com/immesys/bw2bc/p2p/discover"
@@ -213,6 +218,16 @@ var (
 		Name:  "txpool.nolocals",
 		Usage: "Disables price exemptions for locally submitted transactions",
 	}
+	TxPoolJournalFlag = cli.StringFlag{
+		Name:  "txpool.journal",
+		Usage: "Disk journal for local transaction to survive node restarts",
+		Value: core.DefaultTxPoolConfig.Journal,
+	}
+	TxPoolRejournalFlag = cli.DurationFlag{
+		Name:  "txpool.rejournal",
+		Usage: "Time interval to regenerate the local transaction journal",
+		Value: core.DefaultTxPoolConfig.Rejournal,
+	}
 	TxPoolPriceLimitFlag = cli.Uint64Flag{
 		Name:  "txpool.pricelimit",
 		Usage: "Minimum gas price limit to enforce for acceptance into the pool",
@@ -259,6 +274,15 @@ var (
 		Usage: "Number of trie node generations to keep in memory",
 		Value: int(state.MaxTrieCacheGen),
 	}
+	TxLookupLimitFlag = cli.Uint64Flag{
+		Name:  "txlookuplimit",
+		Usage: "Number of recent blocks to maintain transactions index for (default = index all blocks)",
+		Value: 0,
+	}
+	SnapshotFlag = cli.BoolFlag{
+		Name:  "snapshot",
+		Usage: "Maintain a flat state snapshot to speed up state reads (generated in the background)",
+	}
 	// Miner settings
 	MiningEnabledFlag = cli.BoolFlag{
 		Name:  "mine",
@@ -288,6 +312,40 @@ var (
 		Name:  "extradata",
 		Usage: "Block extra data set by the miner (default = client version)",
 	}
+	MinerGasTargetFlag = cli.Uint64Flag{
+		Name:  "miner.gastarget",
+		Usage: "Gas limit to raise mined blocks towards (default = --targetgaslimit)",
+	}
+	MinerGasLimitFlag = cli.Uint64Flag{
+		Name:  "miner.gaslimit",
+		Usage: "Gas limit to lower mined blocks towards (0 = unlimited)",
+	}
+	MinerRecommitFlag = cli.DurationFlag{
+		Name:  "miner.recommit",
+		Usage: "Time interval to rebuild the pending block with new transactions while mining (0 = disabled)",
+		Value: eth.DefaultConfig.MinerRecommit,
+	}
+	MinerOrderingFlag = cli.StringFlag{
+		Name:  "minerordering",
+		Usage: `Ordering of transactions in mined blocks ("price" or "roundrobin")`,
+		Value: miner.OrderingPrice,
+	}
+	MinerPriorityFlag = cli.StringFlag{
+		Name:  "minerpriority",
+		Usage: "Comma separated list of senders whose transactions are mined before anyone else's",
+	}
+	MinerSenderGasFlag = cli.Uint64Flag{
+		Name:  "minersendergas",
+		Usage: "Maximum gas a single sender may claim per mined block (0 = unlimited)",
+	}
+	StratumAddrFlag = cli.StringFlag{
+		Name:  "stratum",
+		Usage: "Stratum mining server listening address (e.g. :8008, disabled if empty)",
+	}
+	StratumDifficultyFlag = BigFlag{
+		Name:  "stratumdiff",
+		Usage: "Default share difficulty of stratum workers (default = block difficulty)",
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -443,6 +501,66 @@ var (
 		Name:  "netrestrict",
 		Usage: "Restricts network communication to the given IP networks (CIDR masks)",
 	}
+	BanDurationFlag = cli.DurationFlag{
+		Name:  "banduration",
+		Usage: "Time misbehaving peers stay banned from reconnecting",
+		Value: time.Hour,
+	}
+	MaxFaultsFlag = cli.IntFlag{
+		Name:  "maxfaults",
+		Usage: "Number of protocol faults a peer may commit before being banned",
+		Value: 3,
+	}
+	InboundRatioFlag = cli.IntFlag{
+		Name:  "inboundratio",
+		Usage: "Maximum percentage of peer slots used by inbound connections (0 = no limit)",
+		Value: 0,
+	}
+	OutboundRatioFlag = cli.IntFlag{
+		Name:  "outboundratio",
+		Usage: "Maximum percentage of peer slots used by dialed connections (0 = no limit)",
+		Value: 0,
+	}
+	SubnetPeersFlag = cli.IntFlag{
+		Name:  "subnetpeers",
+		Usage: "Maximum number of peers from within the same /24 (IPv4) or /64 (IPv6) subnet (0 = no limit)",
+		Value: 0,
+	}
+	ReservedPeersFlag = cli.StringFlag{
+		Name:  "reservedpeers",
+		Usage: "Comma separated peer slots reserved per protocol (e.g. les=10)",
+		Value: "",
+	}
+	CapabilitiesFlag = cli.StringFlag{
+		Name:  "capabilities",
+		Usage: "Comma separated BOSSWAVE capabilities advertised in the node record (e.g. router)",
+		Value: "",
+	}
+	DNSDiscoveryFlag = cli.StringFlag{
+		Name:  "dnsdiscovery",
+		Usage: "Comma separated enodetree:// URLs of node lists published in DNS",
+		Value: "",
+	}
+	MaxIngressRateFlag = cli.IntFlag{
+		Name:  "maxingressrate",
+		Usage: "Maximum inbound traffic of all peers in bytes per second (0 = no limit)",
+		Value: 0,
+	}
+	MaxEgressRateFlag = cli.IntFlag{
+		Name:  "maxegressrate",
+		Usage: "Maximum outbound traffic of all peers in bytes per second (0 = no limit)",
+		Value: 0,
+	}
+	PeerIngressRateFlag = cli.IntFlag{
+		Name:  "peeringressrate",
+		Usage: "Maximum inbound traffic of a single peer in bytes per second (0 = no limit)",
+		Value: 0,
+	}
+	PeerEgressRateFlag = cli.IntFlag{
+		Name:  "peeregressrate",
+		Usage: "Maximum outbound traffic of a single peer in bytes per second (0 = no limit)",
+		Value: 0,
+	}
 
 	// ATM the url is left to the user and deployment to
 	JSpathFlag = cli.StringFlag{
@@ -784,6 +902,56 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		}
 		cfg.NetRestrict = list
 	}
+	if ctx.GlobalIsSet(BanDurationFlag.Name) {
+		cfg.BanDuration = ctx.GlobalDuration(BanDurationFlag.Name)
+	}
+	if ctx.GlobalIsSet(MaxFaultsFlag.Name) {
+		cfg.MaxFaults = ctx.GlobalInt(MaxFaultsFlag.Name)
+	}
+	if ctx.GlobalIsSet(InboundRatioFlag.Name) {
+		cfg.InboundRatio = ctx.GlobalInt(InboundRatioFlag.Name)
+	}
+	if ctx.GlobalIsSet(OutboundRatioFlag.Name) {
+		cfg.OutboundRatio = ctx.GlobalInt(OutboundRatioFlag.Name)
+	}
+	if ctx.GlobalIsSet(SubnetPeersFlag.Name) {
+		cfg.MaxSubnetPeers = ctx.GlobalInt(SubnetPeersFlag.Name)
+	}
+	if ctx.GlobalIsSet(MaxIngressRateFlag.Name) {
+		cfg.MaxIngressRate = ctx.GlobalInt(MaxIngressRateFlag.Name)
+	}
+	if ctx.GlobalIsSet(MaxEgressRateFlag.Name) {
+		cfg.MaxEgressRate = ctx.GlobalInt(MaxEgressRateFlag.Name)
+	}
+	if ctx.GlobalIsSet(PeerIngressRateFlag.Name) {
+		cfg.PeerIngressRate = ctx.GlobalInt(PeerIngressRateFlag.Name)
+	}
+	if ctx.GlobalIsSet(PeerEgressRateFlag.Name) {
+		cfg.PeerEgressRate = ctx.GlobalInt(PeerEgressRateFlag.Name)
+	}
+	if caps := ctx.GlobalString(CapabilitiesFlag.Name); caps != "" {
+		cfg.Capabilities = append(cfg.Capabilities, strings.Split(caps, ",")...)
+	}
+	if ctx.GlobalInt(LightServFlag.Name) > 0 {
+		cfg.Capabilities = append(cfg.Capabilities, "lightserver")
+	}
+	if urls := ctx.GlobalString(DNSDiscoveryFlag.Name); urls != "" {
+		cfg.DNSDiscovery = strings.Split(urls, ",")
+	}
+	if reserved := ctx.GlobalString(ReservedPeersFlag.Name); reserved != "" {
+		cfg.ReservedPeers = make(map[string]int)
+		for _, entry := range strings.Split(reserved, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+			if len(parts) != 2 {
+				Fatalf("Option %q: invalid reservation %q", ReservedPeersFlag.Name, entry)
+			}
+			slots, err := strconv.Atoi(parts[1])
+			if err != nil || slots < 0 {
+				Fatalf("Option %q: invalid slot count %q", ReservedPeersFlag.Name, parts[1])
+			}
+			cfg.ReservedPeers[parts[0]] = slots
+		}
+	}
 
 	if ctx.GlobalBool(DevModeFlag.Name) {
 		// --dev mode can't use p2p networking.
@@ -838,6 +1006,12 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolNoLocalsFlag.Name) {
 		cfg.NoLocals = ctx.GlobalBool(TxPoolNoLocalsFlag.Name)
 	}
+	if ctx.GlobalIsSet(TxPoolJournalFlag.Name) {
+		cfg.Journal = ctx.GlobalString(TxPoolJournalFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolRejournalFlag.Name) {
+		cfg.Rejournal = ctx.GlobalDuration(TxPoolRejournalFlag.Name)
+	}
 	if ctx.GlobalIsSet(TxPoolPriceLimitFlag.Name) {
 		cfg.PriceLimit = ctx.GlobalUint64(TxPoolPriceLimitFlag.Name)
 	}
@@ -942,6 +1116,10 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name)
 	}
 	cfg.DatabaseHandles = makeDatabaseHandles()
+	cfg.Snapshot = ctx.GlobalBool(SnapshotFlag.Name)
+	if ctx.GlobalIsSet(TxLookupLimitFlag.Name) {
+		cfg.TxLookupLimit = ctx.GlobalUint64(TxLookupLimitFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(MinerThreadsFlag.Name) {
 		cfg.MinerThreads = ctx.GlobalInt(MinerThreadsFlag.Name)
@@ -955,6 +1133,35 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	if ctx.GlobalIsSet(GasPriceFlag.Name) {
 		cfg.GasPrice = GlobalBig(ctx, GasPriceFlag.Name)
 	}
+	if ctx.GlobalIsSet(MinerGasTargetFlag.Name) {
+		cfg.MinerGasTarget = ctx.GlobalUint64(MinerGasTargetFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerGasLimitFlag.Name) {
+		cfg.MinerGasLimit = ctx.GlobalUint64(MinerGasLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerRecommitFlag.Name) {
+		cfg.MinerRecommit = ctx.GlobalDuration(MinerRecommitFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerOrderingFlag.Name) {
+		cfg.MinerOrdering = ctx.GlobalString(MinerOrderingFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerPriorityFlag.Name) {
+		for _, addr := range strings.Split(ctx.GlobalString(MinerPriorityFlag.Name), ",") {
+			if addr = strings.TrimSpace(addr); !common.IsHexAddress(addr) {
+				Fatalf("Invalid miner priority address %q", addr)
+			}
+			cfg.MinerPriority = append(cfg.MinerPriority, common.HexToAddress(addr))
+		}
+	}
+	if ctx.GlobalIsSet(MinerSenderGasFlag.Name) {
+		cfg.MinerSenderGas = ctx.GlobalUint64(MinerSenderGasFlag.Name)
+	}
+	if ctx.GlobalIsSet(StratumAddrFlag.Name) {
+		cfg.StratumAddr = ctx.GlobalString(StratumAddrFlag.Name)
+	}
+	if ctx.GlobalIsSet(StratumDifficultyFlag.Name) {
+		cfg.StratumDifficulty = GlobalBig(ctx, StratumDifficultyFlag.Name)
+	}
 	if ctx.GlobalIsSet(VMEnableDebugFlag.Name) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
@@ -1075,14 +1282,21 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	var err error
 	chainDb = MakeChainDatabase(ctx, stack)
 
-	engine := ethash.NewFaker()
-	if !ctx.GlobalBool(FakePoWFlag.Name) {
-		engine = ethash.New("", 1, 0, "", 1, 0)
-	}
 	config, _, err := core.SetupGenesisBlock(chainDb, MakeGenesis(ctx))
 	if err != nil {
 		Fatalf("%v", err)
 	}
+	var engine consensus.Engine = ethash.NewFaker()
+	if !ctx.GlobalBool(FakePoWFlag.Name) {
+		engine = ethash.New("", 1, 0, "", 1, 0)
+	}
+	if config.Clique != nil {
+		if config.CliqueTransitionBlock != nil {
+			engine = transition.New(config.CliqueTransitionBlock, engine, clique.New(config.Clique, chainDb))
+		} else {
+			engine = clique.New(config.Clique, chainDb)
+		}
+	}
 	vmcfg := vm.Config{EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name)}
 	chain, err = core.NewBlockChain(chainDb, config, engine, new(event.TypeMux), vmcfg)
 	if err != nil {
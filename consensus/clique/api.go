@@ -17,12 +17,29 @@
 package clique
 
 import (
+	"bytes"
+	"errors"
+
 	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/common/hexutil"
 	"github.com/immesys/bw2bc/consensus"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/rpc"
 )
 
+const (
+	statusBlocks     = 64    // Default number of recent blocks to gather sealing statistics over
+	maxHistoryBlocks = 65536 // Maximum number of blocks to decode votes from in one request
+)
+
+var (
+	// errInvalidRange is returned if a block range starts after it ends.
+	errInvalidRange = errors.New("invalid block range")
+
+	// errRangeTooLarge is returned if a block range exceeds maxHistoryBlocks.
+	errRangeTooLarge = errors.New("block range too large")
+)
+
 // API is a user facing RPC API to allow controlling the signer and voting
 // mechanisms of the proof-of-authority scheme.
 type API struct {
@@ -102,18 +119,160 @@ func (api *API) Proposals() map[common.Address]bool {
 
 // Propose injects a new authorization proposal that the signer will attempt to
 // push through.
-func (api *API) Propose(address common.Address, auth bool) {
+func (api *API) Propose(address common.Address, auth bool) error {
 	api.clique.lock.Lock()
 	defer api.clique.lock.Unlock()
 
 	api.clique.proposals[address] = auth
+	return api.clique.storeProposals()
 }
 
 // Discard drops a currently running proposal, stopping the signer from casting
 // further votes (either for or against).
-func (api *API) Discard(address common.Address) {
+func (api *API) Discard(address common.Address) error {
 	api.clique.lock.Lock()
 	defer api.clique.lock.Unlock()
 
 	delete(api.clique.proposals, address)
+	return api.clique.storeProposals()
+}
+
+// GetTally retrieves the running proposals and their vote counts at the specified
+// block, ordered by the number of votes cast in their favour.
+func (api *API) GetTally(number *rpc.BlockNumber) ([]*TallyEntry, error) {
+	header, err := api.header(number)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return snap.tallies(), nil
+}
+
+// SignerStatus is the sealing activity of a single signer over a range of blocks.
+type SignerStatus struct {
+	Sealed      uint64  `json:"sealed"`      // Number of blocks sealed by the signer
+	Inturn      uint64  `json:"inturn"`      // Number of blocks sealed in-turn
+	OutOfTurn   uint64  `json:"outOfTurn"`   // Number of blocks sealed out-of-turn
+	InturnRatio float64 `json:"inturnRatio"` // Ratio of the sealed blocks that were in-turn
+}
+
+// Status is the sealing activity of the signer set over the most recent blocks.
+type Status struct {
+	NumBlocks   uint64                           `json:"numBlocks"`   // Number of blocks inspected
+	InturnRatio float64                          `json:"inturnRatio"` // Ratio of the inspected blocks sealed in-turn
+	Signers     map[common.Address]*SignerStatus `json:"signers"`     // Sealing activity per signer
+}
+
+// Status retrieves the sealing statistics of the signers over the last given
+// number of blocks (statusBlocks if unspecified). Currently authorized signers
+// that didn't seal anything are reported with zero counts.
+func (api *API) Status(blocks *hexutil.Uint64) (*Status, error) {
+	limit := uint64(statusBlocks)
+	if blocks != nil {
+		limit = uint64(*blocks)
+	}
+	header := api.chain.CurrentHeader()
+	snap, err := api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{Signers: make(map[common.Address]*SignerStatus)}
+	for _, signer := range snap.signers() {
+		status.Signers[signer] = new(SignerStatus)
+	}
+	var inturn uint64
+	for ; status.NumBlocks < limit && header != nil && header.Number.Sign() > 0; header = api.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1) {
+		// Stop at the proof-of-work blocks of a transitioned chain
+		if transition := api.chain.Config().CliqueTransitionBlock; transition != nil && header.Number.Cmp(transition) < 0 {
+			break
+		}
+		signer, err := ecrecover(header, api.clique.signatures)
+		if err != nil {
+			return nil, err
+		}
+		if status.Signers[signer] == nil {
+			status.Signers[signer] = new(SignerStatus)
+		}
+		stats := status.Signers[signer]
+		stats.Sealed++
+		if header.Difficulty.Cmp(diffInTurn) == 0 {
+			stats.Inturn++
+			inturn++
+		} else {
+			stats.OutOfTurn++
+		}
+		status.NumBlocks++
+	}
+	for _, stats := range status.Signers {
+		if stats.Sealed > 0 {
+			stats.InturnRatio = float64(stats.Inturn) / float64(stats.Sealed)
+		}
+	}
+	if status.NumBlocks > 0 {
+		status.InturnRatio = float64(inturn) / float64(status.NumBlocks)
+	}
+	return status, nil
+}
+
+// GetVoteHistory decodes every authorization vote cast in the headers of the
+// given inclusive block range.
+func (api *API) GetVoteHistory(from, to rpc.BlockNumber) ([]*Vote, error) {
+	last, err := api.header(&to)
+	if err != nil {
+		return nil, err
+	}
+	first, err := api.header(&from)
+	if err != nil {
+		return nil, err
+	}
+	start, end := first.Number.Uint64(), last.Number.Uint64()
+	if start > end {
+		return nil, errInvalidRange
+	}
+	if end-start >= maxHistoryBlocks {
+		return nil, errRangeTooLarge
+	}
+	votes := []*Vote{}
+	for number := start; number <= end; number++ {
+		header := api.chain.GetHeaderByNumber(number)
+		if header == nil {
+			return nil, errUnknownBlock
+		}
+		// Skip the genesis, checkpoints and blocks without a vote
+		if number == 0 || number%api.clique.config.Epoch == 0 || header.Coinbase == (common.Address{}) {
+			continue
+		}
+		if transition := api.chain.Config().CliqueTransitionBlock; transition != nil && header.Number.Cmp(transition) < 0 {
+			continue
+		}
+		signer, err := ecrecover(header, api.clique.signatures)
+		if err != nil {
+			return nil, err
+		}
+		votes = append(votes, &Vote{
+			Signer:    signer,
+			Block:     number,
+			Address:   header.Coinbase,
+			Authorize: bytes.Equal(header.Nonce[:], nonceAuthVote),
+		})
+	}
+	return votes, nil
+}
+
+// header retrieves the header with the given number, or the current head if
+// none or the latest was requested.
+func (api *API) header(number *rpc.BlockNumber) (*types.Header, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber || *number == rpc.PendingBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return header, nil
 }
@@ -0,0 +1,202 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/common/hexutil"
+	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/params"
+	"github.com/immesys/bw2bc/rpc"
+)
+
+// testerHeaderChain implements consensus.ChainReader on top of a genesis block
+// in a database and an in-memory list of headers following it.
+type testerHeaderChain struct {
+	db      ethdb.Database
+	headers []*types.Header
+}
+
+func (c *testerHeaderChain) Config() *params.ChainConfig { return params.TestChainConfig }
+func (c *testerHeaderChain) CurrentHeader() *types.Header {
+	return c.headers[len(c.headers)-1]
+}
+func (c *testerHeaderChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.GetHeaderByNumber(number); header != nil && header.Hash() == hash {
+		return header
+	}
+	return nil
+}
+func (c *testerHeaderChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number == 0 {
+		return core.GetHeader(c.db, core.GetCanonicalHash(c.db, 0), 0)
+	}
+	if number > uint64(len(c.headers)) {
+		return nil
+	}
+	return c.headers[number-1]
+}
+func (c *testerHeaderChain) GetHeaderByHash(hash common.Hash) *types.Header { panic("not supported") }
+func (c *testerHeaderChain) GetBlock(common.Hash, uint64) *types.Block      { panic("not supported") }
+
+// newTesterHeaderChain creates a chain authorized by signers A, B and C, where
+// every signer seals in a round robin fashion, casting the given votes.
+func newTesterHeaderChain(accounts *testerAccountPool, votes []testerVote) *testerHeaderChain {
+	signers := []common.Address{accounts.address("A"), accounts.address("B"), accounts.address("C")}
+	genesis := &core.Genesis{
+		ExtraData: make([]byte, extraVanity+common.AddressLength*len(signers)+extraSeal),
+	}
+	for j, signer := range signers {
+		copy(genesis.ExtraData[extraVanity+j*common.AddressLength:], signer[:])
+	}
+	db, _ := ethdb.NewMemDatabase()
+	parent := genesis.MustCommit(db).Header()
+
+	chain := &testerHeaderChain{db: db}
+	for j, vote := range votes {
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     big.NewInt(int64(j) + 1),
+			Time:       big.NewInt(int64(j) * int64(blockPeriod)),
+			Difficulty: diffNoTurn,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		if vote.voted != "" {
+			header.Coinbase = accounts.address(vote.voted)
+		}
+		if vote.auth {
+			copy(header.Nonce[:], nonceAuthVote)
+		}
+		accounts.sign(header, vote.signer)
+		chain.headers = append(chain.headers, header)
+		parent = header
+	}
+	return chain
+}
+
+// Tests that proposals survive an engine restart.
+func TestProposalPersistence(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	api := &API{clique: New(&params.CliqueConfig{}, db)}
+
+	if err := api.Propose(common.Address{0x01}, true); err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	if err := api.Propose(common.Address{0x02}, false); err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	if err := api.Propose(common.Address{0x03}, true); err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	if err := api.Discard(common.Address{0x03}); err != nil {
+		t.Fatalf("failed to discard: %v", err)
+	}
+	restarted := &API{clique: New(&params.CliqueConfig{}, db)}
+	proposals := restarted.Proposals()
+	if len(proposals) != 2 || !proposals[common.Address{0x01}] || proposals[common.Address{0x02}] {
+		t.Errorf("proposals mismatch after restart: have %v", proposals)
+	}
+}
+
+// Tests that the vote history, the tally and the sealing statistics are correctly
+// derived from the headers.
+func TestGovernanceAPI(t *testing.T) {
+	accounts := newTesterAccountPool()
+	chain := newTesterHeaderChain(accounts, []testerVote{
+		{signer: "A", voted: "D", auth: true},
+		{signer: "B", voted: "E", auth: true},
+		{signer: "C", voted: "D", auth: true},
+		{signer: "A"},
+		{signer: "B", voted: "C"},
+	})
+	// Make the last two blocks in-turn for the statistics
+	for _, header := range chain.headers[3:] {
+		header.Difficulty = diffInTurn
+	}
+	for j, header := range chain.headers {
+		if j > 0 {
+			header.ParentHash = chain.headers[j-1].Hash()
+		}
+		accounts.sign(header, []string{"A", "B", "C", "A", "B"}[j])
+	}
+	api := &API{chain: chain, clique: New(&params.CliqueConfig{}, chain.db)}
+
+	// Check the decoded vote history
+	votes, err := api.GetVoteHistory(0, rpc.LatestBlockNumber)
+	if err != nil {
+		t.Fatalf("failed to retrieve vote history: %v", err)
+	}
+	want := []*Vote{
+		{Signer: accounts.address("A"), Block: 1, Address: accounts.address("D"), Authorize: true},
+		{Signer: accounts.address("B"), Block: 2, Address: accounts.address("E"), Authorize: true},
+		{Signer: accounts.address("C"), Block: 3, Address: accounts.address("D"), Authorize: true},
+		{Signer: accounts.address("B"), Block: 5, Address: accounts.address("C"), Authorize: false},
+	}
+	if len(votes) != len(want) {
+		t.Fatalf("vote count mismatch: have %d, want %d", len(votes), len(want))
+	}
+	for i, vote := range votes {
+		if *vote != *want[i] {
+			t.Errorf("vote %d: mismatch: have %+v, want %+v", i, vote, want[i])
+		}
+	}
+	if _, err := api.GetVoteHistory(4, 2); err != errInvalidRange {
+		t.Errorf("inverted range error mismatch: have %v, want %v", err, errInvalidRange)
+	}
+	// Check the tally: D got authorized, E and C are still pending
+	number := rpc.LatestBlockNumber
+	tallies, err := api.GetTally(&number)
+	if err != nil {
+		t.Fatalf("failed to retrieve tally: %v", err)
+	}
+	if len(tallies) != 2 {
+		t.Fatalf("tally count mismatch: have %d, want %d", len(tallies), 2)
+	}
+	for _, tally := range tallies {
+		if tally.Votes != 1 || tally.Needed != 3 {
+			t.Errorf("tally %x: votes/needed mismatch: have %d/%d, want %d/%d", tally.Address, tally.Votes, tally.Needed, 1, 3)
+		}
+	}
+	// Check the sealing statistics over the last four blocks
+	blocks := hexutil.Uint64(4)
+	status, err := api.Status(&blocks)
+	if err != nil {
+		t.Fatalf("failed to retrieve status: %v", err)
+	}
+	if status.NumBlocks != 4 {
+		t.Errorf("inspected block count mismatch: have %d, want %d", status.NumBlocks, 4)
+	}
+	if status.InturnRatio != 0.5 {
+		t.Errorf("inturn ratio mismatch: have %v, want %v", status.InturnRatio, 0.5)
+	}
+	stats := map[string]SignerStatus{
+		"A": {Sealed: 1, Inturn: 1, InturnRatio: 1},
+		"B": {Sealed: 2, Inturn: 1, OutOfTurn: 1, InturnRatio: 0.5},
+		"C": {Sealed: 1, OutOfTurn: 1},
+		"D": {},
+	}
+	for name, want := range stats {
+		if have := status.Signers[accounts.address(name)]; have == nil || *have != want {
+			t.Errorf("signer %s: status mismatch: have %+v, want %+v", name, have, want)
+		}
+	}
+}
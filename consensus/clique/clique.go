@@ -19,6 +19,7 @@ package clique
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"math/big"
 	"math/rand"
@@ -49,6 +50,10 @@ const (
 	wiggleTime = 500 * time.Millisecond // Random delay (per signer) to allow concurrent signers
 )
 
+// proposalsKey is the database key under which the local signer's pending
+// proposals are persisted across restarts.
+var proposalsKey = []byte("clique-proposals")
+
 // Clique proof-of-authority protocol constants.
 var (
 	epochLength = uint64(30000) // Default number of blocks after which to checkpoint and reset the pending votes
@@ -222,10 +227,36 @@ func New(config *params.CliqueConfig, db ethdb.Database) *Clique {
 		db:         db,
 		recents:    recents,
 		signatures: signatures,
-		proposals:  make(map[common.Address]bool),
+		proposals:  loadProposals(db),
 	}
 }
 
+// loadProposals retrieves the persisted authorization proposals from the
+// database, returning an empty set if none were stored or they are corrupted.
+func loadProposals(db ethdb.Database) map[common.Address]bool {
+	proposals := make(map[common.Address]bool)
+
+	blob, err := db.Get(proposalsKey)
+	if err != nil {
+		return proposals
+	}
+	if err := json.Unmarshal(blob, &proposals); err != nil {
+		log.Warn("Failed to load clique proposals", "err", err)
+		return make(map[common.Address]bool)
+	}
+	return proposals
+}
+
+// storeProposals persists the current authorization proposals into the database.
+// The caller must hold the lock.
+func (c *Clique) storeProposals() error {
+	blob, err := json.Marshal(c.proposals)
+	if err != nil {
+		return err
+	}
+	return c.db.Put(proposalsKey, blob)
+}
+
 // Author implements consensus.Engine, returning the Ethereum address recovered
 // from the signature in the header's extra-data section.
 func (c *Clique) Author(header *types.Header) (common.Address, error) {
@@ -381,6 +412,16 @@ func (c *Clique) snapshot(chain consensus.ChainReader, number uint64, hash commo
 				break
 			}
 		}
+		// If we're at the last proof-of-work block of a transitioning chain, make a
+		// snapshot of the configured initial signers
+		if transition := chain.Config().CliqueTransitionBlock; transition != nil && number+1 == transition.Uint64() {
+			snap = newSnapshot(c.config, c.signatures, number, hash, c.config.Signers)
+			if err := snap.store(c.db); err != nil {
+				return nil, err
+			}
+			log.Trace("Stored transition voting snapshot to disk", "number", number, "hash", hash)
+			break
+		}
 		// If we're at block zero, make a snapshot
 		if number == 0 {
 			genesis := chain.GetHeaderByNumber(0)
@@ -19,6 +19,7 @@ package clique
 import (
 	"bytes"
 	"encoding/json"
+	"sort"
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/core/types"
@@ -43,6 +44,15 @@ type Tally struct {
 	Votes     int  `json:"votes"`     // Number of votes until now wanting to pass the proposal
 }
 
+// TallyEntry is a console friendly view of a single running proposal, listing
+// how far it is from passing.
+type TallyEntry struct {
+	Address   common.Address `json:"address"`   // Account being voted on
+	Authorize bool           `json:"authorize"` // Whether the proposal is about authorizing or kicking
+	Votes     int            `json:"votes"`     // Number of votes cast in favour of the proposal
+	Needed    int            `json:"needed"`    // Number of votes required for the proposal to pass
+}
+
 // Snapshot is the state of the authorization voting at a given point in time.
 type Snapshot struct {
 	config   *params.CliqueConfig // Consensus engine parameters to fine tune behavior
@@ -300,6 +310,35 @@ func (s *Snapshot) signers() []common.Address {
 	return signers
 }
 
+// tallies dumps the current vote tally as a list of proposals, ordered by the
+// number of votes and then by address.
+func (s *Snapshot) tallies() []*TallyEntry {
+	tallies := make([]*TallyEntry, 0, len(s.Tally))
+	for address, tally := range s.Tally {
+		tallies = append(tallies, &TallyEntry{
+			Address:   address,
+			Authorize: tally.Authorize,
+			Votes:     tally.Votes,
+			Needed:    len(s.Signers)/2 + 1,
+		})
+	}
+	sort.Sort(talliesByVotes(tallies))
+	return tallies
+}
+
+// talliesByVotes implements sort.Interface to order proposals by descending
+// number of votes, breaking ties by address.
+type talliesByVotes []*TallyEntry
+
+func (t talliesByVotes) Len() int      { return len(t) }
+func (t talliesByVotes) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t talliesByVotes) Less(i, j int) bool {
+	if t[i].Votes != t[j].Votes {
+		return t[i].Votes > t[j].Votes
+	}
+	return bytes.Compare(t[i].Address[:], t[j].Address[:]) < 0
+}
+
 // inturn returns if a signer at a given block height is in-turn or not.
 func (s *Snapshot) inturn(number uint64, signer common.Address) bool {
 	signers, offset := s.signers(), 0
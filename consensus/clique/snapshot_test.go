@@ -69,12 +69,13 @@ func (ap *testerAccountPool) address(account string) common.Address {
 }
 
 // testerChainReader implements consensus.ChainReader to access the genesis
-// block. All other methods and requests will panic.
+// block and a chain config without a transition. All other methods and requests
+// will panic.
 type testerChainReader struct {
 	db ethdb.Database
 }
 
-func (r *testerChainReader) Config() *params.ChainConfig                 { panic("not supported") }
+func (r *testerChainReader) Config() *params.ChainConfig                 { return params.TestChainConfig }
 func (r *testerChainReader) CurrentHeader() *types.Header                { panic("not supported") }
 func (r *testerChainReader) GetHeader(common.Hash, uint64) *types.Header { panic("not supported") }
 func (r *testerChainReader) GetBlock(common.Hash, uint64) *types.Block   { panic("not supported") }
@@ -37,8 +37,7 @@ import (
 
 // Ethash proof-of-work protocol constants.
 var (
-	blockReward *big.Int = big.NewInt(5e+18) // Block reward in wei for successfully mining a block
-	maxUncles            = 2                 // Maximum number of uncles allowed in a single block
+	maxUncles = 2 // Maximum number of uncles allowed in a single block
 )
 
 // Various error messages to mark blocks invalid. These should be private to
@@ -288,7 +287,7 @@ func (ethash *Ethash) verifyHeader(chain consensus.ChainReader, header, parent *
 // given the parent block's time and difficulty.
 // TODO (karalabe): Move the chain maker into this package and make this private!
 func CalcDifficulty(config *params.ChainConfig, time uint64, parent *types.Header) *big.Int {
-	return calcDifficultyBW2BC(time, parent)
+	return calcDifficultyBW2BC(config.Ethash, time, parent)
 }
 
 // Some weird constants to avoid constant memory allocs for them.
@@ -380,45 +379,65 @@ func calcDifficultyFrontier(time uint64, parent *types.Header) *big.Int {
 	return diff
 }
 
-//Just like homestead but without the bomb.
-func calcDifficultyBW2BC(time uint64, parent *types.Header) *big.Int {
-
-	parentTime := parent.Time.Uint64()
-	parentDiff := parent.Difficulty
-
-	// https://github.com/ethereum/EIPs/blob/master/EIPS/eip-2.mediawiki
+// calcDifficultyBW2BC is the difficulty adjustment algorithm. It returns the
+// difficulty that a new block should have when created at time given the
+// parent block's time and difficulty. The calculation follows the Homestead
+// rules, but the target block time, the bound divisor (from their activation
+// block onwards) and the exponential bomb are taken from the ethash config (by
+// default the bomb is disabled).
+func calcDifficultyBW2BC(config *params.EthashConfig, time uint64, parent *types.Header) *big.Int {
 	// algorithm:
 	// diff = (parent_diff +
-	//         (parent_diff / 2048 * max(1 - (block_timestamp - parent_timestamp) // 10, -99))
+	//         (parent_diff / bound_divisor * max(1 - (block_timestamp - parent_timestamp) // block_time, -99))
 	//        ) + 2^(periodCount - 2)
 
+	next := new(big.Int).Add(parent.Number, common.Big1)
 	bigTime := new(big.Int).SetUint64(time)
-	bigParentTime := new(big.Int).SetUint64(parentTime)
+	bigParentTime := new(big.Int).Set(parent.Time)
 
 	// holds intermediate values to make the algo easier to read & audit
 	x := new(big.Int)
 	y := new(big.Int)
 
-	// 1 - (block_timestamp -parent_timestamp) // 10
+	// 1 - (block_timestamp -parent_timestamp) // block_time
 	x.Sub(bigTime, bigParentTime)
-	x.Div(x, big10)
+	x.Div(x, new(big.Int).SetUint64(config.DifficultyBlockTime(next)))
 	x.Sub(common.Big1, x)
 
-	// max(1 - (block_timestamp - parent_timestamp) // 10, -99)))
+	// max(1 - (block_timestamp - parent_timestamp) // block_time, -99)))
 	if x.Cmp(bigMinus99) < 0 {
 		x.Set(bigMinus99)
 	}
-
-	// (parent_diff + parent_diff // 2048 * max(1 - (block_timestamp - parent_timestamp) // 10, -99))
-	y.Div(parentDiff, params.DifficultyBoundDivisor)
+	// (parent_diff + parent_diff // bound_divisor * max(1 - (block_timestamp - parent_timestamp) // block_time, -99))
+	y.Div(parent.Difficulty, config.DifficultyBoundDivisor(next))
 	x.Mul(y, x)
-	x.Add(parentDiff, x)
+	x.Add(parent.Difficulty, x)
 
 	// minimum difficulty can ever be (before exponential factor)
 	if x.Cmp(params.MinimumDifficulty) < 0 {
-		x = params.MinimumDifficulty
+		x.Set(params.MinimumDifficulty)
+	}
+	if config == nil || !config.Bomb {
+		return x
+	}
+	// for the exponential factor, calculated on a delayed block number if requested
+	periodCount := new(big.Int).Add(parent.Number, common.Big1)
+	if config.BombDelay != nil {
+		if periodCount.Cmp(config.BombDelay) >= 0 {
+			periodCount.Sub(periodCount, config.BombDelay)
+		} else {
+			periodCount.SetUint64(0)
+		}
 	}
+	periodCount.Div(periodCount, expDiffPeriod)
 
+	// the exponential factor, commonly referred to as "the bomb"
+	// diff = diff + 2^(periodCount - 2)
+	if periodCount.Cmp(common.Big1) > 0 {
+		y.Sub(periodCount, common.Big2)
+		y.Exp(common.Big2, y, nil)
+		x.Add(x, y)
+	}
 	return x
 }
 
@@ -427,6 +446,13 @@ var vsglock sync.Mutex
 // VerifySeal implements consensus.Engine, checking whether the given block satisfies
 // the PoW difficulty requirements.
 func (ethash *Ethash) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	return ethash.VerifyShare(chain, header, header.Difficulty)
+}
+
+// VerifyShare checks whether the given block satisfies the PoW requirements of
+// an arbitrary difficulty, which is used to validate the (easier) shares that
+// pooled remote miners submit.
+func (ethash *Ethash) VerifyShare(chain consensus.ChainReader, header *types.Header, difficulty *big.Int) error {
 	vsglock.Lock()
     defer vsglock.Unlock()
     // If we're running a fake PoW, accept any seal as valid
@@ -439,7 +465,7 @@ func (ethash *Ethash) VerifySeal(chain consensus.ChainReader, header *types.Head
 	}
 	// If we're running a shared PoW, delegate verification to it
 	if ethash.shared != nil {
-		return ethash.shared.VerifySeal(chain, header)
+		return ethash.shared.VerifyShare(chain, header, difficulty)
 	}
 	// Sanity check that the block number is below the lookup table size (60M blocks)
 	number := header.Number.Uint64()
@@ -448,7 +474,7 @@ func (ethash *Ethash) VerifySeal(chain consensus.ChainReader, header *types.Head
 		return errNonceOutOfRange
 	}
 	// Ensure that we have a valid difficulty for the block
-	if header.Difficulty.Sign() <= 0 {
+	if header.Difficulty.Sign() <= 0 || difficulty.Sign() <= 0 {
 		return errInvalidDifficulty
 	}
 	// Recompute the digest and PoW value and verify against the header
@@ -462,7 +488,7 @@ func (ethash *Ethash) VerifySeal(chain consensus.ChainReader, header *types.Head
 	if !bytes.Equal(header.MixDigest[:], digest) {
 		return errInvalidMixDigest
 	}
-	target := new(big.Int).Div(maxUint256, header.Difficulty)
+	target := new(big.Int).Div(maxUint256, difficulty)
 	if new(big.Int).SetBytes(result).Cmp(target) > 0 {
 		return errInvalidPoW
 	}
@@ -485,7 +511,7 @@ func (ethash *Ethash) Prepare(chain consensus.ChainReader, header *types.Header)
 // setting the final state and assembling the block.
 func (ethash *Ethash) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
 	// Accumulate any block and uncle rewards and commit the final state root
-	AccumulateRewards(state, header, uncles)
+	AccumulateRewards(chain.Config(), state, header, uncles)
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 
 	// Header seems complete, assemble into a block and return
@@ -494,26 +520,35 @@ func (ethash *Ethash) Finalize(chain consensus.ChainReader, header *types.Header
 
 // Some weird constants to avoid constant memory allocs for them.
 var (
-	big8  = big.NewInt(8)
-	big32 = big.NewInt(32)
+	big8 = big.NewInt(8)
 )
 
 // AccumulateRewards credits the coinbase of the given block with the mining
 // reward. The total reward consists of the static block reward and rewards for
-// included uncles. The coinbase of each uncle block is also rewarded.
+// included uncles. The coinbase of each uncle block is also rewarded, as is the
+// treasury if the reward schedule active at the block defines one.
 // TODO (karalabe): Move the chain maker into this package and make this private!
-func AccumulateRewards(state *state.StateDB, header *types.Header, uncles []*types.Header) {
-	reward := new(big.Int).Set(blockReward)
+func AccumulateRewards(config *params.ChainConfig, state *state.StateDB, header *types.Header, uncles []*types.Header) {
+	schedule := config.Ethash.Reward(header.Number)
+
+	reward := new(big.Int).Set(schedule.BlockReward)
 	r := new(big.Int)
 	for _, uncle := range uncles {
-		r.Add(uncle.Number, big8)
-		r.Sub(r, header.Number)
-		r.Mul(r, blockReward)
-		r.Div(r, big8)
-		state.AddBalance(uncle.Coinbase, r)
-
-		r.Div(blockReward, big32)
-		reward.Add(reward, r)
+		if schedule.UncleDivisor > 0 {
+			r.Add(uncle.Number, big8)
+			r.Sub(r, header.Number)
+			r.Mul(r, schedule.BlockReward)
+			r.Div(r, new(big.Int).SetUint64(schedule.UncleDivisor))
+			state.AddBalance(uncle.Coinbase, r)
+		}
+		if schedule.NephewDivisor > 0 {
+			r.Div(schedule.BlockReward, new(big.Int).SetUint64(schedule.NephewDivisor))
+			reward.Add(reward, r)
+		}
 	}
 	state.AddBalance(header.Coinbase, reward)
+
+	if schedule.Treasury != nil && schedule.TreasuryPayment != nil {
+		state.AddBalance(*schedule.Treasury, schedule.TreasuryPayment)
+	}
 }
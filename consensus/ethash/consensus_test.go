@@ -23,8 +23,11 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/common/math"
+	"github.com/immesys/bw2bc/core/state"
 	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/ethdb"
 	"github.com/immesys/bw2bc/params"
 )
 
@@ -83,3 +86,197 @@ func TestCalcDifficulty(t *testing.T) {
 		}
 	}
 }
+
+// legacyAccumulateRewards is the hard coded Ethereum reward formula, kept to
+// verify that the default reward schedule produces identical state.
+func legacyAccumulateRewards(state *state.StateDB, header *types.Header, uncles []*types.Header) {
+	blockReward := big.NewInt(5e+18)
+
+	reward := new(big.Int).Set(blockReward)
+	r := new(big.Int)
+	for _, uncle := range uncles {
+		r.Add(uncle.Number, big.NewInt(8))
+		r.Sub(r, header.Number)
+		r.Mul(r, blockReward)
+		r.Div(r, big.NewInt(8))
+		state.AddBalance(uncle.Coinbase, r)
+
+		r.Div(blockReward, big.NewInt(32))
+		reward.Add(reward, r)
+	}
+	state.AddBalance(header.Coinbase, reward)
+}
+
+// rewardState applies the given reward function to every block of a synthetic
+// chain with varying uncle depths, returning the resulting state.
+func rewardState(t *testing.T, accumulate func(*state.StateDB, *types.Header, []*types.Header)) *state.StateDB {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to create state: %v", err)
+	}
+	for i := int64(1); i <= 32; i++ {
+		header := &types.Header{Number: big.NewInt(i), Coinbase: common.Address{byte(i % 4)}}
+
+		var uncles []*types.Header
+		for depth := int64(1); depth <= i%3 && depth < i; depth++ {
+			uncles = append(uncles, &types.Header{Number: big.NewInt(i - depth), Coinbase: common.Address{0x10, byte(i)}})
+		}
+		accumulate(statedb, header, uncles)
+	}
+	return statedb
+}
+
+// Tests that the default and explicitly configured mainnet reward schedules
+// produce the same state as the legacy hard coded rewards.
+func TestDefaultRewards(t *testing.T) {
+	want := rewardState(t, legacyAccumulateRewards).IntermediateRoot(false)
+
+	configs := map[string]*params.ChainConfig{
+		"no ethash config": {},
+		"empty schedule":   {Ethash: new(params.EthashConfig)},
+		"explicit mainnet": {Ethash: &params.EthashConfig{Rewards: []*params.EthashReward{{
+			Block:         big.NewInt(0),
+			BlockReward:   big.NewInt(5e+18),
+			UncleDivisor:  8,
+			NephewDivisor: 32,
+		}}}},
+	}
+	for name, config := range configs {
+		statedb := rewardState(t, func(state *state.StateDB, header *types.Header, uncles []*types.Header) {
+			AccumulateRewards(config, state, header, uncles)
+		})
+		if root := statedb.IntermediateRoot(false); root != want {
+			t.Errorf("%s: state root mismatch: have %x, want %x", name, root, want)
+		}
+	}
+}
+
+// Tests that a custom reward schedule switches rewards at the configured blocks
+// and pays the treasury.
+func TestRewardSchedule(t *testing.T) {
+	treasury := common.Address{0xff}
+	config := &params.ChainConfig{Ethash: &params.EthashConfig{Rewards: []*params.EthashReward{
+		{Block: big.NewInt(0), BlockReward: big.NewInt(1000), UncleDivisor: 8, NephewDivisor: 10},
+		{Block: big.NewInt(10), BlockReward: big.NewInt(500), Treasury: &treasury, TreasuryPayment: big.NewInt(50)},
+	}}}
+	tests := []struct {
+		number   int64
+		miner    int64
+		uncle    int64
+		treasury int64
+	}{
+		{number: 9, miner: 1000 + 100, uncle: 7 * 1000 / 8},
+		{number: 10, miner: 500, treasury: 50},
+	}
+	for _, tt := range tests {
+		db, _ := ethdb.NewMemDatabase()
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+		header := &types.Header{Number: big.NewInt(tt.number), Coinbase: common.Address{0x01}}
+		uncle := &types.Header{Number: big.NewInt(tt.number - 1), Coinbase: common.Address{0x02}}
+		AccumulateRewards(config, statedb, header, []*types.Header{uncle})
+
+		if balance := statedb.GetBalance(header.Coinbase); balance.Int64() != tt.miner {
+			t.Errorf("block %d: miner reward mismatch: have %v, want %d", tt.number, balance, tt.miner)
+		}
+		if balance := statedb.GetBalance(uncle.Coinbase); balance.Int64() != tt.uncle {
+			t.Errorf("block %d: uncle reward mismatch: have %v, want %d", tt.number, balance, tt.uncle)
+		}
+		if balance := statedb.GetBalance(treasury); balance.Int64() != tt.treasury {
+			t.Errorf("block %d: treasury payment mismatch: have %v, want %d", tt.number, balance, tt.treasury)
+		}
+	}
+}
+
+// Tests that the difficulty adjustment honours the target block time, bound
+// divisor and bomb settings of the ethash config.
+func TestCalcDifficultyConfig(t *testing.T) {
+	tests := []struct {
+		config *params.EthashConfig
+		number int64
+		delta  uint64
+		want   int64
+	}{
+		// Default Homestead-style adjustment without the bomb
+		{config: nil, number: 1000, delta: 5, want: 200000 + 97},
+		{config: nil, number: 1000, delta: 15, want: 200000},
+		{config: nil, number: 1000, delta: 25, want: 200000 - 97},
+		{config: nil, number: 300000, delta: 15, want: 200000},
+
+		// Longer target block time
+		{config: &params.EthashConfig{BlockTime: 30}, number: 1000, delta: 25, want: 200000 + 97},
+		{config: &params.EthashConfig{BlockTime: 30}, number: 1000, delta: 45, want: 200000},
+		{config: &params.EthashConfig{BlockTime: 30}, number: 1000, delta: 65, want: 200000 - 97},
+
+		// Faster adjustment with a smaller bound divisor
+		{config: &params.EthashConfig{BoundDivisor: big.NewInt(1024)}, number: 1000, delta: 5, want: 200000 + 195},
+
+		// Adjustment parameters only applying from their activation block
+		{config: &params.EthashConfig{BlockTime: 30, DifficultyBlock: big.NewInt(1000)}, number: 999, delta: 25, want: 200000 - 97},
+		{config: &params.EthashConfig{BlockTime: 30, DifficultyBlock: big.NewInt(1000)}, number: 1000, delta: 25, want: 200000 + 97},
+
+		// Enabled and delayed bombs
+		{config: &params.EthashConfig{Bomb: true}, number: 300000, delta: 15, want: 200000 + 2},
+		{config: &params.EthashConfig{Bomb: true, BombDelay: big.NewInt(100000)}, number: 300000, delta: 15, want: 200000 + 1},
+		{config: &params.EthashConfig{Bomb: true, BombDelay: big.NewInt(250000)}, number: 300000, delta: 15, want: 200000},
+		{config: &params.EthashConfig{Bomb: true, BombDelay: big.NewInt(500000)}, number: 300000, delta: 15, want: 200000},
+	}
+	for i, tt := range tests {
+		parent := &types.Header{
+			Number:     big.NewInt(tt.number - 1),
+			Time:       big.NewInt(1000),
+			Difficulty: big.NewInt(200000),
+		}
+		config := &params.ChainConfig{Ethash: tt.config}
+		if diff := CalcDifficulty(config, 1000+tt.delta, parent); diff.Int64() != tt.want {
+			t.Errorf("test %d: difficulty mismatch: have %v, want %d", i, diff, tt.want)
+		}
+	}
+}
+
+// testerChainReader implements consensus.ChainReader to provide a chain config.
+// All other methods and requests will panic.
+type testerChainReader struct {
+	config *params.ChainConfig
+}
+
+func (r *testerChainReader) Config() *params.ChainConfig                 { return r.config }
+func (r *testerChainReader) CurrentHeader() *types.Header                { panic("not supported") }
+func (r *testerChainReader) GetHeader(common.Hash, uint64) *types.Header { panic("not supported") }
+func (r *testerChainReader) GetBlock(common.Hash, uint64) *types.Block   { panic("not supported") }
+func (r *testerChainReader) GetHeaderByHash(common.Hash) *types.Header   { panic("not supported") }
+func (r *testerChainReader) GetHeaderByNumber(uint64) *types.Header      { panic("not supported") }
+
+// Tests that header verification checks the difficulty against the configured
+// adjustment rules.
+func TestVerifyHeaderDifficultyConfig(t *testing.T) {
+	parent := &types.Header{
+		Number:     big.NewInt(1),
+		Time:       big.NewInt(1000),
+		Difficulty: big.NewInt(200000),
+		GasLimit:   big.NewInt(4712388),
+		GasUsed:    new(big.Int),
+	}
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     big.NewInt(2),
+		Time:       big.NewInt(1025),
+		GasLimit:   big.NewInt(4712388),
+		GasUsed:    new(big.Int),
+	}
+	defaults := &params.ChainConfig{Ethash: new(params.EthashConfig)}
+	slower := &params.ChainConfig{Ethash: &params.EthashConfig{BlockTime: 30}}
+
+	header.Difficulty = CalcDifficulty(defaults, header.Time.Uint64(), parent)
+	if err := NewFaker().verifyHeader(&testerChainReader{defaults}, header, parent, false, false); err != nil {
+		t.Errorf("default difficulty rejected by default rules: %v", err)
+	}
+	if err := NewFaker().verifyHeader(&testerChainReader{slower}, header, parent, false, false); err == nil {
+		t.Errorf("default difficulty accepted by 30s block time rules")
+	}
+	header.Difficulty = CalcDifficulty(slower, header.Time.Uint64(), parent)
+	if err := NewFaker().verifyHeader(&testerChainReader{slower}, header, parent, false, false); err != nil {
+		t.Errorf("30s block time difficulty rejected by 30s block time rules: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package transition implements a consensus engine switching a chain from
+// proof-of-work to proof-of-authority at a configured block.
+package transition
+
+import (
+	"math/big"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/consensus"
+	"github.com/immesys/bw2bc/consensus/clique"
+	"github.com/immesys/bw2bc/core/state"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/rpc"
+)
+
+// Transition is a composite consensus engine, delegating every operation on a
+// block to the proof-of-work engine before the transition block and to clique
+// from the transition block onwards.
+type Transition struct {
+	block  *big.Int         // First block sealed by clique
+	pow    consensus.Engine // Engine sealing the blocks before the transition
+	clique *clique.Clique   // Engine sealing the blocks after the transition
+}
+
+// New creates a composite engine switching from pow to clique at the given
+// block number.
+func New(block *big.Int, pow consensus.Engine, clique *clique.Clique) *Transition {
+	return &Transition{
+		block:  new(big.Int).Set(block),
+		pow:    pow,
+		clique: clique,
+	}
+}
+
+// Clique returns the proof-of-authority engine, e.g. to authorize a signer.
+func (t *Transition) Clique() *clique.Clique {
+	return t.clique
+}
+
+// engine returns the consensus engine responsible for the given block number.
+func (t *Transition) engine(number *big.Int) consensus.Engine {
+	if number != nil && number.Cmp(t.block) >= 0 {
+		return t.clique
+	}
+	return t.pow
+}
+
+// Author implements consensus.Engine, returning the author as determined by the
+// engine responsible for the header.
+func (t *Transition) Author(header *types.Header) (common.Address, error) {
+	return t.engine(header.Number).Author(header)
+}
+
+// VerifyHeader implements consensus.Engine, checking the header against the
+// rules of the engine responsible for it.
+func (t *Transition) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return t.engine(header.Number).VerifyHeader(chain, header, seal)
+}
+
+// VerifyHeaders implements consensus.Engine, splitting the batch at the
+// transition block and verifying both parts concurrently with their engines.
+// The results are delivered in the order of the input slice.
+func (t *Transition) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	split := len(headers)
+	for i, header := range headers {
+		if t.engine(header.Number) == consensus.Engine(t.clique) {
+			split = i
+			break
+		}
+	}
+	// Short circuit if the batch doesn't cross the transition
+	if split == 0 {
+		return t.clique.VerifyHeaders(chain, headers, seals)
+	}
+	if split == len(headers) {
+		return t.pow.VerifyHeaders(chain, headers, seals)
+	}
+	// The batch crosses the transition, the clique part may need the headers of
+	// the proof-of-work part as ancestors
+	powAbort, powResults := t.pow.VerifyHeaders(chain, headers[:split], seals[:split])
+	poaAbort, poaResults := t.clique.VerifyHeaders(&batchChain{chain, headers[:split]}, headers[split:], seals[split:])
+
+	var (
+		abort   = make(chan struct{})
+		results = make(chan error, len(headers))
+	)
+	go func() {
+		defer close(powAbort)
+		defer close(poaAbort)
+
+		for _, source := range []<-chan error{powResults, poaResults} {
+			n := split
+			if source == poaResults {
+				n = len(headers) - split
+			}
+			for i := 0; i < n; i++ {
+				select {
+				case <-abort:
+					return
+				case err := <-source:
+					results <- err
+				}
+			}
+		}
+	}()
+	return abort, results
+}
+
+// VerifyUncles implements consensus.Engine, verifying the uncles according to
+// the engine responsible for the block.
+func (t *Transition) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	return t.engine(block.Number()).VerifyUncles(chain, block)
+}
+
+// VerifySeal implements consensus.Engine, checking the seal of the header with
+// the engine responsible for it.
+func (t *Transition) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	return t.engine(header.Number).VerifySeal(chain, header)
+}
+
+// Prepare implements consensus.Engine, initializing the consensus fields of the
+// header according to the engine responsible for it.
+func (t *Transition) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	return t.engine(header.Number).Prepare(chain, header)
+}
+
+// Finalize implements consensus.Engine, applying the post-transaction rules of
+// the engine responsible for the header (i.e. mining rewards only before the
+// transition) and assembling the final block.
+func (t *Transition) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	return t.engine(header.Number).Finalize(chain, header, state, txs, uncles, receipts)
+}
+
+// Seal implements consensus.Engine, sealing the block with the engine responsible
+// for it.
+func (t *Transition) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	return t.engine(block.Number()).Seal(chain, block, stop)
+}
+
+// APIs implements consensus.Engine, returning the RPC APIs of both engines.
+func (t *Transition) APIs(chain consensus.ChainReader) []rpc.API {
+	return append(t.pow.APIs(chain), t.clique.APIs(chain)...)
+}
+
+// Hashrate implements consensus.PoW, returning the hashrate of the proof-of-work
+// engine, which drops to zero once the chain has transitioned.
+func (t *Transition) Hashrate() float64 {
+	if pow, ok := t.pow.(consensus.PoW); ok {
+		return pow.Hashrate()
+	}
+	return 0
+}
+
+// SetThreads updates the number of mining threads of the proof-of-work engine,
+// if it supports local mining.
+func (t *Transition) SetThreads(threads int) {
+	if th, ok := t.pow.(interface {
+		SetThreads(threads int)
+	}); ok {
+		th.SetThreads(threads)
+	}
+}
+
+// batchChain is a chain reader that also resolves the headers of a batch being
+// verified, which aren't in the database yet.
+type batchChain struct {
+	consensus.ChainReader
+	headers []*types.Header
+}
+
+// GetHeader retrieves a block header by hash and number, checking the batch
+// before the database.
+func (c *batchChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	for _, header := range c.headers {
+		if header.Number.Uint64() == number && header.Hash() == hash {
+			return header
+		}
+	}
+	return c.ChainReader.GetHeader(hash, number)
+}
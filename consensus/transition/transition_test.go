@@ -0,0 +1,229 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package transition
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/consensus/clique"
+	"github.com/immesys/bw2bc/consensus/ethash"
+	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/core/vm"
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/crypto/sha3"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/event"
+	"github.com/immesys/bw2bc/params"
+	"github.com/immesys/bw2bc/rlp"
+)
+
+var (
+	signerKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	signerAddr   = crypto.PubkeyToAddress(signerKey.PublicKey)
+	minerAddr    = common.Address{0x01}
+)
+
+// transitionTester is a test environment with a chain switching from ethash to
+// clique at block 3.
+type transitionTester struct {
+	config  *params.ChainConfig
+	db      ethdb.Database
+	genesis *types.Block
+	engine  *Transition
+	chain   *core.BlockChain
+}
+
+func newTransitionTester(t *testing.T) *transitionTester {
+	config := *params.TestChainConfig
+	config.CliqueTransitionBlock = big.NewInt(3)
+	config.Clique = &params.CliqueConfig{Period: 5, Epoch: 30000, Signers: []common.Address{signerAddr}}
+
+	db, _ := ethdb.NewMemDatabase()
+	genesis := (&core.Genesis{Config: &config}).MustCommit(db)
+
+	engine := New(config.CliqueTransitionBlock, ethash.NewFaker(), clique.New(config.Clique, db))
+	chain, err := core.NewBlockChain(db, &config, engine, new(event.TypeMux), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return &transitionTester{config: &config, db: db, genesis: genesis, engine: engine, chain: chain}
+}
+
+// makeChain generates n blocks on top of parent, sealing the ones after the
+// transition with the given clique signer key.
+func (tt *transitionTester) makeChain(parent *types.Block, n int, coinbase common.Address, key *ecdsa.PrivateKey) []*types.Block {
+	var blocks []*types.Block
+	for i := 0; i < n; i++ {
+		number := new(big.Int).Add(parent.Number(), common.Big1)
+		poa := tt.config.IsCliqueTransition(number)
+
+		generated, _ := core.GenerateChain(tt.config, parent, tt.db, 1, func(i int, b *core.BlockGen) {
+			if poa {
+				b.SetCoinbase(common.Address{})
+			} else {
+				b.SetCoinbase(coinbase)
+			}
+		})
+		block := generated[0]
+		if poa && key != nil {
+			block = sealClique(block, key)
+		}
+		blocks = append(blocks, block)
+		parent = block
+	}
+	return blocks
+}
+
+// sealClique replaces the ethash seal of a generated block with an in-turn clique
+// signature.
+func sealClique(block *types.Block, key *ecdsa.PrivateKey) *types.Block {
+	header := block.Header()
+	header.Difficulty = big.NewInt(2)
+	header.Extra = make([]byte, 32+65)
+	header.MixDigest = common.Hash{}
+	header.Nonce = types.BlockNonce{}
+
+	sig, err := crypto.Sign(sigHash(header).Bytes(), key)
+	if err != nil {
+		panic(err)
+	}
+	copy(header.Extra[32:], sig)
+	return block.WithSeal(header)
+}
+
+// sigHash mirrors the hash clique signs, i.e. the header without the seal.
+func sigHash(header *types.Header) (hash common.Hash) {
+	hasher := sha3.NewKeccak256()
+	rlp.Encode(hasher, []interface{}{
+		header.ParentHash,
+		header.UncleHash,
+		header.Coinbase,
+		header.Root,
+		header.TxHash,
+		header.ReceiptHash,
+		header.Bloom,
+		header.Difficulty,
+		header.Number,
+		header.GasLimit,
+		header.GasUsed,
+		header.Time,
+		header.Extra[:len(header.Extra)-65],
+		header.MixDigest,
+		header.Nonce,
+	})
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// Tests that a chain crossing the transition block is accepted, and that mining
+// rewards stop once clique takes over.
+func TestTransition(t *testing.T) {
+	tt := newTransitionTester(t)
+
+	blocks := tt.makeChain(tt.genesis, 5, minerAddr, signerKey)
+	if _, err := tt.chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain across transition: %v", err)
+	}
+	if head := tt.chain.CurrentBlock().NumberU64(); head != 5 {
+		t.Fatalf("head mismatch: have %d, want %d", head, 5)
+	}
+	// Verify the authors and the accumulated rewards
+	for _, block := range blocks {
+		author, err := tt.engine.Author(block.Header())
+		if err != nil {
+			t.Fatalf("block %d: failed to retrieve author: %v", block.NumberU64(), err)
+		}
+		want := minerAddr
+		if tt.config.IsCliqueTransition(block.Number()) {
+			want = signerAddr
+		}
+		if author != want {
+			t.Errorf("block %d: author mismatch: have %x, want %x", block.NumberU64(), author, want)
+		}
+	}
+	pre, err := tt.chain.StateAt(blocks[1].Root())
+	if err != nil {
+		t.Fatalf("failed to retrieve pre-transition state: %v", err)
+	}
+	post, err := tt.chain.StateAt(blocks[4].Root())
+	if err != nil {
+		t.Fatalf("failed to retrieve post-transition state: %v", err)
+	}
+	if pre.GetBalance(minerAddr).Sign() == 0 {
+		t.Errorf("no mining rewards before the transition")
+	}
+	if pre.GetBalance(minerAddr).Cmp(post.GetBalance(minerAddr)) != 0 {
+		t.Errorf("mining rewards after the transition: have %v, want %v", post.GetBalance(minerAddr), pre.GetBalance(minerAddr))
+	}
+	if balance := post.GetBalance(common.Address{}); balance.Sign() != 0 {
+		t.Errorf("clique blocks rewarded: have %v, want 0", balance)
+	}
+}
+
+// Tests that blocks from the transition onwards are rejected unless sealed by an
+// authorized clique signer.
+func TestTransitionSeal(t *testing.T) {
+	tt := newTransitionTester(t)
+
+	blocks := tt.makeChain(tt.genesis, 2, minerAddr, nil)
+	if _, err := tt.chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert pre-transition chain: %v", err)
+	}
+	// A proof-of-work block at the transition must be rejected
+	unsealed := tt.makeChain(blocks[1], 1, minerAddr, nil)
+	if _, err := tt.chain.InsertChain(unsealed); err == nil {
+		t.Errorf("proof-of-work block accepted after transition")
+	}
+	// A block signed by an unauthorized signer must be rejected
+	key, _ := crypto.GenerateKey()
+	unauthorized := tt.makeChain(blocks[1], 1, minerAddr, key)
+	if _, err := tt.chain.InsertChain(unauthorized); err == nil {
+		t.Errorf("unauthorized block accepted after transition")
+	}
+	// A block signed by the initial signer must be accepted
+	authorized := tt.makeChain(blocks[1], 1, minerAddr, signerKey)
+	if _, err := tt.chain.InsertChain(authorized); err != nil {
+		t.Errorf("authorized block rejected: %v", err)
+	}
+}
+
+// Tests that the chain reorganises onto a heavier fork branching off before the
+// transition block.
+func TestTransitionReorg(t *testing.T) {
+	tt := newTransitionTester(t)
+
+	blocks := tt.makeChain(tt.genesis, 4, minerAddr, signerKey)
+	if _, err := tt.chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert canonical chain: %v", err)
+	}
+	fork := tt.makeChain(blocks[0], 4, common.Address{0x02}, signerKey)
+	if _, err := tt.chain.InsertChain(fork); err != nil {
+		t.Fatalf("failed to insert fork: %v", err)
+	}
+	if head := tt.chain.CurrentBlock().Hash(); head != fork[3].Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", head, fork[3].Hash())
+	}
+	for _, block := range append(blocks[:1], fork...) {
+		if hash := core.GetCanonicalHash(tt.db, block.NumberU64()); hash != block.Hash() {
+			t.Errorf("block %d: canonical hash mismatch: have %x, want %x", block.NumberU64(), hash, block.Hash())
+		}
+	}
+}
@@ -112,7 +112,7 @@ func init() {
 func genTxRing(naccounts int) func(int, *BlockGen) {
 	from := 0
 	return func(i int, gen *BlockGen) {
-		gas := CalcGasLimit(gen.PrevBlock(i - 1))
+		gas := CalcGasLimit(gen.PrevBlock(i-1), params.TargetGasLimit, math.MaxBig256)
 		for {
 			gas.Sub(gas, bigTxGas)
 			if gas.Cmp(bigTxGas) < 0 {
@@ -100,10 +100,11 @@ func (v *BlockValidator) ValidateState(block, parent *types.Block, statedb *stat
 	return nil
 }
 
-// CalcGasLimit computes the gas limit of the next block after parent.
+// CalcGasLimit computes the gas limit of the next block after parent, honing it
+// towards the [gasFloor, gasCeil] range if it falls outside of it.
 // The result may be modified by the caller.
 // This is miner strategy, not consensus protocol.
-func CalcGasLimit(parent *types.Block) *big.Int {
+func CalcGasLimit(parent *types.Block, gasFloor, gasCeil *big.Int) *big.Int {
 	// contrib = (parentGasUsed * 3 / 2) / 1024
 	contrib := new(big.Int).Mul(parent.GasUsed(), big.NewInt(3))
 	contrib = contrib.Div(contrib, big.NewInt(2))
@@ -124,11 +125,15 @@ func CalcGasLimit(parent *types.Block) *big.Int {
 	gl = gl.Add(gl, contrib)
 	gl.Set(math.BigMax(gl, params.MinGasLimit))
 
-	// however, if we're now below the target (TargetGasLimit) we increase the
-	// limit as much as we can (parentGasLimit / 1024 -1)
-	if gl.Cmp(params.TargetGasLimit) < 0 {
+	// however, if we're now below the target (gasFloor) we increase the limit
+	// as much as we can (parentGasLimit / 1024 -1), and similarly decrease it
+	// if we're above the allowed maximum (gasCeil)
+	if gl.Cmp(gasFloor) < 0 {
 		gl.Add(parent.GasLimit(), decay)
-		gl.Set(math.BigMin(gl, params.TargetGasLimit))
+		gl.Set(math.BigMin(gl, gasFloor))
+	} else if gl.Cmp(gasCeil) > 0 {
+		gl.Sub(parent.GasLimit(), decay)
+		gl.Set(math.BigMax(gl, gasCeil))
 	}
 	return gl
 }
@@ -17,10 +17,12 @@
 package core
 
 import (
+	"math/big"
 	"runtime"
 	"testing"
 	"time"
 
+	"github.com/immesys/bw2bc/common/math"
 	"github.com/immesys/bw2bc/consensus/ethash"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/core/vm"
@@ -193,3 +195,32 @@ func testHeaderConcurrentAbortion(t *testing.T, threads int) {
 		t.Errorf("verification count too large: have %d, want below %d", verified, 2*threads)
 	}
 }
+
+// Tests that the gas limit of mined blocks is steered towards the configured
+// floor and ceiling, but only as fast as the protocol permits.
+func TestCalcGasLimit(t *testing.T) {
+	tests := []struct {
+		used, floor, ceil int64
+		want              int64
+	}{
+		{0, 4000000, 0, 4995119},             // Empty parent within range, decay
+		{0, 6000000, 0, 5004881},             // Below the floor, raise as much as allowed
+		{0, 5000000, 5000000, 5000000},       // Below a close floor, raise exactly to it
+		{5000000, 4000000, 0, 5002443},       // Full parent within range, grow
+		{5000000, 4000000, 4999000, 4999000}, // Above a close ceiling, lower exactly to it
+		{5000000, 4000000, 4000000, 4995119}, // Above the ceiling, lower as much as allowed
+	}
+	for i, tt := range tests {
+		parent := types.NewBlockWithHeader(&types.Header{
+			GasLimit: big.NewInt(5000000),
+			GasUsed:  big.NewInt(tt.used),
+		})
+		ceil := math.MaxBig256
+		if tt.ceil != 0 {
+			ceil = big.NewInt(tt.ceil)
+		}
+		if limit := CalcGasLimit(parent, big.NewInt(tt.floor), ceil); limit.Int64() != tt.want {
+			t.Errorf("test %d: gas limit mismatch: have %v, want %v", i, limit, tt.want)
+		}
+	}
+}
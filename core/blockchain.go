@@ -34,6 +34,7 @@ import (
 	"github.com/immesys/bw2bc/common/mclock"
 	"github.com/immesys/bw2bc/consensus"
 	"github.com/immesys/bw2bc/core/state"
+	"github.com/immesys/bw2bc/core/state/snapshot"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/core/vm"
 	"github.com/immesys/bw2bc/crypto"
@@ -58,6 +59,7 @@ const (
 	maxFutureBlocks     = 256
 	maxTimeFutureBlocks = 30
 	badBlockLimit       = 10
+	snapshotLayers      = 128
 
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	BlockChainVersion = 3
@@ -94,11 +96,16 @@ type BlockChain struct {
 	currentFastBlock *types.Block // Current head of the fast-sync chain (may be above the block chain!)
 
 	stateCache   state.Database // State database to reuse between imports (contains state cache)
+	snaps        *snapshot.Tree // Flat state snapshot for fast reads, nil if disabled
 	bodyCache    *lru.Cache     // Cache for the most recent block bodies
 	bodyRLPCache *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
 	blockCache   *lru.Cache     // Cache for the most recent entire blocks
 	futureBlocks *lru.Cache     // future blocks are blocks added for later processing
 
+	txLookupLimit uint64     // Number of recent blocks to keep transactions indexed for, 0 = all (atomic)
+	txIndexing    int32      // Flag whether the background transaction indexer runs (atomic)
+	txIndexLock   sync.Mutex // Serialises the transaction index tail updates
+
 	quit    chan struct{} // blockchain quit channel
 	running int32         // running must be called atomically
 	// procInterrupt must be atomically called
@@ -170,6 +177,16 @@ func NewBlockChain(chainDb ethdb.Database, config *params.ChainConfig, engine co
 	return bc, nil
 }
 
+// EnableSnapshot starts maintaining a flat snapshot of the state alongside the
+// chain, loading it from the database or regenerating it in the background. It
+// must be called before any blocks are processed.
+func (bc *BlockChain) EnableSnapshot() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.snaps = snapshot.New(bc.chainDb, bc.currentBlock.Root())
+}
+
 func (bc *BlockChain) getProcInterrupt() bool {
 	return atomic.LoadInt32(&bc.procInterrupt) == 1
 }
@@ -279,7 +296,14 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	if err := WriteHeadFastBlockHash(bc.chainDb, bc.currentFastBlock.Hash()); err != nil {
 		log.Crit("Failed to reset head fast block", "err", err)
 	}
-	return bc.loadLastState()
+	if err := bc.loadLastState(); err != nil {
+		return err
+	}
+	// The snapshot is ahead of the rewound chain, regenerate it
+	if bc.snaps != nil && bc.snaps.Snapshot(bc.currentBlock.Root()) == nil {
+		bc.snaps.Rebuild(bc.currentBlock.Root())
+	}
+	return nil
 }
 
 // FastSyncCommitHead sets the current head block to the one defined by the hash
@@ -386,7 +410,7 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 
 // StateAt returns a new mutable state based on a particular point in time.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache)
+	return state.NewWithSnapshot(root, bc.stateCache, bc.snaps)
 }
 
 // Reset purges the entire blockchain, restoring it to its genesis state.
@@ -605,6 +629,11 @@ func (bc *BlockChain) Stop() {
 	atomic.StoreInt32(&bc.procInterrupt, 1)
 
 	bc.wg.Wait()
+
+	// Persist the state snapshot so it can be reused after a restart
+	if bc.snaps != nil {
+		bc.snaps.Stop(bc.CurrentBlock().Root())
+	}
 	log.Info("Blockchain manager stopped")
 }
 
@@ -760,22 +789,10 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 				log.Crit("Failed to write block receipts", "err", err)
 				return
 			}
-			if err := WriteMipmapBloom(bc.chainDb, block.NumberU64(), receipts); err != nil {
-				errs[index] = fmt.Errorf("failed to write log blooms: %v", err)
-				atomic.AddInt32(&failed, 1)
-				log.Crit("Failed to write log blooms", "err", err)
-				return
-			}
-			if err := WriteTransactions(bc.chainDb, block); err != nil {
-				errs[index] = fmt.Errorf("failed to write individual transactions: %v", err)
-				atomic.AddInt32(&failed, 1)
-				log.Crit("Failed to write individual transactions", "err", err)
-				return
-			}
-			if err := WriteReceipts(bc.chainDb, receipts); err != nil {
-				errs[index] = fmt.Errorf("failed to write individual receipts: %v", err)
+			if err := WriteTxLookupEntries(bc.chainDb, block); err != nil {
+				errs[index] = fmt.Errorf("failed to write transaction lookup entries: %v", err)
 				atomic.AddInt32(&failed, 1)
-				log.Crit("Failed to write individual receipts", "err", err)
+				log.Crit("Failed to write transaction lookup entries", "err", err)
 				return
 			}
 			atomic.AddInt32(&stats.processed, 1)
@@ -863,6 +880,16 @@ func (bc *BlockChain) WriteBlock(block *types.Block) (status WriteStatus, err er
 		}
 		bc.insert(block) // Insert the block as the new head of the chain
 		status = CanonStatTy
+
+		// Keep the snapshot tree bounded, or regenerate it if the new head's
+		// state was never tracked (e.g. after a fast sync or a deep reorg)
+		if bc.snaps != nil {
+			if bc.snaps.Snapshot(block.Root()) == nil {
+				bc.snaps.Rebuild(block.Root())
+			} else if err := bc.snaps.Cap(block.Root(), snapshotLayers); err != nil {
+				log.Warn("Failed to cap state snapshot", "err", err)
+			}
+		}
 	} else {
 		status = SideStatTy
 	}
@@ -967,7 +994,7 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 		} else {
 			parent = chain[i-1]
 		}
-		state, err := state.New(parent.Root(), bc.stateCache)
+		state, err := state.NewWithSnapshot(parent.Root(), bc.stateCache, bc.snaps)
 		if err != nil {
 			return i, err
 		}
@@ -1009,16 +1036,8 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 			blockInsertTimer.UpdateSince(bstart)
 			events = append(events, ChainEvent{block, block.Hash(), logs})
 
-			// This puts transactions in a extra db for rpc
-			if err := WriteTransactions(bc.chainDb, block); err != nil {
-				return i, err
-			}
-			// store the receipts
-			if err := WriteReceipts(bc.chainDb, receipts); err != nil {
-				return i, err
-			}
-			// Write map map bloom filters
-			if err := WriteMipmapBloom(bc.chainDb, block.NumberU64(), receipts); err != nil {
+			// Index the transactions for hash based lookups
+			if err := WriteTxLookupEntries(bc.chainDb, block); err != nil {
 				return i, err
 			}
 			// Write hash preimages
@@ -1174,17 +1193,8 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 	for _, block := range newChain {
 		// insert the block in the canonical way, re-writing history
 		bc.insert(block)
-		// write canonical receipts and transactions
-		if err := WriteTransactions(bc.chainDb, block); err != nil {
-			return err
-		}
-		receipts := GetBlockReceipts(bc.chainDb, block.Hash(), block.NumberU64())
-		// write receipts
-		if err := WriteReceipts(bc.chainDb, receipts); err != nil {
-			return err
-		}
-		// Write map map bloom filters
-		if err := WriteMipmapBloom(bc.chainDb, block.NumberU64(), receipts); err != nil {
+		// index the canonical transactions
+		if err := WriteTxLookupEntries(bc.chainDb, block); err != nil {
 			return err
 		}
 		addedTxs = append(addedTxs, block.Transactions()...)
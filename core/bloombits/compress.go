@@ -0,0 +1,40 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+)
+
+// CompressBits compresses a rotated bloom bit vector for storage or transfer.
+// Most bits of a vector are zero, so even a simple compressor shrinks them well.
+func CompressBits(bits []byte) []byte {
+	return snappy.Encode(nil, bits)
+}
+
+// DecompressBits decompresses a rotated bloom bit vector, verifying that it
+// has the expected length in bytes.
+func DecompressBits(data []byte, length int) ([]byte, error) {
+	if n, err := snappy.DecodedLen(data); err != nil {
+		return nil, err
+	} else if n != length {
+		return nil, fmt.Errorf("invalid bit vector length: have %d, want %d", n, length)
+	}
+	return snappy.Decode(nil, data)
+}
@@ -0,0 +1,233 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/immesys/bw2bc/crypto"
+)
+
+// bloomIndexes represents the bit indexes inside the bloom filter that belong
+// to some key.
+type bloomIndexes [3]uint
+
+// calcBloomIndexes returns the bloom filter bit indexes belonging to the given key.
+func calcBloomIndexes(b []byte) bloomIndexes {
+	b = crypto.Keccak256(b)
+
+	var idxs bloomIndexes
+	for i := 0; i < len(idxs); i++ {
+		idxs[i] = (uint(b[2*i])<<8)&2047 + uint(b[2*i+1])
+	}
+	return idxs
+}
+
+// Retriever is a callback to fetch the bit vectors of a single bloom bit for a
+// batch of sections, in the same order as requested.
+type Retriever func(ctx context.Context, bit uint, sections []uint64) ([][]byte, error)
+
+const (
+	// matcherBatch is the number of sections retrieved and matched together.
+	matcherBatch = 16
+
+	// matcherFetchers is the maximum number of concurrent bit vector retrievals.
+	matcherFetchers = 16
+)
+
+// Matcher is a pipelined system of bloom bit retrievals and binary operations
+// that filters a range of sections against a set of address and topic groups.
+// Within a group any key may match (OR), while all groups need to match (AND).
+type Matcher struct {
+	sectionSize uint64           // Size of the data batches to filter on
+	filters     [][]bloomIndexes // Filter the system is matching for
+}
+
+// NewMatcher creates a new pipeline for retrieving bloom bit streams and doing
+// address and topic filtering on them. Setting a filter component to nil or an
+// empty group is allowed and means a wildcard for that position.
+func NewMatcher(sectionSize uint64, filters [][][]byte) *Matcher {
+	m := &Matcher{sectionSize: sectionSize}
+	for _, filter := range filters {
+		// Gather the bit indexes of the filter rule, special casing the nil filter
+		if len(filter) == 0 {
+			continue
+		}
+		bloomBits := make([]bloomIndexes, len(filter))
+		for i, clause := range filter {
+			if clause == nil {
+				bloomBits = nil
+				break
+			}
+			bloomBits[i] = calcBloomIndexes(clause)
+		}
+		// Accumulate the filter rules if no nil rule was within
+		if bloomBits != nil {
+			m.filters = append(m.filters, bloomBits)
+		}
+	}
+	return m
+}
+
+// Run matches the sections covering the [begin, end] block range against the
+// filters, sending the numbers of the potentially matching blocks in ascending
+// order on the results channel. The channel is closed when the run terminates,
+// after which the returned error is available.
+func (m *Matcher) Run(ctx context.Context, begin, end uint64, retrieve Retriever, results chan<- uint64) error {
+	defer close(results)
+
+	for first := begin / m.sectionSize; first <= end/m.sectionSize; first += matcherBatch {
+		sections := make([]uint64, 0, matcherBatch)
+		for section := first; section < first+matcherBatch && section <= end/m.sectionSize; section++ {
+			sections = append(sections, section)
+		}
+		vectors, err := m.fetch(ctx, retrieve, sections)
+		if err != nil {
+			return err
+		}
+		for i, section := range sections {
+			match := m.match(vectors, i)
+
+			from, to := section*m.sectionSize, (section+1)*m.sectionSize-1
+			if from < begin {
+				from = begin
+			}
+			if to > end {
+				to = end
+			}
+			for number := from; number <= to; number++ {
+				offset := number - section*m.sectionSize
+				if match != nil && match[offset/8]&(1<<(7-offset%8)) == 0 {
+					continue
+				}
+				select {
+				case results <- number:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fetch retrieves the bit vectors of every bloom bit needed by the filters for
+// the given sections concurrently.
+func (m *Matcher) fetch(ctx context.Context, retrieve Retriever, sections []uint64) (map[uint][][]byte, error) {
+	var (
+		vectors = make(map[uint][][]byte)
+		lock    sync.Mutex
+		pend    sync.WaitGroup
+		failure error
+		slots   = make(chan struct{}, matcherFetchers)
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for _, group := range m.filters {
+		for _, idxs := range group {
+			for _, bit := range idxs {
+				if _, ok := vectors[bit]; ok {
+					continue
+				}
+				vectors[bit] = nil
+
+				pend.Add(1)
+				go func(bit uint) {
+					defer pend.Done()
+
+					select {
+					case slots <- struct{}{}:
+						defer func() { <-slots }()
+					case <-ctx.Done():
+						return
+					}
+					bitsets, err := retrieve(ctx, bit, sections)
+					if err == nil && len(bitsets) != len(sections) {
+						err = fmt.Errorf("bit %d: vector count mismatch: have %d, want %d", bit, len(bitsets), len(sections))
+					}
+					for i := 0; err == nil && i < len(bitsets); i++ {
+						if uint64(len(bitsets[i])) != m.sectionSize/8 {
+							err = fmt.Errorf("bit %d section %d: vector length mismatch: have %d, want %d", bit, sections[i], len(bitsets[i]), m.sectionSize/8)
+						}
+					}
+					lock.Lock()
+					defer lock.Unlock()
+
+					if err != nil {
+						if failure == nil {
+							failure = err
+							cancel()
+						}
+						return
+					}
+					vectors[bit] = bitsets
+				}(bit)
+			}
+		}
+	}
+	pend.Wait()
+
+	if failure == nil && ctx.Err() != nil {
+		failure = ctx.Err()
+	}
+	return vectors, failure
+}
+
+// match computes the match vector of a single section (by index into the fetched
+// batch), or nil if the matcher has no filters at all and matches everything.
+func (m *Matcher) match(vectors map[uint][][]byte, index int) []byte {
+	if len(m.filters) == 0 {
+		return nil
+	}
+	var result []byte
+	for _, group := range m.filters {
+		// Calculate the group vector as an OR of all the keys' AND-ed bits
+		groupVec := make([]byte, m.sectionSize/8)
+		for _, idxs := range group {
+			keyVec := make([]byte, m.sectionSize/8)
+			copy(keyVec, vectors[idxs[0]][index])
+			for _, bit := range idxs[1:] {
+				andVector(keyVec, vectors[bit][index])
+			}
+			orVector(groupVec, keyVec)
+		}
+		// AND the group into the running result
+		if result == nil {
+			result = groupVec
+		} else {
+			andVector(result, groupVec)
+		}
+	}
+	return result
+}
+
+// andVector computes the bitwise AND of two bit vectors into the first.
+func andVector(dst, src []byte) {
+	for i := 0; i < len(dst); i++ {
+		dst[i] &= src[i]
+	}
+}
+
+// orVector computes the bitwise OR of two bit vectors into the first.
+func orVector(dst, src []byte) {
+	for i := 0; i < len(dst); i++ {
+		dst[i] |= src[i]
+	}
+}
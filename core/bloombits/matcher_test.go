@@ -0,0 +1,170 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/immesys/bw2bc/core/types"
+)
+
+const testSectionSize = 64
+
+// makeTestBlooms generates the header blooms of the given number of blocks, with
+// every block mentioning the key of its own number and every fifth one the key
+// of the test address.
+func makeTestBlooms(blocks int) []types.Bloom {
+	blooms := make([]types.Bloom, blocks)
+	for i := range blooms {
+		blooms[i].Add(new(big.Int).SetBytes([]byte{byte(i), byte(i >> 8)}))
+		if i%5 == 0 {
+			blooms[i].Add(new(big.Int).SetBytes([]byte("address")))
+		}
+	}
+	return blooms
+}
+
+// makeRetriever rotates the given blooms into sections and returns a retriever
+// serving the bit vectors out of memory.
+func makeRetriever(t *testing.T, blooms []types.Bloom) Retriever {
+	var sections []*Generator
+	for i := 0; i < len(blooms); i += testSectionSize {
+		gen, err := NewGenerator(testSectionSize)
+		if err != nil {
+			t.Fatalf("failed to create generator: %v", err)
+		}
+		for j := 0; j < testSectionSize; j++ {
+			if err := gen.AddBloom(uint(j), blooms[i+j]); err != nil {
+				t.Fatalf("failed to add bloom %d: %v", i+j, err)
+			}
+		}
+		sections = append(sections, gen)
+	}
+	return func(ctx context.Context, bit uint, numbers []uint64) ([][]byte, error) {
+		vectors := make([][]byte, len(numbers))
+		for i, section := range numbers {
+			var err error
+			if vectors[i], err = sections[section].Bitset(bit); err != nil {
+				return nil, err
+			}
+		}
+		return vectors, nil
+	}
+}
+
+// Tests that the rotated bloom bits match the original header blooms.
+func TestGenerator(t *testing.T) {
+	blooms := makeTestBlooms(testSectionSize)
+
+	gen, err := NewGenerator(testSectionSize)
+	if err != nil {
+		t.Fatalf("failed to create generator: %v", err)
+	}
+	for i, bloom := range blooms {
+		if err := gen.AddBloom(uint(i), bloom); err != nil {
+			t.Fatalf("failed to add bloom %d: %v", i, err)
+		}
+	}
+	if err := gen.AddBloom(testSectionSize, blooms[0]); err != errSectionOutOfBounds {
+		t.Errorf("overflow error mismatch: have %v, want %v", err, errSectionOutOfBounds)
+	}
+	for bit := uint(0); bit < types.BloomBitLength; bit++ {
+		vector, _ := gen.Bitset(bit)
+		for i, bloom := range blooms {
+			want := bloom.Big().Bit(int(bit)) == 1
+			if have := vector[i/8]&(1<<uint(7-i%8)) != 0; have != want {
+				t.Fatalf("bit %d block %d: have %v, want %v", bit, i, have, want)
+			}
+		}
+	}
+}
+
+// Tests that the matcher returns exactly the blocks whose blooms match the
+// filters, within the requested range.
+func TestMatcher(t *testing.T) {
+	blooms := makeTestBlooms(4 * testSectionSize)
+	retrieve := makeRetriever(t, blooms)
+
+	tests := []struct {
+		filters    [][][]byte
+		begin, end uint64
+	}{
+		{nil, 10, 100},
+		{[][][]byte{{[]byte("address")}}, 0, 4*testSectionSize - 1},
+		{[][][]byte{{[]byte("address")}, nil}, 3, 200},
+		{[][][]byte{{[]byte{7, 0}, []byte{100, 0}}}, 0, 4*testSectionSize - 1},
+		{[][][]byte{{[]byte("address")}, {[]byte{10, 0}, []byte{11, 0}}}, 0, 4*testSectionSize - 1},
+		{[][][]byte{{[]byte("missing")}}, 0, 4*testSectionSize - 1},
+	}
+	for i, tt := range tests {
+		results := make(chan uint64, 16)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- NewMatcher(testSectionSize, tt.filters).Run(context.Background(), tt.begin, tt.end, retrieve, results)
+		}()
+		var have []uint64
+		for number := range results {
+			have = append(have, number)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("test %d: matcher failed: %v", i, err)
+		}
+		// Every block matching a bloom must be returned (false positives are allowed)
+		var next int
+		for number := tt.begin; number <= tt.end; number++ {
+			if !testMatch(blooms[number], tt.filters) {
+				continue
+			}
+			for next < len(have) && have[next] < number {
+				next++
+			}
+			if next == len(have) || have[next] != number {
+				t.Errorf("test %d: matching block %d missing", i, number)
+			}
+		}
+		for _, number := range have {
+			if number < tt.begin || number > tt.end {
+				t.Errorf("test %d: block %d out of range", i, number)
+			}
+			if !testMatch(blooms[number], tt.filters) {
+				t.Errorf("test %d: non-matching block %d returned", i, number)
+			}
+		}
+	}
+}
+
+// testMatch checks a single bloom against the filters the slow way.
+func testMatch(bloom types.Bloom, filters [][][]byte) bool {
+	for _, group := range filters {
+		if len(group) == 0 {
+			continue
+		}
+		included := false
+		for _, key := range group {
+			if key == nil || types.BloomLookup(bloom, new(big.Int).SetBytes(key)) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	return true
+}
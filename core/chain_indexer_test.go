@@ -0,0 +1,125 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/event"
+)
+
+// testChainIndexBackend records the processed section heads of a chain indexer.
+type testChainIndexBackend struct {
+	lock    sync.Mutex
+	section uint64
+	count   uint64
+	head    common.Hash
+	heads   map[uint64]common.Hash
+}
+
+func (b *testChainIndexBackend) Reset(section uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.section, b.count, b.head = section, 0, common.Hash{}
+}
+
+func (b *testChainIndexBackend) Process(header *types.Header) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.count++
+	b.head = header.Hash()
+	return nil
+}
+
+func (b *testChainIndexBackend) Commit() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.count == 4 {
+		b.heads[b.section] = b.head
+	}
+	return nil
+}
+
+// writeTestHeaders writes a canonical header chain segment on top of the given
+// parent, tagging every header with the given extra data.
+func writeTestHeaders(db ethdb.Database, parent *types.Header, n int, extra byte) []*types.Header {
+	var headers []*types.Header
+	for i := 0; i < n; i++ {
+		header := &types.Header{Number: big.NewInt(0), Extra: []byte{extra}}
+		if parent != nil {
+			header.Number = new(big.Int).Add(parent.Number, common.Big1)
+			header.ParentHash = parent.Hash()
+		}
+		WriteHeader(db, header)
+		WriteCanonicalHash(db, header.Hash(), header.Number.Uint64())
+
+		headers = append(headers, header)
+		parent = header
+	}
+	return headers
+}
+
+// Tests that the chain indexer processes confirmed sections and reprocesses the
+// ones invalidated by a reorg.
+func TestChainIndexer(t *testing.T) {
+	var (
+		db, _   = ethdb.NewMemDatabase()
+		mux     = new(event.TypeMux)
+		backend = &testChainIndexBackend{heads: make(map[uint64]common.Hash)}
+		indexer = NewChainIndexer(db, ethdb.NewTable(db, "i"), backend, 4, 2, 0, "test")
+	)
+	defer indexer.Close()
+
+	headers := writeTestHeaders(db, nil, 20, 0)
+	indexer.Start(headers[19], mux)
+
+	// check waits until the indexer stored the given sections matching the headers
+	check := func(sections uint64) {
+		for i := 0; i < 500; i++ {
+			if stored, head := indexer.Sections(); stored == sections && head == headers[sections*4-1].Hash() {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if stored, _ := indexer.Sections(); stored != sections {
+			t.Fatalf("stored section count mismatch: have %d, want %d", stored, sections)
+		}
+		backend.lock.Lock()
+		defer backend.lock.Unlock()
+
+		for section := uint64(0); section < sections; section++ {
+			if want := headers[section*4+3].Hash(); backend.heads[section] != want || indexer.SectionHead(section) != want {
+				t.Errorf("section %d: head mismatch: have %x/%x, want %x", section, backend.heads[section], indexer.SectionHead(section), want)
+			}
+		}
+	}
+	check(4)
+
+	// Reorg the chain from block #10 and ensure the affected sections are redone
+	headers = append(headers[:10], writeTestHeaders(db, headers[9], 14, 1)...)
+	mux.Post(ChainHeadEvent{Block: types.NewBlockWithHeader(headers[23])})
+	check(5)
+}
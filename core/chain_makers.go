@@ -21,6 +21,7 @@ import (
 	"math/big"
 
 	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/common/math"
 	"github.com/immesys/bw2bc/consensus/ethash"
 	"github.com/immesys/bw2bc/consensus/misc"
 	"github.com/immesys/bw2bc/core/state"
@@ -180,7 +181,9 @@ func GenerateChain(config *params.ChainConfig, parent *types.Block, db ethdb.Dat
 		if gen != nil {
 			gen(i, b)
 		}
-		ethash.AccumulateRewards(statedb, h, b.uncles)
+		if !config.IsCliqueTransition(h.Number) {
+			ethash.AccumulateRewards(config, statedb, h, b.uncles)
+		}
 		root, err := statedb.CommitTo(db, config.IsEIP158(h.Number))
 		if err != nil {
 			panic(fmt.Sprintf("state write error: %v", err))
@@ -219,7 +222,7 @@ func makeHeader(config *params.ChainConfig, parent *types.Block, state *state.St
 			Time:       new(big.Int).Sub(time, big.NewInt(10)),
 			Difficulty: parent.Difficulty(),
 		}),
-		GasLimit: CalcGasLimit(parent),
+		GasLimit: CalcGasLimit(parent, params.TargetGasLimit, math.MaxBig256),
 		GasUsed:  new(big.Int),
 		Number:   new(big.Int).Add(parent.Number(), common.Big1),
 		Time:     time,
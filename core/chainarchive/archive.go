@@ -0,0 +1,295 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package chainarchive implements a segmented, checksummed container format for
+// shipping ranges of the canonical chain between nodes without network sync.
+//
+// An archive starts with a magic marker and a header identifying the chain it
+// belongs to, followed by any number of segments. Every segment is preceded by a
+// descriptor carrying its block range, the hash of its last block and the
+// keccak256 checksum of its uncompressed RLP block stream. Since descriptors are
+// self-delimiting, new ranges can be appended to an existing archive without
+// rewriting what is already there.
+package chainarchive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/rlp"
+)
+
+const (
+	// Version is the archive format version written by this package.
+	Version = 1
+
+	// DefaultSegmentSize is the number of blocks bundled into a single segment.
+	DefaultSegmentSize = 2048
+
+	// maxRecordSize caps the size of headers and segment descriptors to avoid
+	// allocating absurd amounts of memory on corrupted input.
+	maxRecordSize = 1024 * 1024
+)
+
+// magic is the marker every chain archive starts with.
+var magic = []byte("BW2CHAIN")
+
+var (
+	ErrNotArchive       = errors.New("not a chain archive")
+	ErrChecksumMismatch = errors.New("segment checksum mismatch")
+	ErrHeaderMismatch   = errors.New("archive header mismatch")
+)
+
+// Compression is the codec used for segment payloads.
+type Compression uint8
+
+const (
+	NoCompression Compression = iota
+	GzipCompression
+	SnappyCompression
+)
+
+// ParseCompression converts a user supplied codec name into a Compression.
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "none", "":
+		return NoCompression, nil
+	case "gzip":
+		return GzipCompression, nil
+	case "snappy":
+		return SnappyCompression, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", name)
+}
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case GzipCompression:
+		return "gzip"
+	case SnappyCompression:
+		return "snappy"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(c))
+}
+
+// Header is the archive-wide metadata stored right after the magic marker.
+type Header struct {
+	Version     uint64
+	GenesisHash common.Hash
+	NetworkId   uint64
+	Compression Compression
+}
+
+// Segment describes a contiguous run of blocks stored in the archive.
+type Segment struct {
+	First    uint64      // Number of the first block in the segment
+	Last     uint64      // Number of the last block in the segment
+	LastHash common.Hash // Hash of the last block, used to link appended ranges
+	Checksum common.Hash // Keccak256 of the uncompressed RLP block stream
+	Length   uint64      // Size of the (possibly compressed) payload in bytes
+}
+
+// Manifest is the summary of an archive: its header, the overall block range
+// and the descriptors of every segment.
+type Manifest struct {
+	Header
+	First    uint64
+	Last     uint64
+	Segments []Segment
+}
+
+// Blocks returns the total number of blocks contained in the archive.
+func (m *Manifest) Blocks() uint64 {
+	if len(m.Segments) == 0 {
+		return 0
+	}
+	return m.Last - m.First + 1
+}
+
+// IsArchive reports whether the given data starts with the chain archive marker.
+func IsArchive(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, magic)
+}
+
+// writeRecord RLP encodes val and writes it to w prefixed with its length.
+func writeRecord(w io.Writer, val interface{}) error {
+	blob, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(blob)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(blob)
+	return err
+}
+
+// readRecord reads a length prefixed RLP record from r into val, returning the
+// number of bytes consumed.
+func readRecord(r io.Reader, val interface{}) (int64, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxRecordSize {
+		return 0, fmt.Errorf("record too large: %d bytes", n)
+	}
+	blob := make([]byte, n)
+	if _, err := io.ReadFull(r, blob); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return int64(len(size)) + int64(n), rlp.DecodeBytes(blob, val)
+}
+
+// compress encodes an uncompressed segment payload with the given codec.
+func compress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case GzipCompression:
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case SnappyCompression:
+		return snappy.Encode(nil, data), nil
+	}
+	return nil, fmt.Errorf("unknown compression %v", c)
+}
+
+// decompress reverses compress.
+func decompress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case GzipCompression:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return ioutil.ReadAll(zr)
+	case SnappyCompression:
+		return snappy.Decode(nil, data)
+	}
+	return nil, fmt.Errorf("unknown compression %v", c)
+}
+
+// readManifest parses the header and all segment descriptors from r, skipping
+// over the payloads. It returns the manifest along with the file offset of
+// every segment payload.
+func readManifest(r io.ReadSeeker) (*Manifest, []int64, error) {
+	br := bufio.NewReader(r)
+
+	prefix := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, prefix); err != nil || !IsArchive(prefix) {
+		return nil, nil, ErrNotArchive
+	}
+	manifest := new(Manifest)
+	n, err := readRecord(br, &manifest.Header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid archive header: %v", err)
+	}
+	if manifest.Version != Version {
+		return nil, nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+	offset := int64(len(magic)) + n
+
+	var offsets []int64
+	for {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		br.Reset(r)
+
+		var segment Segment
+		n, err := readRecord(br, &segment)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("segment %d: invalid descriptor: %v", len(manifest.Segments), err)
+		}
+		if segment.First > segment.Last {
+			return nil, nil, fmt.Errorf("segment %d: invalid range [%d, %d]", len(manifest.Segments), segment.First, segment.Last)
+		}
+		if len(manifest.Segments) > 0 && segment.First != manifest.Last+1 {
+			return nil, nil, fmt.Errorf("segment %d: gap in block range: have #%d, want #%d", len(manifest.Segments), segment.First, manifest.Last+1)
+		}
+		if len(manifest.Segments) == 0 {
+			manifest.First = segment.First
+		}
+		manifest.Last = segment.Last
+		manifest.Segments = append(manifest.Segments, segment)
+
+		offsets = append(offsets, offset+n)
+		offset += n + int64(segment.Length)
+	}
+	// Make sure the last payload was not truncated
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if end < offset {
+		return nil, nil, fmt.Errorf("segment %d: truncated payload", len(manifest.Segments)-1)
+	}
+	return manifest, offsets, nil
+}
+
+// unexpectedEOF converts a clean EOF in the middle of a record into an error
+// signalling truncation.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// checksum calculates the integrity hash of an uncompressed segment payload.
+func checksum(data []byte) common.Hash {
+	return crypto.Keccak256Hash(data)
+}
+
+// verifyBody checks that the transactions and uncles of a block match the roots
+// committed to by its header.
+func verifyBody(block *types.Block) error {
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return fmt.Errorf("block #%d: transaction root mismatch: have %x, want %x", block.NumberU64(), hash, block.TxHash())
+	}
+	if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+		return fmt.Errorf("block #%d: uncle root mismatch: have %x, want %x", block.NumberU64(), hash, block.UncleHash())
+	}
+	return nil
+}
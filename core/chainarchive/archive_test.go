@@ -0,0 +1,251 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package chainarchive
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/types"
+)
+
+// makeChain creates a chain of n blocks on top of a fake genesis, each with a
+// single transaction so bodies are non-trivial.
+func makeChain(n int) []*types.Block {
+	parent := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0), Extra: []byte("genesis")})
+
+	blocks := make([]*types.Block, n+1)
+	blocks[0] = parent
+	for i := 1; i <= n; i++ {
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     big.NewInt(int64(i)),
+			GasLimit:   big.NewInt(4712388),
+			GasUsed:    new(big.Int),
+			Difficulty: big.NewInt(131072),
+			Time:       big.NewInt(int64(i * 15)),
+		}
+		tx := types.NewTransaction(uint64(i), common.Address{0x01}, big.NewInt(int64(i)), big.NewInt(21000), big.NewInt(1), make([]byte, 64))
+		parent = types.NewBlock(header, []*types.Transaction{tx}, nil, nil)
+		blocks[i] = parent
+	}
+	return blocks
+}
+
+func tempArchive(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "chainarchive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "chain.bw2"), func() { os.RemoveAll(dir) }
+}
+
+func writeArchive(t *testing.T, path string, header Header, blocks []*types.Block, segment int, append bool) {
+	var (
+		w   *Writer
+		err error
+	)
+	if append {
+		w, err = Append(path, header)
+	} else {
+		w, err = Create(path, header)
+	}
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	w.SegmentSize = segment
+	for _, block := range blocks {
+		if err := w.Write(block); err != nil {
+			t.Fatalf("failed to write block #%d: %v", block.NumberU64(), err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+}
+
+func readArchive(t *testing.T, path string) (*Manifest, []*types.Block) {
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer r.Close()
+
+	var blocks []*types.Block
+	it := r.Iterator()
+	for it.Next() {
+		blocks = append(blocks, it.Block())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	return r.Manifest(), blocks
+}
+
+// Tests that blocks survive a round trip through an archive with every codec.
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Compression{NoCompression, GzipCompression, SnappyCompression} {
+		path, cleanup := tempArchive(t)
+
+		chain := makeChain(25)
+		header := Header{GenesisHash: chain[0].Hash(), NetworkId: 28589, Compression: c}
+		writeArchive(t, path, header, chain[1:], 10, false)
+
+		manifest, blocks := readArchive(t, path)
+		if manifest.GenesisHash != header.GenesisHash || manifest.NetworkId != header.NetworkId || manifest.Compression != c {
+			t.Errorf("%v: header mismatch: have %+v, want %+v", c, manifest.Header, header)
+		}
+		if manifest.First != 1 || manifest.Last != 25 || len(manifest.Segments) != 3 {
+			t.Errorf("%v: manifest mismatch: have [%d, %d] in %d segments, want [1, 25] in 3", c, manifest.First, manifest.Last, len(manifest.Segments))
+		}
+		if len(blocks) != 25 {
+			t.Fatalf("%v: block count mismatch: have %d, want 25", c, len(blocks))
+		}
+		for i, block := range blocks {
+			if block.Hash() != chain[i+1].Hash() {
+				t.Errorf("%v: block #%d hash mismatch: have %x, want %x", c, i+1, block.Hash(), chain[i+1].Hash())
+			}
+		}
+		cleanup()
+	}
+}
+
+// Tests that new ranges can be appended to an existing archive, and that only
+// contiguous ranges of the same chain are accepted.
+func TestAppend(t *testing.T) {
+	path, cleanup := tempArchive(t)
+	defer cleanup()
+
+	chain := makeChain(30)
+	header := Header{GenesisHash: chain[0].Hash(), NetworkId: 28589, Compression: SnappyCompression}
+	writeArchive(t, path, header, chain[1:11], 4, false)
+	writeArchive(t, path, header, chain[11:31], 8, true)
+
+	manifest, blocks := readArchive(t, path)
+	if manifest.First != 1 || manifest.Last != 30 || len(blocks) != 30 {
+		t.Fatalf("manifest mismatch: have [%d, %d] with %d blocks, want [1, 30] with 30", manifest.First, manifest.Last, len(blocks))
+	}
+	// Appending a gap or a foreign chain must be rejected
+	w, err := Append(path, header)
+	if err != nil {
+		t.Fatalf("failed to reopen archive: %v", err)
+	}
+	if err := w.Write(chain[5]); err == nil {
+		t.Errorf("non-contiguous block accepted")
+	}
+	w.Close()
+
+	if _, err := Append(path, Header{GenesisHash: common.Hash{0xff}, NetworkId: 28589}); err == nil {
+		t.Errorf("foreign genesis accepted")
+	}
+}
+
+// Tests that aborting a writer leaves no trace of the blocks written through it.
+func TestAbort(t *testing.T) {
+	path, cleanup := tempArchive(t)
+	defer cleanup()
+
+	chain := makeChain(20)
+	header := Header{GenesisHash: chain[0].Hash(), NetworkId: 28589, Compression: SnappyCompression}
+
+	// An aborted new archive must be removed, even if segments were flushed
+	w, err := Create(path, header)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	w.SegmentSize = 4
+	for _, block := range chain[1:11] {
+		if err := w.Write(block); err != nil {
+			t.Fatalf("failed to write block #%d: %v", block.NumberU64(), err)
+		}
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("failed to abort archive: %v", err)
+	}
+	if common.FileExist(path) {
+		t.Fatalf("aborted archive still exists")
+	}
+	// An aborted append must restore the original archive
+	writeArchive(t, path, header, chain[1:11], 4, false)
+	if w, err = Append(path, header); err != nil {
+		t.Fatalf("failed to reopen archive: %v", err)
+	}
+	w.SegmentSize = 4
+	for _, block := range chain[11:21] {
+		if err := w.Write(block); err != nil {
+			t.Fatalf("failed to write block #%d: %v", block.NumberU64(), err)
+		}
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("failed to abort append: %v", err)
+	}
+	manifest, blocks := readArchive(t, path)
+	if manifest.First != 1 || manifest.Last != 10 || len(blocks) != 10 {
+		t.Fatalf("manifest mismatch: have [%d, %d] with %d blocks, want [1, 10] with 10", manifest.First, manifest.Last, len(blocks))
+	}
+}
+
+// Tests that corruption of a segment payload is detected.
+func TestCorruption(t *testing.T) {
+	path, cleanup := tempArchive(t)
+	defer cleanup()
+
+	chain := makeChain(10)
+	writeArchive(t, path, Header{GenesisHash: chain[0].Hash(), Compression: NoCompression}, chain[1:], 5, false)
+
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)-10] ^= 0xff
+	if err := ioutil.WriteFile(path, blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer r.Close()
+
+	if err := r.Verify(); err == nil {
+		t.Fatalf("corrupted archive verified")
+	}
+	// Truncated archives must be rejected upfront
+	if err := ioutil.WriteFile(path, blob[:len(blob)-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatalf("truncated archive opened")
+	}
+}
+
+// Tests that files without the archive marker are rejected.
+func TestNotArchive(t *testing.T) {
+	path, cleanup := tempArchive(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(path, []byte{0xc0, 0xc0, 0xc0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err != ErrNotArchive {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrNotArchive)
+	}
+}
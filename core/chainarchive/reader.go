@@ -0,0 +1,169 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package chainarchive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/rlp"
+)
+
+// Reader provides verified access to the blocks stored in a chain archive.
+type Reader struct {
+	file     *os.File
+	manifest *Manifest
+	offsets  []int64
+}
+
+// Open opens the chain archive at path and reads its manifest.
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest, offsets, err := readManifest(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Reader{file: file, manifest: manifest, offsets: offsets}, nil
+}
+
+// Manifest returns the summary of the archive.
+func (r *Reader) Manifest() *Manifest {
+	return r.manifest
+}
+
+// Close releases the underlying file.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// Iterator returns an iterator over every block in the archive, starting with
+// the first one.
+func (r *Reader) Iterator() *Iterator {
+	return &Iterator{reader: r, segment: -1}
+}
+
+// Verify iterates over the entire archive, checking segment checksums, block
+// linkage and body integrity without importing anything.
+func (r *Reader) Verify() error {
+	it := r.Iterator()
+	for it.Next() {
+	}
+	return it.Err()
+}
+
+// Iterator walks the blocks of an archive segment by segment, verifying every
+// segment before yielding any of its blocks.
+type Iterator struct {
+	reader *Reader
+
+	segment int            // Index of the segment currently being iterated
+	blocks  []*types.Block // Verified blocks of the current segment
+	block   *types.Block   // Block at the current iterator position
+
+	hasLast  bool        // Whether any block was already yielded
+	lastHash common.Hash // Hash of the previously yielded block
+
+	err error
+}
+
+// Next moves the iterator to the next block, returning whether there is one.
+// If the archive is corrupt, Next returns false and Err reports the problem.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for len(it.blocks) == 0 {
+		if it.segment+1 >= len(it.reader.manifest.Segments) {
+			it.block = nil
+			return false
+		}
+		it.segment++
+		if it.blocks, it.err = it.load(it.segment); it.err != nil {
+			it.block = nil
+			return false
+		}
+	}
+	it.block, it.blocks = it.blocks[0], it.blocks[1:]
+	return true
+}
+
+// Block returns the block at the current iterator position.
+func (it *Iterator) Block() *types.Block {
+	return it.block
+}
+
+// Segment returns the descriptor of the segment the current block belongs to.
+func (it *Iterator) Segment() Segment {
+	return it.reader.manifest.Segments[it.segment]
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// load reads, decompresses and verifies the index'th segment of the archive.
+func (it *Iterator) load(index int) ([]*types.Block, error) {
+	segment := it.reader.manifest.Segments[index]
+
+	payload := make([]byte, segment.Length)
+	if _, err := it.reader.file.ReadAt(payload, it.reader.offsets[index]); err != nil {
+		return nil, fmt.Errorf("segment %d: %v", index, unexpectedEOF(err))
+	}
+	data, err := decompress(it.reader.manifest.Compression, payload)
+	if err != nil {
+		return nil, fmt.Errorf("segment %d: %v", index, err)
+	}
+	if hash := checksum(data); hash != segment.Checksum {
+		return nil, fmt.Errorf("segment %d: %v: have %x, want %x", index, ErrChecksumMismatch, hash, segment.Checksum)
+	}
+	blocks := make([]*types.Block, 0, segment.Last-segment.First+1)
+
+	stream := rlp.NewStream(bytes.NewReader(data), uint64(len(data)))
+	for number := segment.First; number <= segment.Last; number++ {
+		block := new(types.Block)
+		if err := stream.Decode(block); err != nil {
+			return nil, fmt.Errorf("segment %d: block #%d: %v", index, number, unexpectedEOF(err))
+		}
+		if block.NumberU64() != number {
+			return nil, fmt.Errorf("segment %d: non-contiguous block: have #%d, want #%d", index, block.NumberU64(), number)
+		}
+		if it.hasLast && block.ParentHash() != it.lastHash {
+			return nil, fmt.Errorf("segment %d: block #%d: parent hash mismatch: have %x, want %x", index, number, block.ParentHash(), it.lastHash)
+		}
+		if err := verifyBody(block); err != nil {
+			return nil, fmt.Errorf("segment %d: %v", index, err)
+		}
+		it.hasLast, it.lastHash = true, block.Hash()
+		blocks = append(blocks, block)
+	}
+	if _, err := stream.Raw(); err != io.EOF {
+		return nil, fmt.Errorf("segment %d: trailing data after block #%d", index, segment.Last)
+	}
+	if it.lastHash != segment.LastHash {
+		return nil, fmt.Errorf("segment %d: last hash mismatch: have %x, want %x", index, it.lastHash, segment.LastHash)
+	}
+	return blocks, nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package chainarchive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/types"
+)
+
+// Writer streams blocks into a chain archive, cutting a new segment every
+// SegmentSize blocks.
+type Writer struct {
+	SegmentSize int // Number of blocks per segment, DefaultSegmentSize if unset
+
+	file   *os.File
+	path   string // Path of the archive, removed on abort if newly created
+	size   int64  // Size of an appended archive before any writes, restored on abort
+	header Header
+
+	hasLast  bool        // Whether any block was written to (or found in) the archive
+	last     uint64      // Number of the last block written
+	lastHash common.Hash // Hash of the last block written
+
+	first   uint64       // Number of the first block in the pending segment
+	pending int          // Number of blocks in the pending segment
+	buffer  bytes.Buffer // Uncompressed RLP stream of the pending segment
+}
+
+// Create creates a new, empty chain archive at path, truncating any existing
+// file.
+func Create(path string, header Header) (*Writer, error) {
+	header.Version = Version
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(magic); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := writeRecord(file, &header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Writer{file: file, path: path, size: -1, header: header}, nil
+}
+
+// Append opens an existing chain archive for appending new blocks. The archive
+// must belong to the same chain as the one described by header, and the first
+// block written must directly follow the last block already in the archive.
+// The compression of the existing archive is retained.
+func Append(path string, header Header) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	manifest, _, err := readManifest(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if manifest.GenesisHash != header.GenesisHash || manifest.NetworkId != header.NetworkId {
+		file.Close()
+		return nil, fmt.Errorf("%v: have genesis %x network %d, want genesis %x network %d", ErrHeaderMismatch,
+			manifest.GenesisHash, manifest.NetworkId, header.GenesisHash, header.NetworkId)
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w := &Writer{file: file, path: path, size: size, header: manifest.Header}
+	if n := len(manifest.Segments); n > 0 {
+		w.hasLast, w.last, w.lastHash = true, manifest.Last, manifest.Segments[n-1].LastHash
+	}
+	return w, nil
+}
+
+// Header returns the header of the archive being written.
+func (w *Writer) Header() Header {
+	return w.header
+}
+
+// Next returns the number of the block the archive expects next, and whether
+// such a restriction exists at all (i.e. whether the archive is non-empty).
+func (w *Writer) Next() (uint64, bool) {
+	return w.last + 1, w.hasLast
+}
+
+// Write appends a block to the archive. Blocks must be written in order, each
+// one being the child of the previous.
+func (w *Writer) Write(block *types.Block) error {
+	if w.hasLast {
+		if block.NumberU64() != w.last+1 {
+			return fmt.Errorf("non-contiguous block: have #%d, want #%d", block.NumberU64(), w.last+1)
+		}
+		if block.ParentHash() != w.lastHash {
+			return fmt.Errorf("block #%d: parent hash mismatch: have %x, want %x", block.NumberU64(), block.ParentHash(), w.lastHash)
+		}
+	}
+	if w.pending == 0 {
+		w.first = block.NumberU64()
+	}
+	if err := block.EncodeRLP(&w.buffer); err != nil {
+		return err
+	}
+	w.hasLast, w.last, w.lastHash = true, block.NumberU64(), block.Hash()
+	w.pending++
+
+	size := w.SegmentSize
+	if size <= 0 {
+		size = DefaultSegmentSize
+	}
+	if w.pending >= size {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush compresses the pending segment and writes it out, prefixed by its
+// descriptor.
+func (w *Writer) flush() error {
+	if w.pending == 0 {
+		return nil
+	}
+	payload, err := compress(w.header.Compression, w.buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	segment := Segment{
+		First:    w.first,
+		Last:     w.last,
+		LastHash: w.lastHash,
+		Checksum: checksum(w.buffer.Bytes()),
+		Length:   uint64(len(payload)),
+	}
+	if err := writeRecord(w.file, &segment); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(payload); err != nil {
+		return err
+	}
+	w.buffer.Reset()
+	w.pending = 0
+	return nil
+}
+
+// Close flushes any pending blocks and closes the underlying file.
+func (w *Writer) Close() error {
+	if err := w.flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Abort discards everything written through the writer and closes the file. A
+// newly created archive is deleted, an appended one is truncated back to its
+// original contents, so a failed export never leaves a valid looking archive.
+func (w *Writer) Abort() error {
+	w.buffer.Reset()
+	w.pending = 0
+
+	if w.size < 0 {
+		w.file.Close()
+		return os.Remove(w.path)
+	}
+	if err := w.file.Truncate(w.size); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -23,7 +23,6 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"sync"
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/core/types"
@@ -38,6 +37,7 @@ var (
 	headHeaderKey = []byte("LastHeader")
 	headBlockKey  = []byte("LastBlock")
 	headFastKey   = []byte("LastFast")
+	txIndexTail   = []byte("TransactionIndexTail")
 
 	headerPrefix        = []byte("h")   // headerPrefix + num (uint64 big endian) + hash -> header
 	tdSuffix            = []byte("t")   // headerPrefix + num (uint64 big endian) + hash + tdSuffix -> td
@@ -45,14 +45,13 @@ var (
 	blockHashPrefix     = []byte("H")   // blockHashPrefix + hash -> num (uint64 big endian)
 	bodyPrefix          = []byte("b")   // bodyPrefix + num (uint64 big endian) + hash -> block body
 	blockReceiptsPrefix = []byte("r")   // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
+	txLookupPrefix      = []byte("l")   // txLookupPrefix + hash -> transaction lookup entry
+	bloomBitsPrefix     = []byte("B")   // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
 	preimagePrefix      = "secure-key-" // preimagePrefix + hash -> preimage
 
 	txMetaSuffix   = []byte{0x01}
 	receiptsPrefix = []byte("receipts-")
 
-	mipmapPre    = []byte("mipmap-log-bloom-")
-	MIPMapLevels = []uint64{1000000, 500000, 100000, 50000, 1000}
-
 	configPrefix = []byte("ethereum-config-") // config prefix for the db
 
 	// used by old (non-sequential keys) db, now only used for conversion
@@ -66,8 +65,6 @@ var (
 
 	ErrChainConfigNotFound = errors.New("ChainConfig not found") // general config not found error
 
-	mipmapBloomMu sync.Mutex // protect against race condition when updating mipmap blooms
-
 	preimageCounter    = metrics.NewCounter("db/preimage/total")
 	preimageHitCounter = metrics.NewCounter("db/preimage/hits")
 )
@@ -256,10 +253,54 @@ func GetBlockReceipts(db ethdb.Database, hash common.Hash, number uint64) types.
 	return receipts
 }
 
+// TxLookupEntry is the positional metadata stored for every indexed transaction,
+// pointing to the canonical block containing it.
+type TxLookupEntry struct {
+	BlockHash  common.Hash
+	BlockIndex uint64
+	Index      uint64
+}
+
+// GetTxLookupEntry retrieves the positional metadata associated with a transaction
+// hash, falling back to the legacy per-transaction metadata if no lookup entry is
+// stored for it.
+func GetTxLookupEntry(db ethdb.Database, hash common.Hash) (common.Hash, uint64, uint64) {
+	data, _ := db.Get(append(txLookupPrefix, hash.Bytes()...))
+	if len(data) == 0 {
+		data, _ = db.Get(append(hash.Bytes(), txMetaSuffix...))
+		if len(data) == 0 {
+			return common.Hash{}, 0, 0
+		}
+	}
+	var entry TxLookupEntry
+	if err := rlp.DecodeBytes(data, &entry); err != nil {
+		log.Error("Invalid transaction lookup entry RLP", "hash", hash, "err", err)
+		return common.Hash{}, 0, 0
+	}
+	return entry.BlockHash, entry.BlockIndex, entry.Index
+}
+
+// GetTxIndexTail retrieves the number of the oldest block whose transactions are
+// indexed, or nil if the index tail was never tracked (everything is indexed).
+func GetTxIndexTail(db ethdb.Database) *uint64 {
+	data, _ := db.Get(txIndexTail)
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
 // GetTransaction retrieves a specific transaction from the database, along with
 // its added positional metadata.
 func GetTransaction(db ethdb.Database, hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64) {
-	// Retrieve the transaction itself from the database
+	// Look the transaction up in its canonical block if it's indexed
+	if blockHash, number, index := GetTxLookupEntry(db, hash); blockHash != (common.Hash{}) {
+		if body := GetBody(db, blockHash, number); body != nil && uint64(len(body.Transactions)) > index {
+			return body.Transactions[index], blockHash, number, index
+		}
+	}
+	// Retrieve the transaction itself from the legacy entries
 	data, _ := db.Get(hash.Bytes())
 	if len(data) == 0 {
 		return nil, common.Hash{}, 0, 0
@@ -286,6 +327,13 @@ func GetTransaction(db ethdb.Database, hash common.Hash) (*types.Transaction, co
 
 // GetReceipt returns a receipt by hash
 func GetReceipt(db ethdb.Database, hash common.Hash) *types.Receipt {
+	// Look the receipt up among its block's receipts if the transaction is indexed
+	if blockHash, number, index := GetTxLookupEntry(db, hash); blockHash != (common.Hash{}) {
+		if receipts := GetBlockReceipts(db, blockHash, number); uint64(len(receipts)) > index {
+			return receipts[index]
+		}
+	}
+	// Fall back to the legacy per-transaction receipt
 	data, _ := db.Get(append(receiptsPrefix, hash[:]...))
 	if len(data) == 0 {
 		return nil
@@ -458,6 +506,34 @@ func WriteTransactions(db ethdb.Database, block *types.Block) error {
 	return nil
 }
 
+// WriteTxLookupEntries stores a positional metadata entry for every transaction
+// of a block, allowing them to be retrieved from the block body and receipts by
+// hash.
+func WriteTxLookupEntries(db ethdb.Database, block *types.Block) error {
+	batch := db.NewBatch()
+
+	for i, tx := range block.Transactions() {
+		data, err := rlp.EncodeToBytes(TxLookupEntry{
+			BlockHash:  block.Hash(),
+			BlockIndex: block.NumberU64(),
+			Index:      uint64(i),
+		})
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(append(txLookupPrefix, tx.Hash().Bytes()...), data); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// WriteTxIndexTail stores the number of the oldest block whose transactions are
+// indexed.
+func WriteTxIndexTail(db ethdb.Database, number uint64) error {
+	return db.Put(txIndexTail, encodeBlockNumber(number))
+}
+
 // WriteReceipt stores a single transaction receipt into the database.
 func WriteReceipt(db ethdb.Database, receipt *types.Receipt) error {
 	storageReceipt := (*types.ReceiptForStorage)(receipt)
@@ -526,6 +602,7 @@ func DeleteBlockReceipts(db ethdb.Database, hash common.Hash, number uint64) {
 
 // DeleteTransaction removes all transaction data associated with a hash.
 func DeleteTransaction(db ethdb.Database, hash common.Hash) {
+	db.Delete(append(txLookupPrefix, hash.Bytes()...))
 	db.Delete(hash.Bytes())
 	db.Delete(append(hash.Bytes(), txMetaSuffix...))
 }
@@ -535,48 +612,32 @@ func DeleteReceipt(db ethdb.Database, hash common.Hash) {
 	db.Delete(append(receiptsPrefix, hash.Bytes()...))
 }
 
-// returns a formatted MIP mapped key by adding prefix, canonical number and level
-//
-// ex. fn(98, 1000) = (prefix || 1000 || 0)
-func mipmapKey(num, level uint64) []byte {
-	lkey := make([]byte, 8)
-	binary.BigEndian.PutUint64(lkey, level)
-	key := new(big.Int).SetUint64(num / level * level)
-
-	return append(mipmapPre, append(lkey, key.Bytes()...)...)
+// bloomBitsKey returns the database key of the bit vector of a bloom bit within
+// a section, identified by the hash of the section's last canonical header.
+func bloomBitsKey(bit uint, section uint64, head common.Hash) []byte {
+	key := make([]byte, len(bloomBitsPrefix)+2+8+common.HashLength)
+	copy(key, bloomBitsPrefix)
+	binary.BigEndian.PutUint16(key[len(bloomBitsPrefix):], uint16(bit))
+	binary.BigEndian.PutUint64(key[len(bloomBitsPrefix)+2:], section)
+	copy(key[len(bloomBitsPrefix)+10:], head.Bytes())
+	return key
 }
 
-// WriteMipmapBloom writes each address included in the receipts' logs to the
-// MIP bloom bin.
-func WriteMipmapBloom(db ethdb.Database, number uint64, receipts types.Receipts) error {
-	mipmapBloomMu.Lock()
-	defer mipmapBloomMu.Unlock()
+// GetBloomBits retrieves the compressed bit vector belonging to the given
+// section and bit index from the database.
+func GetBloomBits(db ethdb.Database, bit uint, section uint64, head common.Hash) ([]byte, error) {
+	return db.Get(bloomBitsKey(bit, section, head))
+}
 
-	batch := db.NewBatch()
-	for _, level := range MIPMapLevels {
-		key := mipmapKey(number, level)
-		bloomDat, _ := db.Get(key)
-		bloom := types.BytesToBloom(bloomDat)
-		for _, receipt := range receipts {
-			for _, log := range receipt.Logs {
-				bloom.Add(log.Address.Big())
-			}
-		}
-		batch.Put(key, bloom.Bytes())
-	}
-	if err := batch.Write(); err != nil {
-		return fmt.Errorf("mipmap write fail for: %d: %v", number, err)
+// WriteBloomBits writes the compressed bit vector belonging to the given section
+// and bit index into the database.
+func WriteBloomBits(db ethdb.Putter, bit uint, section uint64, head common.Hash, bits []byte) error {
+	if err := db.Put(bloomBitsKey(bit, section, head), bits); err != nil {
+		log.Crit("Failed to store bloom bits", "err", err)
 	}
 	return nil
 }
 
-// GetMipmapBloom returns a bloom filter using the number and level as input
-// parameters. For available levels see MIPMapLevels.
-func GetMipmapBloom(db ethdb.Database, number, level uint64) types.Bloom {
-	bloomDat, _ := db.Get(mipmapKey(number, level))
-	return types.BytesToBloom(bloomDat)
-}
-
 // PreimageTable returns a Database instance with the key prefix for preimage entries.
 func PreimageTable(db ethdb.Database) ethdb.Database {
 	return ethdb.NewTable(db, preimagePrefix)
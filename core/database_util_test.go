@@ -18,17 +18,13 @@ package core
 
 import (
 	"bytes"
-	"io/ioutil"
 	"math/big"
-	"os"
 	"testing"
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/core/types"
-	"github.com/immesys/bw2bc/crypto"
 	"github.com/immesys/bw2bc/crypto/sha3"
 	"github.com/immesys/bw2bc/ethdb"
-	"github.com/immesys/bw2bc/params"
 	"github.com/immesys/bw2bc/rlp"
 )
 
@@ -447,110 +443,28 @@ func TestBlockReceiptStorage(t *testing.T) {
 	}
 }
 
-func TestMipmapBloom(t *testing.T) {
+// Tests that bloom bit vectors are stored per bit, section and section head.
+func TestBloomBitsStorage(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 
-	receipt1 := new(types.Receipt)
-	receipt1.Logs = []*types.Log{
-		{Address: common.BytesToAddress([]byte("test"))},
-		{Address: common.BytesToAddress([]byte("address"))},
-	}
-	receipt2 := new(types.Receipt)
-	receipt2.Logs = []*types.Log{
-		{Address: common.BytesToAddress([]byte("test"))},
-		{Address: common.BytesToAddress([]byte("address1"))},
-	}
-
-	WriteMipmapBloom(db, 1, types.Receipts{receipt1})
-	WriteMipmapBloom(db, 2, types.Receipts{receipt2})
-
-	for _, level := range MIPMapLevels {
-		bloom := GetMipmapBloom(db, 2, level)
-		if !bloom.Test(new(big.Int).SetBytes([]byte("address1"))) {
-			t.Error("expected test to be included on level:", level)
-		}
+	head1, head2 := common.Hash{0x01}, common.Hash{0x02}
+	if bits, _ := GetBloomBits(db, 1, 2, head1); bits != nil {
+		t.Fatalf("non existent bloom bits returned: %x", bits)
 	}
+	WriteBloomBits(db, 1, 2, head1, []byte{0xaa})
+	WriteBloomBits(db, 1, 2, head2, []byte{0xbb})
+	WriteBloomBits(db, 2047, 2, head1, []byte{0xcc})
 
-	// reset
-	db, _ = ethdb.NewMemDatabase()
-	receipt := new(types.Receipt)
-	receipt.Logs = []*types.Log{
-		{Address: common.BytesToAddress([]byte("test"))},
+	if bits, err := GetBloomBits(db, 1, 2, head1); err != nil || !bytes.Equal(bits, []byte{0xaa}) {
+		t.Errorf("bloom bits mismatch: have %x (%v), want %x", bits, err, []byte{0xaa})
 	}
-	WriteMipmapBloom(db, 999, types.Receipts{receipt1})
-
-	receipt = new(types.Receipt)
-	receipt.Logs = []*types.Log{
-		{Address: common.BytesToAddress([]byte("test 1"))},
+	if bits, err := GetBloomBits(db, 1, 2, head2); err != nil || !bytes.Equal(bits, []byte{0xbb}) {
+		t.Errorf("bloom bits mismatch: have %x (%v), want %x", bits, err, []byte{0xbb})
 	}
-	WriteMipmapBloom(db, 1000, types.Receipts{receipt})
-
-	bloom := GetMipmapBloom(db, 1000, 1000)
-	if bloom.TestBytes([]byte("test")) {
-		t.Error("test should not have been included")
+	if bits, err := GetBloomBits(db, 2047, 2, head1); err != nil || !bytes.Equal(bits, []byte{0xcc}) {
+		t.Errorf("bloom bits mismatch: have %x (%v), want %x", bits, err, []byte{0xcc})
 	}
-}
-
-func TestMipmapChain(t *testing.T) {
-	dir, err := ioutil.TempDir("", "mipmap")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-
-	var (
-		db, _   = ethdb.NewLDBDatabase(dir, 0, 0)
-		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		addr    = crypto.PubkeyToAddress(key1.PublicKey)
-		addr2   = common.BytesToAddress([]byte("jeff"))
-
-		hash1 = common.BytesToHash([]byte("topic1"))
-	)
-	defer db.Close()
-
-	gspec := &Genesis{
-		Config: params.TestChainConfig,
-		Alloc:  GenesisAlloc{addr: {Balance: big.NewInt(1000000)}},
-	}
-	genesis := gspec.MustCommit(db)
-	chain, receipts := GenerateChain(params.TestChainConfig, genesis, db, 1010, func(i int, gen *BlockGen) {
-		var receipts types.Receipts
-		switch i {
-		case 1:
-			receipt := types.NewReceipt(nil, new(big.Int))
-			receipt.Logs = []*types.Log{{Address: addr, Topics: []common.Hash{hash1}}}
-			gen.AddUncheckedReceipt(receipt)
-			receipts = types.Receipts{receipt}
-		case 1000:
-			receipt := types.NewReceipt(nil, new(big.Int))
-			receipt.Logs = []*types.Log{{Address: addr2}}
-			gen.AddUncheckedReceipt(receipt)
-			receipts = types.Receipts{receipt}
-
-		}
-
-		// store the receipts
-		err := WriteReceipts(db, receipts)
-		if err != nil {
-			t.Fatal(err)
-		}
-		WriteMipmapBloom(db, uint64(i+1), receipts)
-	})
-	for i, block := range chain {
-		WriteBlock(db, block)
-		if err := WriteCanonicalHash(db, block.Hash(), block.NumberU64()); err != nil {
-			t.Fatalf("failed to insert block number: %v", err)
-		}
-		if err := WriteHeadBlockHash(db, block.Hash()); err != nil {
-			t.Fatalf("failed to insert block number: %v", err)
-		}
-		if err := WriteBlockReceipts(db, block.Hash(), block.NumberU64(), receipts[i]); err != nil {
-			t.Fatal("error writing block receipts:", err)
-		}
-	}
-
-	bloom := GetMipmapBloom(db, 0, 1000)
-	if bloom.TestBytes(addr2[:]) {
-		t.Error("address was included in bloom and should not have")
+	if bits, _ := GetBloomBits(db, 1, 3, head1); bits != nil {
+		t.Errorf("bloom bits of other section returned: %x", bits)
 	}
 }
@@ -24,6 +24,44 @@ import (
 // TxPreEvent is posted when a transaction enters the transaction pool.
 type TxPreEvent struct{ Tx *types.Transaction }
 
+// TxPoolEventKind is the lifecycle stage a transaction reached within the pool.
+type TxPoolEventKind uint8
+
+const (
+	TxAdded    TxPoolEventKind = iota // Transaction accepted into the pool
+	TxPromoted                        // Transaction became executable
+	TxReplaced                        // Transaction superseded by another with the same nonce
+	TxDropped                         // Transaction rejected or evicted, see the reason
+	TxMined                           // Transaction included in the canonical chain
+)
+
+// String implements fmt.Stringer, returning the lowercase name of the stage.
+func (k TxPoolEventKind) String() string {
+	switch k {
+	case TxAdded:
+		return "added"
+	case TxPromoted:
+		return "promoted"
+	case TxReplaced:
+		return "replaced"
+	case TxDropped:
+		return "dropped"
+	case TxMined:
+		return "mined"
+	default:
+		return "unknown"
+	}
+}
+
+// TxPoolEvent is sent on the transaction pool feed whenever a transaction moves
+// through its lifecycle within the pool.
+type TxPoolEvent struct {
+	Kind   TxPoolEventKind
+	Tx     *types.Transaction
+	From   common.Address
+	Reason error // Why the transaction was dropped, nil for all other kinds
+}
+
 // PendingLogsEvent is posted pre mining and notifies of pending logs.
 type PendingLogsEvent struct {
 	Logs []*types.Log
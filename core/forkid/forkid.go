@@ -0,0 +1,198 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+// Package forkid implements fork identifiers, compact summaries of the chain a
+// node is on and the forks it knows about, as described by EIP-2124.
+package forkid
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/params"
+)
+
+var (
+	// ErrRemoteStale is returned by the validator if a remote fork checksum is a
+	// subset of our already applied forks, but the announced next fork block is
+	// not on our already passed chain.
+	ErrRemoteStale = errors.New("remote needs update")
+
+	// ErrLocalIncompatibleOrStale is returned by the validator if a remote fork
+	// checksum does not match any local checksum variation, signalling that the
+	// two chains have diverged in the past at some point (possibly at genesis).
+	ErrLocalIncompatibleOrStale = errors.New("local incompatible or needs update")
+)
+
+// Blockchain defines all necessary method to build a forkID.
+type Blockchain interface {
+	// Config retrieves the chain's fork configuration.
+	Config() *params.ChainConfig
+
+	// Genesis retrieves the chain's genesis block.
+	Genesis() *types.Block
+
+	// CurrentHeader retrieves the current head header of the canonical chain.
+	CurrentHeader() *types.Header
+}
+
+// ID is a fork identifier: a CRC32 checksum of the genesis hash and the blocks
+// of all forks passed so far, along with the block number of the next scheduled
+// fork (zero if none is known).
+type ID struct {
+	Hash [4]byte // CRC32 checksum of the genesis block and passed fork block numbers
+	Next uint64  // Block number of the next upcoming fork, or 0 if no forks are known
+}
+
+// Filter is a fork identifier validator, reporting whether a remote fork ID is
+// compatible with the local chain.
+type Filter func(id ID) error
+
+// NewID calculates the fork identifier of a chain at the given head block.
+func NewID(config *params.ChainConfig, genesis common.Hash, head uint64) ID {
+	hash := crc32.ChecksumIEEE(genesis[:])
+	for _, fork := range gatherForks(config) {
+		if fork > head {
+			return ID{Hash: checksumToBytes(hash), Next: fork}
+		}
+		hash = checksumUpdate(hash, fork)
+	}
+	return ID{Hash: checksumToBytes(hash)}
+}
+
+// NewFilter creates a filter that validates remote fork IDs against the current
+// state of the local chain.
+func NewFilter(chain Blockchain) Filter {
+	return newFilter(chain.Config(), chain.Genesis().Hash(), func() uint64 {
+		return chain.CurrentHeader().Number.Uint64()
+	})
+}
+
+// newFilter is the internal version of NewFilter, taking closures as its
+// inputs to allow testing without a real chain.
+func newFilter(config *params.ChainConfig, genesis common.Hash, headfn func() uint64) Filter {
+	// Calculate all the valid fork hash and fork next combos
+	var (
+		forks = gatherForks(config)
+		sums  = make([][4]byte, len(forks)+1) // 0th is the genesis
+	)
+	hash := crc32.ChecksumIEEE(genesis[:])
+	sums[0] = checksumToBytes(hash)
+	for i, fork := range forks {
+		hash = checksumUpdate(hash, fork)
+		sums[i+1] = checksumToBytes(hash)
+	}
+	// Add a sentinel fork that is never passed, so the first unpassed fork is
+	// always found below
+	forks = append(forks, math.MaxUint64)
+
+	return func(id ID) error {
+		head := headfn()
+		for i, fork := range forks {
+			// If our head is beyond this fork, continue to the next one
+			if head >= fork {
+				continue
+			}
+			// Found the first unpassed fork block, check if our current state
+			// matches the remote checksum
+			if sums[i] == id.Hash {
+				// Fork checksum matched, reject if the remote announces a fork
+				// that we have already passed without knowing about it
+				if id.Next > 0 && head >= id.Next {
+					return ErrLocalIncompatibleOrStale
+				}
+				return nil
+			}
+			// The nodes are at different forks, accept the remote if its
+			// checksum is a subset of our passed forks and it knows about the
+			// next fork we passed
+			for j := 0; j < i; j++ {
+				if sums[j] == id.Hash {
+					if forks[j] != id.Next {
+						return ErrRemoteStale
+					}
+					return nil
+				}
+			}
+			// Accept the remote if its checksum is a superset of ours, meaning
+			// we are simply not synced yet
+			for j := i + 1; j < len(sums); j++ {
+				if sums[j] == id.Hash {
+					return nil
+				}
+			}
+			// No exact, subset or superset match, the chains have diverged
+			return ErrLocalIncompatibleOrStale
+		}
+		// Unreachable because of the sentinel fork
+		return ErrLocalIncompatibleOrStale
+	}
+}
+
+// checksumUpdate calculates the next CRC32 checksum based on the previous one
+// and a fork block number.
+func checksumUpdate(hash uint32, fork uint64) uint32 {
+	var blob [8]byte
+	binary.BigEndian.PutUint64(blob[:], fork)
+	return crc32.Update(hash, crc32.IEEETable, blob[:])
+}
+
+// checksumToBytes converts a uint32 checksum into a [4]byte array.
+func checksumToBytes(hash uint32) [4]byte {
+	var blob [4]byte
+	binary.BigEndian.PutUint32(blob[:], hash)
+	return blob
+}
+
+// gatherForks returns the sorted and deduplicated block numbers of all forks in
+// the chain configuration. Forks at genesis and placeholders for unscheduled
+// forks (at the maximum block number) are omitted.
+func gatherForks(config *params.ChainConfig) []uint64 {
+	var forks []uint64
+	for _, block := range []*big.Int{
+		config.HomesteadBlock,
+		config.DAOForkBlock,
+		config.EIP150Block,
+		config.EIP155Block,
+		config.EIP158Block,
+		config.MetropolisBlock,
+		config.CliqueTransitionBlock,
+	} {
+		if block == nil || block.Sign() == 0 || !block.IsUint64() || block.Uint64() >= math.MaxInt64 {
+			continue
+		}
+		forks = append(forks, block.Uint64())
+	}
+	sort.Sort(uint64Slice(forks))
+	for i := 1; i < len(forks); i++ {
+		if forks[i] == forks[i-1] {
+			forks = append(forks[:i], forks[i+1:]...)
+			i--
+		}
+	}
+	return forks
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
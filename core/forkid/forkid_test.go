@@ -0,0 +1,117 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package forkid
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/params"
+)
+
+// Tests that fork identifiers are created correctly for the known networks.
+func TestCreation(t *testing.T) {
+	type testcase struct {
+		head uint64
+		want ID
+	}
+	tests := []struct {
+		config  *params.ChainConfig
+		genesis common.Hash
+		cases   []testcase
+	}{
+		// Mainnet test cases
+		{
+			params.MainnetChainConfig,
+			params.MainnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: [4]byte{0x85, 0x42, 0xef, 0x4c}, Next: 99950000}},        // Unsynced, Homestead at genesis
+				{99949999, ID{Hash: [4]byte{0x85, 0x42, 0xef, 0x4c}, Next: 99950000}}, // Last Homestead block
+				{99950000, ID{Hash: [4]byte{0x65, 0xb2, 0xd4, 0x29}, Next: 0}},        // First EIP150/155/158 block
+				{200000000, ID{Hash: [4]byte{0x65, 0xb2, 0xd4, 0x29}, Next: 0}},       // Future block
+			},
+		},
+		// Testnet test cases
+		{
+			params.TestnetChainConfig,
+			params.TestnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: [4]byte{0x30, 0xc7, 0xdd, 0xbc}, Next: 10}},  // Unsynced, EIP150 at genesis
+				{9, ID{Hash: [4]byte{0x30, 0xc7, 0xdd, 0xbc}, Next: 10}},  // Last EIP150 block
+				{10, ID{Hash: [4]byte{0x63, 0x76, 0x01, 0x90}, Next: 0}},  // First EIP155/158 block
+				{100, ID{Hash: [4]byte{0x63, 0x76, 0x01, 0x90}, Next: 0}}, // Future block
+			},
+		},
+	}
+	for i, tt := range tests {
+		for j, ttt := range tt.cases {
+			if have := NewID(tt.config, tt.genesis, ttt.head); have != ttt.want {
+				t.Errorf("test %d, case %d: fork ID mismatch: have %x, want %x", i, j, have, ttt.want)
+			}
+		}
+	}
+}
+
+// Tests that remote fork IDs are validated according to the EIP-2124 rules.
+func TestValidation(t *testing.T) {
+	config := &params.ChainConfig{
+		HomesteadBlock:  big.NewInt(10),
+		EIP150Block:     big.NewInt(20),
+		EIP155Block:     big.NewInt(30),
+		EIP158Block:     big.NewInt(30),
+		MetropolisBlock: big.NewInt(math.MaxInt64),
+	}
+	genesis := common.HexToHash("0x01")
+	var (
+		gen  = NewID(config, genesis, 0)  // checksum at genesis, next 10
+		home = NewID(config, genesis, 10) // checksum after homestead, next 20
+		e150 = NewID(config, genesis, 20) // checksum after EIP150, next 30
+		last = NewID(config, genesis, 30) // checksum after all forks, next 0
+	)
+	tests := []struct {
+		head uint64
+		id   ID
+		err  error
+	}{
+		// Local is at genesis, remote is at the same state and knows the same next fork
+		{0, gen, nil},
+		// Local is at genesis, remote announces no upcoming fork
+		{0, ID{Hash: gen.Hash}, nil},
+		// Local is at genesis, remote announces an unknown future fork
+		{0, ID{Hash: gen.Hash, Next: 5}, nil},
+		// Local passed the fork the remote announces as upcoming without knowing it
+		{15, ID{Hash: home.Hash, Next: 12}, ErrLocalIncompatibleOrStale},
+		// Remote is behind, but knows the fork we passed next
+		{25, home, nil},
+		// Remote is multiple forks behind, but knows the next fork it has to pass
+		{35, home, nil},
+		// Remote is behind and doesn't know about the fork we passed
+		{25, ID{Hash: home.Hash, Next: 0}, ErrRemoteStale},
+		// Local is behind, remote is further along the same fork schedule
+		{15, e150, nil},
+		{0, last, nil},
+		// Remote is on a different chain altogether
+		{15, ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		filter := newFilter(config, genesis, func() uint64 { return tt.head })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package core
 
 import (
+	"errors"
 	"math/big"
 	"reflect"
 	"testing"
@@ -51,6 +52,14 @@ func TestSetupGenesis(t *testing.T) {
 			},
 		}
 		oldcustomg = customg
+		badrewardg = Genesis{
+			Config: &params.ChainConfig{Ethash: &params.EthashConfig{
+				Rewards: []*params.EthashReward{
+					{Block: big.NewInt(10), BlockReward: big.NewInt(1)},
+					{Block: big.NewInt(5), BlockReward: big.NewInt(2)},
+				},
+			}},
+		}
 	)
 	oldcustomg.Config = &params.ChainConfig{HomesteadBlock: big.NewInt(2)}
 	tests := []struct {
@@ -68,6 +77,14 @@ func TestSetupGenesis(t *testing.T) {
 			wantErr:    errGenesisNoConfig,
 			wantConfig: params.AllProtocolChanges,
 		},
+		{
+			name: "genesis with unordered reward schedule",
+			fn: func(db ethdb.Database) (*params.ChainConfig, common.Hash, error) {
+				return SetupGenesisBlock(db, &badrewardg)
+			},
+			wantErr:    errors.New("ethash reward 1: activation block 5 not after 10"),
+			wantConfig: badrewardg.Config,
+		},
 		{
 			name: "no block in DB, genesis == nil",
 			fn: func(db ethdb.Database) (*params.ChainConfig, common.Hash, error) {
@@ -0,0 +1,252 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/rlp"
+	"github.com/immesys/bw2bc/trie"
+)
+
+// importCommitInterval is the number of trie insertions after which an import
+// flushes the partially built tries to disk to bound memory use.
+const importCommitInterval = 100000
+
+// exportAccount is the portable representation of an account in a state export.
+// It is followed in the stream by Slots exportSlot records holding its storage.
+type exportAccount struct {
+	Hash     common.Hash // Hash of the account address (key in the account trie)
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash // Storage root, verified on import
+	CodeHash []byte
+	Code     []byte // Contract code, only included the first time a code hash is seen
+	Slots    uint64 // Number of storage slots following this account
+}
+
+// exportSlot is a single storage entry of an account in a state export.
+type exportSlot struct {
+	Hash  common.Hash // Hash of the storage slot (key in the storage trie)
+	Value []byte      // RLP encoded slot value, as stored in the trie
+}
+
+// ExportStats contains some statistics about a state export or import.
+type ExportStats struct {
+	Accounts uint64 // Number of accounts processed
+	Slots    uint64 // Number of storage slots processed
+	Codes    uint64 // Number of distinct contract codes processed
+}
+
+// Export streams every account, contract code and storage slot of the state
+// trie rooted at root into w, in trie order.
+func Export(db Database, root common.Hash, w io.Writer) (*ExportStats, error) {
+	tr, err := db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		stats = new(ExportStats)
+		codes = make(map[common.Hash]struct{})
+	)
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		var data Account
+		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
+			return nil, fmt.Errorf("account %x: %v", it.Key, err)
+		}
+		account := exportAccount{
+			Hash:     common.BytesToHash(it.Key),
+			Nonce:    data.Nonce,
+			Balance:  data.Balance,
+			Root:     data.Root,
+			CodeHash: data.CodeHash,
+		}
+		// Embed the contract code unless already exported for another account
+		if codeHash := common.BytesToHash(data.CodeHash); !bytes.Equal(data.CodeHash, emptyCodeHash) {
+			if _, ok := codes[codeHash]; !ok {
+				if account.Code, err = db.ContractCode(account.Hash, codeHash); err != nil {
+					return nil, fmt.Errorf("account %x: code %x: %v", it.Key, codeHash, err)
+				}
+				codes[codeHash] = struct{}{}
+				stats.Codes++
+			}
+		}
+		// Count the storage slots first, they need to be known before the account
+		// is written. Iterating the trie twice keeps memory use independent of the
+		// size of the storage.
+		storage, err := db.OpenStorageTrie(account.Hash, data.Root)
+		if err != nil {
+			return nil, fmt.Errorf("account %x: %v", it.Key, err)
+		}
+		storageIt := trie.NewIterator(storage.NodeIterator(nil))
+		for storageIt.Next() {
+			account.Slots++
+		}
+		if storageIt.Err != nil {
+			return nil, fmt.Errorf("account %x: %v", it.Key, storageIt.Err)
+		}
+		if err := rlp.Encode(w, &account); err != nil {
+			return nil, err
+		}
+		// Stream the storage slots right behind the account
+		var slots uint64
+
+		storageIt = trie.NewIterator(storage.NodeIterator(nil))
+		for storageIt.Next() {
+			if err := rlp.Encode(w, &exportSlot{Hash: common.BytesToHash(storageIt.Key), Value: storageIt.Value}); err != nil {
+				return nil, err
+			}
+			slots++
+		}
+		if storageIt.Err != nil {
+			return nil, fmt.Errorf("account %x: %v", it.Key, storageIt.Err)
+		}
+		if slots != account.Slots {
+			return nil, fmt.Errorf("account %x: storage changed during export: %d slots counted, %d written", it.Key, account.Slots, slots)
+		}
+		stats.Accounts++
+		stats.Slots += account.Slots
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	return stats, nil
+}
+
+// Import rebuilds a state trie from a stream produced by Export, writing all
+// trie nodes and contract code into db. Every storage root is verified against
+// the one recorded for its account; the root of the resulting account trie is
+// returned for the caller to check against the expected block header.
+func Import(db ethdb.Database, r io.Reader) (common.Hash, *ExportStats, error) {
+	accounts, err := trie.New(common.Hash{}, db)
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	var (
+		stats  = new(ExportStats)
+		stream = rlp.NewStream(r, 0)
+		batch  = db.NewBatch()
+		codes  = make(map[common.Hash]struct{})
+
+		pending uint64 // Number of trie insertions since the last flush
+	)
+	for {
+		var account exportAccount
+		if err := stream.Decode(&account); err == io.EOF {
+			break
+		} else if err != nil {
+			return common.Hash{}, nil, fmt.Errorf("account #%d: %v", stats.Accounts, err)
+		}
+		// Rebuild the storage trie of the account and make sure it matches
+		storage, err := trie.New(common.Hash{}, db)
+		if err != nil {
+			return common.Hash{}, nil, err
+		}
+		for i := uint64(0); i < account.Slots; i++ {
+			var slot exportSlot
+			if err := stream.Decode(&slot); err != nil {
+				return common.Hash{}, nil, fmt.Errorf("account %x: slot #%d: %v", account.Hash, i, err)
+			}
+			if err := storage.TryUpdate(slot.Hash[:], slot.Value); err != nil {
+				return common.Hash{}, nil, err
+			}
+			// Flush huge storage tries midway too, they'd blow up memory otherwise
+			if pending++; pending >= importCommitInterval {
+				if _, err := storage.CommitTo(batch); err != nil {
+					return common.Hash{}, nil, err
+				}
+				if err := batch.Write(); err != nil {
+					return common.Hash{}, nil, err
+				}
+				batch, pending = db.NewBatch(), 0
+			}
+		}
+		root, err := storage.CommitTo(batch)
+		if err != nil {
+			return common.Hash{}, nil, err
+		}
+		if root != account.Root {
+			return common.Hash{}, nil, fmt.Errorf("account %x: storage root mismatch: have %x, want %x", account.Hash, root, account.Root)
+		}
+		// Store the contract code, ensuring it was provided at least once
+		if !bytes.Equal(account.CodeHash, emptyCodeHash) {
+			if len(account.Code) > 0 {
+				if err := batch.Put(account.CodeHash, account.Code); err != nil {
+					return common.Hash{}, nil, err
+				}
+				codes[common.BytesToHash(account.CodeHash)] = struct{}{}
+				stats.Codes++
+			} else if _, ok := codes[common.BytesToHash(account.CodeHash)]; !ok {
+				return common.Hash{}, nil, fmt.Errorf("account %x: missing code %x", account.Hash, account.CodeHash)
+			}
+		}
+		// Insert the account itself into the account trie
+		blob, err := rlp.EncodeToBytes(&Account{
+			Nonce:    account.Nonce,
+			Balance:  account.Balance,
+			Root:     account.Root,
+			CodeHash: account.CodeHash,
+		})
+		if err != nil {
+			return common.Hash{}, nil, err
+		}
+		if err := accounts.TryUpdate(account.Hash[:], blob); err != nil {
+			return common.Hash{}, nil, err
+		}
+		stats.Accounts++
+		stats.Slots += account.Slots
+
+		// Periodically flush the accumulated nodes to keep memory use bounded. The
+		// batch must hit the disk right away as the trie may unload committed nodes.
+		if pending++; pending >= importCommitInterval {
+			if _, err := accounts.CommitTo(batch); err != nil {
+				return common.Hash{}, nil, err
+			}
+			if err := batch.Write(); err != nil {
+				return common.Hash{}, nil, err
+			}
+			batch, pending = db.NewBatch(), 0
+		}
+	}
+	root, err := accounts.CommitTo(batch)
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	if err := batch.Write(); err != nil {
+		return common.Hash{}, nil, err
+	}
+	return root, stats, nil
+}
+
+// VerifyComplete walks every node of the state trie rooted at root, including
+// all storage tries and contract code, to ensure nothing is missing from db.
+func VerifyComplete(db Database, root common.Hash) error {
+	statedb, err := New(root, db)
+	if err != nil {
+		return err
+	}
+	it := NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	return it.Error
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/ethdb"
+)
+
+// makeTestStorageState creates a state with accounts holding balances, shared
+// and unique contract code and storage.
+func makeTestStorageState() (*ethdb.MemDatabase, common.Hash) {
+	mem, _ := ethdb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(mem))
+
+	for i := byte(0); i < 64; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		state.AddBalance(addr, big.NewInt(int64(i)+1))
+		state.SetNonce(addr, uint64(i))
+
+		if i%4 == 0 {
+			state.SetCode(addr, []byte{i % 8, 0xde, 0xad})
+			for j := byte(0); j < i; j++ {
+				state.SetState(addr, common.BytesToHash([]byte{j}), common.BytesToHash([]byte{i, j}))
+			}
+		}
+	}
+	root, _ := state.CommitTo(mem, false)
+	return mem, root
+}
+
+// Tests that a state exported from one database and imported into another one
+// reproduces the exact same state trie.
+func TestExportImport(t *testing.T) {
+	src, root := makeTestStorageState()
+
+	blob := new(bytes.Buffer)
+	exported, err := Export(NewDatabase(src), root, blob)
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	if exported.Accounts != 64 || exported.Codes != 2 {
+		t.Errorf("export stats mismatch: have %d accounts, %d codes; want 64, 2", exported.Accounts, exported.Codes)
+	}
+	dst, _ := ethdb.NewMemDatabase()
+	imported, stats, err := Import(dst, blob)
+	if err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if imported != root {
+		t.Fatalf("root mismatch: have %x, want %x", imported, root)
+	}
+	if *stats != *exported {
+		t.Errorf("stats mismatch: have %+v, want %+v", stats, exported)
+	}
+	if err := VerifyComplete(NewDatabase(dst), root); err != nil {
+		t.Fatalf("imported state incomplete: %v", err)
+	}
+	state, _ := New(root, NewDatabase(dst))
+	addr := common.BytesToAddress([]byte{12})
+	if code := state.GetCode(addr); !bytes.Equal(code, []byte{4, 0xde, 0xad}) {
+		t.Errorf("code mismatch: have %x, want %x", code, []byte{4, 0xde, 0xad})
+	}
+	if value := state.GetState(addr, common.BytesToHash([]byte{5})); value != common.BytesToHash([]byte{12, 5}) {
+		t.Errorf("storage mismatch: have %x, want %x", value, common.BytesToHash([]byte{12, 5}))
+	}
+}
+
+// Tests that incomplete states are detected by the completeness check.
+func TestVerifyCompleteMissingCode(t *testing.T) {
+	mem, root := makeTestStorageState()
+	mem.Delete(crypto.Keccak256([]byte{0, 0xde, 0xad}))
+
+	if err := VerifyComplete(NewDatabase(mem), root); err == nil {
+		t.Fatalf("missing contract code not detected")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"fmt"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/rlp"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	snapshotRootKey      = []byte("SnapshotRoot")      // snapshotRootKey -> state root of the disk layer
+	snapshotGeneratorKey = []byte("SnapshotGenerator") // snapshotGeneratorKey -> RLP(generatorStatus)
+
+	accountSnapshotPrefix = []byte("a") // accountSnapshotPrefix + account hash -> slim account RLP
+	storageSnapshotPrefix = []byte("o") // storageSnapshotPrefix + account hash + storage hash -> slot value RLP
+)
+
+// generatorStatus is the persisted progress of the disk layer generator.
+type generatorStatus struct {
+	Done   bool
+	Marker []byte // Hash of the last account fully generated
+}
+
+// accountKey = accountSnapshotPrefix + hash
+func accountKey(hash common.Hash) []byte {
+	return append(append([]byte{}, accountSnapshotPrefix...), hash[:]...)
+}
+
+// storageKey = storageSnapshotPrefix + account hash + storage hash
+func storageKey(accountHash, storageHash common.Hash) []byte {
+	return append(append(append([]byte{}, storageSnapshotPrefix...), accountHash[:]...), storageHash[:]...)
+}
+
+// readSnapshotRoot retrieves the root of the persisted disk layer, or an empty
+// hash if there is none.
+func readSnapshotRoot(db ethdb.Database) common.Hash {
+	data, _ := db.Get(snapshotRootKey)
+	if len(data) != common.HashLength {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// writeSnapshotRoot stores the root of the persisted disk layer.
+func writeSnapshotRoot(db ethdb.Database, root common.Hash) {
+	if err := db.Put(snapshotRootKey, root[:]); err != nil {
+		log.Crit("Failed to store snapshot root", "err", err)
+	}
+}
+
+// deleteSnapshotRoot removes the root marker, flagging the flat data as
+// inconsistent until it is written again.
+func deleteSnapshotRoot(db ethdb.Database) {
+	if err := db.Delete(snapshotRootKey); err != nil {
+		log.Crit("Failed to remove snapshot root", "err", err)
+	}
+}
+
+// readGeneratorStatus retrieves the persisted progress of the generator.
+func readGeneratorStatus(db ethdb.Database) *generatorStatus {
+	data, _ := db.Get(snapshotGeneratorKey)
+	if len(data) == 0 {
+		return nil
+	}
+	status := new(generatorStatus)
+	if err := rlp.DecodeBytes(data, status); err != nil {
+		log.Warn("Failed to decode snapshot generator status", "err", err)
+		return nil
+	}
+	return status
+}
+
+// writeGeneratorStatus stores the progress of the generator. A nil marker means
+// the generation is complete.
+func writeGeneratorStatus(db ethdb.Database, marker []byte) {
+	blob, err := rlp.EncodeToBytes(&generatorStatus{Done: marker == nil, Marker: marker})
+	if err != nil {
+		log.Crit("Failed to encode snapshot generator status", "err", err)
+	}
+	if err := db.Put(snapshotGeneratorKey, blob); err != nil {
+		log.Crit("Failed to store snapshot generator status", "err", err)
+	}
+}
+
+// deletePrefix removes every key of exactly the given length starting with
+// prefix. The length check is needed as trie nodes are keyed by raw hashes and
+// may start with the same byte as the snapshot prefixes.
+func deletePrefix(db ethdb.Database, prefix []byte, keylen int) error {
+	var keys [][]byte
+	switch db := db.(type) {
+	case *ethdb.LDBDatabase:
+		it := db.LDB().NewIterator(util.BytesPrefix(prefix), nil)
+		for it.Next() {
+			if len(it.Key()) == keylen {
+				keys = append(keys, common.CopyBytes(it.Key()))
+			}
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return err
+		}
+	case *ethdb.MemDatabase:
+		for _, key := range db.Keys() {
+			if len(key) == keylen && string(key[:len(prefix)]) == string(prefix) {
+				keys = append(keys, key)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported snapshot database %T", db)
+	}
+	for _, key := range keys {
+		if err := db.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipeSnapshot removes all flat account and storage entries from the database.
+func wipeSnapshot(db ethdb.Database) error {
+	deleteSnapshotRoot(db)
+	if err := deletePrefix(db, accountSnapshotPrefix, len(accountSnapshotPrefix)+common.HashLength); err != nil {
+		return err
+	}
+	return deletePrefix(db, storageSnapshotPrefix, len(storageSnapshotPrefix)+2*common.HashLength)
+}
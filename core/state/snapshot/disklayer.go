@@ -0,0 +1,222 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/rlp"
+)
+
+// diskLayer is a low level persistent snapshot built on top of a key-value store.
+type diskLayer struct {
+	diskdb ethdb.Database // Key-value store containing the base snapshot
+	root   common.Hash    // Root hash of the base snapshot
+	stale  bool           // Signals that the layer became stale (state progressed)
+
+	genMarker  []byte        // Hash of the last account generated, nil if generation is done
+	genAbort   chan struct{} // Closed to request the generator to stop, nil if not generating
+	genPending chan struct{} // Closed by the generator when it terminates, nil if not generating
+
+	lock sync.RWMutex
+}
+
+// Root returns root hash for which this snapshot was made.
+func (dl *diskLayer) Root() common.Hash {
+	return dl.root
+}
+
+// Parent always returns nil as there's no layer below the disk.
+func (dl *diskLayer) Parent() snapshot {
+	return nil
+}
+
+// Stale returns whether this layer has become stale (was flattened across) or if
+// it's still live.
+func (dl *diskLayer) Stale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+// markStale flags the layer as unusable.
+func (dl *diskLayer) markStale() {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.stale = true
+}
+
+// Generating returns whether the layer is still being constructed from the trie.
+func (dl *diskLayer) Generating() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.genMarker != nil
+}
+
+// covered returns whether the given account hash was already generated.
+//
+// Note, this method assumes that the read lock is held!
+func (dl *diskLayer) covered(hash common.Hash) bool {
+	return dl.genMarker == nil || bytes.Compare(hash[:], dl.genMarker) <= 0
+}
+
+// Account directly retrieves the account associated with a particular hash in
+// the snapshot slim data format.
+func (dl *diskLayer) Account(hash common.Hash) (*Account, error) {
+	data, err := dl.AccountRLP(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 { // can be both nil and []byte{}
+		return nil, nil
+	}
+	account := new(Account)
+	if err := rlp.DecodeBytes(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// AccountRLP directly retrieves the account RLP associated with a particular
+// hash in the snapshot slim data format.
+func (dl *diskLayer) AccountRLP(hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	if !dl.covered(hash) {
+		return nil, ErrNotCoveredYet
+	}
+	blob, _ := dl.diskdb.Get(accountKey(hash))
+	return blob, nil
+}
+
+// Storage directly retrieves the storage data associated with a particular hash,
+// within a particular account.
+func (dl *diskLayer) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	if !dl.covered(accountHash) {
+		return nil, ErrNotCoveredYet
+	}
+	blob, _ := dl.diskdb.Get(storageKey(accountHash, storageHash))
+	return blob, nil
+}
+
+// stopGeneration aborts a running generator and waits for it to persist its
+// progress. It is a noop if the layer is not being generated.
+func (dl *diskLayer) stopGeneration() {
+	if dl.genAbort == nil {
+		return
+	}
+	close(dl.genAbort)
+	<-dl.genPending
+
+	dl.genAbort, dl.genPending = nil, nil
+}
+
+// flatten merges a diff layer into the disk layer, returning a new disk layer
+// representing the diff's state and marking the current one stale. Any running
+// generation is paused while merging and continued on the new layer from where
+// it left off.
+//
+// Only entries already covered by the generator are written out; the rest will
+// be produced by the generator from the new state trie.
+func (dl *diskLayer) flatten(diff *diffLayer) (*diskLayer, error) {
+	dl.stopGeneration()
+
+	dl.lock.Lock()
+	marker := dl.genMarker
+	dl.stale = true
+	dl.lock.Unlock()
+
+	covered := func(hash common.Hash) bool {
+		return marker == nil || bytes.Compare(hash[:], marker) <= 0
+	}
+	diff.lock.RLock()
+	defer diff.lock.RUnlock()
+
+	// Flag the flat data inconsistent until the new root is persisted
+	deleteSnapshotRoot(dl.diskdb)
+
+	// Drop destructed accounts along with all their storage slots
+	for hash := range diff.destructSet {
+		if !covered(hash) {
+			continue
+		}
+		if err := dl.diskdb.Delete(accountKey(hash)); err != nil {
+			return nil, err
+		}
+		prefix := append(append([]byte{}, storageSnapshotPrefix...), hash[:]...)
+		if err := deletePrefix(dl.diskdb, prefix, len(storageSnapshotPrefix)+2*common.HashLength); err != nil {
+			return nil, err
+		}
+	}
+	// Push all updated accounts and storage slots into the database
+	batch := dl.diskdb.NewBatch()
+	for hash, data := range diff.accountData {
+		if !covered(hash) {
+			continue
+		}
+		if err := batch.Put(accountKey(hash), data); err != nil {
+			return nil, err
+		}
+	}
+	for accountHash, storage := range diff.storageData {
+		if !covered(accountHash) {
+			continue
+		}
+		for storageHash, data := range storage {
+			if len(data) == 0 {
+				if err := dl.diskdb.Delete(storageKey(accountHash, storageHash)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := batch.Put(storageKey(accountHash, storageHash), data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	writeGeneratorStatus(dl.diskdb, marker)
+	writeSnapshotRoot(dl.diskdb, diff.root)
+
+	base := &diskLayer{
+		diskdb:    dl.diskdb,
+		root:      diff.root,
+		genMarker: marker,
+	}
+	if marker != nil {
+		base.startGeneration()
+	}
+	return base, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/rlp"
+	"github.com/immesys/bw2bc/trie"
+)
+
+// generatorBatchSize is the number of flat entries after which the generator
+// flushes its batch and advances the coverage marker.
+const generatorBatchSize = 10000
+
+// generateStats is a collection of statistics gathered by the snapshot generator
+// for logging purposes.
+type generateStats struct {
+	accounts uint64
+	slots    uint64
+	start    time.Time
+	logged   time.Time
+}
+
+// startGeneration launches a background goroutine building the flat snapshot
+// from the state trie, continuing from the layer's current marker.
+func (dl *diskLayer) startGeneration() {
+	dl.genAbort = make(chan struct{})
+	dl.genPending = make(chan struct{})
+
+	go dl.generate(&generateStats{start: time.Now(), logged: time.Now()})
+}
+
+// generate is a background thread that iterates over the state trie of the disk
+// layer and writes every account and storage slot into the flat snapshot. The
+// progress is tracked by the layer's marker, so reads can be served for any
+// account already generated.
+func (dl *diskLayer) generate(stats *generateStats) {
+	defer close(dl.genPending)
+
+	dl.lock.RLock()
+	marker := dl.genMarker
+	dl.lock.RUnlock()
+
+	logger := log.New("root", dl.root)
+	logger.Info("Generating state snapshot", "at", common.BytesToHash(marker))
+
+	accTrie, err := trie.New(dl.root, dl.diskdb)
+	if err != nil {
+		// The state trie is missing (e.g. fast sync in progress), nothing to do
+		logger.Warn("Snapshot generation aborted, state missing", "err", err)
+		return
+	}
+	var (
+		batch   = dl.diskdb.NewBatch()
+		pending int
+		last    []byte
+	)
+	// flush writes out the accumulated entries and advances the marker to the
+	// last account fully written.
+	flush := func() bool {
+		if err := batch.Write(); err != nil {
+			logger.Error("Failed to write state snapshot", "err", err)
+			return false
+		}
+		batch, pending = dl.diskdb.NewBatch(), 0
+		if last != nil {
+			writeGeneratorStatus(dl.diskdb, last)
+
+			dl.lock.Lock()
+			dl.genMarker = last
+			dl.lock.Unlock()
+		}
+		return true
+	}
+	it := trie.NewIterator(accTrie.NodeIterator(marker))
+	for it.Next() {
+		// Skip the account the marker points to, it was already generated
+		if len(marker) == common.HashLength && bytes.Equal(it.Key, marker) {
+			continue
+		}
+		var account Account
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			logger.Error("Invalid account encountered during snapshot generation", "hash", common.BytesToHash(it.Key), "err", err)
+			return
+		}
+		accountHash := common.BytesToHash(it.Key)
+		data := SlimAccountRLP(account.Nonce, account.Balance, common.BytesToHash(account.Root), account.CodeHash)
+		if err := batch.Put(accountKey(accountHash), data); err != nil {
+			logger.Error("Failed to write state snapshot", "err", err)
+			return
+		}
+		pending++
+		stats.accounts++
+
+		// Generate the entire storage of the account before advancing the marker
+		if root := common.BytesToHash(account.Root); root != emptyRoot {
+			n, err := dl.generateStorage(batch, accountHash, root)
+			if err != nil {
+				logger.Error("Failed to generate storage snapshot", "account", accountHash, "err", err)
+				return
+			}
+			pending += n
+			stats.slots += uint64(n)
+		}
+		last = common.CopyBytes(it.Key)
+
+		if pending >= generatorBatchSize {
+			if !flush() {
+				return
+			}
+		}
+		if time.Since(stats.logged) > 8*time.Second {
+			logger.Info("Generating state snapshot", "at", accountHash, "accounts", stats.accounts, "slots", stats.slots, "elapsed", common.PrettyDuration(time.Since(stats.start)))
+			stats.logged = time.Now()
+		}
+		// Stop if the layer is being flattened, the new one will continue
+		select {
+		case <-dl.genAbort:
+			flush()
+			logger.Debug("Paused state snapshot generation", "at", common.BytesToHash(last))
+			return
+		default:
+		}
+	}
+	if it.Err != nil {
+		logger.Error("Failed to iterate state trie for snapshot", "err", it.Err)
+		return
+	}
+	if !flush() {
+		return
+	}
+	writeGeneratorStatus(dl.diskdb, nil)
+
+	dl.lock.Lock()
+	dl.genMarker = nil
+	dl.lock.Unlock()
+
+	logger.Info("Generated state snapshot", "accounts", stats.accounts, "slots", stats.slots, "elapsed", common.PrettyDuration(time.Since(stats.start)))
+}
+
+// generateStorage writes every slot of the storage trie into the batch,
+// returning the number of slots written.
+func (dl *diskLayer) generateStorage(batch ethdb.Batch, accountHash, root common.Hash) (int, error) {
+	storeTrie, err := trie.New(root, dl.diskdb)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	it := trie.NewIterator(storeTrie.NodeIterator(nil))
+	for it.Next() {
+		if err := batch.Put(storageKey(accountHash, common.BytesToHash(it.Key)), common.CopyBytes(it.Value)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, it.Err
+}
@@ -0,0 +1,295 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package snapshot implements a flat, key-value view of the state trie used to
+// serve account and storage reads without walking the Merkle Patricia trie.
+//
+// The snapshot consists of a persistent disk layer holding the flattened state
+// of some block, and a tree of in-memory diff layers on top of it, one for each
+// recently imported block. Old diff layers are periodically merged down into the
+// disk layer. If the disk layer is missing or out of sync with the chain, it is
+// rebuilt from the state trie in the background; until done, reads for parts of
+// the state not yet generated fail with ErrNotCoveredYet and callers are expected
+// to fall back to the trie.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/log"
+)
+
+var (
+	// ErrSnapshotStale is returned from data accessors if the underlying snapshot
+	// layer had been invalidated due to the chain progressing forward far enough
+	// to not maintain the layer's original state.
+	ErrSnapshotStale = errors.New("snapshot stale")
+
+	// ErrNotCoveredYet is returned from data accessors if the underlying snapshot
+	// is being generated currently and the requested data item is not yet in the
+	// range of accounts covered.
+	ErrNotCoveredYet = errors.New("not covered yet")
+)
+
+// Snapshot represents the functionality supported by a snapshot storage layer.
+type Snapshot interface {
+	// Root returns the root hash for which this snapshot was made.
+	Root() common.Hash
+
+	// Account directly retrieves the account associated with a particular hash in
+	// the snapshot slim data format. A nil account means it does not exist.
+	Account(hash common.Hash) (*Account, error)
+
+	// AccountRLP directly retrieves the account RLP associated with a particular
+	// hash in the snapshot slim data format.
+	AccountRLP(hash common.Hash) ([]byte, error)
+
+	// Storage directly retrieves the storage data associated with a particular hash,
+	// within a particular account. The data is RLP encoded as in the storage trie.
+	Storage(accountHash, storageHash common.Hash) ([]byte, error)
+}
+
+// snapshot is the internal version of the snapshot data layer that supports some
+// additional methods compared to the public API.
+type snapshot interface {
+	Snapshot
+
+	// Parent returns the subsequent layer of a snapshot, or nil if the base was
+	// reached.
+	Parent() snapshot
+
+	// Stale return whether this layer has become stale (was flattened across) or
+	// if it's still live.
+	Stale() bool
+
+	// markStale flags the layer as unusable.
+	markStale()
+}
+
+// Tree is an Ethereum state snapshot tree. It consists of one persistent base
+// layer backed by a key-value store, on top of which arbitrarily many in-memory
+// diff layers are topped. The memory diffs can form a tree with branching, but
+// the disk layer is singleton and common to all.
+//
+// The goal of a state snapshot is twofold: to allow direct access to account and
+// storage data to avoid expensive multi-level trie lookups; and to allow sorted,
+// cheap iteration of the account/storage tries for sync aid.
+type Tree struct {
+	diskdb ethdb.Database           // Persistent database to store the snapshot
+	layers map[common.Hash]snapshot // Collection of all known layers
+	lock   sync.RWMutex
+}
+
+// New attempts to load an already existing snapshot from a persistent key-value
+// store. If the snapshot is missing or inconsistent with the given root, it is
+// wiped and regenerated in the background from the state trie.
+func New(diskdb ethdb.Database, root common.Hash) *Tree {
+	snap := &Tree{
+		diskdb: diskdb,
+		layers: make(map[common.Hash]snapshot),
+	}
+	base := &diskLayer{diskdb: diskdb, root: root}
+
+	status := readGeneratorStatus(diskdb)
+	switch {
+	case readSnapshotRoot(diskdb) != root || status == nil:
+		log.Info("Rebuilding state snapshot", "root", root)
+		if err := wipeSnapshot(diskdb); err != nil {
+			log.Error("Failed to wipe state snapshot", "err", err)
+		}
+		writeGeneratorStatus(diskdb, []byte{})
+		writeSnapshotRoot(diskdb, root)
+
+		base.genMarker = []byte{}
+		base.startGeneration()
+
+	case !status.Done:
+		log.Info("Resuming state snapshot generation", "root", root, "at", common.BytesToHash(status.Marker))
+		base.genMarker = status.Marker
+		if base.genMarker == nil {
+			base.genMarker = []byte{}
+		}
+		base.startGeneration()
+
+	default:
+		log.Info("Loaded state snapshot", "root", root)
+	}
+	snap.layers[root] = base
+	return snap
+}
+
+// Snapshot retrieves a snapshot belonging to the given block root, or nil if no
+// snapshot is maintained for that block.
+func (t *Tree) Snapshot(blockRoot common.Hash) Snapshot {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if layer, ok := t.layers[blockRoot]; ok {
+		return layer
+	}
+	return nil
+}
+
+// Update adds a new snapshot into the tree, if that can be linked to an existing
+// old parent. It is disallowed to insert a disk layer (the origin of all).
+func (t *Tree) Update(blockRoot common.Hash, parentRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) error {
+	// Blocks not modifying the state (e.g. empty clique blocks) share the parent's
+	// layer, nothing to do.
+	if blockRoot == parentRoot {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.layers[blockRoot]; ok {
+		return nil
+	}
+	parent, ok := t.layers[parentRoot]
+	if !ok {
+		return fmt.Errorf("parent [%#x] snapshot missing", parentRoot)
+	}
+	t.layers[blockRoot] = newDiffLayer(parent, blockRoot, destructs, accounts, storage)
+	return nil
+}
+
+// Cap traverses downwards the snapshot tree from a head block hash until the
+// number of allowed layers are crossed. All layers beyond the permitted number
+// are flattened downwards into the disk layer.
+func (t *Tree) Cap(root common.Hash, layers int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	snap, ok := t.layers[root]
+	if !ok {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	// Gather the diff layers from the head downwards
+	var diffs []*diffLayer
+	for layer := snap; ; {
+		diff, ok := layer.(*diffLayer)
+		if !ok {
+			break
+		}
+		diffs = append(diffs, diff)
+		layer = diff.Parent()
+	}
+	if len(diffs) <= layers {
+		return nil
+	}
+	base, ok := diffs[len(diffs)-1].Parent().(*diskLayer)
+	if !ok {
+		return fmt.Errorf("snapshot [%#x] not anchored to disk", root)
+	}
+	// Merge every layer beyond the limit into the disk, starting at the bottom
+	for i := len(diffs) - 1; i >= layers; i-- {
+		merged, err := base.flatten(diffs[i])
+		if err != nil {
+			return err
+		}
+		diffs[i].markStale()
+		base = merged
+
+		t.layers[base.root] = base
+		if i > 0 {
+			diffs[i-1].setParent(base)
+		}
+	}
+	// Drop all layers not descending from the new disk layer (side branches of
+	// the flattened diffs), they can never be served again.
+	for hash, layer := range t.layers {
+		if !t.anchored(layer, base) {
+			layer.markStale()
+			delete(t.layers, hash)
+		}
+	}
+	return nil
+}
+
+// anchored returns whether the given layer descends from the given disk layer.
+//
+// Note, this method assumes that the tree lock is held!
+func (t *Tree) anchored(layer snapshot, base *diskLayer) bool {
+	for layer != nil {
+		if layer == base {
+			return true
+		}
+		if layer.Stale() {
+			return false
+		}
+		layer = layer.Parent()
+	}
+	return false
+}
+
+// Rebuild wipes all available snapshot data from the persistent database and
+// discards all diff layers. Afterwards, it starts a new snapshot generator with
+// the given root hash.
+func (t *Tree) Rebuild(root common.Hash) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, layer := range t.layers {
+		if disk, ok := layer.(*diskLayer); ok {
+			disk.stopGeneration()
+		}
+		layer.markStale()
+	}
+	log.Info("Rebuilding state snapshot", "root", root)
+	if err := wipeSnapshot(t.diskdb); err != nil {
+		log.Error("Failed to wipe state snapshot", "err", err)
+	}
+	writeGeneratorStatus(t.diskdb, []byte{})
+	writeSnapshotRoot(t.diskdb, root)
+
+	base := &diskLayer{diskdb: t.diskdb, root: root, genMarker: []byte{}}
+	base.startGeneration()
+
+	t.layers = map[common.Hash]snapshot{root: base}
+}
+
+// Stop flattens all diff layers below the given head into the disk layer so the
+// snapshot can be reloaded after a restart, and pauses any running generation,
+// persisting its progress.
+func (t *Tree) Stop(head common.Hash) {
+	if err := t.Cap(head, 0); err != nil {
+		log.Warn("Failed to persist state snapshot", "err", err)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, layer := range t.layers {
+		if disk, ok := layer.(*diskLayer); ok && !disk.Stale() {
+			disk.stopGeneration()
+		}
+	}
+}
+
+// Generating returns whether the disk layer is still being built.
+func (t *Tree) Generating() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	for _, layer := range t.layers {
+		if disk, ok := layer.(*diskLayer); ok && !disk.Stale() {
+			return disk.Generating()
+		}
+	}
+	return false
+}
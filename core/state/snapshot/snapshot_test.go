@@ -0,0 +1,239 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/rlp"
+	"github.com/immesys/bw2bc/trie"
+)
+
+// fullAccount is the consensus encoding of an account as stored in the trie.
+type fullAccount struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash []byte
+}
+
+// makeTestState creates a raw state trie (keyed by hashes) with the given number
+// of accounts, every second one having a few storage slots.
+func makeTestState(t *testing.T, db ethdb.Database, accounts int) common.Hash {
+	accTrie, _ := trie.New(common.Hash{}, db)
+	for i := 0; i < accounts; i++ {
+		root := emptyRoot
+		if i%2 == 0 {
+			stTrie, _ := trie.New(common.Hash{}, db)
+			for j := 0; j < 3; j++ {
+				value, _ := rlp.EncodeToBytes([]byte{byte(i), byte(j)})
+				stTrie.Update(common.BytesToHash([]byte{byte(j + 1)}).Bytes(), value)
+			}
+			var err error
+			if root, err = stTrie.CommitTo(db); err != nil {
+				t.Fatalf("failed to commit storage trie: %v", err)
+			}
+		}
+		blob, _ := rlp.EncodeToBytes(&fullAccount{Nonce: uint64(i), Balance: big.NewInt(int64(i)), Root: root, CodeHash: emptyCode[:]})
+		accTrie.Update(accountHash(i).Bytes(), blob)
+	}
+	root, err := accTrie.CommitTo(db)
+	if err != nil {
+		t.Fatalf("failed to commit account trie: %v", err)
+	}
+	return root
+}
+
+func accountHash(i int) common.Hash {
+	return common.BytesToHash([]byte{0xaa, byte(i)})
+}
+
+func slotHash(j int) common.Hash {
+	return common.BytesToHash([]byte{byte(j + 1)})
+}
+
+// waitGeneration blocks until the disk layer of the tree is fully generated.
+func waitGeneration(t *testing.T, snaps *Tree) {
+	for i := 0; i < 500; i++ {
+		if !snaps.Generating() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("snapshot generation timed out")
+}
+
+// Tests that a snapshot generated from a state trie contains every account and
+// storage slot of it.
+func TestGeneration(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	root := makeTestState(t, db, 20)
+
+	snaps := New(db, root)
+	waitGeneration(t, snaps)
+
+	snap := snaps.Snapshot(root)
+	for i := 0; i < 20; i++ {
+		account, err := snap.Account(accountHash(i))
+		if err != nil || account == nil {
+			t.Fatalf("account %d: missing: %v", i, err)
+		}
+		if account.Nonce != uint64(i) || account.Balance.Int64() != int64(i) {
+			t.Errorf("account %d: content mismatch: have nonce %d balance %v", i, account.Nonce, account.Balance)
+		}
+		if (i%2 == 0) != (len(account.Root) > 0) || len(account.CodeHash) != 0 {
+			t.Errorf("account %d: slim encoding mismatch: root %x, codehash %x", i, account.Root, account.CodeHash)
+		}
+		for j := 0; j < 3; j++ {
+			value, err := snap.Storage(accountHash(i), slotHash(j))
+			if err != nil {
+				t.Fatalf("account %d slot %d: %v", i, j, err)
+			}
+			if i%2 == 0 {
+				want, _ := rlp.EncodeToBytes([]byte{byte(i), byte(j)})
+				if !bytes.Equal(value, want) {
+					t.Errorf("account %d slot %d: value mismatch: have %x, want %x", i, j, value, want)
+				}
+			} else if value != nil {
+				t.Errorf("account %d slot %d: unexpected value %x", i, j, value)
+			}
+		}
+	}
+	if account, err := snap.Account(accountHash(100)); account != nil || err != nil {
+		t.Errorf("non-existent account returned: %v, %v", account, err)
+	}
+	// Reloading the snapshot from disk must not regenerate it
+	if snaps = New(db, root); snaps.Generating() {
+		t.Errorf("complete snapshot regenerated on reload")
+	}
+}
+
+// Tests that diff layers shadow their parents, including account destructions,
+// and that capping the tree merges them correctly into the disk layer.
+func TestDiffLayersAndCap(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	root := makeTestState(t, db, 4)
+
+	snaps := New(db, root)
+	waitGeneration(t, snaps)
+
+	var (
+		root1 = common.Hash{0x01}
+		root2 = common.Hash{0x02}
+		root3 = common.Hash{0x03}
+	)
+	// Layer 1 modifies account 0 and one of its slots, deletes another slot
+	if err := snaps.Update(root1, root, nil,
+		map[common.Hash][]byte{accountHash(0): SlimAccountRLP(100, big.NewInt(100), emptyRoot, emptyCode[:])},
+		map[common.Hash]map[common.Hash][]byte{accountHash(0): {slotHash(0): []byte{0x42}, slotHash(1): nil}},
+	); err != nil {
+		t.Fatalf("failed to add layer 1: %v", err)
+	}
+	// Layer 2 destructs account 2
+	if err := snaps.Update(root2, root1, map[common.Hash]struct{}{accountHash(2): {}}, nil, nil); err != nil {
+		t.Fatalf("failed to add layer 2: %v", err)
+	}
+	// Layer 3 branches off of layer 1 as a side chain
+	if err := snaps.Update(root3, root1, nil, map[common.Hash][]byte{accountHash(1): SlimAccountRLP(7, big.NewInt(7), emptyRoot, emptyCode[:])}, nil); err != nil {
+		t.Fatalf("failed to add layer 3: %v", err)
+	}
+	if err := snaps.Update(common.Hash{0xff}, common.Hash{0xfe}, nil, nil, nil); err == nil {
+		t.Errorf("dangling layer accepted")
+	}
+	check := func(snap Snapshot) {
+		if account, _ := snap.Account(accountHash(0)); account == nil || account.Nonce != 100 {
+			t.Errorf("account 0: modification lost: %+v", account)
+		}
+		if value, _ := snap.Storage(accountHash(0), slotHash(0)); !bytes.Equal(value, []byte{0x42}) {
+			t.Errorf("slot 0: modification lost: %x", value)
+		}
+		if value, _ := snap.Storage(accountHash(0), slotHash(1)); len(value) != 0 {
+			t.Errorf("slot 1: deletion lost: %x", value)
+		}
+		if value, _ := snap.Storage(accountHash(0), slotHash(2)); len(value) == 0 {
+			t.Errorf("slot 2: untouched slot lost")
+		}
+		if account, _ := snap.Account(accountHash(2)); account != nil {
+			t.Errorf("account 2: destruction lost: %+v", account)
+		}
+		if value, _ := snap.Storage(accountHash(2), slotHash(0)); len(value) != 0 {
+			t.Errorf("account 2: storage survived destruction: %x", value)
+		}
+	}
+	check(snaps.Snapshot(root2))
+
+	// Flatten everything into the disk layer and recheck
+	if err := snaps.Cap(root2, 0); err != nil {
+		t.Fatalf("failed to cap tree: %v", err)
+	}
+	disk := snaps.Snapshot(root2)
+	if _, ok := disk.(*diskLayer); !ok {
+		t.Fatalf("head not flattened into disk: %T", disk)
+	}
+	check(disk)
+
+	// The side chain branched off a flattened layer and must be gone
+	if snaps.Snapshot(root3) != nil {
+		t.Errorf("side chain layer survived flattening")
+	}
+	if readSnapshotRoot(db) != root2 {
+		t.Errorf("persisted root mismatch: have %x, want %x", readSnapshotRoot(db), root2)
+	}
+}
+
+// Tests that flattening diff layers while the disk layer is being generated
+// only persists the already covered entries and lets the generator continue.
+func TestCapDuringGeneration(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	root := makeTestState(t, db, 4)
+
+	// Build the second state on top as a real trie, so the generator can resume on it
+	accTrie, _ := trie.New(root, db)
+	blob, _ := rlp.EncodeToBytes(&fullAccount{Nonce: 9, Balance: big.NewInt(9), Root: emptyRoot, CodeHash: emptyCode[:]})
+	accTrie.Update(accountHash(3).Bytes(), blob)
+	root1, _ := accTrie.CommitTo(db)
+
+	// Pretend only the first account was generated so far
+	wipeSnapshot(db)
+	base := &diskLayer{diskdb: db, root: root, genMarker: accountHash(0).Bytes()}
+	db.Put(accountKey(accountHash(0)), SlimAccountRLP(0, big.NewInt(0), emptyRoot, emptyCode[:]))
+	snaps := &Tree{diskdb: db, layers: map[common.Hash]snapshot{root: base}}
+
+	if _, err := base.AccountRLP(accountHash(1)); err != ErrNotCoveredYet {
+		t.Fatalf("uncovered account error mismatch: have %v, want %v", err, ErrNotCoveredYet)
+	}
+	if err := snaps.Update(root1, root, nil, map[common.Hash][]byte{accountHash(3): SlimAccountRLP(9, big.NewInt(9), emptyRoot, emptyCode[:])}, nil); err != nil {
+		t.Fatalf("failed to add layer: %v", err)
+	}
+	if err := snaps.Cap(root1, 0); err != nil {
+		t.Fatalf("failed to cap tree: %v", err)
+	}
+	waitGeneration(t, snaps)
+
+	snap := snaps.Snapshot(root1)
+	if account, err := snap.Account(accountHash(3)); err != nil || account == nil || account.Nonce != 9 {
+		t.Errorf("account 3 mismatch: have %+v (%v), want nonce 9", account, err)
+	}
+	if value, err := snap.Storage(accountHash(2), slotHash(1)); err != nil || len(value) == 0 {
+		t.Errorf("account 2 storage missing after resumed generation: %x (%v)", value, err)
+	}
+}
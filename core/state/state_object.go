@@ -81,10 +81,13 @@ type stateObject struct {
 	cachedStorage Storage // Storage entry cache to avoid duplicate reads
 	dirtyStorage  Storage // Storage entries that need to be flushed to disk
 
+	snapStorage map[common.Hash][]byte // Storage changes flushed to the trie, pending for the snapshot
+
 	// Cache flags.
 	// When an object is marked suicided it will be delete from the trie
 	// during the "update" phase of the state transition.
 	dirtyCode bool // true if the code was updated
+	created   bool // true if the account was (re)created, its storage is not in the snapshot
 	suicided  bool
 	touched   bool
 	deleted   bool
@@ -175,8 +178,8 @@ func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
 	if exists {
 		return value
 	}
-	// Load from DB in case it is missing.
-	enc, err := self.getTrie(db).TryGet(key[:])
+	// Load from the snapshot or the DB in case it is missing.
+	enc, err := self.readStorage(db, key)
 	if err != nil {
 		self.setError(err)
 		return common.Hash{}
@@ -194,6 +197,21 @@ func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
 	return value
 }
 
+// readStorage retrieves the RLP encoded value of a storage slot, preferring the
+// flat state snapshot for accounts which were not recreated in this state.
+func (self *stateObject) readStorage(db Database, key common.Hash) ([]byte, error) {
+	if self.db.snap != nil && !self.created {
+		hash := crypto.Keccak256Hash(key[:])
+		if enc, ok := self.snapStorage[hash]; ok {
+			return enc, nil
+		}
+		if enc, err := self.db.snap.Storage(self.addrHash, hash); err == nil {
+			return enc, nil
+		}
+	}
+	return self.getTrie(db).TryGet(key[:])
+}
+
 // SetState updates a value in account storage.
 func (self *stateObject) SetState(db Database, key, value common.Hash) {
 	self.db.journal = append(self.db.journal, storageChange{
@@ -219,13 +237,22 @@ func (self *stateObject) updateTrie(db Database) Trie {
 	tr := self.getTrie(db)
 	for key, value := range self.dirtyStorage {
 		delete(self.dirtyStorage, key)
+
+		var v []byte
 		if (value == common.Hash{}) {
 			self.setError(tr.TryDelete(key[:]))
-			continue
+		} else {
+			// Encoding []byte cannot fail, ok to ignore the error.
+			v, _ = rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
+			self.setError(tr.TryUpdate(key[:], v))
+		}
+		// Track the change for the snapshot, keyed the same way as the trie
+		if self.db.snap != nil {
+			if self.snapStorage == nil {
+				self.snapStorage = make(map[common.Hash][]byte)
+			}
+			self.snapStorage[crypto.Keccak256Hash(key[:])] = v
 		}
-		// Encoding []byte cannot fail, ok to ignore the error.
-		v, _ := rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
-		self.setError(tr.TryUpdate(key[:], v))
 	}
 	return tr
 }
@@ -301,6 +328,13 @@ func (self *stateObject) deepCopy(db *StateDB, onDirty func(addr common.Address)
 	stateObject.code = self.code
 	stateObject.dirtyStorage = self.dirtyStorage.Copy()
 	stateObject.cachedStorage = self.dirtyStorage.Copy()
+	if self.snapStorage != nil {
+		stateObject.snapStorage = make(map[common.Hash][]byte, len(self.snapStorage))
+		for hash, value := range self.snapStorage {
+			stateObject.snapStorage[hash] = value
+		}
+	}
+	stateObject.created = self.created
 	stateObject.suicided = self.suicided
 	stateObject.dirtyCode = self.dirtyCode
 	stateObject.deleted = self.deleted
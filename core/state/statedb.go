@@ -24,6 +24,7 @@ import (
 	"sync"
 
 	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/state/snapshot"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/crypto"
 	"github.com/immesys/bw2bc/log"
@@ -45,6 +46,9 @@ type StateDB struct {
 	db   Database
 	trie Trie
 
+	snaps *snapshot.Tree    // Snapshot tree to feed the state changes into, nil if disabled
+	snap  snapshot.Snapshot // Flat view of the state at the current root, nil if unavailable
+
 	// This map holds 'live' objects, which will get modified while processing a state transition.
 	stateObjects           map[common.Address]*stateObject
 	stateObjectsDirty      map[common.Address]struct{}
@@ -94,6 +98,21 @@ func New(root common.Hash, db Database) (*StateDB, error) {
 	}, nil
 }
 
+// NewWithSnapshot creates a new state from a given trie, serving account and
+// storage reads from the flat snapshot tree where possible. The changes made to
+// the state are pushed into the tree as a new layer on commit.
+func NewWithSnapshot(root common.Hash, db Database, snaps *snapshot.Tree) (*StateDB, error) {
+	state, err := New(root, db)
+	if err != nil {
+		return nil, err
+	}
+	if snaps != nil {
+		state.snaps = snaps
+		state.snap = snaps.Snapshot(root)
+	}
+	return state, nil
+}
+
 // setError remembers the first non-nil error it is called with.
 func (self *StateDB) setError(err error) {
 	if self.dbErr == nil {
@@ -113,6 +132,9 @@ func (self *StateDB) Reset(root common.Hash) error {
 		return err
 	}
 	self.trie = tr
+	if self.snaps != nil {
+		self.snap = self.snaps.Snapshot(root)
+	}
 	self.stateObjects = make(map[common.Address]*stateObject)
 	self.stateObjectsDirty = make(map[common.Address]struct{})
 	self.stateObjectsDestructed = make(map[common.Address]struct{})
@@ -359,16 +381,32 @@ func (self *StateDB) getStateObject(addr common.Address) (stateObject *stateObje
 		return obj
 	}
 
-	// Load the object from the database.
-	enc, err := self.trie.TryGet(addr[:])
-	if len(enc) == 0 {
-		self.setError(err)
-		return nil
+	// Load the object from the flat snapshot if it's available and covers the
+	// account, otherwise fall back to the database.
+	var (
+		data   Account
+		loaded bool
+	)
+	if self.snap != nil {
+		if acc, err := self.snap.Account(crypto.Keccak256Hash(addr[:])); err == nil {
+			if acc == nil {
+				return nil
+			}
+			data.Nonce, data.Balance = acc.Nonce, acc.Balance
+			data.Root, data.CodeHash = acc.FullRoot(), acc.FullCodeHash()
+			loaded = true
+		}
 	}
-	var data Account
-	if err := rlp.DecodeBytes(enc, &data); err != nil {
-		log.Error("Failed to decode state object", "addr", addr, "err", err)
-		return nil
+	if !loaded {
+		enc, err := self.trie.TryGet(addr[:])
+		if len(enc) == 0 {
+			self.setError(err)
+			return nil
+		}
+		if err := rlp.DecodeBytes(enc, &data); err != nil {
+			log.Error("Failed to decode state object", "addr", addr, "err", err)
+			return nil
+		}
 	}
 	// Insert into the live set.
 	obj := newObject(self, addr, data, self.MarkStateObjectDirty)
@@ -400,6 +438,7 @@ func (self *StateDB) MarkStateObjectDirty(addr common.Address) {
 func (self *StateDB) createObject(addr common.Address) (newobj, prev *stateObject) {
 	prev = self.getStateObject(addr)
 	newobj = newObject(self, addr, Account{}, self.MarkStateObjectDirty)
+	newobj.created = true
 	newobj.setNonce(0) // sets the object to dirty
 	if prev == nil {
 		self.journal = append(self.journal, createObjectChange{account: &addr})
@@ -458,6 +497,8 @@ func (self *StateDB) Copy() *StateDB {
 	state := &StateDB{
 		db:                     self.db,
 		trie:                   self.trie,
+		snaps:                  self.snaps,
+		snap:                   self.snap,
 		stateObjects:           make(map[common.Address]*stateObject, len(self.stateObjectsDirty)),
 		stateObjectsDirty:      make(map[common.Address]struct{}, len(self.stateObjectsDirty)),
 		stateObjectsDestructed: make(map[common.Address]struct{}, len(self.stateObjectsDestructed)),
@@ -590,6 +631,17 @@ func (s *StateDB) clearJournalAndRefund() {
 func (s *StateDB) CommitTo(dbw trie.DatabaseWriter, deleteEmptyObjects bool) (root common.Hash, err error) {
 	defer s.clearJournalAndRefund()
 
+	// Gather the flat changes for the snapshot if one is tracked for this state
+	var (
+		destructs map[common.Hash]struct{}
+		accounts  map[common.Hash][]byte
+		storage   map[common.Hash]map[common.Hash][]byte
+	)
+	if s.snap != nil {
+		destructs = make(map[common.Hash]struct{})
+		accounts = make(map[common.Hash][]byte)
+		storage = make(map[common.Hash]map[common.Hash][]byte)
+	}
 	// Commit objects to the trie.
 	for addr, stateObject := range s.stateObjects {
 		_, isDirty := s.stateObjectsDirty[addr]
@@ -598,6 +650,9 @@ func (s *StateDB) CommitTo(dbw trie.DatabaseWriter, deleteEmptyObjects bool) (ro
 			// If the object has been removed, don't bother syncing it
 			// and just mark it for deletion in the trie.
 			s.deleteStateObject(stateObject)
+			if s.snap != nil {
+				destructs[stateObject.addrHash] = struct{}{}
+			}
 		case isDirty:
 			// Write any contract code associated with the state object
 			if stateObject.code != nil && stateObject.dirtyCode {
@@ -612,11 +667,33 @@ func (s *StateDB) CommitTo(dbw trie.DatabaseWriter, deleteEmptyObjects bool) (ro
 			}
 			// Update the object in the main account trie.
 			s.updateStateObject(stateObject)
+
+			// Recreated accounts must drop any storage of their previous incarnation
+			if s.snap != nil {
+				if stateObject.created {
+					destructs[stateObject.addrHash] = struct{}{}
+				}
+				accounts[stateObject.addrHash] = snapshot.SlimAccountRLP(stateObject.data.Nonce, stateObject.data.Balance, stateObject.data.Root, stateObject.data.CodeHash)
+				if len(stateObject.snapStorage) > 0 {
+					storage[stateObject.addrHash] = stateObject.snapStorage
+				}
+			}
+			stateObject.created, stateObject.snapStorage = false, nil
 		}
 		delete(s.stateObjectsDirty, addr)
 	}
 	// Write trie changes.
 	root, err = s.trie.CommitTo(dbw)
 	log.Debug("Trie cache stats after commit", "misses", trie.CacheMisses(), "unloads", trie.CacheUnloads())
-	return root, err
+	if err != nil {
+		return root, err
+	}
+	// Push the changes into the snapshot tree and switch over to the new layer
+	if s.snap != nil {
+		if err := s.snaps.Update(root, s.snap.Root(), destructs, accounts, storage); err != nil {
+			log.Debug("Failed to update state snapshot", "parent", s.snap.Root(), "root", root, "err", err)
+		}
+		s.snap = s.snaps.Snapshot(root)
+	}
+	return root, nil
 }
@@ -27,14 +27,85 @@ import (
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	check "gopkg.in/check.v1"
 
 	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/state/snapshot"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/ethdb"
 )
 
+// Tests that state changes committed on top of a snapshot are reflected in the
+// new snapshot layer, and that reads served by the snapshot match the trie.
+func TestSnapshotCommit(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	sdb := NewDatabase(db)
+
+	// Create an initial state with a few contracts and plain accounts
+	state, _ := New(common.Hash{}, sdb)
+	for i := byte(0); i < 8; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		state.AddBalance(addr, big.NewInt(int64(i)+1))
+		if i%2 == 0 {
+			state.SetCode(addr, []byte{i, i})
+			for j := byte(0); j < 4; j++ {
+				state.SetState(addr, common.BytesToHash([]byte{j}), common.BytesToHash([]byte{i, j}))
+			}
+		}
+	}
+	root, _ := state.CommitTo(db, false)
+
+	snaps := snapshot.New(db, root)
+	for i := 0; snaps.Generating(); i++ {
+		if i == 500 {
+			t.Fatalf("snapshot generation timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Modify the state through the snapshot: updates, deletions, suicides and a
+	// recreation of a contract with fresh storage
+	state, _ = NewWithSnapshot(root, sdb, snaps)
+	if have := state.GetState(common.BytesToAddress([]byte{2}), common.BytesToHash([]byte{1})); have != common.BytesToHash([]byte{2, 1}) {
+		t.Fatalf("snapshot storage read mismatch: have %x", have)
+	}
+	state.SetBalance(common.BytesToAddress([]byte{1}), big.NewInt(100))
+	state.SetState(common.BytesToAddress([]byte{0}), common.BytesToHash([]byte{0}), common.Hash{})
+	state.SetState(common.BytesToAddress([]byte{0}), common.BytesToHash([]byte{1}), common.BytesToHash([]byte{0xff}))
+	state.IntermediateRoot(false)
+	state.Suicide(common.BytesToAddress([]byte{2}))
+	state.IntermediateRoot(false)
+	state.CreateAccount(common.BytesToAddress([]byte{4}))
+	state.SetState(common.BytesToAddress([]byte{4}), common.BytesToHash([]byte{9}), common.BytesToHash([]byte{9}))
+
+	root2, _ := state.CommitTo(db, false)
+	if snaps.Snapshot(root2) == nil {
+		t.Fatalf("snapshot layer not created for committed state")
+	}
+	// Every read through the snapshot must match the trie
+	snapState, _ := NewWithSnapshot(root2, sdb, snaps)
+	trieState, _ := New(root2, sdb)
+	for i := byte(0); i < 8; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		if snapState.Exist(addr) != trieState.Exist(addr) {
+			t.Errorf("account %x: existence mismatch", addr)
+		}
+		if have, want := snapState.GetBalance(addr), trieState.GetBalance(addr); have.Cmp(want) != 0 {
+			t.Errorf("account %x: balance mismatch: have %v, want %v", addr, have, want)
+		}
+		if have, want := snapState.GetCodeHash(addr), trieState.GetCodeHash(addr); have != want {
+			t.Errorf("account %x: code hash mismatch: have %x, want %x", addr, have, want)
+		}
+		for j := byte(0); j < 10; j++ {
+			key := common.BytesToHash([]byte{j})
+			if have, want := snapState.GetState(addr, key), trieState.GetState(addr, key); have != want {
+				t.Errorf("account %x slot %x: value mismatch: have %x, want %x", addr, key, have, want)
+			}
+		}
+	}
+}
+
 // Tests that updating a state trie does not leak any database writes prior to
 // actually committing the state.
 func TestUpdateLeaks(t *testing.T) {
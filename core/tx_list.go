@@ -380,6 +380,35 @@ func (h *priceHeap) Pop() interface{} {
 	return x
 }
 
+// txTails is a heap of the nonce sorted transactions of accounts, ordered by the
+// price of their highest nonce transaction (the tail), cheapest first. Evicting
+// tails only ensures that no nonce gaps are left behind.
+type txTails []types.Transactions
+
+func (h txTails) Len() int      { return len(h) }
+func (h txTails) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h txTails) Less(i, j int) bool {
+	ti, tj := h[i][len(h[i])-1], h[j][len(h[j])-1]
+	if cmp := ti.GasPrice().Cmp(tj.GasPrice()); cmp != 0 {
+		return cmp < 0
+	}
+	// On price ties, shrink the longer nonce sequence first
+	return len(h[i]) > len(h[j])
+}
+
+func (h *txTails) Push(x interface{}) {
+	*h = append(*h, x.(types.Transactions))
+}
+
+func (h *txTails) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
 // txPricedList is a price-sorted heap to allow operating on transactions pool
 // contents in a price-incrementing way.
 type txPricedList struct {
@@ -17,10 +17,10 @@
 package core
 
 import (
+	"container/heap"
 	"errors"
 	"fmt"
 	"math/big"
-	"sort"
 	"sync"
 	"time"
 
@@ -31,7 +31,6 @@ import (
 	"github.com/immesys/bw2bc/log"
 	"github.com/immesys/bw2bc/metrics"
 	"github.com/immesys/bw2bc/params"
-	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
 
 var (
@@ -72,9 +71,28 @@ var (
 	ErrOversizedData = errors.New("oversized data")
 )
 
+var (
+	// ErrTxPoolOverflow is reported when a transaction is evicted to keep the pool
+	// within its global pending or queued limits.
+	ErrTxPoolOverflow = errors.New("transaction pool overflow")
+
+	// ErrAccountLimit is reported when a transaction is evicted because its sender
+	// queued up more future transactions than allowed for a single account.
+	ErrAccountLimit = errors.New("account queue limit exceeded")
+
+	// ErrTxExpired is reported when a queued transaction is evicted because its
+	// sender was inactive for longer than the configured lifetime.
+	ErrTxExpired = errors.New("transaction lifetime exceeded")
+
+	// ErrTxRemoved is reported when a transaction is removed from the pool by an
+	// explicit request (e.g. a resend or a failed mining attempt).
+	ErrTxRemoved = errors.New("transaction removed")
+)
+
 var (
 	evictionInterval    = time.Minute     // Time interval to check for evictable transactions
 	statsReportInterval = 8 * time.Second // Time interval to report transaction pool stats
+	maxQueuedTxEvents   = 4096            // Maximum number of lifecycle events awaiting delivery
 )
 
 var (
@@ -93,13 +111,16 @@ var (
 	// General tx metrics
 	invalidTxCounter     = metrics.NewCounter("txpool/invalid")
 	underpricedTxCounter = metrics.NewCounter("txpool/underpriced")
+	droppedEventCounter  = metrics.NewCounter("txpool/events/dropped") // Lifecycle events lost to slow subscribers
 )
 
 type stateFn func() (*state.StateDB, error)
 
 // TxPoolConfig are the configuration parameters of the transaction pool.
 type TxPoolConfig struct {
-	NoLocals bool // Whether local transaction handling should be disabled
+	NoLocals  bool          // Whether local transaction handling should be disabled
+	Journal   string        // Journal of local transactions to survive node restarts
+	Rejournal time.Duration // Time interval to regenerate the local transaction journal
 
 	PriceLimit uint64 // Minimum gas price to enforce for acceptance into the pool
 	PriceBump  uint64 // Minimum price bump percentage to replace an already existing transaction (nonce)
@@ -115,6 +136,9 @@ type TxPoolConfig struct {
 // DefaultTxPoolConfig contains the default configurations for the transaction
 // pool.
 var DefaultTxPoolConfig = TxPoolConfig{
+	Journal:   "transactions.rlp",
+	Rejournal: time.Hour,
+
 	PriceLimit: 1,
 	PriceBump:  10,
 
@@ -138,6 +162,10 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 		log.Warn("Sanitizing invalid txpool price bump", "provided", conf.PriceBump, "updated", DefaultTxPoolConfig.PriceBump)
 		conf.PriceBump = DefaultTxPoolConfig.PriceBump
 	}
+	if conf.Rejournal < time.Second {
+		log.Warn("Sanitizing invalid txpool journal time", "provided", conf.Rejournal, "updated", time.Second)
+		conf.Rejournal = time.Second
+	}
 	return conf
 }
 
@@ -157,7 +185,8 @@ type TxPool struct {
 	gasPrice     *big.Int
 	eventMux     *event.TypeMux
 	events       *event.TypeMuxSubscription
-	locals       *accountSet
+	locals       *accountSet // Set of local transaction to exempt from eviction rules
+	journal      *txJournal  // Journal of local transaction to back up to disk
 	signer       types.Signer
 	mu           sync.RWMutex
 
@@ -166,6 +195,12 @@ type TxPool struct {
 	beats   map[common.Address]time.Time       // Last heartbeat from each known account
 	all     map[common.Hash]*types.Transaction // All transactions to allow lookups
 	priced  *txPricedList                      // All transactions sorted by price
+	mined   map[common.Hash]struct{}           // Pooled transactions included in recent blocks
+
+	txFeed      event.Feed
+	scope       event.SubscriptionScope
+	txEvents    []TxPoolEvent // Lifecycle events waiting to be delivered to subscribers
+	txEventsSig chan struct{} // Channel to wake up the event delivery loop
 
 	wg   sync.WaitGroup // for shutdown sync
 	quit chan struct{}
@@ -188,22 +223,37 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, eventMux *e
 		queue:        make(map[common.Address]*txList),
 		beats:        make(map[common.Address]time.Time),
 		all:          make(map[common.Hash]*types.Transaction),
+		mined:        make(map[common.Hash]struct{}),
+		txEventsSig:  make(chan struct{}, 1),
 		eventMux:     eventMux,
 		currentState: currentStateFn,
 		gasLimit:     gasLimitFn,
 		gasPrice:     new(big.Int).SetUint64(config.PriceLimit),
 		pendingState: nil,
-		events:       eventMux.Subscribe(ChainHeadEvent{}, RemovedTransactionEvent{}),
+		events:       eventMux.Subscribe(ChainHeadEvent{}, ChainEvent{}, RemovedTransactionEvent{}),
 		quit:         make(chan struct{}),
 	}
 	pool.locals = newAccountSet(pool.signer)
 	pool.priced = newTxPricedList(&pool.all)
 	pool.resetState()
 
+	// If local transactions and journaling is enabled, load from disk
+	if !config.NoLocals && config.Journal != "" {
+		pool.journal = newTxJournal(config.Journal)
+
+		if err := pool.journal.load(pool.AddLocal); err != nil {
+			log.Warn("Failed to load transaction journal", "err", err)
+		}
+		if err := pool.journal.rotate(pool.local()); err != nil {
+			log.Warn("Failed to rotate transaction journal", "err", err)
+		}
+	}
+
 	// Start the various events loops and return
-	pool.wg.Add(2)
+	pool.wg.Add(3)
 	go pool.eventLoop()
 	go pool.expirationLoop()
+	go pool.feedLoop()
 
 	return pool
 }
@@ -217,6 +267,9 @@ func (pool *TxPool) eventLoop() {
 	report := time.NewTicker(statsReportInterval)
 	defer report.Stop()
 
+	journal := time.NewTicker(pool.config.Rejournal)
+	defer journal.Stop()
+
 	// Track chain events. When a chain events occurs (new chain canon block)
 	// we need to know the new state. The new state will help us determine
 	// the nonces in the managed state
@@ -234,10 +287,21 @@ func (pool *TxPool) eventLoop() {
 					if pool.chainconfig.IsHomestead(ev.Block.Number()) {
 						pool.homestead = true
 					}
+					pool.markMined(ev.Block)
 				}
 				pool.resetState()
+				pool.mined = make(map[common.Hash]struct{})
 				pool.mu.Unlock()
 
+			case ChainEvent:
+				// Head events are only fired for the last block of an import, so
+				// remember any pooled transactions included in intermediate ones
+				if pool.scope.Count() > 0 {
+					pool.mu.Lock()
+					pool.markMined(ev.Block)
+					pool.mu.Unlock()
+				}
+
 			case RemovedTransactionEvent:
 				pool.addTxs(ev.Txs, false)
 			}
@@ -253,10 +317,85 @@ func (pool *TxPool) eventLoop() {
 				log.Debug("Transaction pool status report", "executable", pending, "queued", queued, "stales", stales)
 				prevPending, prevQueued, prevStales = pending, queued, stales
 			}
+
+		// Handle local transaction journal rotation
+		case <-journal.C:
+			if pool.journal != nil {
+				pool.mu.Lock()
+				if err := pool.journal.rotate(pool.local()); err != nil {
+					log.Warn("Failed to rotate local tx journal", "err", err)
+				}
+				pool.mu.Unlock()
+			}
 		}
 	}
 }
 
+// markMined records the pooled transactions included in the given block, so that
+// they are reported as mined instead of dropped when the pool is reset.
+func (pool *TxPool) markMined(block *types.Block) {
+	for _, tx := range block.Transactions() {
+		if hash := tx.Hash(); pool.all[hash] != nil {
+			pool.mined[hash] = struct{}{}
+		}
+	}
+}
+
+// feedLoop delivers the queued up lifecycle events to the subscribers outside of
+// the pool lock, so slow consumers cannot stall transaction processing.
+func (pool *TxPool) feedLoop() {
+	defer pool.wg.Done()
+
+	for {
+		select {
+		case <-pool.txEventsSig:
+			pool.mu.Lock()
+			events := pool.txEvents
+			pool.txEvents = nil
+			pool.mu.Unlock()
+
+			for _, ev := range events {
+				pool.txFeed.Send(ev)
+			}
+
+		case <-pool.quit:
+			return
+		}
+	}
+}
+
+// notify queues a lifecycle event of the given transaction for delivery to the
+// subscribers. The caller must hold the pool lock.
+func (pool *TxPool) notify(kind TxPoolEventKind, tx *types.Transaction, reason error) {
+	if pool.scope.Count() == 0 {
+		return
+	}
+	// Drop the event if the subscribers can't keep up, rather than buffering
+	// an unbounded backlog for them
+	if len(pool.txEvents) >= maxQueuedTxEvents {
+		droppedEventCounter.Inc(1)
+		log.Trace("Dropping transaction pool event", "hash", tx.Hash(), "kind", kind)
+		return
+	}
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	pool.txEvents = append(pool.txEvents, TxPoolEvent{Kind: kind, Tx: tx, From: from, Reason: reason})
+
+	select {
+	case pool.txEventsSig <- struct{}{}:
+	default:
+	}
+}
+
+// notifyForwarded reports transactions dropped due to their nonce being used up,
+// which are either mined or were superseded by a different transaction on chain.
+func (pool *TxPool) notifyForwarded(tx *types.Transaction) {
+	if _, ok := pool.mined[tx.Hash()]; ok {
+		pool.notify(TxMined, tx, nil)
+	} else {
+		pool.notify(TxDropped, tx, ErrNonceTooLow)
+	}
+}
+
 func (pool *TxPool) resetState() {
 	currentState, err := pool.currentState()
 	if err != nil {
@@ -287,6 +426,12 @@ func (pool *TxPool) Stop() {
 	close(pool.quit)
 	pool.wg.Wait()
 
+	pool.scope.Close()
+
+	if pool.journal != nil {
+		pool.journal.close()
+	}
+
 	log.Info("Transaction pool stopped")
 }
 
@@ -306,7 +451,7 @@ func (pool *TxPool) SetGasPrice(price *big.Int) {
 
 	pool.gasPrice = price
 	for _, tx := range pool.priced.Cap(price, pool.locals) {
-		pool.removeTx(tx.Hash())
+		pool.removeTx(tx.Hash(), ErrUnderpriced)
 	}
 	log.Info("Transaction pool price threshold updated", "price", price)
 }
@@ -359,6 +504,44 @@ func (pool *TxPool) Content() (map[common.Address]types.Transactions, map[common
 	return pending, queued
 }
 
+// ContentFrom retrieves the data content of the transaction pool, returning the
+// pending as well as queued transactions of the given account, sorted by nonce.
+func (pool *TxPool) ContentFrom(addr common.Address) (types.Transactions, types.Transactions) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	var pending, queued types.Transactions
+	if list := pool.pending[addr]; list != nil {
+		pending = list.Flatten()
+	}
+	if list := pool.queue[addr]; list != nil {
+		queued = list.Flatten()
+	}
+	return pending, queued
+}
+
+// SubscribeTxPoolEvent registers a subscription of TxPoolEvent, reporting every
+// transaction as it is added, promoted, replaced, dropped or mined.
+func (pool *TxPool) SubscribeTxPoolEvent(ch chan<- TxPoolEvent) event.Subscription {
+	return pool.scope.Track(pool.txFeed.Subscribe(ch))
+}
+
+// local retrieves all currently known local transactions, grouped by origin
+// account and sorted by nonce. The returned transaction set is a copy and can be
+// freely modified by calling code.
+func (pool *TxPool) local() map[common.Address]types.Transactions {
+	txs := make(map[common.Address]types.Transactions)
+	for addr := range pool.locals.accounts {
+		if pending := pool.pending[addr]; pending != nil {
+			txs[addr] = append(txs[addr], pending.Flatten()...)
+		}
+		if queued := pool.queue[addr]; queued != nil {
+			txs[addr] = append(txs[addr], queued.Flatten()...)
+		}
+	}
+	return txs
+}
+
 // Pending retrieves all currently processable transactions, groupped by origin
 // account and sorted by nonce. The returned transaction set is a copy and can be
 // freely modified by calling code.
@@ -438,6 +621,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 	if err := pool.validateTx(tx, local); err != nil {
 		log.Trace("Discarding invalid transaction", "hash", hash, "err", err)
 		invalidTxCounter.Inc(1)
+		pool.notify(TxDropped, tx, err)
 		return false, err
 	}
 	// If the transaction pool is full, discard underpriced transactions
@@ -446,6 +630,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		if pool.priced.Underpriced(tx, pool.locals) {
 			log.Trace("Discarding underpriced transaction", "hash", hash, "price", tx.GasPrice())
 			underpricedTxCounter.Inc(1)
+			pool.notify(TxDropped, tx, ErrUnderpriced)
 			return false, ErrUnderpriced
 		}
 		// New transaction is better than our worse ones, make room for it
@@ -453,7 +638,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		for _, tx := range drop {
 			log.Trace("Discarding freshly underpriced transaction", "hash", tx.Hash(), "price", tx.GasPrice())
 			underpricedTxCounter.Inc(1)
-			pool.removeTx(tx.Hash())
+			pool.removeTx(tx.Hash(), ErrUnderpriced)
 		}
 	}
 	// If the transaction is replacing an already pending one, do directly
@@ -463,6 +648,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		inserted, old := list.Add(tx, pool.config.PriceBump)
 		if !inserted {
 			pendingDiscardCounter.Inc(1)
+			pool.notify(TxDropped, tx, ErrReplaceUnderpriced)
 			return false, ErrReplaceUnderpriced
 		}
 		// New transaction is better, replace old one
@@ -470,9 +656,14 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 			delete(pool.all, old.Hash())
 			pool.priced.Removed()
 			pendingReplaceCounter.Inc(1)
+			pool.notify(TxReplaced, old, nil)
 		}
 		pool.all[tx.Hash()] = tx
 		pool.priced.Put(tx)
+		pool.journalTx(from, tx)
+
+		pool.notify(TxAdded, tx, nil)
+		pool.notify(TxPromoted, tx, nil)
 
 		log.Trace("Pooled new executable transaction", "hash", hash, "from", from, "to", tx.To())
 		return old != nil, nil
@@ -480,15 +671,31 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 	// New transaction isn't replacing a pending one, push into queue and potentially mark local
 	replace, err := pool.enqueueTx(hash, tx)
 	if err != nil {
+		pool.notify(TxDropped, tx, err)
 		return false, err
 	}
 	if local {
 		pool.locals.add(from)
 	}
+	pool.journalTx(from, tx)
+	pool.notify(TxAdded, tx, nil)
+
 	log.Trace("Pooled new future transaction", "hash", hash, "from", from, "to", tx.To())
 	return replace, nil
 }
 
+// journalTx adds the specified transaction to the local disk journal if it is
+// deemed to have been sent from a local account.
+func (pool *TxPool) journalTx(from common.Address, tx *types.Transaction) {
+	// Only journal if it's enabled and the transaction is local
+	if pool.journal == nil || !pool.locals.contains(from) {
+		return
+	}
+	if err := pool.journal.insert(tx); err != nil {
+		log.Warn("Failed to journal local transaction", "err", err)
+	}
+}
+
 // enqueueTx inserts a new transaction into the non-executable transaction queue.
 //
 // Note, this method assumes the pool lock is held!
@@ -509,9 +716,13 @@ func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction) (bool, er
 		delete(pool.all, old.Hash())
 		pool.priced.Removed()
 		queuedReplaceCounter.Inc(1)
+		pool.notify(TxReplaced, old, nil)
+	}
+	// Demoted transactions are already tracked, don't duplicate their price points
+	if pool.all[hash] == nil {
+		pool.all[hash] = tx
+		pool.priced.Put(tx)
 	}
-	pool.all[hash] = tx
-	pool.priced.Put(tx)
 	return old != nil, nil
 }
 
@@ -532,6 +743,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 		pool.priced.Removed()
 
 		pendingDiscardCounter.Inc(1)
+		pool.notify(TxDropped, tx, ErrReplaceUnderpriced)
 		return
 	}
 	// Otherwise discard any previous transaction and mark this
@@ -540,6 +752,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 		pool.priced.Removed()
 
 		pendingReplaceCounter.Inc(1)
+		pool.notify(TxReplaced, old, nil)
 	}
 	// Failsafe to work around direct pending inserts (tests)
 	if pool.all[hash] == nil {
@@ -549,6 +762,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 	// Set the potentially new pending nonce and notify any subsystems of the new tx
 	pool.beats[addr] = time.Now()
 	pool.pendingState.SetNonce(addr, tx.Nonce()+1)
+	pool.notify(TxPromoted, tx, nil)
 	go pool.eventMux.Post(TxPreEvent{tx})
 }
 
@@ -646,7 +860,7 @@ func (pool *TxPool) Remove(hash common.Hash) {
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
 
-	pool.removeTx(hash)
+	pool.removeTx(hash, ErrTxRemoved)
 }
 
 // RemoveBatch removes all given transactions from the pool.
@@ -655,19 +869,20 @@ func (pool *TxPool) RemoveBatch(txs types.Transactions) {
 	defer pool.mu.Unlock()
 
 	for _, tx := range txs {
-		pool.removeTx(tx.Hash())
+		pool.removeTx(tx.Hash(), ErrTxRemoved)
 	}
 }
 
 // removeTx removes a single transaction from the queue, moving all subsequent
-// transactions back to the future queue.
-func (pool *TxPool) removeTx(hash common.Hash) {
+// transactions back to the future queue. The reason is reported to subscribers.
+func (pool *TxPool) removeTx(hash common.Hash, reason error) {
 	// Fetch the transaction we wish to delete
 	tx, ok := pool.all[hash]
 	if !ok {
 		return
 	}
 	addr, _ := types.Sender(pool.signer, tx) // already validated during insertion
+	pool.notify(TxDropped, tx, reason)
 
 	// Remove it from the list of known transactions
 	delete(pool.all, hash)
@@ -680,11 +895,10 @@ func (pool *TxPool) removeTx(hash common.Hash) {
 			if pending.Empty() {
 				delete(pool.pending, addr)
 				delete(pool.beats, addr)
-			} else {
-				// Otherwise postpone any invalidated transactions
-				for _, tx := range invalids {
-					pool.enqueueTx(tx.Hash(), tx)
-				}
+			}
+			// Postpone any invalidated transactions
+			for _, tx := range invalids {
+				pool.enqueueTx(tx.Hash(), tx)
 			}
 			// Update the account nonce if needed
 			if nonce := tx.Nonce(); pool.pendingState.GetNonce(addr) > nonce {
@@ -727,6 +941,7 @@ func (pool *TxPool) promoteExecutables(state *state.StateDB, accounts []common.A
 			log.Trace("Removed old queued transaction", "hash", hash)
 			delete(pool.all, hash)
 			pool.priced.Removed()
+			pool.notifyForwarded(tx)
 		}
 		// Drop all transactions that are too costly (low balance or out of gas)
 		drops, _ := list.Filter(state.GetBalance(addr), gaslimit)
@@ -736,6 +951,7 @@ func (pool *TxPool) promoteExecutables(state *state.StateDB, accounts []common.A
 			delete(pool.all, hash)
 			pool.priced.Removed()
 			queuedNofundsCounter.Inc(1)
+			pool.notify(TxDropped, tx, unpayableReason(tx, gaslimit))
 		}
 		// Gather all executable transactions and promote them
 		for _, tx := range list.Ready(pool.pendingState.GetNonce(addr)) {
@@ -750,6 +966,7 @@ func (pool *TxPool) promoteExecutables(state *state.StateDB, accounts []common.A
 				delete(pool.all, hash)
 				pool.priced.Removed()
 				queuedRateLimitCounter.Inc(1)
+				pool.notify(TxDropped, tx, ErrAccountLimit)
 				log.Trace("Removed cap-exceeding queued transaction", "hash", hash)
 			}
 		}
@@ -758,117 +975,58 @@ func (pool *TxPool) promoteExecutables(state *state.StateDB, accounts []common.A
 			delete(pool.queue, addr)
 		}
 	}
-	// If the pending limit is overflown, start equalizing allowances
+	// If the pending limit is overflown, evict the cheapest account tails above the
+	// guaranteed per-account allowance
 	pending := uint64(0)
 	for _, list := range pool.pending {
 		pending += uint64(list.Len())
 	}
 	if pending > pool.config.GlobalSlots {
-		pendingBeforeCap := pending
-		// Assemble a spam order to penalize large transactors first
-		spammers := prque.New()
-		for addr, list := range pool.pending {
-			// Only evict transactions from high rollers
-			if !pool.locals.contains(addr) && uint64(list.Len()) > pool.config.AccountSlots {
-				spammers.Push(addr, float32(list.Len()))
-			}
-		}
-		// Gradually drop transactions from offenders
-		offenders := []common.Address{}
-		for pending > pool.config.GlobalSlots && !spammers.Empty() {
-			// Retrieve the next offender if not local address
-			offender, _ := spammers.Pop()
-			offenders = append(offenders, offender.(common.Address))
-
-			// Equalize balances until all the same or below threshold
-			if len(offenders) > 1 {
-				// Calculate the equalization threshold for all current offenders
-				threshold := pool.pending[offender.(common.Address)].Len()
-
-				// Iteratively reduce all offenders until below limit or threshold reached
-				for pending > pool.config.GlobalSlots && pool.pending[offenders[len(offenders)-2]].Len() > threshold {
-					for i := 0; i < len(offenders)-1; i++ {
-						list := pool.pending[offenders[i]]
-						for _, tx := range list.Cap(list.Len() - 1) {
-							// Drop the transaction from the global pools too
-							hash := tx.Hash()
-							delete(pool.all, hash)
-							pool.priced.Removed()
-
-							// Update the account nonce to the dropped transaction
-							if nonce := tx.Nonce(); pool.pendingState.GetNonce(offenders[i]) > nonce {
-								pool.pendingState.SetNonce(offenders[i], nonce)
-							}
-							log.Trace("Removed fairness-exceeding pending transaction", "hash", hash)
-						}
-						pending--
-					}
-				}
-			}
-		}
-		// If still above threshold, reduce to limit or min allowance
-		if pending > pool.config.GlobalSlots && len(offenders) > 0 {
-			for pending > pool.config.GlobalSlots && uint64(pool.pending[offenders[len(offenders)-1]].Len()) > pool.config.AccountSlots {
-				for _, addr := range offenders {
-					list := pool.pending[addr]
-					for _, tx := range list.Cap(list.Len() - 1) {
-						// Drop the transaction from the global pools too
-						hash := tx.Hash()
-						delete(pool.all, hash)
-						pool.priced.Removed()
-
-						// Update the account nonce to the dropped transaction
-						if nonce := tx.Nonce(); pool.pendingState.GetNonce(addr) > nonce {
-							pool.pendingState.SetNonce(addr, nonce)
-						}
-						log.Trace("Removed fairness-exceeding pending transaction", "hash", hash)
-					}
-					pending--
-				}
-			}
+		for _, tx := range pool.evictTails(pool.pending, int(pool.config.AccountSlots), pending-pool.config.GlobalSlots) {
+			pendingRateLimitCounter.Inc(1)
+			log.Trace("Removed underpriced pending transaction", "hash", tx.Hash(), "price", tx.GasPrice())
 		}
-		pendingRateLimitCounter.Inc(int64(pendingBeforeCap - pending))
 	}
-	// If we've queued more transactions than the hard limit, drop oldest ones
+	// If we've queued more transactions than the hard limit, drop the cheapest tails
 	queued := uint64(0)
 	for _, list := range pool.queue {
 		queued += uint64(list.Len())
 	}
 	if queued > pool.config.GlobalQueue {
-		// Sort all accounts with queued transactions by heartbeat
-		addresses := make(addresssByHeartbeat, 0, len(pool.queue))
-		for addr := range pool.queue {
-			if !pool.locals.contains(addr) { // don't drop locals
-				addresses = append(addresses, addressByHeartbeat{addr, pool.beats[addr]})
-			}
+		for _, tx := range pool.evictTails(pool.queue, 0, queued-pool.config.GlobalQueue) {
+			queuedRateLimitCounter.Inc(1)
+			log.Trace("Removed underpriced queued transaction", "hash", tx.Hash(), "price", tx.GasPrice())
+		}
+	}
+}
+
+// evictTails drops up to count transactions of non-local accounts from the given
+// lists, keeping the first allowance ones of every account. Only the tail (the
+// highest nonce transaction) of an account is ever evicted, the cheapest tail of
+// all accounts first, so no nonce gaps are left behind. The evicted transactions
+// are returned.
+func (pool *TxPool) evictTails(lists map[common.Address]*txList, allowance int, count uint64) types.Transactions {
+	tails := make(txTails, 0, len(lists))
+	for addr, list := range lists {
+		if !pool.locals.contains(addr) && list.Len() > allowance {
+			tails = append(tails, list.Flatten())
 		}
-		sort.Sort(addresses)
+	}
+	heap.Init(&tails)
 
-		// Drop transactions until the total is below the limit or only locals remain
-		for drop := queued - pool.config.GlobalQueue; drop > 0 && len(addresses) > 0; {
-			addr := addresses[len(addresses)-1]
-			list := pool.queue[addr.address]
+	var evicted types.Transactions
+	for uint64(len(evicted)) < count && tails.Len() > 0 {
+		txs := heap.Pop(&tails).(types.Transactions)
 
-			addresses = addresses[:len(addresses)-1]
+		tx := txs[len(txs)-1]
+		pool.removeTx(tx.Hash(), ErrTxPoolOverflow)
+		evicted = append(evicted, tx)
 
-			// Drop all transactions if they are less than the overflow
-			if size := uint64(list.Len()); size <= drop {
-				for _, tx := range list.Flatten() {
-					pool.removeTx(tx.Hash())
-				}
-				drop -= size
-				queuedRateLimitCounter.Inc(int64(size))
-				continue
-			}
-			// Otherwise drop only last few transactions
-			txs := list.Flatten()
-			for i := len(txs) - 1; i >= 0 && drop > 0; i-- {
-				pool.removeTx(txs[i].Hash())
-				drop--
-				queuedRateLimitCounter.Inc(1)
-			}
+		if txs = txs[:len(txs)-1]; len(txs) > allowance {
+			heap.Push(&tails, txs)
 		}
 	}
+	return evicted
 }
 
 // demoteUnexecutables removes invalid and processed transactions from the pools
@@ -887,6 +1045,7 @@ func (pool *TxPool) demoteUnexecutables(state *state.StateDB) {
 			log.Trace("Removed old pending transaction", "hash", hash)
 			delete(pool.all, hash)
 			pool.priced.Removed()
+			pool.notifyForwarded(tx)
 		}
 		// Drop all transactions that are too costly (low balance or out of gas), and queue any invalids back for later
 		drops, invalids := list.Filter(state.GetBalance(addr), gaslimit)
@@ -896,6 +1055,7 @@ func (pool *TxPool) demoteUnexecutables(state *state.StateDB) {
 			delete(pool.all, hash)
 			pool.priced.Removed()
 			pendingNofundsCounter.Inc(1)
+			pool.notify(TxDropped, tx, unpayableReason(tx, gaslimit))
 		}
 		for _, tx := range invalids {
 			hash := tx.Hash()
@@ -910,6 +1070,15 @@ func (pool *TxPool) demoteUnexecutables(state *state.StateDB) {
 	}
 }
 
+// unpayableReason returns why a transaction was filtered out as unpayable: it
+// either exceeds the block gas limit, or its sender cannot cover its cost.
+func unpayableReason(tx *types.Transaction, gaslimit *big.Int) error {
+	if tx.Gas().Cmp(gaslimit) > 0 {
+		return ErrGasLimit
+	}
+	return ErrInsufficientFunds
+}
+
 // expirationLoop is a loop that periodically iterates over all accounts with
 // queued transactions and drop all that have been inactive for a prolonged amount
 // of time.
@@ -931,7 +1100,7 @@ func (pool *TxPool) expirationLoop() {
 				// Any non-locals old enough should be removed
 				if time.Since(pool.beats[addr]) > pool.config.Lifetime {
 					for _, tx := range pool.queue[addr].Flatten() {
-						pool.removeTx(tx.Hash())
+						pool.removeTx(tx.Hash(), ErrTxExpired)
 					}
 				}
 			}
@@ -943,18 +1112,6 @@ func (pool *TxPool) expirationLoop() {
 	}
 }
 
-// addressByHeartbeat is an account address tagged with its last activity timestamp.
-type addressByHeartbeat struct {
-	address   common.Address
-	heartbeat time.Time
-}
-
-type addresssByHeartbeat []addressByHeartbeat
-
-func (a addresssByHeartbeat) Len() int           { return len(a) }
-func (a addresssByHeartbeat) Less(i, j int) bool { return a[i].heartbeat.Before(a[j].heartbeat) }
-func (a addresssByHeartbeat) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-
 // accountSet is simply a set of addresses to check for existance, and a signer
 // capable of deriving addresses from transactions.
 type accountSet struct {
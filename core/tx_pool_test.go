@@ -19,8 +19,10 @@ package core
 import (
 	"crypto/ecdsa"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 
@@ -33,6 +35,15 @@ import (
 	"github.com/immesys/bw2bc/params"
 )
 
+// testTxPoolConfig is a transaction pool configuration without stateful disk
+// sideeffects used during testing.
+var testTxPoolConfig TxPoolConfig
+
+func init() {
+	testTxPoolConfig = DefaultTxPoolConfig
+	testTxPoolConfig.Journal = ""
+}
+
 func transaction(nonce uint64, gaslimit *big.Int, key *ecdsa.PrivateKey) *types.Transaction {
 	return pricedTransaction(nonce, gaslimit, big.NewInt(1), key)
 }
@@ -47,7 +58,7 @@ func setupTxPool() (*TxPool, *ecdsa.PrivateKey) {
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
 	key, _ := crypto.GenerateKey()
-	pool := NewTxPool(DefaultTxPoolConfig, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool := NewTxPool(testTxPoolConfig, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
 	pool.resetState()
 
 	return pool, key
@@ -125,7 +136,7 @@ func TestStateChangeDuringPoolReset(t *testing.T) {
 
 	gasLimitFunc := func() *big.Int { return big.NewInt(1000000000) }
 
-	pool := NewTxPool(DefaultTxPoolConfig, params.TestChainConfig, mux, stateFunc, gasLimitFunc)
+	pool := NewTxPool(testTxPoolConfig, params.TestChainConfig, mux, stateFunc, gasLimitFunc)
 	defer pool.Stop()
 	pool.resetState()
 
@@ -657,7 +668,7 @@ func testTransactionQueueGlobalLimiting(t *testing.T, nolocals bool) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
-	config := DefaultTxPoolConfig
+	config := testTxPoolConfig
 	config.NoLocals = nolocals
 	config.GlobalQueue = config.AccountQueue*3 - 1 // reduce the queue limits to shorten test time (-1 to make it non divisible)
 
@@ -748,7 +759,7 @@ func testTransactionQueueTimeLimiting(t *testing.T, nolocals bool) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
-	config := DefaultTxPoolConfig
+	config := testTxPoolConfig
 	config.Lifetime = 250 * time.Millisecond
 	config.NoLocals = nolocals
 
@@ -888,7 +899,7 @@ func TestTransactionPendingGlobalLimiting(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
-	config := DefaultTxPoolConfig
+	config := testTxPoolConfig
 	config.GlobalSlots = config.AccountSlots * 10
 
 	pool := NewTxPool(config, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
@@ -935,7 +946,7 @@ func TestTransactionCapClearsFromAll(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
-	config := DefaultTxPoolConfig
+	config := testTxPoolConfig
 	config.AccountSlots = 2
 	config.AccountQueue = 2
 	config.GlobalSlots = 8
@@ -970,7 +981,7 @@ func TestTransactionPendingMinimumAllowance(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
-	config := DefaultTxPoolConfig
+	config := testTxPoolConfig
 	config.GlobalSlots = 0
 
 	pool := NewTxPool(config, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
@@ -1019,7 +1030,7 @@ func TestTransactionPoolRepricing(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
-	pool := NewTxPool(DefaultTxPoolConfig, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool := NewTxPool(testTxPoolConfig, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
 	defer pool.Stop()
 	pool.resetState()
 
@@ -1104,7 +1115,7 @@ func TestTransactionPoolUnderpricing(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
-	config := DefaultTxPoolConfig
+	config := testTxPoolConfig
 	config.GlobalSlots = 2
 	config.GlobalQueue = 2
 
@@ -1185,6 +1196,123 @@ func TestTransactionPoolUnderpricing(t *testing.T) {
 	}
 }
 
+// Tests that when the pending pool overflows its global limit, only the tails of
+// remote accounts above the guaranteed per-account allowance are evicted, the
+// cheapest tail first, so that no nonce gaps are left behind.
+func TestTransactionPendingPricedEviction(t *testing.T) {
+	// Create the pool to test the eviction order with
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	config := testTxPoolConfig
+	config.AccountSlots = 2
+	config.GlobalSlots = 8
+
+	pool := NewTxPool(config, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	defer pool.Stop()
+	pool.resetState()
+
+	// Create a number of test accounts and fund them
+	state, _ := pool.currentState()
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		state.AddBalance(crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	}
+	// Generate a batch of executable transactions: cheap ones guarded by a pricey
+	// tail, a flat mid priced account and an account with a cheap tail
+	prices := [][]int64{{1, 1, 1, 3}, {2, 2, 2, 2}, {4, 4, 1, 1}}
+
+	txs := make([]types.Transactions, len(keys))
+	for i, key := range keys {
+		for nonce, price := range prices[i] {
+			txs[i] = append(txs[i], pricedTransaction(uint64(nonce), big.NewInt(100000), big.NewInt(price), key))
+		}
+	}
+	for _, batch := range txs {
+		pool.AddRemotes(batch)
+	}
+	// Ensure the cheapest tails were evicted and no follower was demoted
+	pending, queued := pool.stats()
+	if pending != 8 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 8)
+	}
+	if queued != 0 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	}
+	for i, want := range []int{4, 2, 2} {
+		list := pool.pending[crypto.PubkeyToAddress(keys[i].PublicKey)]
+		if list.Len() != want {
+			t.Fatalf("account %d: pending mismatch: have %d, want %d", i, list.Len(), want)
+		}
+		for nonce, tx := range txs[i] {
+			if present := list.txs.Get(uint64(nonce)) == tx; present != (nonce < want) {
+				t.Errorf("account %d, nonce %d: presence mismatch: have %v, want %v", i, nonce, present, !present)
+			}
+		}
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that when the queue overflows its global limit, the cheapest remote
+// transactions are evicted first, regardless of account activity.
+func TestTransactionQueuePricedEviction(t *testing.T) {
+	// Create the pool to test the eviction order with
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	config := testTxPoolConfig
+	config.GlobalQueue = 4
+
+	pool := NewTxPool(config, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	defer pool.Stop()
+	pool.resetState()
+
+	// Create a number of test accounts and fund them
+	state, _ := pool.currentState()
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		state.AddBalance(crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	}
+	// Queue up some well priced transactions, followed by a burst of cheap spam
+	for i := uint64(1); i <= 3; i++ {
+		if err := pool.AddRemote(pricedTransaction(i, big.NewInt(100000), big.NewInt(3), keys[0])); err != nil {
+			t.Fatalf("failed to add well priced transaction: %v", err)
+		}
+	}
+	spam := types.Transactions{}
+	for i := uint64(1); i <= 4; i++ {
+		spam = append(spam, pricedTransaction(i, big.NewInt(100000), big.NewInt(1), keys[1]))
+	}
+	pool.AddRemotes(spam)
+
+	// Only a single spam transaction should fit and it must be the lowest nonce one
+	if list := pool.queue[crypto.PubkeyToAddress(keys[0].PublicKey)]; list.Len() != 3 {
+		t.Fatalf("well priced queue mismatch: have %d, want %d", list.Len(), 3)
+	}
+	if list := pool.queue[crypto.PubkeyToAddress(keys[1].PublicKey)]; list.Len() != 1 || list.txs.Get(1) != spam[0] {
+		t.Fatalf("spam queue mismatch: have %d, want %d", list.Len(), 1)
+	}
+	// Ensure a mid priced transaction from a new account displaces the spam
+	if err := pool.AddRemote(pricedTransaction(1, big.NewInt(100000), big.NewInt(2), keys[2])); err != nil {
+		t.Fatalf("failed to add mid priced transaction: %v", err)
+	}
+	if _, ok := pool.queue[crypto.PubkeyToAddress(keys[1].PublicKey)]; ok {
+		t.Fatalf("spam transaction not evicted")
+	}
+	if _, queued := pool.stats(); queued != 4 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 4)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 // Tests that the pool rejects replacement transactions that don't meet the minimum
 // price bump required.
 func TestTransactionReplacement(t *testing.T) {
@@ -1192,7 +1320,7 @@ func TestTransactionReplacement(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 
-	pool := NewTxPool(DefaultTxPoolConfig, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool := NewTxPool(testTxPoolConfig, params.TestChainConfig, new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
 	defer pool.Stop()
 	pool.resetState()
 
@@ -1350,3 +1478,203 @@ func benchmarkPoolBatchInsert(b *testing.B, size int) {
 		pool.AddRemotes(batch)
 	}
 }
+
+// Tests that local transactions are journaled to disk, but remote transactions
+// get discarded between restarts.
+func TestTransactionJournaling(t *testing.T) {
+	// Create a temporary file for the journal
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary journal: %v", err)
+	}
+	journal := file.Name()
+	defer os.Remove(journal)
+
+	// Clean up the temporary file, we only need the path for now
+	file.Close()
+	os.Remove(journal)
+
+	// Create the original pool to inject transaction into the journal
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	stateFn := func() (*state.StateDB, error) { return statedb, nil }
+	gasLimitFn := func() *big.Int { return big.NewInt(1000000) }
+
+	config := testTxPoolConfig
+	config.Journal = journal
+	config.Rejournal = time.Second
+
+	pool := NewTxPool(config, params.TestChainConfig, new(event.TypeMux), stateFn, gasLimitFn)
+
+	// Create two test accounts to ensure remotes expire but locals do not
+	local, _ := crypto.GenerateKey()
+	remote, _ := crypto.GenerateKey()
+
+	statedb.AddBalance(crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+	statedb.AddBalance(crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+
+	// Add three local and a remote transactions and ensure they are queued up
+	if err := pool.AddLocal(pricedTransaction(0, big.NewInt(100000), big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	if err := pool.AddLocal(pricedTransaction(1, big.NewInt(100000), big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	if err := pool.AddLocal(pricedTransaction(3, big.NewInt(100000), big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	if err := pool.AddRemote(pricedTransaction(0, big.NewInt(100000), big.NewInt(1), remote)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	pending, queued := pool.Stats()
+	if pending != 3 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 3)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	// Terminate the old pool, create a new pool and ensure relevant transaction survive
+	pool.Stop()
+
+	pool = NewTxPool(config, params.TestChainConfig, new(event.TypeMux), stateFn, gasLimitFn)
+
+	pending, queued = pool.Stats()
+	if pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	// Bump the nonce temporarily and ensure the newly invalidated transaction is
+	// removed from the journal on the next rotation
+	statedb.SetNonce(crypto.PubkeyToAddress(local.PublicKey), 2)
+	pool.mu.Lock()
+	pool.resetState()
+	pool.mu.Unlock()
+
+	time.Sleep(2 * config.Rejournal)
+	pool.Stop()
+
+	statedb.SetNonce(crypto.PubkeyToAddress(local.PublicKey), 0)
+	pool = NewTxPool(config, params.TestChainConfig, new(event.TypeMux), stateFn, gasLimitFn)
+	defer pool.Stop()
+
+	pending, queued = pool.Stats()
+	if pending != 0 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that the pool reports the lifecycle of transactions to its subscribers,
+// including the reasons for any rejections or evictions.
+func TestTransactionPoolEvents(t *testing.T) {
+	// Create the pool to test the event reporting with
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	mux := new(event.TypeMux)
+
+	pool := NewTxPool(testTxPoolConfig, params.TestChainConfig, mux, func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	defer pool.Stop()
+	pool.resetState()
+
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	statedb.AddBalance(addr, big.NewInt(1000000000))
+
+	events := make(chan TxPoolEvent, 16)
+	sub := pool.SubscribeTxPoolEvent(events)
+	defer sub.Unsubscribe()
+
+	expect := func(kind TxPoolEventKind, tx *types.Transaction, reason error) {
+		select {
+		case ev := <-events:
+			if ev.Kind != kind || ev.Tx.Hash() != tx.Hash() || ev.Reason != reason {
+				t.Fatalf("event mismatch: have %v %x (%v), want %v %x (%v)", ev.Kind, ev.Tx.Hash(), ev.Reason, kind, tx.Hash(), reason)
+			}
+			if ev.From != addr {
+				t.Fatalf("event sender mismatch: have %x, want %x", ev.From, addr)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v event for %x not fired", kind, tx.Hash())
+		}
+	}
+	// Add an executable transaction and ensure it's reported added and promoted
+	tx0 := pricedTransaction(0, big.NewInt(100000), big.NewInt(1), key)
+	if err := pool.AddRemote(tx0); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	expect(TxAdded, tx0, nil)
+	expect(TxPromoted, tx0, nil)
+
+	// Ensure rejected replacements are reported with their reason
+	cheap := pricedTransaction(0, big.NewInt(100001), big.NewInt(1), key)
+	if err := pool.AddRemote(cheap); err != ErrReplaceUnderpriced {
+		t.Fatalf("underpriced replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
+	}
+	expect(TxDropped, cheap, ErrReplaceUnderpriced)
+
+	// Replace the transaction and ensure both sides are reported
+	tx0r := pricedTransaction(0, big.NewInt(100000), big.NewInt(2), key)
+	if err := pool.AddRemote(tx0r); err != nil {
+		t.Fatalf("failed to replace transaction: %v", err)
+	}
+	expect(TxReplaced, tx0, nil)
+	expect(TxAdded, tx0r, nil)
+	expect(TxPromoted, tx0r, nil)
+
+	// Remove a transaction explicitly and ensure it's reported dropped
+	tx1 := pricedTransaction(1, big.NewInt(100000), big.NewInt(1), key)
+	if err := pool.AddRemote(tx1); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	expect(TxAdded, tx1, nil)
+	expect(TxPromoted, tx1, nil)
+
+	pool.Remove(tx1.Hash())
+	expect(TxDropped, tx1, ErrTxRemoved)
+
+	// Include the replacement in a block and ensure it's reported mined
+	statedb.SetNonce(addr, 1)
+	mux.Post(ChainHeadEvent{types.NewBlock(&types.Header{Number: big.NewInt(1)}, types.Transactions{tx0r}, nil, nil)})
+	expect(TxMined, tx0r, nil)
+
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that lifecycle events are dropped instead of piling up without bounds if
+// the subscribers cannot keep up with the pool.
+func TestTransactionPoolEventsLimit(t *testing.T) {
+	defer func(old int) { maxQueuedTxEvents = old }(maxQueuedTxEvents)
+	maxQueuedTxEvents = 4
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	// Subscribe but never consume any events
+	sub := pool.SubscribeTxPoolEvent(make(chan TxPoolEvent))
+	defer sub.Unsubscribe()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for i := 0; i < 2*maxQueuedTxEvents; i++ {
+		pool.notify(TxAdded, transaction(uint64(i), big.NewInt(100000), key), nil)
+	}
+	if len(pool.txEvents) > maxQueuedTxEvents {
+		t.Fatalf("queued event count mismatch: have %d, want at most %d", len(pool.txEvents), maxQueuedTxEvents)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/log"
+)
+
+// TxIndexProgress is the progress report of the transaction indexer.
+type TxIndexProgress struct {
+	Indexed   uint64 `json:"indexed"`   // Number of recent blocks whose transactions are indexed
+	Remaining uint64 `json:"remaining"` // Number of blocks still to be indexed to reach the limit
+}
+
+// Done returns whether the transaction index covers the whole retention window.
+func (p TxIndexProgress) Done() bool {
+	return p.Remaining == 0
+}
+
+// StartTxIndexer launches a background thread maintaining the transaction lookup
+// index for the most recent limit blocks of the canonical chain (zero meaning
+// every block). Entries of older blocks are removed, missing ones of newer blocks
+// are added, following the chain head as it progresses.
+func (bc *BlockChain) StartTxIndexer(limit uint64) {
+	atomic.StoreUint64(&bc.txLookupLimit, limit)
+	atomic.StoreInt32(&bc.txIndexing, 1)
+
+	bc.wg.Add(1)
+	go bc.txIndexLoop(limit)
+}
+
+// TxIndexProgress retrieves the progress of the transaction indexer. If the
+// indexer is not running, the index is considered complete.
+func (bc *BlockChain) TxIndexProgress() TxIndexProgress {
+	if atomic.LoadInt32(&bc.txIndexing) == 0 {
+		return TxIndexProgress{}
+	}
+	head := bc.CurrentBlock().NumberU64()
+	target := txIndexTarget(head, atomic.LoadUint64(&bc.txLookupLimit))
+
+	tail := uint64(0)
+	if number := GetTxIndexTail(bc.chainDb); number != nil {
+		tail = *number
+	}
+	var progress TxIndexProgress
+	if tail <= head {
+		progress.Indexed = head - tail + 1
+	}
+	if tail > target {
+		progress.Remaining = tail - target
+	}
+	return progress
+}
+
+// ReindexTransactions rewrites the transaction lookup entries of the canonical
+// blocks in the given (inclusive) range, returning the number of transactions
+// indexed.
+//
+// If the range reaches down from the index tail (i.e. it overlaps or directly
+// precedes the indexed blocks), the tail is moved to the start of the range and
+// the blocks become part of the index maintained by the background indexer, so
+// those older than the retention limit are unindexed again on the next chain
+// head. Entries of a range detached from the indexed blocks are not tracked by
+// the tail and stay until removed manually.
+func (bc *BlockChain) ReindexTransactions(from, to uint64) (int, error) {
+	if from > to {
+		return 0, fmt.Errorf("invalid range: #%d > #%d", from, to)
+	}
+	if head := bc.CurrentBlock().NumberU64(); to > head {
+		return 0, fmt.Errorf("range end #%d beyond head #%d", to, head)
+	}
+	bc.txIndexLock.Lock()
+	defer bc.txIndexLock.Unlock()
+
+	txs := 0
+	for number := from; number <= to; number++ {
+		block := bc.GetBlockByNumber(number)
+		if block == nil {
+			return txs, fmt.Errorf("canonical block #%d missing", number)
+		}
+		if err := WriteTxLookupEntries(bc.chainDb, block); err != nil {
+			return txs, err
+		}
+		txs += len(block.Transactions())
+	}
+	// Extend the tracked tail if the range is adjacent to the indexed blocks
+	if tail := GetTxIndexTail(bc.chainDb); tail != nil && from < *tail && to+1 >= *tail {
+		if err := WriteTxIndexTail(bc.chainDb, from); err != nil {
+			return txs, err
+		}
+	}
+	log.Info("Reindexed transactions", "from", from, "to", to, "txs", txs)
+	return txs, nil
+}
+
+// txIndexTarget returns the number of the oldest block that should be indexed
+// for the given head and retention limit.
+func txIndexTarget(head, limit uint64) uint64 {
+	if limit == 0 || head+1 <= limit {
+		return 0
+	}
+	return head + 1 - limit
+}
+
+// txIndexLoop runs the indexer every time the chain head changes, making sure
+// only one indexing run is active at any time.
+func (bc *BlockChain) txIndexLoop(limit uint64) {
+	defer bc.wg.Done()
+
+	sub := bc.eventMux.Subscribe(ChainHeadEvent{})
+	defer sub.Unsubscribe()
+
+	var (
+		done   chan struct{} // Non-nil if an indexing run is active
+		abort  chan struct{} // Closed to interrupt the active indexing run
+		queued *uint64       // Latest head announced during the active run
+	)
+	run := func(head uint64) {
+		done, abort = make(chan struct{}), make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			bc.indexTransactions(head, limit, abort)
+		}(done)
+	}
+	run(bc.CurrentBlock().NumberU64())
+
+	for {
+		select {
+		case ev, ok := <-sub.Chan():
+			if !ok {
+				return
+			}
+			head := ev.Data.(ChainHeadEvent).Block.NumberU64()
+			if done == nil {
+				run(head)
+			} else {
+				queued = &head
+			}
+		case <-done:
+			done = nil
+			if queued != nil {
+				run(*queued)
+				queued = nil
+			}
+
+		case <-bc.quit:
+			if done != nil {
+				close(abort)
+				<-done
+			}
+			return
+		}
+	}
+}
+
+// indexTransactions moves the index tail towards the target for the given head,
+// indexing blocks newest first or unindexing them oldest first. The tail is
+// persisted after every block so an interrupted run can be resumed.
+func (bc *BlockChain) indexTransactions(head, limit uint64, abort chan struct{}) {
+	var (
+		target = txIndexTarget(head, limit)
+		start  = time.Now()
+		logged = time.Now()
+		blocks = 0
+		txs    = 0
+	)
+	for {
+		select {
+		case <-abort:
+			log.Debug("Transaction indexing interrupted", "blocks", blocks, "txs", txs)
+			return
+		default:
+		}
+		tail, n, done, err := bc.indexTransactionsStep(head, target)
+		if err != nil {
+			log.Error("Failed to update transaction index", "tail", tail, "err", err)
+			return
+		}
+		if done {
+			if blocks > 0 {
+				log.Info("Updated transaction index", "blocks", blocks, "txs", txs, "tail", tail, "elapsed", common.PrettyDuration(time.Since(start)))
+			}
+			return
+		}
+		blocks, txs = blocks+1, txs+n
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Updating transaction index", "blocks", blocks, "txs", txs, "tail", tail, "target", target, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+}
+
+// indexTransactionsStep moves the index tail by a single block towards target,
+// returning the new tail, the number of transactions (un)indexed and whether the
+// target was already reached. The tail is reloaded on every step under the index
+// lock, as manual reindexing might have moved it in the meantime.
+func (bc *BlockChain) indexTransactionsStep(head, target uint64) (uint64, int, bool, error) {
+	bc.txIndexLock.Lock()
+	defer bc.txIndexLock.Unlock()
+
+	// Databases without a tracked tail have every transaction indexed
+	tail := GetTxIndexTail(bc.chainDb)
+	if tail == nil {
+		tail = new(uint64)
+		if err := WriteTxIndexTail(bc.chainDb, 0); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	// Never index above the head, the chain might have been rewound
+	current := *tail
+	if current > head+1 {
+		current = head + 1
+		if err := WriteTxIndexTail(bc.chainDb, current); err != nil {
+			return current, 0, false, err
+		}
+	}
+	txs := 0
+	switch {
+	case current > target:
+		block := bc.GetBlockByNumber(current - 1)
+		if block == nil {
+			return current, 0, false, fmt.Errorf("canonical block #%d missing", current-1)
+		}
+		if err := WriteTxLookupEntries(bc.chainDb, block); err != nil {
+			return current, 0, false, err
+		}
+		current, txs = current-1, len(block.Transactions())
+
+	case current < target:
+		if block := bc.GetBlockByNumber(current); block != nil {
+			for _, tx := range block.Transactions() {
+				DeleteTransaction(bc.chainDb, tx.Hash())
+				DeleteReceipt(bc.chainDb, tx.Hash())
+			}
+			txs = len(block.Transactions())
+		}
+		current++
+
+	default:
+		return current, 0, true, nil
+	}
+	return current, txs, false, WriteTxIndexTail(bc.chainDb, current)
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/consensus/ethash"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/core/vm"
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/event"
+	"github.com/immesys/bw2bc/params"
+)
+
+// Tests that the transaction indexer only keeps the most recent blocks indexed,
+// and that lifting the limit reindexes the older ones.
+func TestTxIndexerLimit(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		db, _   = ethdb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(10000000000000)}}}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainId)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, db, 10, func(i int, gen *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(addr), common.Address{0x01}, big.NewInt(1), big.NewInt(21000), new(big.Int), nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign tx: %v", err)
+		}
+		gen.AddTx(tx)
+	})
+	// waitTail waits until the indexer moved the index tail to the given block
+	waitTail := func(chain *BlockChain, want uint64) {
+		for i := 0; i < 500; i++ {
+			if tail := GetTxIndexTail(db); tail != nil && *tail == want && chain.TxIndexProgress().Done() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("index tail mismatch: have %v, want %d", GetTxIndexTail(db), want)
+	}
+	// checkIndex verifies that only transactions from the tail onwards are found
+	checkIndex := func(tail uint64) {
+		for _, block := range blocks {
+			hash := block.Transactions()[0].Hash()
+			tx, _, number, _ := GetTransaction(db, hash)
+			receipt := GetReceipt(db, hash)
+			if block.NumberU64() >= tail {
+				if tx == nil || number != block.NumberU64() {
+					t.Errorf("block #%d: indexed transaction not found", block.NumberU64())
+				}
+				if receipt == nil || receipt.TxHash != hash {
+					t.Errorf("block #%d: indexed receipt not found", block.NumberU64())
+				}
+			} else if tx != nil || receipt != nil {
+				t.Errorf("block #%d: unindexed transaction found", block.NumberU64())
+			}
+		}
+	}
+	// Import the chain with a limited index and wait for the old blocks to be pruned
+	chain, _ := NewBlockChain(db, gspec.Config, ethash.NewFaker(), new(event.TypeMux), vm.Config{})
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.StartTxIndexer(4)
+	waitTail(chain, 7)
+	checkIndex(7)
+	chain.Stop()
+
+	// Restart without a limit and wait for the old blocks to be indexed again
+	chain, _ = NewBlockChain(db, gspec.Config, ethash.NewFaker(), new(event.TypeMux), vm.Config{})
+	chain.StartTxIndexer(0)
+	waitTail(chain, 0)
+	checkIndex(0)
+
+	// Drop a few entries manually and make sure reindexing restores them
+	for _, block := range blocks[2:5] {
+		DeleteTransaction(db, block.Transactions()[0].Hash())
+	}
+	if n, err := chain.ReindexTransactions(3, 5); err != nil || n != 3 {
+		t.Fatalf("failed to reindex transactions: %d, %v", n, err)
+	}
+	checkIndex(0)
+	chain.Stop()
+}
+
+// Tests that chain heads arriving while an indexing run is active are not lost,
+// but picked up by a follow-up run once the active one finishes.
+func TestTxIndexerQueuedHead(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		db, _   = ethdb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(10000000000000)}}}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainId)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, db, 64, func(i int, gen *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(addr), common.Address{0x01}, big.NewInt(1), big.NewInt(21000), new(big.Int), nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign tx: %v", err)
+		}
+		gen.AddTx(tx)
+	})
+	mux := new(event.TypeMux)
+	chain, _ := NewBlockChain(db, gspec.Config, ethash.NewFaker(), mux, vm.Config{})
+	defer chain.Stop()
+
+	// insert imports a batch of blocks and waits for its head event to be posted
+	sub := mux.Subscribe(ChainHeadEvent{})
+	insert := func(blocks types.Blocks) {
+		if _, err := chain.InsertChain(blocks); err != nil {
+			t.Fatalf("failed to insert chain: %v", err)
+		}
+		for ev := range sub.Chan() {
+			if ev.Data.(ChainHeadEvent).Block.Hash() == blocks[len(blocks)-1].Hash() {
+				return
+			}
+		}
+	}
+	insert(blocks[:32])
+
+	// Start the indexer but block its first run, the new head must be queued
+	chain.txIndexLock.Lock()
+	chain.StartTxIndexer(4)
+	insert(blocks[32:])
+	sub.Unsubscribe()
+
+	// Posting is synchronous, so the head surely reached the indexer afterwards
+	mux.Post(ChainHeadEvent{blocks[len(blocks)-1]})
+	chain.txIndexLock.Unlock()
+
+	for i := 0; i < 500; i++ {
+		if tail := GetTxIndexTail(db); tail != nil && *tail == 61 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tail := GetTxIndexTail(db); tail == nil || *tail != 61 {
+		t.Fatalf("index tail mismatch: have %v, want %d", tail, 61)
+	}
+	for _, block := range blocks {
+		tx, _, _, _ := GetTransaction(db, block.Transactions()[0].Hash())
+		if indexed := tx != nil; indexed != (block.NumberU64() >= 61) {
+			t.Errorf("block #%d: index mismatch: have %v, want %v", block.NumberU64(), indexed, !indexed)
+		}
+	}
+}
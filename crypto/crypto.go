@@ -124,6 +124,36 @@ func FromECDSAPub(pub *ecdsa.PublicKey) []byte {
 	return elliptic.Marshal(S256(), pub.X, pub.Y)
 }
 
+// CompressPubkey encodes a public key to the 33-byte compressed format.
+func CompressPubkey(pub *ecdsa.PublicKey) []byte {
+	b := make([]byte, 33)
+	b[0] = 2 | byte(pub.Y.Bit(0))
+	math.ReadBits(pub.X, b[1:])
+	return b
+}
+
+// DecompressPubkey parses a public key in the 33-byte compressed format.
+func DecompressPubkey(pubkey []byte) (*ecdsa.PublicKey, error) {
+	if len(pubkey) != 33 || (pubkey[0] != 2 && pubkey[0] != 3) {
+		return nil, errors.New("invalid compressed public key")
+	}
+	params := S256().Params()
+	x := new(big.Int).SetBytes(pubkey[1:])
+	if x.Cmp(params.P) >= 0 {
+		return nil, errors.New("invalid compressed public key")
+	}
+	// Recover y from y² = x³ + b, picking the root with the encoded parity.
+	y := new(big.Int).Exp(x, big.NewInt(3), params.P)
+	y.Add(y, params.B)
+	if y.ModSqrt(y.Mod(y, params.P), params.P) == nil {
+		return nil, errors.New("invalid compressed public key")
+	}
+	if y.Bit(0) != uint(pubkey[0]&1) {
+		y.Sub(params.P, y)
+	}
+	return &ecdsa.PublicKey{Curve: S256(), X: x, Y: y}, nil
+}
+
 // HexToECDSA parses a secp256k1 private key.
 func HexToECDSA(hexkey string) (*ecdsa.PrivateKey, error) {
 	b, err := hex.DecodeString(hexkey)
@@ -92,6 +92,26 @@ func TestInvalidSign(t *testing.T) {
 	}
 }
 
+func TestCompressPubkey(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		key, _ := GenerateKey()
+		compressed := CompressPubkey(&key.PublicKey)
+		if len(compressed) != 33 {
+			t.Fatalf("wrong compressed length: %d", len(compressed))
+		}
+		pub, err := DecompressPubkey(compressed)
+		if err != nil {
+			t.Fatalf("decompression failed: %v", err)
+		}
+		if !bytes.Equal(FromECDSAPub(pub), FromECDSAPub(&key.PublicKey)) {
+			t.Fatalf("decompressed key mismatch")
+		}
+	}
+	if _, err := DecompressPubkey(make([]byte, 33)); err == nil {
+		t.Errorf("expected error for invalid prefix")
+	}
+}
+
 func TestNewContractAddress(t *testing.T) {
 	key, _ := HexToECDSA(testPrivHex)
 	addr := common.HexToAddress(testAddrHex)
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -194,6 +195,36 @@ func (api *PrivateMinerAPI) SetGasPrice(gasPrice hexutil.Big) bool {
 	return true
 }
 
+// SetGasTarget sets the gas limit the miner raises its blocks towards, lifting
+// the maximum too if it was lower.
+func (api *PrivateMinerAPI) SetGasTarget(gasTarget hexutil.Big) (bool, error) {
+	floor, ceil := api.e.miner.GasLimits()
+
+	floor = (*big.Int)(&gasTarget)
+	if ceil.Cmp(floor) < 0 {
+		ceil = floor
+	}
+	if err := api.e.miner.SetGasLimits(floor, ceil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetGasLimit sets the gas limit the miner lowers its blocks towards, dropping
+// the target too if it was higher.
+func (api *PrivateMinerAPI) SetGasLimit(gasLimit hexutil.Big) (bool, error) {
+	floor, ceil := api.e.miner.GasLimits()
+
+	ceil = (*big.Int)(&gasLimit)
+	if floor.Cmp(ceil) > 0 {
+		floor = ceil
+	}
+	if err := api.e.miner.SetGasLimits(floor, ceil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // SetEtherbase sets the etherbase of the miner
 func (api *PrivateMinerAPI) SetEtherbase(etherbase common.Address) bool {
 	api.e.SetEtherbase(etherbase)
@@ -205,6 +236,15 @@ func (api *PrivateMinerAPI) GetHashrate() uint64 {
 	return uint64(api.e.miner.HashRate())
 }
 
+// StratumStats returns the activity of the workers connected to the stratum
+// server since it started.
+func (api *PrivateMinerAPI) StratumStats() (*miner.StratumStats, error) {
+	if api.e.stratum == nil {
+		return nil, errors.New("stratum server not running")
+	}
+	return api.e.stratum.Stats(), nil
+}
+
 // PrivateAdminAPI is the collection of Etheruem full node-related APIs
 // exposed over the private admin endpoint.
 type PrivateAdminAPI struct {
@@ -298,6 +338,25 @@ func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
 	return true, nil
 }
 
+// ReindexTransactions rewrites the transaction lookup index of the canonical
+// blocks between first and last (inclusive), returning the number of indexed
+// transactions.
+func (api *PrivateAdminAPI) ReindexTransactions(first, last rpc.BlockNumber) (int, error) {
+	head := rpc.BlockNumber(api.eth.BlockChain().CurrentBlock().NumberU64())
+	if first == rpc.LatestBlockNumber || first == rpc.PendingBlockNumber {
+		first = head
+	}
+	if last == rpc.LatestBlockNumber || last == rpc.PendingBlockNumber {
+		last = head
+	}
+	return api.eth.BlockChain().ReindexTransactions(uint64(first), uint64(last))
+}
+
+// TxIndexProgress retrieves the progress of the background transaction indexer.
+func (api *PrivateAdminAPI) TxIndexProgress() core.TxIndexProgress {
+	return api.eth.BlockChain().TxIndexProgress()
+}
+
 // PublicDebugAPI is the collection of Etheruem full node APIs exposed
 // over the public debugging endpoint.
 type PublicDebugAPI struct {
@@ -24,6 +24,7 @@ import (
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/common/math"
 	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/bloombits"
 	"github.com/immesys/bw2bc/core/state"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/core/vm"
@@ -151,6 +152,14 @@ func (b *EthApiBackend) TxPoolContent() (map[common.Address]types.Transactions,
 	return b.eth.TxPool().Content()
 }
 
+func (b *EthApiBackend) TxPoolContentFrom(addr common.Address) (types.Transactions, types.Transactions) {
+	return b.eth.TxPool().ContentFrom(addr)
+}
+
+func (b *EthApiBackend) SubscribeTxPoolEvent(ch chan<- core.TxPoolEvent) (event.Subscription, error) {
+	return b.eth.TxPool().SubscribeTxPoolEvent(ch), nil
+}
+
 func (b *EthApiBackend) Downloader() *downloader.Downloader {
 	return b.eth.Downloader()
 }
@@ -163,6 +172,30 @@ func (b *EthApiBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	return b.gpo.SuggestPrice(ctx)
 }
 
+func (b *EthApiBackend) BloomStatus() (uint64, uint64) {
+	sections, _ := b.eth.bloomIndexer.Sections()
+	return params.BloomBitsBlocks, sections
+}
+
+func (b *EthApiBackend) GetBloomBits(ctx context.Context, bit uint, sections []uint64) ([][]byte, error) {
+	results := make([][]byte, len(sections))
+	for i, section := range sections {
+		head := core.GetCanonicalHash(b.eth.chainDb, (section+1)*params.BloomBitsBlocks-1)
+		compressed, err := core.GetBloomBits(b.eth.chainDb, bit, section, head)
+		if err != nil {
+			return nil, err
+		}
+		if results[i], err = bloombits.DecompressBits(compressed, int(params.BloomBitsBlocks/8)); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (b *EthApiBackend) TxIndexProgress() core.TxIndexProgress {
+	return b.eth.blockchain.TxIndexProgress()
+}
+
 func (b *EthApiBackend) ChainDb() ethdb.Database {
 	return b.eth.ChainDb()
 }
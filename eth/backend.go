@@ -28,9 +28,11 @@ import (
 	"github.com/immesys/bw2bc/accounts"
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/common/hexutil"
+	"github.com/immesys/bw2bc/common/math"
 	"github.com/immesys/bw2bc/consensus"
 	"github.com/immesys/bw2bc/consensus/clique"
 	"github.com/immesys/bw2bc/consensus/ethash"
+	"github.com/immesys/bw2bc/consensus/transition"
 	"github.com/immesys/bw2bc/core"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/core/vm"
@@ -57,6 +59,7 @@ type LesServer interface {
 
 // Ethereum implements the Ethereum full node service.
 type Ethereum struct {
+	config      *Config
 	chainConfig *params.ChainConfig
 	// Channel for shutting down the service
 	shutdownChan  chan bool // Channel for shutting down the ethereum
@@ -73,9 +76,12 @@ type Ethereum struct {
 	engine         consensus.Engine
 	accountManager *accounts.Manager
 
+	bloomIndexer *core.ChainIndexer // Bloom indexer operating during block imports
+
 	ApiBackend *EthApiBackend
 
 	miner     *miner.Miner
+	stratum   *miner.StratumServer // Stratum endpoint for remote miners, nil if disabled
 	gasPrice  *big.Int
 	etherbase common.Address
 
@@ -111,6 +117,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	log.Info("Initialised chain configuration", "config", chainConfig)
 
 	eth := &Ethereum{
+		config:         config,
 		chainDb:        chainDb,
 		chainConfig:    chainConfig,
 		eventMux:       ctx.EventMux,
@@ -121,11 +128,9 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		networkId:      config.NetworkId,
 		gasPrice:       config.GasPrice,
 		etherbase:      config.Etherbase,
+		bloomIndexer:   NewBloomIndexer(chainDb, params.BloomBitsBlocks),
 	}
 
-	if err := addMipmapBloomBins(chainDb); err != nil {
-		return nil, err
-	}
 	log.Info("Initialising Ethereum protocol", "versions", ProtocolVersions, "network", config.NetworkId)
 
 	if !config.SkipBcVersionCheck {
@@ -141,13 +146,21 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.Snapshot {
+		eth.blockchain.EnableSnapshot()
+	}
 	// Rewind the chain in case of an incompatible config upgrade.
 	if compat, ok := genesisErr.(*params.ConfigCompatError); ok {
 		log.Warn("Rewinding chain to upgrade configuration", "err", compat)
 		eth.blockchain.SetHead(compat.RewindTo)
 		core.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
+	eth.blockchain.StartTxIndexer(config.TxLookupLimit)
+	eth.bloomIndexer.Start(eth.blockchain.CurrentHeader(), eth.eventMux)
 
+	if config.TxPool.Journal != "" {
+		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
+	}
 	newPool := core.NewTxPool(config.TxPool, eth.chainConfig, eth.EventMux(), eth.blockchain.State, eth.blockchain.GasLimit)
 	eth.txPool = newPool
 
@@ -169,6 +182,24 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	eth.miner = miner.New(eth, eth.chainConfig, eth.EventMux(), eth.engine)
 	eth.miner.SetExtra(makeExtraData(config.ExtraData))
 
+	ordering, err := miner.MakeOrderingPolicy(config.MinerOrdering, config.MinerPriority, config.MinerSenderGas)
+	if err != nil {
+		return nil, err
+	}
+	eth.miner.SetOrdering(ordering)
+
+	gasFloor, gasCeil := params.TargetGasLimit, math.MaxBig256
+	if config.MinerGasTarget != 0 {
+		gasFloor = new(big.Int).SetUint64(config.MinerGasTarget)
+	}
+	if config.MinerGasLimit != 0 {
+		gasCeil = new(big.Int).SetUint64(config.MinerGasLimit)
+	}
+	if err := eth.miner.SetGasLimits(gasFloor, gasCeil); err != nil {
+		return nil, err
+	}
+	eth.miner.SetRecommit(config.MinerRecommit)
+
 	eth.ApiBackend = &EthApiBackend{eth, nil}
 	gpoParams := config.GPO
 	if gpoParams.Default == nil {
@@ -210,11 +241,22 @@ func CreateDB(ctx *node.ServiceContext, config *Config, name string) (ethdb.Data
 
 // CreateConsensusEngine creates the required type of consensus engine instance for an Ethereum service
 func CreateConsensusEngine(ctx *node.ServiceContext, config *Config, chainConfig *params.ChainConfig, db ethdb.Database) consensus.Engine {
+	// If a switch from proof-of-work to proof-of-authority is requested, set it up
+	if chainConfig.Clique != nil && chainConfig.CliqueTransitionBlock != nil {
+		log.Info("Ethash transitioning to clique", "block", chainConfig.CliqueTransitionBlock)
+		return transition.New(chainConfig.CliqueTransitionBlock, createEthash(ctx, config), clique.New(chainConfig.Clique, db))
+	}
 	// If proof-of-authority is requested, set it up
 	if chainConfig.Clique != nil {
 		return clique.New(chainConfig.Clique, db)
 	}
 	// Otherwise assume proof-of-work
+	return createEthash(ctx, config)
+}
+
+// createEthash creates the proof-of-work engine in the mode requested by the
+// config.
+func createEthash(ctx *node.ServiceContext, config *Config) consensus.Engine {
 	switch {
 	case config.PowFake:
 		log.Warn("Ethash used in fake mode")
@@ -325,13 +367,20 @@ func (s *Ethereum) StartMining(local bool) error {
 		log.Error("Cannot start mining without etherbase", "err", err)
 		return fmt.Errorf("etherbase missing: %v", err)
 	}
-	if clique, ok := s.engine.(*clique.Clique); ok {
+	var signer *clique.Clique
+	switch engine := s.engine.(type) {
+	case *clique.Clique:
+		signer = engine
+	case *transition.Transition:
+		signer = engine.Clique()
+	}
+	if signer != nil {
 		wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
 		if wallet == nil || err != nil {
 			log.Error("Etherbase account unavailable locally", "err", err)
 			return fmt.Errorf("singer missing: %v", err)
 		}
-		clique.Authorize(eb, wallet.SignHash)
+		signer.Authorize(eb, wallet.SignHash)
 	}
 	if local {
 		// If local (CPU) mining is started, we can disable the transaction rejection
@@ -375,9 +424,22 @@ func (s *Ethereum) Start(srvr *p2p.Server) error {
 	s.netRPCService = ethapi.NewPublicNetAPI(srvr, s.NetVersion())
 
 	s.protocolManager.Start()
+	s.protocolManager.startENRUpdater(srvr)
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
+	// Start the stratum server if remote miners were requested
+	if s.config.StratumAddr != "" {
+		agent := miner.NewRemoteAgent(s.blockchain, s.engine)
+		s.miner.Register(agent)
+
+		s.stratum = miner.NewStratumServer(agent, s.config.StratumDifficulty)
+		if err := s.stratum.Start(s.config.StratumAddr); err != nil {
+			s.miner.Unregister(agent)
+			s.stratum = nil
+			return err
+		}
+	}
 	return nil
 }
 
@@ -387,12 +449,16 @@ func (s *Ethereum) Stop() error {
 	if s.stopDbUpgrade != nil {
 		s.stopDbUpgrade()
 	}
+	s.bloomIndexer.Close()
 	s.blockchain.Stop()
 	s.protocolManager.Stop()
 	if s.lesServer != nil {
 		s.lesServer.Stop()
 	}
 	s.txPool.Stop()
+	if s.stratum != nil {
+		s.stratum.Stop()
+	}
 	s.miner.Stop()
 	s.eventMux.Stop()
 
@@ -0,0 +1,81 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/bloombits"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/ethdb"
+)
+
+// Tests that the bloom indexer stores the rotated header blooms of a section,
+// keyed by the section head.
+func TestBloomIndexer(t *testing.T) {
+	const size = 16
+
+	db, _ := ethdb.NewMemDatabase()
+	indexer := &BloomIndexer{db: db, size: size}
+
+	var headers []*types.Header
+	indexer.Reset(1)
+	for i := 0; i < size; i++ {
+		header := &types.Header{Number: big.NewInt(int64(size + i))}
+		header.Bloom.Add(big.NewInt(int64(i + 1)))
+		if err := indexer.Process(header); err != nil {
+			t.Fatalf("failed to process header #%d: %v", header.Number, err)
+		}
+		headers = append(headers, header)
+	}
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("failed to commit section: %v", err)
+	}
+	head := headers[size-1].Hash()
+	for bit := uint(0); bit < types.BloomBitLength; bit++ {
+		compressed, err := core.GetBloomBits(db, bit, 1, head)
+		if err != nil {
+			t.Fatalf("bit %d: vector missing: %v", bit, err)
+		}
+		vector, err := bloombits.DecompressBits(compressed, size/8)
+		if err != nil {
+			t.Fatalf("bit %d: invalid vector: %v", bit, err)
+		}
+		for i, header := range headers {
+			want := header.Bloom.Big().Bit(int(bit)) == 1
+			if have := vector[i/8]&(1<<uint(7-i%8)) != 0; have != want {
+				t.Errorf("bit %d header %d: have %v, want %v", bit, i, have, want)
+			}
+		}
+	}
+}
+
+// Tests that headers the bloom generator cannot accept are reported instead of
+// silently corrupting the section.
+func TestBloomIndexerProcessError(t *testing.T) {
+	const size = 16
+
+	db, _ := ethdb.NewMemDatabase()
+	indexer := &BloomIndexer{db: db, size: size}
+
+	indexer.Reset(1)
+	if err := indexer.Process(&types.Header{Number: big.NewInt(size + 1)}); err == nil {
+		t.Fatalf("out of order header accepted")
+	}
+}
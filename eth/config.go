@@ -22,6 +22,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/common/hexutil"
@@ -43,6 +44,7 @@ var DefaultConfig = Config{
 	LightPeers:           20,
 	DatabaseCache:        128,
 	GasPrice:             big.NewInt(18 * params.Shannon),
+	MinerRecommit:        3 * time.Second,
 
 	TxPool: core.DefaultTxPoolConfig,
 	GPO: gasprice.Config{
@@ -85,6 +87,8 @@ type Config struct {
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
 	DatabaseCache      int
+	Snapshot           bool   // Maintain a flat state snapshot alongside the chain
+	TxLookupLimit      uint64 // Number of recent blocks to index transactions for, 0 = all
 
 	// Mining-related options
 	Etherbase    common.Address `toml:",omitempty"`
@@ -92,6 +96,20 @@ type Config struct {
 	ExtraData    []byte         `toml:",omitempty"`
 	GasPrice     *big.Int
 
+	// Transaction ordering options of mined blocks
+	MinerOrdering  string           `toml:",omitempty"` // Ordering policy of the transactions (price or roundrobin)
+	MinerPriority  []common.Address `toml:",omitempty"` // Senders whose transactions are included before anyone else's
+	MinerSenderGas uint64           `toml:",omitempty"` // Maximum gas a single sender may claim per block (0 = unlimited)
+
+	// Block gas limit targeting and pending work options
+	MinerGasTarget uint64        `toml:",omitempty"` // Gas limit to raise mined blocks towards (0 = --targetgaslimit)
+	MinerGasLimit  uint64        `toml:",omitempty"` // Gas limit to lower mined blocks towards (0 = unlimited)
+	MinerRecommit  time.Duration // Interval to rebuild the pending work at while mining (0 = disabled)
+
+	// Stratum options
+	StratumAddr       string   `toml:",omitempty"` // Listening address of the stratum server (disabled if empty)
+	StratumDifficulty *big.Int `toml:",omitempty"` // Default share difficulty of stratum workers
+
 	// Ethash options
 	EthashCacheDir       string
 	EthashCachesInMem    int
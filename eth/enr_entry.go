@@ -0,0 +1,82 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package eth
+
+import (
+	"fmt"
+
+	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/forkid"
+	"github.com/immesys/bw2bc/event"
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p/enr"
+	"github.com/immesys/bw2bc/rlp"
+)
+
+// recordUpdater is the part of the p2p server maintaining the local node record.
+type recordUpdater interface {
+	SetRecordEntry(entry enr.Entry) error
+}
+
+// ethEntry is the "eth" node record entry, announcing the chain a node is on.
+type ethEntry struct {
+	ForkID forkid.ID
+
+	// Ignore additional fields (for forward compatibility).
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// ENRKey implements enr.Entry.
+func (e ethEntry) ENRKey() string {
+	return "eth"
+}
+
+// currentENREntry constructs an "eth" node record entry for the current head.
+func (pm *ProtocolManager) currentENREntry() *ethEntry {
+	return &ethEntry{ForkID: pm.currentForkID()}
+}
+
+// currentForkID calculates the fork identifier of the local chain.
+func (pm *ProtocolManager) currentForkID() forkid.ID {
+	head := pm.blockchain.CurrentHeader().Number.Uint64()
+	return forkid.NewID(pm.chainconfig, pm.blockchain.Genesis().Hash(), head)
+}
+
+// startENRUpdater keeps the "eth" entry of the local node record in sync with
+// the chain, re-signing the record whenever the fork ID of the head changes.
+func (pm *ProtocolManager) startENRUpdater(records recordUpdater) {
+	pm.chainHeadSub = pm.eventMux.Subscribe(core.ChainHeadEvent{})
+	go pm.enrUpdateLoop(records, pm.chainHeadSub, pm.currentForkID())
+}
+
+// enrUpdateLoop updates the announced fork ID on every chain head event which
+// changes it, until the subscription is terminated.
+func (pm *ProtocolManager) enrUpdateLoop(records recordUpdater, sub *event.TypeMuxSubscription, announced forkid.ID) {
+	genesis := pm.blockchain.Genesis().Hash()
+	for obj := range sub.Chan() {
+		head := obj.Data.(core.ChainHeadEvent).Block
+		id := forkid.NewID(pm.chainconfig, genesis, head.NumberU64())
+		if id == announced {
+			continue
+		}
+		if err := records.SetRecordEntry(&ethEntry{ForkID: id}); err != nil {
+			log.Warn("Failed to update node record", "err", err)
+			continue
+		}
+		log.Debug("Updated announced fork ID", "hash", fmt.Sprintf("%x", id.Hash), "next", id.Next)
+		announced = id
+	}
+}
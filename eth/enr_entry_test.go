@@ -0,0 +1,103 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package eth
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/forkid"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/eth/downloader"
+	"github.com/immesys/bw2bc/p2p/enr"
+)
+
+// testRecord is a node record updater collecting the entries set.
+type testRecord chan enr.Entry
+
+func (r testRecord) SetRecordEntry(entry enr.Entry) error {
+	r <- entry
+	return nil
+}
+
+// Tests that the "eth" entry survives a round trip through a signed record.
+func TestENREntryEncoding(t *testing.T) {
+	pm := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	key, _ := crypto.GenerateKey()
+	var record enr.Record
+	record.Set(pm.currentENREntry())
+	if err := enr.SignV4(&record, key); err != nil {
+		t.Fatalf("failed to sign record: %v", err)
+	}
+	var decoded enr.Record
+	if err := decoded.UnmarshalText([]byte(record.String())); err != nil {
+		t.Fatalf("failed to decode record: %v", err)
+	}
+	var entry ethEntry
+	if err := decoded.Load(&entry); err != nil {
+		t.Fatalf("failed to load eth entry: %v", err)
+	}
+	if want := pm.currentForkID(); entry.ForkID != want {
+		t.Errorf("fork ID mismatch: have %v, want %v", entry.ForkID, want)
+	}
+}
+
+// Tests that the "eth" entry is updated when a new chain head passes a fork,
+// but not on heads leaving the fork ID unchanged.
+func TestENREntryUpdate(t *testing.T) {
+	pm := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	config := *pm.chainconfig
+	config.MetropolisBlock = big.NewInt(2)
+	pm.chainconfig = &config
+
+	records := make(testRecord, 1)
+	pm.startENRUpdater(records)
+
+	head := func(number int64) {
+		pm.eventMux.Post(core.ChainHeadEvent{Block: types.NewBlockWithHeader(&types.Header{Number: big.NewInt(number)})})
+	}
+	expect := func(want *forkid.ID) {
+		select {
+		case entry := <-records:
+			if want == nil {
+				t.Fatalf("unexpected record update: %v", entry)
+			}
+			if have := entry.(*ethEntry).ForkID; have != *want {
+				t.Fatalf("fork ID mismatch: have %v, want %v", have, *want)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if want != nil {
+				t.Fatalf("record not updated, want fork ID %v", *want)
+			}
+		}
+	}
+	head(1)
+	expect(nil)
+
+	head(2)
+	want := forkid.NewID(&config, pm.blockchain.Genesis().Hash(), 2)
+	expect(&want)
+
+	head(3)
+	expect(nil)
+}
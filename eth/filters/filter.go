@@ -18,12 +18,10 @@ package filters
 
 import (
 	"context"
-	"math"
 	"math/big"
-	"time"
 
 	"github.com/immesys/bw2bc/common"
-	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/bloombits"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/ethdb"
 	"github.com/immesys/bw2bc/event"
@@ -35,30 +33,26 @@ type Backend interface {
 	EventMux() *event.TypeMux
 	HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error)
 	GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error)
+
+	BloomStatus() (uint64, uint64)
+	GetBloomBits(ctx context.Context, bit uint, sections []uint64) ([][]byte, error)
 }
 
 // Filter can be used to retrieve and filter logs.
 type Filter struct {
-	backend   Backend
-	useMipMap bool
-
-	created time.Time
+	backend Backend
 
-	db         ethdb.Database
 	begin, end int64
 	addresses  []common.Address
 	topics     [][]common.Hash
 }
 
-// New creates a new filter which uses a bloom filter on blocks to figure out whether
-// a particular block is interesting or not.
-// MipMaps allow past blocks to be searched much more efficiently, but are not available
-// to light clients.
-func New(backend Backend, useMipMap bool) *Filter {
+// New creates a new filter which uses the bloom bits index to find the sections
+// of the chain that might contain matching logs, and the header blooms of the
+// blocks not yet covered by the index.
+func New(backend Backend) *Filter {
 	return &Filter{
-		backend:   backend,
-		useMipMap: useMipMap,
-		db:        backend.ChainDb(),
+		backend: backend,
 	}
 }
 
@@ -85,115 +79,145 @@ func (f *Filter) SetTopics(topics [][]common.Hash) {
 	f.topics = topics
 }
 
-// FindOnce searches the blockchain for matching log entries, returning
-// all matching entries from the first block that contains matches,
-// updating the start point of the filter accordingly. If no results are
-// found, a nil slice is returned.
-func (f *Filter) FindOnce(ctx context.Context) ([]*types.Log, error) {
-	head, _ := f.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
-	if head == nil {
+// Logs searches the blockchain for matching log entries, returning all from the
+// first block that contains matches, updating the start of the filter accordingly.
+func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
+	// Figure out the limits of the filter range
+	header, _ := f.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if header == nil {
 		return nil, nil
 	}
-	headBlockNumber := head.Number.Uint64()
+	head := header.Number.Uint64()
 
-	var beginBlockNo uint64 = uint64(f.begin)
 	if f.begin == -1 {
-		beginBlockNo = headBlockNumber
+		f.begin = int64(head)
 	}
-	var endBlockNo uint64 = uint64(f.end)
-	if f.end == -1 {
-		endBlockNo = headBlockNumber
+	end := uint64(f.end)
+	if f.end == -1 || end > head {
+		end = head
 	}
-
-	// if no addresses are present we can't make use of fast search which
-	// uses the mipmap bloom filters to check for fast inclusion and uses
-	// higher range probability in order to ensure at least a false positive
-	if !f.useMipMap || len(f.addresses) == 0 {
-		logs, blockNumber, err := f.getLogs(ctx, beginBlockNo, endBlockNo)
-		f.begin = int64(blockNumber + 1)
-		return logs, err
+	// Gather all indexed logs, and finish with non indexed ones
+	var (
+		logs []*types.Log
+		err  error
+	)
+	size, sections := f.backend.BloomStatus()
+	if indexed := sections * size; indexed > uint64(f.begin) {
+		if indexed > end {
+			logs, err = f.indexedLogs(ctx, end)
+		} else {
+			logs, err = f.indexedLogs(ctx, indexed-1)
+		}
+		if err != nil {
+			return logs, err
+		}
 	}
-
-	logs, blockNumber := f.mipFind(beginBlockNo, endBlockNo, 0)
-	f.begin = int64(blockNumber + 1)
-	return logs, nil
+	rest, err := f.unindexedLogs(ctx, end)
+	logs = append(logs, rest...)
+	return logs, err
 }
 
-// Run filters logs with the current parameters set
-func (f *Filter) Find(ctx context.Context) (logs []*types.Log, err error) {
-	for {
-		newLogs, err := f.FindOnce(ctx)
-		if len(newLogs) == 0 || err != nil {
+// indexedLogs returns the logs matching the filter criteria based on the bloom
+// bits index, available locally or via the network.
+func (f *Filter) indexedLogs(ctx context.Context, end uint64) ([]*types.Log, error) {
+	size, _ := f.backend.BloomStatus()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Start the matcher over the indexed sections and collect candidate blocks
+	var (
+		matches = make(chan uint64, 64)
+		errc    = make(chan error, 1)
+	)
+	matcher := bloombits.NewMatcher(size, f.matcherFilters())
+	go func() {
+		errc <- matcher.Run(ctx, uint64(f.begin), end, f.backend.GetBloomBits, matches)
+	}()
+
+	var logs []*types.Log
+	for number := range matches {
+		f.begin = int64(number) + 1
+
+		// Retrieve the suggested block and pull any truly matching logs
+		header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+		if header == nil || err != nil {
+			cancel()
+			<-errc
+			return logs, err
+		}
+		found, err := f.checkMatches(ctx, header)
+		if err != nil {
+			cancel()
+			<-errc
 			return logs, err
 		}
-		logs = append(logs, newLogs...)
+		logs = append(logs, found...)
+	}
+	if err := <-errc; err != nil {
+		return logs, err
 	}
+	f.begin = int64(end) + 1
+	return logs, nil
 }
 
-func (f *Filter) mipFind(start, end uint64, depth int) (logs []*types.Log, blockNumber uint64) {
-	level := core.MIPMapLevels[depth]
-	// normalise numerator so we can work in level specific batches and
-	// work with the proper range checks
-	for num := start / level * level; num <= end; num += level {
-		// find addresses in bloom filters
-		bloom := core.GetMipmapBloom(f.db, num, level)
-		// Don't bother checking the first time through the loop - we're probably picking
-		// up where a previous run left off.
-		first := true
-		for _, addr := range f.addresses {
-			if first || bloom.TestBytes(addr[:]) {
-				first = false
-				// range check normalised values and make sure that
-				// we're resolving the correct range instead of the
-				// normalised values.
-				start := uint64(math.Max(float64(num), float64(start)))
-				end := uint64(math.Min(float64(num+level-1), float64(end)))
-				if depth+1 == len(core.MIPMapLevels) {
-					l, blockNumber, _ := f.getLogs(context.Background(), start, end)
-					if len(l) > 0 {
-						return l, blockNumber
-					}
-				} else {
-					l, blockNumber := f.mipFind(start, end, depth+1)
-					if len(l) > 0 {
-						return l, blockNumber
-					}
-				}
+// unindexedLogs returns the logs matching the filter criteria based on raw block
+// iteration and bloom matching.
+func (f *Filter) unindexedLogs(ctx context.Context, end uint64) ([]*types.Log, error) {
+	var logs []*types.Log
+
+	for ; f.begin <= int64(end); f.begin++ {
+		header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(f.begin))
+		if header == nil || err != nil {
+			return logs, err
+		}
+		if f.bloomFilter(header.Bloom) {
+			found, err := f.checkMatches(ctx, header)
+			if err != nil {
+				return logs, err
 			}
+			logs = append(logs, found...)
 		}
 	}
+	return logs, nil
+}
 
-	return nil, end
+// checkMatches checks if the receipts belonging to the given header contain any
+// log events that match the filter criteria.
+func (f *Filter) checkMatches(ctx context.Context, header *types.Header) ([]*types.Log, error) {
+	// Get the logs of the block
+	receipts, err := f.backend.GetReceipts(ctx, header.Hash())
+	if err != nil {
+		return nil, err
+	}
+	var unfiltered []*types.Log
+	for _, receipt := range receipts {
+		unfiltered = append(unfiltered, ([]*types.Log)(receipt.Logs)...)
+	}
+	return filterLogs(unfiltered, nil, nil, f.addresses, f.topics), nil
 }
 
-func (f *Filter) getLogs(ctx context.Context, start, end uint64) (logs []*types.Log, blockNumber uint64, err error) {
-	for i := start; i <= end; i++ {
-		blockNumber := rpc.BlockNumber(i)
-		header, err := f.backend.HeaderByNumber(ctx, blockNumber)
-		if header == nil || err != nil {
-			return logs, end, err
+// matcherFilters converts the address and topic criteria into the key groups of
+// a bloom bits matcher, with empty topics acting as wildcards.
+func (f *Filter) matcherFilters() [][][]byte {
+	var filters [][][]byte
+	if len(f.addresses) > 0 {
+		filter := make([][]byte, len(f.addresses))
+		for i, address := range f.addresses {
+			filter[i] = address.Bytes()
 		}
-
-		// Use bloom filtering to see if this block is interesting given the
-		// current parameters
-		if f.bloomFilter(header.Bloom) {
-			// Get the logs of the block
-			receipts, err := f.backend.GetReceipts(ctx, header.Hash())
-			if err != nil {
-				return nil, end, err
-			}
-			var unfiltered []*types.Log
-			for _, receipt := range receipts {
-				unfiltered = append(unfiltered, ([]*types.Log)(receipt.Logs)...)
-			}
-			logs = filterLogs(unfiltered, nil, nil, f.addresses, f.topics)
-			if len(logs) > 0 {
-				return logs, uint64(blockNumber), nil
+		filters = append(filters, filter)
+	}
+	for _, topicList := range f.topics {
+		filter := make([][]byte, len(topicList))
+		for i, topic := range topicList {
+			if topic != (common.Hash{}) {
+				filter[i] = topic.Bytes()
 			}
 		}
+		filters = append(filters, filter)
 	}
-
-	return logs, end, nil
+	return filters
 }
 
 func includes(addresses []common.Address, a common.Address) bool {
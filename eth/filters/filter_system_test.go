@@ -18,6 +18,7 @@ package filters
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 	"reflect"
 	"testing"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/bloombits"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/ethdb"
 	"github.com/immesys/bw2bc/event"
@@ -32,6 +34,9 @@ import (
 	"github.com/immesys/bw2bc/rpc"
 )
 
+// testBloomSectionSize is the bloom bits section size used by the test backends.
+const testBloomSectionSize = 64
+
 type testBackend struct {
 	mux *event.TypeMux
 	db  ethdb.Database
@@ -63,6 +68,34 @@ func (b *testBackend) GetReceipts(ctx context.Context, blockHash common.Hash) (t
 	return core.GetBlockReceipts(b.db, blockHash, num), nil
 }
 
+func (b *testBackend) BloomStatus() (uint64, uint64) {
+	return testBloomSectionSize, 0
+}
+
+// GetBloomBits rotates the header blooms of the requested sections on the fly,
+// as the test chains are too short for the real bloom indexer.
+func (b *testBackend) GetBloomBits(ctx context.Context, bit uint, sections []uint64) ([][]byte, error) {
+	results := make([][]byte, len(sections))
+	for i, section := range sections {
+		gen, err := bloombits.NewGenerator(testBloomSectionSize)
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < testBloomSectionSize; j++ {
+			number := section*testBloomSectionSize + j
+			header := core.GetHeader(b.db, core.GetCanonicalHash(b.db, number), number)
+			if header == nil {
+				return nil, fmt.Errorf("header #%d missing", number)
+			}
+			gen.AddBloom(uint(j), header.Bloom)
+		}
+		if results[i], err = gen.Bitset(bit); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
 // TestBlockSubscription tests if a block subscription returns block hashes for posted chain events.
 // It creates multiple subscriptions:
 // - one at the start and should receive all posted chain events and a second (blockHashes)
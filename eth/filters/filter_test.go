@@ -41,8 +41,19 @@ func makeReceipt(addr common.Address) *types.Receipt {
 	return receipt
 }
 
-func BenchmarkMipmaps(b *testing.B) {
-	dir, err := ioutil.TempDir("", "mipmap")
+// indexedTestBackend is a test backend that reports a number of its sections
+// as indexed, so filters run the bloom bits matcher over them.
+type indexedTestBackend struct {
+	*testBackend
+	sections uint64
+}
+
+func (b *indexedTestBackend) BloomStatus() (uint64, uint64) {
+	return testBloomSectionSize, b.sections
+}
+
+func BenchmarkFilters(b *testing.B) {
+	dir, err := ioutil.TempDir("", "filtertest")
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -88,7 +99,6 @@ func BenchmarkMipmaps(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		core.WriteMipmapBloom(db, uint64(i+1), receipts)
 	})
 	for i, block := range chain {
 		core.WriteBlock(db, block)
@@ -104,21 +114,29 @@ func BenchmarkMipmaps(b *testing.B) {
 	}
 	b.ResetTimer()
 
-	filter := New(backend, true)
-	filter.SetAddresses([]common.Address{addr1, addr2, addr3, addr4})
-	filter.SetBeginBlock(0)
-	filter.SetEndBlock(-1)
-
 	for i := 0; i < b.N; i++ {
-		logs, _ := filter.Find(context.Background())
+		filter := New(backend)
+		filter.SetAddresses([]common.Address{addr1, addr2, addr3, addr4})
+		filter.SetBeginBlock(0)
+		filter.SetEndBlock(-1)
+
+		logs, _ := filter.Logs(context.Background())
 		if len(logs) != 4 {
 			b.Fatal("expected 4 logs, got", len(logs))
 		}
 	}
 }
 
+// Tests log filtering over the header blooms only, as well as over partially
+// bloom bits indexed chains.
 func TestFilters(t *testing.T) {
-	dir, err := ioutil.TempDir("", "mipmap")
+	for _, sections := range []uint64{0, 1, 15} {
+		testFilters(t, sections)
+	}
+}
+
+func testFilters(t *testing.T, sections uint64) {
+	dir, err := ioutil.TempDir("", "filtertest")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,7 +145,7 @@ func TestFilters(t *testing.T) {
 	var (
 		db, _   = ethdb.NewLDBDatabase(dir, 0, 0)
 		mux     = new(event.TypeMux)
-		backend = &testBackend{mux, db}
+		backend = &indexedTestBackend{&testBackend{mux, db}, sections}
 		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 		addr    = crypto.PubkeyToAddress(key1.PublicKey)
 
@@ -189,10 +207,6 @@ func TestFilters(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		// i is used as block number for the writes but since the i
-		// starts at 0 and block 0 (genesis) is already present increment
-		// by one
-		core.WriteMipmapBloom(db, uint64(i+1), receipts)
 	})
 	for i, block := range chain {
 		core.WriteBlock(db, block)
@@ -207,82 +221,82 @@ func TestFilters(t *testing.T) {
 		}
 	}
 
-	filter := New(backend, true)
+	filter := New(backend)
 	filter.SetAddresses([]common.Address{addr})
 	filter.SetTopics([][]common.Hash{{hash1, hash2, hash3, hash4}})
 	filter.SetBeginBlock(0)
 	filter.SetEndBlock(-1)
 
-	logs, _ := filter.Find(context.Background())
+	logs, _ := filter.Logs(context.Background())
 	if len(logs) != 4 {
-		t.Error("expected 4 log, got", len(logs))
+		t.Errorf("sections %d: expected 4 log, got %d", sections, len(logs))
 	}
 
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetAddresses([]common.Address{addr})
 	filter.SetTopics([][]common.Hash{{hash3}})
 	filter.SetBeginBlock(900)
 	filter.SetEndBlock(999)
-	logs, _ = filter.Find(context.Background())
+	logs, _ = filter.Logs(context.Background())
 	if len(logs) != 1 {
-		t.Error("expected 1 log, got", len(logs))
+		t.Errorf("sections %d: expected 1 log, got %d", sections, len(logs))
 	}
 	if len(logs) > 0 && logs[0].Topics[0] != hash3 {
 		t.Errorf("expected log[0].Topics[0] to be %x, got %x", hash3, logs[0].Topics[0])
 	}
 
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetAddresses([]common.Address{addr})
 	filter.SetTopics([][]common.Hash{{hash3}})
 	filter.SetBeginBlock(990)
 	filter.SetEndBlock(-1)
-	logs, _ = filter.Find(context.Background())
+	logs, _ = filter.Logs(context.Background())
 	if len(logs) != 1 {
-		t.Error("expected 1 log, got", len(logs))
+		t.Errorf("sections %d: expected 1 log, got %d", sections, len(logs))
 	}
 	if len(logs) > 0 && logs[0].Topics[0] != hash3 {
 		t.Errorf("expected log[0].Topics[0] to be %x, got %x", hash3, logs[0].Topics[0])
 	}
 
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetTopics([][]common.Hash{{hash1, hash2}})
 	filter.SetBeginBlock(1)
 	filter.SetEndBlock(10)
 
-	logs, _ = filter.Find(context.Background())
+	logs, _ = filter.Logs(context.Background())
 	if len(logs) != 2 {
-		t.Error("expected 2 log, got", len(logs))
+		t.Errorf("sections %d: expected 2 log, got %d", sections, len(logs))
 	}
 
 	failHash := common.BytesToHash([]byte("fail"))
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetTopics([][]common.Hash{{failHash}})
 	filter.SetBeginBlock(0)
 	filter.SetEndBlock(-1)
 
-	logs, _ = filter.Find(context.Background())
+	logs, _ = filter.Logs(context.Background())
 	if len(logs) != 0 {
-		t.Error("expected 0 log, got", len(logs))
+		t.Errorf("sections %d: expected 0 log, got %d", sections, len(logs))
 	}
 
 	failAddr := common.BytesToAddress([]byte("failmenow"))
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetAddresses([]common.Address{failAddr})
 	filter.SetBeginBlock(0)
 	filter.SetEndBlock(-1)
 
-	logs, _ = filter.Find(context.Background())
+	logs, _ = filter.Logs(context.Background())
 	if len(logs) != 0 {
-		t.Error("expected 0 log, got", len(logs))
+		t.Errorf("sections %d: expected 0 log, got %d", sections, len(logs))
 	}
 
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetTopics([][]common.Hash{{failHash}, {hash1}})
 	filter.SetBeginBlock(0)
 	filter.SetEndBlock(-1)
 
-	logs, _ = filter.Find(context.Background())
+	logs, _ = filter.Logs(context.Background())
 	if len(logs) != 0 {
-		t.Error("expected 0 log, got", len(logs))
+		t.Errorf("sections %d: expected 0 log, got %d", sections, len(logs))
 	}
 }
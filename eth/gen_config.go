@@ -4,6 +4,7 @@ package eth
 
 import (
 	"math/big"
+	"time"
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/common/hexutil"
@@ -23,10 +24,20 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		SkipBcVersionCheck      bool `toml:"-"`
 		DatabaseHandles         int  `toml:"-"`
 		DatabaseCache           int
+		Snapshot                bool
+		TxLookupLimit           uint64
 		Etherbase               common.Address `toml:",omitempty"`
 		MinerThreads            int            `toml:",omitempty"`
 		ExtraData               hexutil.Bytes  `toml:",omitempty"`
 		GasPrice                *big.Int
+		MinerOrdering           string           `toml:",omitempty"`
+		MinerPriority           []common.Address `toml:",omitempty"`
+		MinerSenderGas          uint64           `toml:",omitempty"`
+		MinerGasTarget          uint64           `toml:",omitempty"`
+		MinerGasLimit           uint64           `toml:",omitempty"`
+		MinerRecommit           time.Duration
+		StratumAddr             string   `toml:",omitempty"`
+		StratumDifficulty       *big.Int `toml:",omitempty"`
 		EthashCacheDir          string
 		EthashCachesInMem       int
 		EthashCachesOnDisk      int
@@ -51,10 +62,20 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
+	enc.Snapshot = c.Snapshot
+	enc.TxLookupLimit = c.TxLookupLimit
 	enc.Etherbase = c.Etherbase
 	enc.MinerThreads = c.MinerThreads
 	enc.ExtraData = c.ExtraData
 	enc.GasPrice = c.GasPrice
+	enc.MinerOrdering = c.MinerOrdering
+	enc.MinerPriority = c.MinerPriority
+	enc.MinerSenderGas = c.MinerSenderGas
+	enc.MinerGasTarget = c.MinerGasTarget
+	enc.MinerGasLimit = c.MinerGasLimit
+	enc.MinerRecommit = c.MinerRecommit
+	enc.StratumAddr = c.StratumAddr
+	enc.StratumDifficulty = c.StratumDifficulty
 	enc.EthashCacheDir = c.EthashCacheDir
 	enc.EthashCachesInMem = c.EthashCachesInMem
 	enc.EthashCachesOnDisk = c.EthashCachesOnDisk
@@ -82,10 +103,20 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		SkipBcVersionCheck      *bool `toml:"-"`
 		DatabaseHandles         *int  `toml:"-"`
 		DatabaseCache           *int
+		Snapshot                *bool
+		TxLookupLimit           *uint64
 		Etherbase               *common.Address `toml:",omitempty"`
 		MinerThreads            *int            `toml:",omitempty"`
 		ExtraData               hexutil.Bytes   `toml:",omitempty"`
 		GasPrice                *big.Int
+		MinerOrdering           *string          `toml:",omitempty"`
+		MinerPriority           []common.Address `toml:",omitempty"`
+		MinerSenderGas          *uint64          `toml:",omitempty"`
+		MinerGasTarget          *uint64          `toml:",omitempty"`
+		MinerGasLimit           *uint64          `toml:",omitempty"`
+		MinerRecommit           *time.Duration
+		StratumAddr             *string  `toml:",omitempty"`
+		StratumDifficulty       *big.Int `toml:",omitempty"`
 		EthashCacheDir          *string
 		EthashCachesInMem       *int
 		EthashCachesOnDisk      *int
@@ -131,6 +162,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.DatabaseCache != nil {
 		c.DatabaseCache = *dec.DatabaseCache
 	}
+	if dec.Snapshot != nil {
+		c.Snapshot = *dec.Snapshot
+	}
+	if dec.TxLookupLimit != nil {
+		c.TxLookupLimit = *dec.TxLookupLimit
+	}
 	if dec.Etherbase != nil {
 		c.Etherbase = *dec.Etherbase
 	}
@@ -143,6 +180,30 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.GasPrice != nil {
 		c.GasPrice = dec.GasPrice
 	}
+	if dec.MinerOrdering != nil {
+		c.MinerOrdering = *dec.MinerOrdering
+	}
+	if dec.MinerPriority != nil {
+		c.MinerPriority = dec.MinerPriority
+	}
+	if dec.MinerSenderGas != nil {
+		c.MinerSenderGas = *dec.MinerSenderGas
+	}
+	if dec.MinerGasTarget != nil {
+		c.MinerGasTarget = *dec.MinerGasTarget
+	}
+	if dec.MinerGasLimit != nil {
+		c.MinerGasLimit = *dec.MinerGasLimit
+	}
+	if dec.MinerRecommit != nil {
+		c.MinerRecommit = *dec.MinerRecommit
+	}
+	if dec.StratumAddr != nil {
+		c.StratumAddr = *dec.StratumAddr
+	}
+	if dec.StratumDifficulty != nil {
+		c.StratumDifficulty = dec.StratumDifficulty
+	}
 	if dec.EthashCacheDir != nil {
 		c.EthashCacheDir = *dec.EthashCacheDir
 	}
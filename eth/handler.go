@@ -30,6 +30,7 @@ import (
 	"github.com/immesys/bw2bc/consensus"
 	"github.com/immesys/bw2bc/consensus/misc"
 	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/forkid"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/eth/downloader"
 	"github.com/immesys/bw2bc/eth/fetcher"
@@ -38,6 +39,7 @@ import (
 	"github.com/immesys/bw2bc/log"
 	"github.com/immesys/bw2bc/p2p"
 	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/enr"
 	"github.com/immesys/bw2bc/params"
 	"github.com/immesys/bw2bc/rlp"
 )
@@ -55,8 +57,18 @@ var (
 // not compatible (low protocol version restrictions and high requirements).
 var errIncompatibleConfig = errors.New("incompatible configuration")
 
+// protoError is a protocol violation committed by a remote peer.
+type protoError struct {
+	code errCode
+	msg  string
+}
+
+func (e *protoError) Error() string {
+	return fmt.Sprintf("%v - %v", e.code, e.msg)
+}
+
 func errResp(code errCode, format string, v ...interface{}) error {
-	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
+	return &protoError{code: code, msg: fmt.Sprintf(format, v...)}
 }
 
 type ProtocolManager struct {
@@ -69,6 +81,7 @@ type ProtocolManager struct {
 	blockchain  *core.BlockChain
 	chaindb     ethdb.Database
 	chainconfig *params.ChainConfig
+	forkFilter  forkid.Filter // Fork ID filter, constant across the lifetime of the node
 	maxPeers    int
 
 	downloader *downloader.Downloader
@@ -80,6 +93,7 @@ type ProtocolManager struct {
 	eventMux      *event.TypeMux
 	txSub         *event.TypeMuxSubscription
 	minedBlockSub *event.TypeMuxSubscription
+	chainHeadSub  *event.TypeMuxSubscription // Updates the node record, nil if not announced
 
 	// channels for fetcher, syncer, txsyncLoop
 	newPeerCh   chan *peer
@@ -103,6 +117,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		blockchain:  blockchain,
 		chaindb:     chaindb,
 		chainconfig: config,
+		forkFilter:  forkid.NewFilter(blockchain),
 		maxPeers:    maxPeers,
 		peers:       newPeerSet(),
 		newPeerCh:   make(chan *peer),
@@ -151,6 +166,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 				}
 				return nil
 			},
+			Attributes: []enr.Entry{manager.currentENREntry()},
 		})
 	}
 	if len(manager.SubProtocols) == 0 {
@@ -216,6 +232,9 @@ func (pm *ProtocolManager) Stop() {
 
 	pm.txSub.Unsubscribe()         // quits txBroadcastLoop
 	pm.minedBlockSub.Unsubscribe() // quits blockBroadcastLoop
+	if pm.chainHeadSub != nil {
+		pm.chainHeadSub.Unsubscribe() // quits enrUpdateLoop
+	}
 
 	// Quit the sync loop.
 	// After this send has completed, no new peers will be accepted.
@@ -250,7 +269,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 
 	// Execute the Ethereum handshake
 	td, head, genesis := pm.blockchain.Status()
-	if err := p.Handshake(pm.networkId, td, head, genesis); err != nil {
+	if err := p.Handshake(pm.networkId, td, head, genesis, pm.currentForkID(), pm.forkFilter); err != nil {
 		p.Log().Debug("Ethereum handshake failed", "err", err)
 		return err
 	}
@@ -295,6 +314,9 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	for {
 		if err := pm.handleMsg(p); err != nil {
 			p.Log().Debug("Ethereum message handling failed", "err", err)
+			if _, ok := err.(*protoError); ok {
+				p.Fault(err)
+			}
 			return err
 		}
 	}
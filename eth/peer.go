@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/forkid"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/p2p"
 	"github.com/immesys/bw2bc/rlp"
@@ -229,13 +230,25 @@ func (p *peer) RequestReceipts(hashes []common.Hash) error {
 }
 
 // Handshake executes the eth protocol handshake, negotiating version number,
-// network IDs, difficulties, head and genesis blocks.
-func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
+// network IDs, difficulties, head and genesis blocks. Since eth/64 the fork
+// identifiers are exchanged too and the remote one is validated by forkFilter.
+func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID forkid.ID, forkFilter forkid.Filter) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
-	var status statusData // safe to read after two values have been received from errc
+	var status statusData64 // safe to read after two values have been received from errc
 
 	go func() {
+		if p.version >= eth64 {
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData64{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       network,
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+				ForkID:          forkID,
+			})
+			return
+		}
 		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
 			ProtocolVersion: uint32(p.version),
 			NetworkId:       network,
@@ -245,7 +258,14 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 		})
 	}()
 	go func() {
-		errc <- p.readStatus(network, &status, genesis)
+		if p.version >= eth64 {
+			errc <- p.readStatus64(network, &status, genesis, forkFilter)
+			return
+		}
+		var legacy statusData
+		err := p.readStatus(network, &legacy, genesis)
+		status.TD, status.CurrentBlock = legacy.TD, legacy.CurrentBlock
+		errc <- err
 	}()
 	timeout := time.NewTimer(handshakeTimeout)
 	defer timeout.Stop()
@@ -264,28 +284,61 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 }
 
 func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
-	msg, err := p.rw.ReadMsg()
+	msg, err := p.readStatusMsg()
 	if err != nil {
 		return err
 	}
-	if msg.Code != StatusMsg {
-		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	// Decode the handshake and make sure everything matches
+	if err := msg.Decode(&status); err != nil {
+		return errResp(ErrDecode, "msg %v: %v", msg, err)
 	}
-	if msg.Size > ProtocolMaxMsgSize {
-		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	return p.checkStatus(network, status.ProtocolVersion, status.NetworkId, status.GenesisBlock, genesis)
+}
+
+func (p *peer) readStatus64(network uint64, status *statusData64, genesis common.Hash, forkFilter forkid.Filter) (err error) {
+	msg, err := p.readStatusMsg()
+	if err != nil {
+		return err
 	}
 	// Decode the handshake and make sure everything matches
 	if err := msg.Decode(&status); err != nil {
 		return errResp(ErrDecode, "msg %v: %v", msg, err)
 	}
-	if status.GenesisBlock != genesis {
-		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status.GenesisBlock[:8], genesis[:8])
+	if err := p.checkStatus(network, status.ProtocolVersion, status.NetworkId, status.GenesisBlock, genesis); err != nil {
+		return err
+	}
+	if err := forkFilter(status.ForkID); err != nil {
+		return errResp(ErrForkIDRejected, "%v", err)
+	}
+	return nil
+}
+
+// readStatusMsg reads the first message from the remote peer, ensuring it is
+// a status message within the size limits.
+func (p *peer) readStatusMsg() (p2p.Msg, error) {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	if msg.Code != StatusMsg {
+		return msg, errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return msg, errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	return msg, nil
+}
+
+// checkStatus verifies the fields common to all status message versions.
+func (p *peer) checkStatus(network uint64, version uint32, remoteNetwork uint64, remoteGenesis, genesis common.Hash) error {
+	if remoteGenesis != genesis {
+		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", remoteGenesis[:8], genesis[:8])
 	}
-	if status.NetworkId != network {
-		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", status.NetworkId, network)
+	if remoteNetwork != network {
+		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", remoteNetwork, network)
 	}
-	if int(status.ProtocolVersion) != p.version {
-		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, p.version)
+	if int(version) != p.version {
+		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", version, p.version)
 	}
 	return nil
 }
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/forkid"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/crypto"
 	"github.com/immesys/bw2bc/eth/downloader"
@@ -39,12 +40,21 @@ var testAccount, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6
 // Tests that handshake failures are detected and reported correctly.
 func TestStatusMsgErrors62(t *testing.T) { testStatusMsgErrors(t, 62) }
 func TestStatusMsgErrors63(t *testing.T) { testStatusMsgErrors(t, 63) }
+func TestStatusMsgErrors64(t *testing.T) { testStatusMsgErrors(t, 64) }
 
 func testStatusMsgErrors(t *testing.T, protocol int) {
 	pm := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
 	td, currentBlock, genesis := pm.blockchain.Status()
+	forkID := pm.currentForkID()
 	defer pm.Stop()
 
+	// status assembles a status message in the format of the tested version.
+	status := func(version uint32, network uint64, genesis common.Hash, forkID forkid.ID) interface{} {
+		if protocol >= eth64 {
+			return statusData64{version, network, td, currentBlock, genesis, forkID}
+		}
+		return statusData{version, network, td, currentBlock, genesis}
+	}
 	tests := []struct {
 		code      uint64
 		data      interface{}
@@ -55,18 +65,28 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 			wantError: errResp(ErrNoStatusMsg, "first msg has code 2 (!= 0)"),
 		},
 		{
-			code: StatusMsg, data: statusData{10, DefaultConfig.NetworkId, td, currentBlock, genesis},
+			code: StatusMsg, data: status(10, DefaultConfig.NetworkId, genesis, forkID),
 			wantError: errResp(ErrProtocolVersionMismatch, "10 (!= %d)", protocol),
 		},
 		{
-			code: StatusMsg, data: statusData{uint32(protocol), 999, td, currentBlock, genesis},
+			code: StatusMsg, data: status(uint32(protocol), 999, genesis, forkID),
 			wantError: errResp(ErrNetworkIdMismatch, "999 (!= 1)"),
 		},
 		{
-			code: StatusMsg, data: statusData{uint32(protocol), DefaultConfig.NetworkId, td, currentBlock, common.Hash{3}},
+			code: StatusMsg, data: status(uint32(protocol), DefaultConfig.NetworkId, common.Hash{3}, forkID),
 			wantError: errResp(ErrGenesisBlockMismatch, "0300000000000000 (!= %x)", genesis[:8]),
 		},
 	}
+	if protocol >= eth64 {
+		tests = append(tests, struct {
+			code      uint64
+			data      interface{}
+			wantError error
+		}{
+			code: StatusMsg, data: status(uint32(protocol), DefaultConfig.NetworkId, genesis, forkid.ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}}),
+			wantError: errResp(ErrForkIDRejected, "%v", forkid.ErrLocalIncompatibleOrStale),
+		})
+	}
 
 	for i, test := range tests {
 		p, errc := newTestPeer("peer", protocol, pm, false)
@@ -91,6 +111,7 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 // This test checks that received transactions are added to the local pool.
 func TestRecvTransactions62(t *testing.T) { testRecvTransactions(t, 62) }
 func TestRecvTransactions63(t *testing.T) { testRecvTransactions(t, 63) }
+func TestRecvTransactions64(t *testing.T) { testRecvTransactions(t, 64) }
 
 func testRecvTransactions(t *testing.T, protocol int) {
 	txAdded := make(chan []*types.Transaction)
@@ -119,6 +140,7 @@ func testRecvTransactions(t *testing.T, protocol int) {
 // This test checks that pending transactions are sent.
 func TestSendTransactions62(t *testing.T) { testSendTransactions(t, 62) }
 func TestSendTransactions63(t *testing.T) { testSendTransactions(t, 63) }
+func TestSendTransactions64(t *testing.T) { testSendTransactions(t, 64) }
 
 func testSendTransactions(t *testing.T, protocol int) {
 	pm := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
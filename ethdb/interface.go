@@ -16,8 +16,13 @@
 
 package ethdb
 
-type Database interface {
+// Putter wraps the database write operation supported by both batches and regular databases.
+type Putter interface {
 	Put(key []byte, value []byte) error
+}
+
+type Database interface {
+	Putter
 	Get(key []byte) ([]byte, error)
 	Delete(key []byte) error
 	Close()
@@ -25,6 +30,6 @@ type Database interface {
 }
 
 type Batch interface {
-	Put(key, value []byte) error
+	Putter
 	Write() error
 }
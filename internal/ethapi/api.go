@@ -17,7 +17,6 @@
 package ethapi
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -133,6 +132,79 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	return content
 }
 
+// ContentFrom returns the transactions contained within the transaction pool that
+// were sent from the given account.
+func (s *PublicTxPoolAPI) ContentFrom(addr common.Address) map[string]map[string]*RPCTransaction {
+	content := map[string]map[string]*RPCTransaction{
+		"pending": make(map[string]*RPCTransaction),
+		"queued":  make(map[string]*RPCTransaction),
+	}
+	pending, queue := s.b.TxPoolContentFrom(addr)
+
+	for _, tx := range pending {
+		content["pending"][fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx)
+	}
+	for _, tx := range queue {
+		content["queued"][fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx)
+	}
+	return content
+}
+
+// RPCTxPoolEvent is the notification sent to txpool subscribers whenever a
+// transaction moves through its lifecycle within the pool.
+type RPCTxPoolEvent struct {
+	Type   string         `json:"type"`
+	Hash   common.Hash    `json:"hash"`
+	From   common.Address `json:"from"`
+	Nonce  hexutil.Uint64 `json:"nonce"`
+	Reason string         `json:"reason,omitempty"`
+}
+
+// Events creates a subscription that is notified each time a transaction is added
+// to, promoted within, replaced in, dropped from or mined out of the pool. If an
+// account is given, only its transactions are reported.
+func (s *PublicTxPoolAPI) Events(ctx context.Context, from *common.Address) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	events := make(chan core.TxPoolEvent, 128)
+	sub, err := s.b.SubscribeTxPoolEvent(events)
+	if err != nil {
+		return &rpc.Subscription{}, err
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				if from != nil && ev.From != *from {
+					continue
+				}
+				notification := &RPCTxPoolEvent{
+					Type:  ev.Kind.String(),
+					Hash:  ev.Tx.Hash(),
+					From:  ev.From,
+					Nonce: hexutil.Uint64(ev.Tx.Nonce()),
+				}
+				if ev.Reason != nil {
+					notification.Reason = ev.Reason.Error()
+				}
+				notifier.Notify(rpcSub.ID, notification)
+
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
 // Status returns the number of pending and queued transaction in the pool.
 func (s *PublicTxPoolAPI) Status() map[string]hexutil.Uint {
 	pending, queue := s.b.Stats()
@@ -898,22 +970,22 @@ func NewPublicTransactionPoolAPI(b Backend, nonceLock *AddrLocker) *PublicTransa
 	return &PublicTransactionPoolAPI{b, nonceLock}
 }
 
-func getTransaction(chainDb ethdb.Database, b Backend, txHash common.Hash) (*types.Transaction, bool, error) {
-	txData, err := chainDb.Get(txHash.Bytes())
-	isPending := false
-	tx := new(types.Transaction)
+// errTxIndexing is returned if a transaction is not found while the transaction
+// index is still being built, so it might exist in a not yet indexed block.
+var errTxIndexing = errors.New("transaction indexing is in progress")
 
-	if err == nil && len(txData) > 0 {
-		if err := rlp.DecodeBytes(txData, tx); err != nil {
-			return nil, isPending, err
-		}
-	} else {
-		// pending transaction?
-		tx = b.GetPoolTransaction(txHash)
-		isPending = true
+func getTransaction(chainDb ethdb.Database, b Backend, txHash common.Hash) (*types.Transaction, bool, error) {
+	if tx, _, _, _ := core.GetTransaction(chainDb, txHash); tx != nil {
+		return tx, false, nil
 	}
-
-	return tx, isPending, nil
+	// pending transaction?
+	if tx := b.GetPoolTransaction(txHash); tx != nil {
+		return tx, true, nil
+	}
+	if !b.TxIndexProgress().Done() {
+		return nil, false, errTxIndexing
+	}
+	return nil, false, nil
 }
 
 // GetBlockTransactionCountByNumber returns the number of transactions in the block with the given block number.
@@ -979,23 +1051,11 @@ func (s *PublicTransactionPoolAPI) GetTransactionCount(ctx context.Context, addr
 // getTransactionBlockData fetches the meta data for the given transaction from the chain database. This is useful to
 // retrieve block information for a hash. It returns the block hash, block index and transaction index.
 func getTransactionBlockData(chainDb ethdb.Database, txHash common.Hash) (common.Hash, uint64, uint64, error) {
-	var txBlock struct {
-		BlockHash  common.Hash
-		BlockIndex uint64
-		Index      uint64
+	blockHash, blockIndex, index := core.GetTxLookupEntry(chainDb, txHash)
+	if blockHash == (common.Hash{}) {
+		return common.Hash{}, 0, 0, fmt.Errorf("transaction %x not indexed", txHash)
 	}
-
-	blockData, err := chainDb.Get(append(txHash.Bytes(), 0x0001))
-	if err != nil {
-		return common.Hash{}, uint64(0), uint64(0), err
-	}
-
-	reader := bytes.NewReader(blockData)
-	if err = rlp.Decode(reader, &txBlock); err != nil {
-		return common.Hash{}, uint64(0), uint64(0), err
-	}
-
-	return txBlock.BlockHash, txBlock.BlockIndex, txBlock.Index, nil
+	return blockHash, blockIndex, index, nil
 }
 
 // GetTransactionByHash returns the transaction for the given hash
@@ -1006,7 +1066,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, has
 
 	if tx, isPending, err = getTransaction(s.b.ChainDb(), s.b, hash); err != nil {
 		log.Debug("Failed to retrieve transaction", "hash", hash, "err", err)
-		return nil, nil
+		return nil, err
 	} else if tx == nil {
 		return nil, nil
 	}
@@ -1033,7 +1093,7 @@ func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context,
 
 	if tx, _, err = getTransaction(s.b.ChainDb(), s.b, hash); err != nil {
 		log.Debug("Failed to retrieve transaction", "hash", hash, "err", err)
-		return nil, nil
+		return nil, err
 	} else if tx == nil {
 		return nil, nil
 	}
@@ -1046,6 +1106,9 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(hash common.Hash) (map[
 	receipt := core.GetReceipt(s.b.ChainDb(), hash)
 	if receipt == nil {
 		log.Debug("Receipt not found for transaction", "hash", hash)
+		if !s.b.TxIndexProgress().Done() {
+			return nil, errTxIndexing
+		}
 		return nil, nil
 	}
 
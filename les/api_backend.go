@@ -18,6 +18,7 @@ package les
 
 import (
 	"context"
+	"errors"
 	"math/big"
 
 	"github.com/immesys/bw2bc/accounts"
@@ -36,6 +37,10 @@ import (
 	"github.com/immesys/bw2bc/rpc"
 )
 
+// errTxPoolEventsUnsupported is returned when subscribing to the lifecycle events
+// of the light transaction pool, which doesn't track them.
+var errTxPoolEventsUnsupported = errors.New("transaction pool events not supported by light clients")
+
 type LesApiBackend struct {
 	eth *LightEthereum
 	gpo *gasprice.Oracle
@@ -124,6 +129,16 @@ func (b *LesApiBackend) TxPoolContent() (map[common.Address]types.Transactions,
 	return b.eth.txPool.Content()
 }
 
+func (b *LesApiBackend) TxPoolContentFrom(addr common.Address) (types.Transactions, types.Transactions) {
+	pending, queued := b.eth.txPool.Content()
+	return pending[addr], queued[addr]
+}
+
+func (b *LesApiBackend) SubscribeTxPoolEvent(ch chan<- core.TxPoolEvent) (event.Subscription, error) {
+	// The light pool doesn't track transaction lifecycles
+	return nil, errTxPoolEventsUnsupported
+}
+
 func (b *LesApiBackend) Downloader() *downloader.Downloader {
 	return b.eth.Downloader()
 }
@@ -136,6 +151,21 @@ func (b *LesApiBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	return b.gpo.SuggestPrice(ctx)
 }
 
+func (b *LesApiBackend) BloomStatus() (uint64, uint64) {
+	// Bloom bits retrieved from the network can't be verified yet without a trusted
+	// index root, so don't report any sections. Filters fall back to the headers,
+	// whose blooms are covered by the light chain's own verification.
+	return params.BloomBitsBlocks, 0
+}
+
+func (b *LesApiBackend) GetBloomBits(ctx context.Context, bit uint, sections []uint64) ([][]byte, error) {
+	return light.GetBloomBits(ctx, b.eth.odr, bit, sections)
+}
+
+func (b *LesApiBackend) TxIndexProgress() core.TxIndexProgress {
+	return core.TxIndexProgress{} // Light clients don't maintain a transaction index
+}
+
 func (b *LesApiBackend) ChainDb() ethdb.Database {
 	return b.eth.chainDb
 }
@@ -342,3 +342,25 @@ func testGetProofs(t *testing.T, protocol int) {
 		t.Errorf("proofs mismatch: %v", err)
 	}
 }
+
+// Tests that bloom bit vectors can be retrieved, with unindexed ones left empty.
+func TestGetBloomBitsLes2(t *testing.T) { testGetBloomBits(t, 2) }
+
+func testGetBloomBits(t *testing.T, protocol int) {
+	// Assemble the test environment
+	db, _ := ethdb.NewMemDatabase()
+	pm := newTestProtocolManagerMust(t, false, 4, testChainGen, nil, nil, db)
+	peer, _ := newTestPeer(t, "peer", protocol, pm, true)
+	defer peer.close()
+
+	// The test chain is too short to be indexed, so fake a vector for the first section
+	vector := []byte{0x01, 0x02, 0x03}
+	core.WriteBloomBits(db, 7, 0, common.Hash{}, vector)
+
+	reqs := []BloomReq{{BitIdx: 7, SectionIdx: 0}, {BitIdx: 8, SectionIdx: 0}, {BitIdx: 7, SectionIdx: 1}}
+	cost := peer.GetRequestCost(GetBloomBitsMsg, len(reqs))
+	sendRequest(peer.app, GetBloomBitsMsg, 42, cost, reqs)
+	if err := expectResponse(peer.app, BloomBitsMsg, 42, testBufLimit, [][]byte{vector, {}, {}}); err != nil {
+		t.Errorf("bloom bits mismatch: %v", err)
+	}
+}
@@ -25,11 +25,13 @@ import (
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/bloombits"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/crypto"
 	"github.com/immesys/bw2bc/ethdb"
 	"github.com/immesys/bw2bc/light"
 	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/params"
 	"github.com/immesys/bw2bc/rlp"
 	"github.com/immesys/bw2bc/trie"
 )
@@ -43,6 +45,7 @@ var (
 	errReceiptHashMismatch = errors.New("receipt hash mismatch")
 	errDataHashMismatch    = errors.New("data hash mismatch")
 	errCHTHashMismatch     = errors.New("cht hash mismatch")
+	errInvalidEntryCount   = errors.New("invalid number of response entries")
 )
 
 type LesOdrRequest interface {
@@ -64,6 +67,8 @@ func LesRequest(req light.OdrRequest) LesOdrRequest {
 		return (*CodeRequest)(r)
 	case *light.ChtRequest:
 		return (*ChtRequest)(r)
+	case *light.BloomRequest:
+		return (*BloomRequest)(r)
 	default:
 		return nil
 	}
@@ -354,3 +359,74 @@ func (r *ChtRequest) Validate(db ethdb.Database, msg *Msg) error {
 
 	return nil
 }
+
+type BloomReq struct {
+	BitIdx, SectionIdx uint64
+}
+
+// ODR request type for requesting bloom bit vectors, see LesOdrRequest interface
+type BloomRequest light.BloomRequest
+
+// GetCost returns the cost of the given ODR request according to the serving
+// peer's cost table (implementation of LesOdrRequest)
+func (r *BloomRequest) GetCost(peer *peer) uint64 {
+	return peer.GetRequestCost(GetBloomBitsMsg, len(r.SectionIdxList))
+}
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *BloomRequest) CanSend(peer *peer) bool {
+	if peer.version < lpv2 {
+		return false
+	}
+	peer.lock.RLock()
+	defer peer.lock.RUnlock()
+
+	// The peer only has the sections indexed that it considers confirmed
+	for _, section := range r.SectionIdxList {
+		if (section+1)*params.BloomBitsBlocks+params.BloomConfirms > peer.headInfo.Number+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *BloomRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting bloom bits", "bit", r.BitIdx, "sections", len(r.SectionIdxList))
+	reqs := make([]*BloomReq, len(r.SectionIdxList))
+	for i, section := range r.SectionIdxList {
+		reqs[i] = &BloomReq{BitIdx: uint64(r.BitIdx), SectionIdx: section}
+	}
+	return peer.RequestBloomBits(reqID, r.GetCost(peer), reqs)
+}
+
+// Valid processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+//
+// Bloom bits can't be proven without a trusted index root, so only their
+// structure is checked here. A malicious server could hide matches by omitting
+// set bits, hence the light client doesn't use retrieved vectors for filtering
+// until they can be verified (see LesApiBackend.BloomStatus).
+func (r *BloomRequest) Validate(db ethdb.Database, msg *Msg) error {
+	log.Debug("Validating bloom bits", "bit", r.BitIdx, "sections", len(r.SectionIdxList))
+
+	// Ensure we have a correct message with a vector for each section
+	if msg.MsgType != MsgBloomBits {
+		return errInvalidMessageType
+	}
+	vectors := msg.Obj.([][]byte)
+	if len(vectors) != len(r.SectionIdxList) {
+		return errInvalidEntryCount
+	}
+	bits := make([][]byte, len(vectors))
+	for i, vector := range vectors {
+		var err error
+		if bits[i], err = bloombits.DecompressBits(vector, int(params.BloomBitsBlocks/8)); err != nil {
+			return err
+		}
+	}
+	// Verifications passed, store and return
+	r.BloomBits = bits
+	return nil
+}
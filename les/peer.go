@@ -210,6 +210,12 @@ func (p *peer) SendHeaderProofs(reqID, bv uint64, proofs []ChtResp) error {
 	return sendResponse(p.rw, HeaderProofsMsg, reqID, bv, proofs)
 }
 
+// SendBloomBits sends a batch of compressed bloom bit vectors, corresponding to
+// the ones requested.
+func (p *peer) SendBloomBits(reqID, bv uint64, bits [][]byte) error {
+	return sendResponse(p.rw, BloomBitsMsg, reqID, bv, bits)
+}
+
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
 func (p *peer) RequestHeadersByHash(reqID, cost uint64, origin common.Hash, amount int, skip int, reverse bool) error {
@@ -256,6 +262,12 @@ func (p *peer) RequestHeaderProofs(reqID, cost uint64, reqs []*ChtReq) error {
 	return sendRequest(p.rw, GetHeaderProofsMsg, reqID, cost, reqs)
 }
 
+// RequestBloomBits fetches a batch of compressed bloom bit vectors from a remote node.
+func (p *peer) RequestBloomBits(reqID, cost uint64, reqs []*BloomReq) error {
+	p.Log().Debug("Fetching batch of bloom bits", "count", len(reqs))
+	return sendRequest(p.rw, GetBloomBitsMsg, reqID, cost, reqs)
+}
+
 func (p *peer) SendTxs(reqID, cost uint64, txs types.Transactions) error {
 	p.Log().Debug("Fetching batch of transactions", "count", len(txs))
 	return p2p.Send(p.rw, SendTxMsg, txs)
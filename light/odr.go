@@ -24,9 +24,11 @@ import (
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/bloombits"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/crypto"
 	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/params"
 	"github.com/immesys/bw2bc/rlp"
 )
 
@@ -157,3 +159,23 @@ func (req *ChtRequest) StoreResult(db ethdb.Database) {
 	core.WriteCanonicalHash(db, hash, num)
 	//storeProof(db, req.Proof)
 }
+
+// BloomRequest is the ODR request type for retrieving the bloom bit vectors of
+// a single bit index for a list of sections
+type BloomRequest struct {
+	OdrRequest
+	BitIdx         uint
+	SectionIdxList []uint64
+	BloomBits      [][]byte
+}
+
+// StoreResult stores the retrieved data in local database
+func (req *BloomRequest) StoreResult(db ethdb.Database) {
+	for i, section := range req.SectionIdxList {
+		head := core.GetCanonicalHash(db, (section+1)*params.BloomBitsBlocks-1)
+		if head == (common.Hash{}) {
+			continue
+		}
+		core.WriteBloomBits(db, req.BitIdx, section, head, bloombits.CompressBits(req.BloomBits[i]))
+	}
+}
@@ -24,9 +24,11 @@ import (
 
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/bloombits"
 	"github.com/immesys/bw2bc/core/types"
 	"github.com/immesys/bw2bc/crypto"
 	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/params"
 	"github.com/immesys/bw2bc/rlp"
 )
 
@@ -162,3 +164,37 @@ func GetBlockReceipts(ctx context.Context, odr OdrBackend, hash common.Hash, num
 	}
 	return r.Receipts, nil
 }
+
+// GetBloomBits retrieves the bloom bit vectors of the given bit index for a list
+// of sections, taking the locally stored ones from the database and requesting
+// the rest from the network.
+func GetBloomBits(ctx context.Context, odr OdrBackend, bitIdx uint, sectionIdxList []uint64) ([][]byte, error) {
+	var (
+		db      = odr.Database()
+		result  = make([][]byte, len(sectionIdxList))
+		reqList []uint64
+		reqIdx  []int
+	)
+	for i, section := range sectionIdxList {
+		head := core.GetCanonicalHash(db, (section+1)*params.BloomBitsBlocks-1)
+		if compressed, err := core.GetBloomBits(db, bitIdx, section, head); err == nil {
+			if bits, err := bloombits.DecompressBits(compressed, int(params.BloomBitsBlocks/8)); err == nil {
+				result[i] = bits
+				continue
+			}
+		}
+		reqList = append(reqList, section)
+		reqIdx = append(reqIdx, i)
+	}
+	if reqList == nil {
+		return result, nil
+	}
+	r := &BloomRequest{BitIdx: bitIdx, SectionIdxList: reqList}
+	if err := odr.Retrieve(ctx, r); err != nil {
+		return nil, err
+	}
+	for i, idx := range reqIdx {
+		result[idx] = r.BloomBits[i]
+	}
+	return result, nil
+}
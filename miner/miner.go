@@ -19,7 +19,9 @@ package miner
 
 import (
 	"fmt"
+	"math/big"
 	"sync/atomic"
+	"time"
 
 	"github.com/immesys/bw2bc/accounts"
 	"github.com/immesys/bw2bc/common"
@@ -163,6 +165,37 @@ func (self *Miner) SetExtra(extra []byte) error {
 	return nil
 }
 
+// SetGasLimits sets the gas limit range the mined blocks are steered towards:
+// blocks below the floor are raised and blocks above the ceiling are lowered, as
+// quickly as the protocol permits.
+func (self *Miner) SetGasLimits(floor, ceil *big.Int) error {
+	if floor.Cmp(params.MinGasLimit) < 0 {
+		return fmt.Errorf("gas target below protocol minimum: %v < %v", floor, params.MinGasLimit)
+	}
+	if ceil.Cmp(floor) < 0 {
+		return fmt.Errorf("gas limit below gas target: %v < %v", ceil, floor)
+	}
+	self.worker.setGasLimits(floor, ceil)
+	return nil
+}
+
+// GasLimits returns the gas limit range the mined blocks are steered towards.
+func (self *Miner) GasLimits() (*big.Int, *big.Int) {
+	return self.worker.gasLimits()
+}
+
+// SetRecommit sets the interval at which the pending work is rebuilt while mining
+// to include newly arrived transactions. Zero disables periodic recommits.
+func (self *Miner) SetRecommit(interval time.Duration) {
+	self.worker.setRecommit(interval)
+}
+
+// SetOrdering sets the policy deciding the order in which pending transactions
+// are included into the mined blocks.
+func (self *Miner) SetOrdering(policy OrderingPolicy) {
+	self.worker.setOrdering(policy)
+}
+
 // Pending returns the currently pending block and associated state.
 func (self *Miner) Pending() (*types.Block, *state.StateDB) {
 	return self.worker.pending()
@@ -0,0 +1,257 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/core/types"
+)
+
+const (
+	OrderingPrice      = "price"      // Greedy ordering by gas price, honouring nonces
+	OrderingRoundRobin = "roundrobin" // Take turns between senders, one transaction each
+)
+
+// TransactionSet is an ordered view over the executable transactions the worker
+// consumes while filling a block. Implementations may interleave the senders in
+// any way they like, but must honour the nonce ordering of each of them.
+type TransactionSet interface {
+	// Peek returns the next transaction to try, or nil if the set is exhausted.
+	Peek() *types.Transaction
+
+	// Shift replaces the current head with the next one from the same sender.
+	Shift()
+
+	// Pop removes the current head along with all later ones from the same
+	// sender, as they cannot be executed any more.
+	Pop()
+}
+
+// inclusionTracker is implemented by transaction sets that need to know which of
+// their transactions were actually included into the block, not merely tried.
+type inclusionTracker interface {
+	// Included is called with the current head after it was successfully
+	// executed and added to the block, before the set is shifted.
+	Included(tx *types.Transaction)
+}
+
+// OrderingPolicy decides the order in which the pending transactions of the pool
+// are included into a new block.
+type OrderingPolicy interface {
+	// Order assembles the transaction set to fill a block from, out of the pending
+	// transactions grouped by sender and sorted by nonce. The map is reowned.
+	Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet
+}
+
+// MakeOrderingPolicy assembles the ordering policy described by the given
+// settings: the base ordering name, an optional list of senders whose transactions
+// are included before anyone else's and an optional per-sender gas allowance.
+func MakeOrderingPolicy(name string, priority []common.Address, senderGas uint64) (OrderingPolicy, error) {
+	var policy OrderingPolicy
+
+	switch name {
+	case "", OrderingPrice:
+		policy = PriceOrdering{}
+	case OrderingRoundRobin:
+		policy = RoundRobinOrdering{}
+	default:
+		return nil, fmt.Errorf("unknown transaction ordering %q", name)
+	}
+	if len(priority) > 0 {
+		policy = &PriorityOrdering{Senders: priority, Policy: policy}
+	}
+	if senderGas > 0 {
+		policy = &SenderGasOrdering{Limit: senderGas, Policy: policy}
+	}
+	return policy, nil
+}
+
+// PriceOrdering greedily includes the best paying transactions first, which is
+// the classical miner strategy.
+type PriceOrdering struct{}
+
+// Order implements OrderingPolicy, sorting the transactions by price and nonce.
+func (PriceOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	return types.NewTransactionsByPriceAndNonce(pending)
+}
+
+// RoundRobinOrdering takes turns between the senders, including one transaction
+// from each before moving on to the next, so a single busy account cannot push
+// everyone else out of a block. Senders start out ordered by their best price.
+type RoundRobinOrdering struct{}
+
+// Order implements OrderingPolicy, interleaving the senders one by one.
+func (RoundRobinOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	queue := make(txListsByPrice, 0, len(pending))
+	for _, txs := range pending {
+		if len(txs) > 0 {
+			queue = append(queue, txs)
+		}
+	}
+	sort.Sort(queue)
+
+	return &roundRobinSet{queue: queue}
+}
+
+// roundRobinSet is the transaction set of the round-robin ordering.
+type roundRobinSet struct {
+	queue []types.Transactions // Nonce sorted transactions of each sender, in turn order
+}
+
+func (s *roundRobinSet) Peek() *types.Transaction {
+	if len(s.queue) == 0 {
+		return nil
+	}
+	return s.queue[0][0]
+}
+
+func (s *roundRobinSet) Shift() {
+	rest := s.queue[0][1:]
+	s.queue = s.queue[1:]
+	if len(rest) > 0 {
+		s.queue = append(s.queue, rest)
+	}
+}
+
+func (s *roundRobinSet) Pop() {
+	s.queue = s.queue[1:]
+}
+
+// txListsByPrice sorts per-sender transaction lists by the price of their first
+// transaction, highest first, falling back to the hash to remain deterministic.
+type txListsByPrice []types.Transactions
+
+func (l txListsByPrice) Len() int      { return len(l) }
+func (l txListsByPrice) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l txListsByPrice) Less(i, j int) bool {
+	if cmp := l[i][0].GasPrice().Cmp(l[j][0].GasPrice()); cmp != 0 {
+		return cmp > 0
+	}
+	hi, hj := l[i][0].Hash(), l[j][0].Hash()
+	return bytes.Compare(hi[:], hj[:]) < 0
+}
+
+// PriorityOrdering includes all the transactions of an allow-list of senders (e.g.
+// the operators of the registry contracts) before anyone else's. Both groups are
+// ordered among themselves by the wrapped policy.
+type PriorityOrdering struct {
+	Senders []common.Address // Accounts to include before everyone else
+	Policy  OrderingPolicy   // Ordering within the priority and the remaining senders
+}
+
+// Order implements OrderingPolicy, splitting the senders by priority.
+func (p *PriorityOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	priority := make(map[common.Address]types.Transactions)
+	for _, addr := range p.Senders {
+		if txs, ok := pending[addr]; ok {
+			priority[addr] = txs
+			delete(pending, addr)
+		}
+	}
+	return &chainedSet{sets: []TransactionSet{
+		p.Policy.Order(signer, priority),
+		p.Policy.Order(signer, pending),
+	}}
+}
+
+// chainedSet exhausts a list of transaction sets one after the other.
+type chainedSet struct {
+	sets []TransactionSet
+}
+
+func (s *chainedSet) Peek() *types.Transaction {
+	for len(s.sets) > 0 {
+		if tx := s.sets[0].Peek(); tx != nil {
+			return tx
+		}
+		s.sets = s.sets[1:]
+	}
+	return nil
+}
+
+func (s *chainedSet) Shift() { s.sets[0].Shift() }
+func (s *chainedSet) Pop()   { s.sets[0].Pop() }
+
+func (s *chainedSet) Included(tx *types.Transaction) {
+	if tracker, ok := s.sets[0].(inclusionTracker); ok {
+		tracker.Included(tx)
+	}
+}
+
+// SenderGasOrdering caps the total gas the transactions of a single sender may
+// claim within a block. As the limit needs to be enforced before execution, the
+// declared gas limits of the transactions are counted, not the gas actually used.
+type SenderGasOrdering struct {
+	Limit  uint64         // Maximum gas allowance of a single sender per block
+	Policy OrderingPolicy // Ordering of the transactions within the allowance
+}
+
+// Order implements OrderingPolicy, skipping senders exceeding their allowance.
+func (p *SenderGasOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	return &gasCappedSet{
+		set:    p.Policy.Order(signer, pending),
+		signer: signer,
+		limit:  p.Limit,
+		used:   make(map[common.Address]uint64),
+	}
+}
+
+// gasCappedSet is the transaction set of the per-sender gas capped ordering.
+type gasCappedSet struct {
+	set    TransactionSet
+	signer types.Signer
+	limit  uint64
+	used   map[common.Address]uint64 // Gas already claimed by each sender
+}
+
+func (s *gasCappedSet) Peek() *types.Transaction {
+	for {
+		tx := s.set.Peek()
+		if tx == nil {
+			return nil
+		}
+		from, _ := types.Sender(s.signer, tx) // already validated by the pool
+		if s.used[from]+tx.Gas().Uint64() <= s.limit {
+			return tx
+		}
+		// Sender ran out of allowance, later nonces cannot be included either
+		s.set.Pop()
+	}
+}
+
+func (s *gasCappedSet) Shift() {
+	s.set.Shift()
+}
+
+// Included charges the gas of a transaction against its sender's allowance. Only
+// included transactions are counted, failed ones don't use up any block space.
+func (s *gasCappedSet) Included(tx *types.Transaction) {
+	from, _ := types.Sender(s.signer, tx)
+	s.used[from] += tx.Gas().Uint64()
+
+	if tracker, ok := s.set.(inclusionTracker); ok {
+		tracker.Included(tx)
+	}
+}
+
+func (s *gasCappedSet) Pop() {
+	s.set.Pop()
+}
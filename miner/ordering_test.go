@@ -0,0 +1,213 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/common/math"
+	"github.com/immesys/bw2bc/consensus/ethash"
+	"github.com/immesys/bw2bc/core"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/core/vm"
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/ethdb"
+	"github.com/immesys/bw2bc/event"
+	"github.com/immesys/bw2bc/params"
+)
+
+var (
+	busyKey, _  = crypto.GenerateKey()
+	quietKey, _ = crypto.GenerateKey()
+	prioKey, _  = crypto.GenerateKey()
+
+	busyAddr  = crypto.PubkeyToAddress(busyKey.PublicKey)
+	quietAddr = crypto.PubkeyToAddress(quietKey.PublicKey)
+	prioAddr  = crypto.PubkeyToAddress(prioKey.PublicKey)
+
+	orderingSigner = types.NewEIP155Signer(params.TestChainConfig.ChainId)
+)
+
+// orderingTx creates a signed plain value transfer with the given nonce and price.
+func orderingTx(nonce uint64, price int64, key *ecdsa.PrivateKey) *types.Transaction {
+	tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(1), new(big.Int).SetUint64(params.TxGas), big.NewInt(price), nil), orderingSigner, key)
+	return tx
+}
+
+// newOrderingTestEnv simulates a short chain in which the busy sender already
+// transacted, and creates a mining environment on top of its head.
+func newOrderingTestEnv(t *testing.T) (*core.BlockChain, *Work) {
+	db, _ := ethdb.NewMemDatabase()
+	gspec := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: core.GenesisAlloc{
+			busyAddr:  {Balance: big.NewInt(1000000000000000000)},
+			quietAddr: {Balance: big.NewInt(1000000000000000000)},
+			prioAddr:  {Balance: big.NewInt(1000000000000000000)},
+		},
+	}
+	genesis := gspec.MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, gspec.Config, ethash.NewFaker(), new(event.TypeMux), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, db, 2, func(i int, gen *core.BlockGen) {
+		gen.AddTx(orderingTx(uint64(i), 1, busyKey))
+	})
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	parent := chain.CurrentBlock()
+	statedb, err := chain.StateAt(parent.Root())
+	if err != nil {
+		t.Fatalf("failed to retrieve head state: %v", err)
+	}
+	work := &Work{
+		config: gspec.Config,
+		signer: orderingSigner,
+		state:  statedb,
+		header: &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			Difficulty: parent.Difficulty(),
+			GasLimit:   core.CalcGasLimit(parent, params.TargetGasLimit, math.MaxBig256),
+			GasUsed:    new(big.Int),
+			Time:       new(big.Int).Add(parent.Time(), common.Big1),
+		},
+	}
+	return chain, work
+}
+
+// orderingTestPending assembles the pending transactions of the test senders: a
+// busy one flooding the pool with well priced transactions and two quiet ones.
+func orderingTestPending() map[common.Address]types.Transactions {
+	busy := make(types.Transactions, 5)
+	for i := range busy {
+		busy[i] = orderingTx(uint64(2+i), 2, busyKey)
+	}
+	return map[common.Address]types.Transactions{
+		busyAddr:  busy,
+		quietAddr: {orderingTx(0, 1, quietKey)},
+		prioAddr:  {orderingTx(0, 1, prioKey)},
+	}
+}
+
+// Tests that the ordering policies fill blocks in their expected sender order.
+func TestOrderingPolicies(t *testing.T) {
+	tests := []struct {
+		policy OrderingPolicy
+		order  []common.Address // Expected senders, empty for either of the quiet ones
+	}{
+		// Greedy pricing lets the busy sender go first with everything
+		{
+			policy: PriceOrdering{},
+			order:  []common.Address{busyAddr, busyAddr, busyAddr, busyAddr, busyAddr, {}, {}},
+		},
+		// Round-robin lets everyone in after the best paying one
+		{
+			policy: RoundRobinOrdering{},
+			order:  []common.Address{busyAddr, {}, {}, busyAddr, busyAddr, busyAddr, busyAddr},
+		},
+		// Priority senders precede everyone else
+		{
+			policy: &PriorityOrdering{Senders: []common.Address{prioAddr}, Policy: PriceOrdering{}},
+			order:  []common.Address{prioAddr, busyAddr, busyAddr, busyAddr, busyAddr, busyAddr, quietAddr},
+		},
+		// Gas allowances cut off the busy sender after its quota
+		{
+			policy: &SenderGasOrdering{Limit: 2 * params.TxGas, Policy: PriceOrdering{}},
+			order:  []common.Address{busyAddr, busyAddr, {}, {}},
+		},
+	}
+	for i, tt := range tests {
+		chain, work := newOrderingTestEnv(t)
+
+		txs := tt.policy.Order(work.signer, orderingTestPending())
+		work.commitTransactions(new(event.TypeMux), txs, chain, common.Address{})
+		chain.Stop()
+
+		if len(work.txs) != len(tt.order) {
+			t.Errorf("test %d: transaction count mismatch: have %d, want %d", i, len(work.txs), len(tt.order))
+			continue
+		}
+		for j, tx := range work.txs {
+			from, _ := types.Sender(work.signer, tx)
+			switch want := tt.order[j]; {
+			case want == (common.Address{}) && from != quietAddr && from != prioAddr:
+				t.Errorf("test %d, tx %d: sender mismatch: have %x, want quiet one", i, j, from)
+			case want != (common.Address{}) && from != want:
+				t.Errorf("test %d, tx %d: sender mismatch: have %x, want %x", i, j, from, want)
+			}
+		}
+		if len(work.failedTxs) > 0 {
+			t.Errorf("test %d: failed transactions: %v", i, work.failedTxs)
+		}
+	}
+}
+
+// Tests that unknown ordering names are rejected and the options compose.
+func TestMakeOrderingPolicy(t *testing.T) {
+	if _, err := MakeOrderingPolicy("fifo", nil, 0); err == nil {
+		t.Fatalf("unknown ordering accepted")
+	}
+	policy, err := MakeOrderingPolicy(OrderingRoundRobin, []common.Address{prioAddr}, params.TxGas)
+	if err != nil {
+		t.Fatalf("failed to assemble ordering: %v", err)
+	}
+	capped, ok := policy.(*SenderGasOrdering)
+	if !ok {
+		t.Fatalf("gas allowance not applied: %T", policy)
+	}
+	prio, ok := capped.Policy.(*PriorityOrdering)
+	if !ok {
+		t.Fatalf("priority senders not applied: %T", capped.Policy)
+	}
+	if _, ok := prio.Policy.(RoundRobinOrdering); !ok {
+		t.Fatalf("base ordering mismatch: %T", prio.Policy)
+	}
+}
+
+// Tests that the per-sender gas allowance is only charged for transactions that
+// were included, not for the ones merely tried.
+func TestSenderGasOrderingIncluded(t *testing.T) {
+	busy := make(types.Transactions, 3)
+	for i := range busy {
+		busy[i] = orderingTx(uint64(i), 1, busyKey)
+	}
+	policy := &SenderGasOrdering{Limit: 2 * params.TxGas, Policy: RoundRobinOrdering{}}
+
+	// Shifting without inclusion must not use up the allowance
+	txs := policy.Order(orderingSigner, map[common.Address]types.Transactions{busyAddr: busy})
+	txs.Shift()
+	txs.Shift()
+	if tx := txs.Peek(); tx != busy[2] {
+		t.Fatalf("allowance charged for transactions not included")
+	}
+	// Included transactions must be charged
+	txs = policy.Order(orderingSigner, map[common.Address]types.Transactions{busyAddr: busy})
+	for i := 0; i < 2; i++ {
+		txs.(inclusionTracker).Included(txs.Peek())
+		txs.Shift()
+	}
+	if tx := txs.Peek(); tx != nil {
+		t.Fatalf("allowance exceeded: have %x, want none", tx.Hash())
+	}
+}
@@ -18,7 +18,6 @@ package miner
 
 import (
 	"errors"
-	"math/big"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,6 +26,7 @@ import (
 	"github.com/immesys/bw2bc/consensus"
 	"github.com/immesys/bw2bc/consensus/ethash"
 	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/event"
 	"github.com/immesys/bw2bc/log"
 )
 
@@ -50,6 +50,8 @@ type RemoteAgent struct {
 	hashrateMu sync.RWMutex
 	hashrate   map[common.Hash]hashrate
 
+	workFeed event.Feed // Notifies subscribers (e.g. the stratum server) of new work
+
 	running int32 // running indicates whether the agent is active. Call atomically
 }
 
@@ -94,6 +96,24 @@ func (a *RemoteAgent) Stop() {
 	close(a.workCh)
 }
 
+// SubscribeWork registers a subscription for every new mining work package the
+// agent receives from the miner.
+func (a *RemoteAgent) SubscribeWork(ch chan<- *Work) event.Subscription {
+	return a.workFeed.Subscribe(ch)
+}
+
+// pendingHeader retrieves the header of a work package previously handed out to
+// a remote miner.
+func (a *RemoteAgent) pendingHeader(hash common.Hash) *types.Header {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if work := a.work[hash]; work != nil {
+		return work.Block.Header()
+	}
+	return nil
+}
+
 // GetHashRate returns the accumulated hashrate of all identifier combined
 func (a *RemoteAgent) GetHashRate() (tot int64) {
 	a.hashrateMu.RLock()
@@ -110,25 +130,38 @@ func (a *RemoteAgent) GetWork() ([3]string, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	var res [3]string
-
 	if a.currentWork != nil {
 		block := a.currentWork.Block
 
-		res[0] = block.HashNoNonce().Hex()
-		seedHash := ethash.SeedHash(block.NumberU64())
-		res[1] = common.BytesToHash(seedHash).Hex()
-		// Calculate the "target" to be returned to the external miner
-		n := big.NewInt(1)
-		n.Lsh(n, 255)
-		n.Div(n, block.Difficulty())
-		n.Lsh(n, 1)
-		res[2] = common.BytesToHash(n.Bytes()).Hex()
-
 		a.work[block.HashNoNonce()] = a.currentWork
-		return res, nil
+		return workPackage(block), nil
 	}
-	return res, errors.New("No work available yet, don't panic.")
+	return [3]string{}, errors.New("No work available yet, don't panic.")
+}
+
+// registerWork hands out the given work package, accepting solutions for it as
+// long as it isn't stale. As opposed to GetWork, it doesn't matter whether the
+// package is still the current one.
+func (a *RemoteAgent) registerWork(work *Work) [3]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.work[work.Block.HashNoNonce()] = work
+	return workPackage(work.Block)
+}
+
+// workPackage assembles the header hash, seed hash and target of a block to be
+// returned to an external miner.
+func workPackage(block *types.Block) [3]string {
+	var res [3]string
+
+	res[0] = block.HashNoNonce().Hex()
+	seedHash := ethash.SeedHash(block.NumberU64())
+	res[1] = common.BytesToHash(seedHash).Hex()
+	// Calculate the "target" to be returned to the external miner
+	res[2] = common.BytesToHash(difficultyTarget(block.Difficulty()).Bytes()).Hex()
+
+	return res
 }
 
 // SubmitWork tries to inject a pow solution into the remote agent, returning
@@ -179,6 +212,8 @@ func (a *RemoteAgent) loop(workCh chan *Work, quitCh chan struct{}) {
 			a.mu.Lock()
 			a.currentWork = work
 			a.mu.Unlock()
+
+			a.workFeed.Send(work)
 		case <-ticker:
 			// cleanup
 			a.mu.Lock()
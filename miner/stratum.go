@@ -0,0 +1,460 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/common/hexutil"
+	"github.com/immesys/bw2bc/consensus"
+	"github.com/immesys/bw2bc/core/types"
+	"github.com/immesys/bw2bc/log"
+)
+
+const (
+	stratumMaxLineSize  = 16 * 1024 // Maximum size of a single stratum request
+	stratumWorkChanSize = 16        // Size of the channel listening to new work packages
+)
+
+var (
+	errStratumNotLoggedIn  = errors.New("not logged in")
+	errStratumUnknownCall  = errors.New("method not supported")
+	errStratumInvalidParam = errors.New("invalid parameters")
+	errStratumNoWork       = errors.New("no work available yet")
+)
+
+// shareVerifier is implemented by consensus engines able to check a seal against
+// an arbitrary difficulty, as opposed to the one in the header.
+type shareVerifier interface {
+	VerifyShare(chain consensus.ChainReader, header *types.Header, difficulty *big.Int) error
+}
+
+// StratumWorkerStats is the mining activity of a single remote worker.
+type StratumWorkerStats struct {
+	Login      string       `json:"login"`      // Account the worker logged in with
+	Online     bool         `json:"online"`     // Whether the worker is currently connected
+	Difficulty *hexutil.Big `json:"difficulty"` // Share difficulty assigned to the worker
+	Accepted   uint64       `json:"accepted"`   // Number of valid shares submitted
+	Rejected   uint64       `json:"rejected"`   // Number of invalid or stale shares submitted
+	Blocks     uint64       `json:"blocks"`     // Number of shares that sealed a block
+	Hashrate   uint64       `json:"hashrate"`   // Hashrate last reported by the worker
+	LastShare  time.Time    `json:"lastShare"`  // Time of the last accepted share
+}
+
+// StratumStats is the mining activity reported by the stratum server.
+type StratumStats struct {
+	Address string                         `json:"address"` // Listening address of the server
+	Workers map[string]*StratumWorkerStats `json:"workers"` // Activity of every worker seen since startup
+}
+
+// stratumRequest is a single line based JSON-RPC request of a stratum miner.
+type stratumRequest struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params []string        `json:"params"`
+	Worker string          `json:"worker"`
+}
+
+// stratumResponse is a reply (or an unsolicited work notification, with id 0)
+// sent to a stratum miner.
+type stratumResponse struct {
+	Id      json.RawMessage `json:"id"`
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result"`
+	Error   interface{}     `json:"error,omitempty"`
+}
+
+// stratumSession is a single connected remote miner.
+type stratumSession struct {
+	conn net.Conn
+	lock sync.Mutex // Serializes writes to the connection
+
+	login      string
+	worker     string
+	difficulty *big.Int
+}
+
+// send writes a single response line to the miner.
+func (s *stratumSession) send(res *stratumResponse) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	blob, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = s.conn.Write(append(blob, '\n'))
+	return err
+}
+
+// StratumServer is a TCP endpoint speaking the line based JSON-RPC protocol of
+// stratum mining proxies. It pushes every new work package of a RemoteAgent to
+// the connected miners, and validates their shares against a per-worker share
+// difficulty before forwarding the ones sealing a block to the agent.
+type StratumServer struct {
+	agent      *RemoteAgent
+	difficulty *big.Int // Default share difficulty of the workers
+
+	listener net.Listener
+	sessions map[*stratumSession]struct{}
+	workers  map[string]*StratumWorkerStats
+	current  *Work // Work package last pushed to the miners
+	lock     sync.RWMutex
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewStratumServer creates a stratum server on top of the given remote agent.
+// The share difficulty applies to workers not requesting one explicitly, a nil
+// value meaning that only block sealing solutions are accepted.
+func NewStratumServer(agent *RemoteAgent, difficulty *big.Int) *StratumServer {
+	return &StratumServer{
+		agent:      agent,
+		difficulty: difficulty,
+		sessions:   make(map[*stratumSession]struct{}),
+		workers:    make(map[string]*StratumWorkerStats),
+	}
+}
+
+// Start begins accepting stratum connections on the given TCP address.
+func (s *StratumServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.quit = make(chan struct{})
+
+	workCh := make(chan *Work, stratumWorkChanSize)
+	sub := s.agent.SubscribeWork(workCh)
+
+	s.wg.Add(2)
+	go s.accept()
+	go s.loop(workCh, sub.Unsubscribe)
+
+	log.Info("Stratum server started", "addr", listener.Addr())
+	return nil
+}
+
+// Stop terminates the listener and disconnects all the miners.
+func (s *StratumServer) Stop() {
+	close(s.quit)
+	s.listener.Close()
+
+	s.lock.Lock()
+	for session := range s.sessions {
+		session.conn.Close()
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	log.Info("Stratum server stopped")
+}
+
+// Addr returns the address the server is listening on.
+func (s *StratumServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stats retrieves the activity of all the workers seen since startup.
+func (s *StratumServer) Stats() *StratumStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	stats := &StratumStats{
+		Address: s.listener.Addr().String(),
+		Workers: make(map[string]*StratumWorkerStats),
+	}
+	for name, worker := range s.workers {
+		cpy := *worker
+		stats.Workers[name] = &cpy
+	}
+	return stats
+}
+
+// accept serves incoming connections until the listener is closed.
+func (s *StratumServer) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Warn("Stratum accept failed", "err", err)
+			continue
+		}
+		session := &stratumSession{conn: conn}
+
+		s.lock.Lock()
+		s.sessions[session] = struct{}{}
+		s.lock.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(session)
+	}
+}
+
+// loop pushes every new work package to the logged in miners.
+func (s *StratumServer) loop(workCh chan *Work, unsubscribe func()) {
+	defer s.wg.Done()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case work := <-workCh:
+			// Register the exact work package with the agent so solutions are accepted
+			pkg := s.agent.registerWork(work)
+
+			s.lock.Lock()
+			s.current = work
+			sessions := make(map[*stratumSession]*big.Int)
+			for session := range s.sessions {
+				if session.login != "" {
+					sessions[session] = session.difficulty
+				}
+			}
+			s.lock.Unlock()
+
+			for session, difficulty := range sessions {
+				res := &stratumResponse{Id: json.RawMessage("0"), Version: "2.0", Result: personalize(pkg, difficulty, work.Block)}
+				if err := session.send(res); err != nil {
+					log.Debug("Failed to push stratum work", "worker", session.worker, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// serve reads and answers the requests of a single miner until it disconnects.
+func (s *StratumServer) serve(session *stratumSession) {
+	defer s.wg.Done()
+	defer func() {
+		session.conn.Close()
+
+		s.lock.Lock()
+		delete(s.sessions, session)
+		if worker := s.workers[session.worker]; worker != nil && session.login != "" {
+			worker.Online = false
+		}
+		s.lock.Unlock()
+	}()
+	scanner := bufio.NewScanner(session.conn)
+	scanner.Buffer(make([]byte, 0, 1024), stratumMaxLineSize)
+
+	for scanner.Scan() {
+		var req stratumRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Debug("Malformed stratum request", "addr", session.conn.RemoteAddr(), "err", err)
+			return
+		}
+		result, err := s.handle(session, &req)
+
+		res := &stratumResponse{Id: req.Id, Version: "2.0", Result: result}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		if err := session.send(res); err != nil {
+			return
+		}
+	}
+}
+
+// handle executes a single stratum request on behalf of a session.
+func (s *StratumServer) handle(session *stratumSession, req *stratumRequest) (interface{}, error) {
+	if req.Method != "eth_submitLogin" && session.login == "" {
+		return nil, errStratumNotLoggedIn
+	}
+	switch req.Method {
+	case "eth_submitLogin":
+		return s.login(session, req)
+
+	case "eth_getWork":
+		s.lock.RLock()
+		current := s.current
+		s.lock.RUnlock()
+
+		if current == nil {
+			return nil, errStratumNoWork
+		}
+		return personalize(s.agent.registerWork(current), session.difficulty, current.Block), nil
+
+	case "eth_submitWork":
+		if len(req.Params) != 3 {
+			return false, errStratumInvalidParam
+		}
+		blob, err := hexutil.Decode(req.Params[0])
+		if err != nil || len(blob) != len(types.BlockNonce{}) {
+			return false, errStratumInvalidParam
+		}
+		var nonce types.BlockNonce
+		copy(nonce[:], blob)
+
+		return s.submit(session, nonce, common.HexToHash(req.Params[1]), common.HexToHash(req.Params[2])), nil
+
+	case "eth_submitHashrate":
+		if len(req.Params) != 2 {
+			return false, errStratumInvalidParam
+		}
+		// Miners report the rate zero padded to 32 bytes, not as a quantity
+		rate := common.HexToHash(req.Params[0]).Big().Uint64()
+		s.agent.SubmitHashrate(common.HexToHash(req.Params[1]), rate)
+
+		s.lock.Lock()
+		s.workers[session.worker].Hashrate = rate
+		s.lock.Unlock()
+		return true, nil
+
+	default:
+		return nil, errStratumUnknownCall
+	}
+}
+
+// login authenticates a session. The login is the account to mine for, optionally
+// suffixed by the worker name, and the password may request a share difficulty
+// in the "d=<difficulty>" format.
+func (s *StratumServer) login(session *stratumSession, req *stratumRequest) (interface{}, error) {
+	if len(req.Params) < 1 || req.Params[0] == "" {
+		return false, errStratumInvalidParam
+	}
+	login, worker := req.Params[0], req.Worker
+	if idx := strings.Index(login, "."); idx >= 0 {
+		login, worker = login[:idx], login[idx+1:]
+	}
+	if worker == "" {
+		worker = "default"
+	}
+	difficulty := s.difficulty
+	if len(req.Params) > 1 && strings.HasPrefix(req.Params[1], "d=") {
+		requested, ok := new(big.Int).SetString(req.Params[1][2:], 10)
+		if !ok || requested.Sign() <= 0 {
+			return false, errStratumInvalidParam
+		}
+		difficulty = requested
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session.login, session.worker, session.difficulty = login, worker, difficulty
+
+	stats := s.workers[worker]
+	if stats == nil {
+		stats = new(StratumWorkerStats)
+		s.workers[worker] = stats
+	}
+	stats.Login, stats.Online = login, true
+	if difficulty != nil {
+		stats.Difficulty = (*hexutil.Big)(new(big.Int).Set(difficulty))
+	}
+	log.Debug("Stratum worker logged in", "login", login, "worker", worker, "difficulty", difficulty)
+	return true, nil
+}
+
+// submit validates a share of a session, forwarding it to the agent if it also
+// satisfies the difficulty of the block.
+func (s *StratumServer) submit(session *stratumSession, nonce types.BlockNonce, hash, mixDigest common.Hash) bool {
+	accepted, sealed := s.verify(session, nonce, hash, mixDigest)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := s.workers[session.worker]
+	switch {
+	case !accepted:
+		stats.Rejected++
+	default:
+		stats.Accepted++
+		stats.LastShare = time.Now()
+		if sealed {
+			stats.Blocks++
+		}
+	}
+	return accepted
+}
+
+// verify checks a share against the session's difficulty and submits it to the
+// agent if it seals the block, returning whether it was a valid share and whether
+// it sealed the block.
+func (s *StratumServer) verify(session *stratumSession, nonce types.BlockNonce, hash, mixDigest common.Hash) (bool, bool) {
+	header := s.agent.pendingHeader(hash)
+	if header == nil {
+		log.Debug("Stale stratum share submitted", "worker", session.worker, "hash", hash)
+		return false, false
+	}
+	header.Nonce, header.MixDigest = nonce, mixDigest
+
+	// If the engine can't check shares, only accept block solutions
+	verifier, ok := s.agent.engine.(shareVerifier)
+	if !ok {
+		sealed := s.agent.SubmitWork(nonce, mixDigest, hash)
+		return sealed, sealed
+	}
+	difficulty := shareDifficulty(session.difficulty, header.Difficulty)
+	if err := verifier.VerifyShare(s.agent.chain, header, difficulty); err != nil {
+		log.Debug("Invalid stratum share submitted", "worker", session.worker, "hash", hash, "err", err)
+		return false, false
+	}
+	if difficulty.Cmp(header.Difficulty) != 0 {
+		if err := verifier.VerifyShare(s.agent.chain, header, header.Difficulty); err != nil {
+			return true, false
+		}
+	}
+	return true, s.agent.SubmitWork(nonce, mixDigest, hash)
+}
+
+// personalize replaces the block target of a work package with the target of
+// a worker's share difficulty.
+func personalize(pkg [3]string, difficulty *big.Int, block *types.Block) [3]string {
+	if block != nil && difficulty != nil {
+		pkg[2] = common.BytesToHash(difficultyTarget(shareDifficulty(difficulty, block.Difficulty())).Bytes()).Hex()
+	}
+	return pkg
+}
+
+// shareDifficulty returns the difficulty shares of a worker are checked against,
+// which is never above the difficulty of the block itself.
+func shareDifficulty(worker, block *big.Int) *big.Int {
+	if worker == nil || worker.Cmp(block) > 0 {
+		return block
+	}
+	return worker
+}
+
+// difficultyTarget calculates the boundary a PoW hash needs to be below to
+// satisfy the given difficulty.
+func difficultyTarget(difficulty *big.Int) *big.Int {
+	n := big.NewInt(1)
+	n.Lsh(n, 255)
+	n.Div(n, difficulty)
+	n.Lsh(n, 1)
+	return n
+}
@@ -0,0 +1,240 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/consensus"
+	"github.com/immesys/bw2bc/consensus/ethash"
+	"github.com/immesys/bw2bc/core/types"
+)
+
+// fakeStratumMiner is an in-process stratum client.
+type fakeStratumMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	id     int
+}
+
+func newFakeStratumMiner(t *testing.T, addr net.Addr) *fakeStratumMiner {
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial stratum server: %v", err)
+	}
+	return &fakeStratumMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// read waits for the next message from the server.
+func (m *fakeStratumMiner) read() map[string]json.RawMessage {
+	m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatalf("failed to read stratum message: %v", err)
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		m.t.Fatalf("failed to decode stratum message %q: %v", line, err)
+	}
+	return msg
+}
+
+// call sends a request and waits for its result.
+func (m *fakeStratumMiner) call(result interface{}, method string, params ...string) {
+	m.id++
+	req, _ := json.Marshal(map[string]interface{}{"id": m.id, "jsonrpc": "2.0", "method": method, "params": params})
+	if _, err := m.conn.Write(append(req, '\n')); err != nil {
+		m.t.Fatalf("failed to send %s: %v", method, err)
+	}
+	msg := m.read()
+	if id := string(msg["id"]); id != fmt.Sprint(m.id) {
+		m.t.Fatalf("%s: response id mismatch: have %s, want %d", method, id, m.id)
+	}
+	if err := json.Unmarshal(msg["result"], result); err != nil {
+		m.t.Fatalf("%s: failed to decode result %s: %v", method, msg["result"], err)
+	}
+}
+
+// newStratumTester creates a remote agent backed by the given engine and a
+// stratum server on top with a default share difficulty of 1000.
+func newStratumTester(t *testing.T, engine consensus.Engine) (*RemoteAgent, *StratumServer, chan *Result) {
+	results := make(chan *Result, 4)
+
+	agent := NewRemoteAgent(nil, engine)
+	agent.SetReturnCh(results)
+	agent.Start()
+
+	server := NewStratumServer(agent, big.NewInt(1000))
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start stratum server: %v", err)
+	}
+	return agent, server, results
+}
+
+// pushWork feeds a new work package for the given block number to the agent.
+func pushWork(agent *RemoteAgent, number int64) *types.Block {
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(number), Difficulty: big.NewInt(200000)})
+	agent.Work() <- &Work{Block: block, createdAt: time.Now()}
+	return block
+}
+
+// Tests that work is pushed to logged in miners with their share target, and that
+// shares are accepted, forwarded and accounted for.
+func TestStratumServer(t *testing.T) {
+	agent, server, results := newStratumTester(t, ethash.NewFaker())
+	defer agent.Stop()
+	defer server.Stop()
+
+	miner := newFakeStratumMiner(t, server.Addr())
+	defer miner.conn.Close()
+
+	var ok bool
+	if miner.call(&ok, "eth_getWork"); ok {
+		t.Fatalf("work handed out before login")
+	}
+	if miner.call(&ok, "eth_submitLogin", "0x0102030405060708091011121314151617181920.rig1", "d=500"); !ok {
+		t.Fatalf("login rejected")
+	}
+	// Push a new work package and ensure it arrives with the worker's target
+	block := pushWork(agent, 1)
+
+	msg := miner.read()
+	if id := string(msg["id"]); id != "0" {
+		t.Fatalf("work notification id mismatch: have %s, want 0", id)
+	}
+	var work [3]string
+	if err := json.Unmarshal(msg["result"], &work); err != nil {
+		t.Fatalf("failed to decode work notification: %v", err)
+	}
+	if work[0] != block.HashNoNonce().Hex() {
+		t.Errorf("work hash mismatch: have %s, want %s", work[0], block.HashNoNonce().Hex())
+	}
+	if want := common.BytesToHash(difficultyTarget(big.NewInt(500)).Bytes()).Hex(); work[2] != want {
+		t.Errorf("share target mismatch: have %s, want %s", work[2], want)
+	}
+	// Report a hashrate and submit a solution, which must be forwarded
+	if miner.call(&ok, "eth_submitHashrate", common.BigToHash(big.NewInt(0x1234)).Hex(), common.Hash{0x01}.Hex()); !ok {
+		t.Errorf("hashrate rejected")
+	}
+	if miner.call(&ok, "eth_submitWork", "0x0000000000000001", work[0], common.Hash{}.Hex()); !ok {
+		t.Errorf("valid share rejected")
+	}
+	select {
+	case result := <-results:
+		if result.Block.HashNoNonce() != block.HashNoNonce() {
+			t.Errorf("sealed block mismatch: have %x, want %x", result.Block.HashNoNonce(), block.HashNoNonce())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("solution not forwarded to the miner")
+	}
+	// Resubmitting the same (now stale) work must be rejected
+	if miner.call(&ok, "eth_submitWork", "0x0000000000000001", work[0], common.Hash{}.Hex()); ok {
+		t.Errorf("stale share accepted")
+	}
+	stats := server.Stats().Workers["rig1"]
+	if stats == nil {
+		t.Fatalf("worker stats missing")
+	}
+	if stats.Accepted != 1 || stats.Rejected != 1 || stats.Blocks != 1 || stats.Hashrate != 0x1234 {
+		t.Errorf("worker stats mismatch: have %+v", stats)
+	}
+	if stats.Difficulty.ToInt().Int64() != 500 {
+		t.Errorf("worker difficulty mismatch: have %v, want %d", stats.Difficulty, 500)
+	}
+	if rate := agent.GetHashRate(); rate != 0x1234 {
+		t.Errorf("agent hashrate mismatch: have %d, want %d", rate, 0x1234)
+	}
+}
+
+// Tests that invalid shares are rejected and not forwarded.
+func TestStratumInvalidShare(t *testing.T) {
+	agent, server, results := newStratumTester(t, ethash.NewFakeFailer(1))
+	defer agent.Stop()
+	defer server.Stop()
+
+	miner := newFakeStratumMiner(t, server.Addr())
+	defer miner.conn.Close()
+
+	var ok bool
+	if miner.call(&ok, "eth_submitLogin", "0x0102030405060708091011121314151617181920"); !ok {
+		t.Fatalf("login rejected")
+	}
+	block := pushWork(agent, 1)
+	miner.read()
+
+	if miner.call(&ok, "eth_submitWork", "0x0000000000000001", block.HashNoNonce().Hex(), common.Hash{}.Hex()); ok {
+		t.Errorf("invalid share accepted")
+	}
+	select {
+	case <-results:
+		t.Errorf("invalid solution forwarded to the miner")
+	default:
+	}
+	stats := server.Stats().Workers["default"]
+	if stats == nil || stats.Accepted != 0 || stats.Rejected != 1 {
+		t.Errorf("worker stats mismatch: have %+v", stats)
+	}
+	if stats.Difficulty.ToInt().Int64() != 1000 {
+		t.Errorf("worker difficulty mismatch: have %v, want %d", stats.Difficulty, 1000)
+	}
+}
+
+// Tests that every work notification carries the package it announces, even if
+// newer work arrived in the meantime, and that solutions for it are accepted.
+func TestStratumWorkNotifications(t *testing.T) {
+	agent, server, results := newStratumTester(t, ethash.NewFaker())
+	defer agent.Stop()
+	defer server.Stop()
+
+	miner := newFakeStratumMiner(t, server.Addr())
+	defer miner.conn.Close()
+
+	var ok bool
+	if miner.call(&ok, "eth_submitLogin", "0x0102030405060708091011121314151617181920"); !ok {
+		t.Fatalf("login rejected")
+	}
+	blocks := []*types.Block{pushWork(agent, 1), pushWork(agent, 2)}
+	for i, block := range blocks {
+		var work [3]string
+		if err := json.Unmarshal(miner.read()["result"], &work); err != nil {
+			t.Fatalf("notification %d: failed to decode work: %v", i, err)
+		}
+		if work[0] != block.HashNoNonce().Hex() {
+			t.Errorf("notification %d: work hash mismatch: have %s, want %s", i, work[0], block.HashNoNonce().Hex())
+		}
+	}
+	// The first package was superseded, but solutions for it are still valid
+	if miner.call(&ok, "eth_submitWork", "0x0000000000000001", blocks[0].HashNoNonce().Hex(), common.Hash{}.Hex()); !ok {
+		t.Errorf("share of superseded work rejected")
+	}
+	select {
+	case result := <-results:
+		if result.Block.HashNoNonce() != blocks[0].HashNoNonce() {
+			t.Errorf("sealed block mismatch: have %x, want %x", result.Block.HashNoNonce(), blocks[0].HashNoNonce())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("solution not forwarded to the miner")
+	}
+}
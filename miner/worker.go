@@ -26,6 +26,7 @@ import (
 
 	"github.com/immesys/bw2bc/accounts"
 	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/common/math"
 	"github.com/immesys/bw2bc/consensus"
 	"github.com/immesys/bw2bc/consensus/misc"
 	"github.com/immesys/bw2bc/core"
@@ -102,6 +103,12 @@ type worker struct {
 
 	coinbase common.Address
 	extra    []byte
+	ordering OrderingPolicy // Policy deciding the order of transactions within a block
+	gasFloor *big.Int       // Gas limit to raise the mined blocks towards
+	gasCeil  *big.Int       // Gas limit to lower the mined blocks towards
+
+	recommit     time.Duration // Interval to rebuild the pending work at while mining
+	recommitQuit chan struct{} // Quit channel of the running recommit loop, nil if none
 
 	currentMu sync.Mutex
 	current   *Work
@@ -117,6 +124,7 @@ type worker struct {
 	// atomic status counters
 	mining int32
 	atWork int32
+	newTxs int32 // Whether transactions arrived since the last work was committed
 
 	fullValidation bool
 }
@@ -133,6 +141,9 @@ func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase com
 		proc:           eth.BlockChain().Validator(),
 		possibleUncles: make(map[common.Hash]*types.Block),
 		coinbase:       coinbase,
+		ordering:       PriceOrdering{},
+		gasFloor:       params.TargetGasLimit,
+		gasCeil:        math.MaxBig256,
 		txQueue:        make(map[common.Hash]*types.Transaction),
 		agents:         make(map[Agent]struct{}),
 		unconfirmed:    newUnconfirmedBlocks(eth.BlockChain(), 5),
@@ -159,6 +170,52 @@ func (self *worker) setExtra(extra []byte) {
 	self.extra = extra
 }
 
+func (self *worker) setGasLimits(floor, ceil *big.Int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.gasFloor, self.gasCeil = floor, ceil
+}
+
+func (self *worker) gasLimits() (*big.Int, *big.Int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return new(big.Int).Set(self.gasFloor), new(big.Int).Set(self.gasCeil)
+}
+
+func (self *worker) setRecommit(interval time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.recommit = interval
+	if atomic.LoadInt32(&self.mining) == 1 {
+		self.stopRecommit()
+		self.startRecommit()
+	}
+}
+
+// startRecommit spins up the recommit loop if an interval is configured. The
+// caller must hold the worker lock.
+func (self *worker) startRecommit() {
+	if self.recommit > 0 && self.recommitQuit == nil {
+		self.recommitQuit = make(chan struct{})
+		go self.recommitLoop(self.recommit, self.recommitQuit)
+	}
+}
+
+// stopRecommit terminates the running recommit loop, if any. The caller must
+// hold the worker lock.
+func (self *worker) stopRecommit() {
+	if self.recommitQuit != nil {
+		close(self.recommitQuit)
+		self.recommitQuit = nil
+	}
+}
+
+func (self *worker) setOrdering(policy OrderingPolicy) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.ordering = policy
+}
+
 func (self *worker) pending() (*types.Block, *state.StateDB) {
 	self.currentMu.Lock()
 	defer self.currentMu.Unlock()
@@ -194,6 +251,7 @@ func (self *worker) start() {
 	defer self.mu.Unlock()
 
 	atomic.StoreInt32(&self.mining, 1)
+	self.startRecommit()
 
 	// spin up agents
 	for agent := range self.agents {
@@ -211,6 +269,7 @@ func (self *worker) stop() {
 			agent.Stop()
 		}
 	}
+	self.stopRecommit()
 	atomic.StoreInt32(&self.mining, 0)
 	atomic.StoreInt32(&self.atWork, 0)
 }
@@ -240,8 +299,11 @@ func (self *worker) update() {
 			self.possibleUncles[ev.Block.Hash()] = ev.Block
 			self.uncleMu.Unlock()
 		case core.TxPreEvent:
-			// Apply transaction to the pending state if we're not mining
-			if atomic.LoadInt32(&self.mining) == 0 {
+			// Apply transaction to the pending state if we're not mining, otherwise
+			// flag it for inclusion on the next recommit
+			if atomic.LoadInt32(&self.mining) == 1 {
+				atomic.StoreInt32(&self.newTxs, 1)
+			} else {
 				self.currentMu.Lock()
 
 				acc, _ := types.Sender(self.current.signer, ev.Tx)
@@ -255,6 +317,28 @@ func (self *worker) update() {
 	}
 }
 
+// recommitLoop periodically rebuilds the pending work while mining, so that any
+// transactions arriving after the work was assembled (e.g. better paying ones)
+// are picked up before the block is sealed. The loop runs until quit is closed,
+// which happens when mining stops or the interval changes.
+func (self *worker) recommitLoop(interval time.Duration, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&self.mining) == 1 && atomic.LoadInt32(&self.newTxs) == 1 {
+				log.Debug("Recommitting pending work with new transactions")
+				self.commitNewWork()
+			}
+
+		case <-quit:
+			return
+		}
+	}
+}
+
 func (self *worker) wait() {
 	for {
 		mustCommitNewWork := true
@@ -292,12 +376,8 @@ func (self *worker) wait() {
 
 				// check if canon block and write transactions
 				if stat == core.CanonStatTy {
-					// This puts transactions in a extra db for rpc
-					core.WriteTransactions(self.chainDb, block)
-					// store the receipts
-					core.WriteReceipts(self.chainDb, work.receipts)
-					// Write map map bloom filters
-					core.WriteMipmapBloom(self.chainDb, block.NumberU64(), work.receipts)
+					// Index the transactions for hash based lookups
+					core.WriteTxLookupEntries(self.chainDb, block)
 					// implicit by posting ChainHeadEvent
 					mustCommitNewWork = false
 				}
@@ -383,6 +463,8 @@ func (self *worker) commitNewWork() {
 	self.currentMu.Lock()
 	defer self.currentMu.Unlock()
 
+	atomic.StoreInt32(&self.newTxs, 0)
+
 	tstart := time.Now()
 	parent := self.chain.CurrentBlock()
 
@@ -401,7 +483,7 @@ func (self *worker) commitNewWork() {
 	header := &types.Header{
 		ParentHash: parent.Hash(),
 		Number:     num.Add(num, common.Big1),
-		GasLimit:   core.CalcGasLimit(parent),
+		GasLimit:   core.CalcGasLimit(parent, self.gasFloor, self.gasCeil),
 		GasUsed:    new(big.Int),
 		Extra:      self.extra,
 		Time:       big.NewInt(tstamp),
@@ -443,7 +525,7 @@ func (self *worker) commitNewWork() {
 		log.Error("Failed to fetch pending transactions", "err", err)
 		return
 	}
-	txs := types.NewTransactionsByPriceAndNonce(pending)
+	txs := self.ordering.Order(work.signer, pending)
 	work.commitTransactions(self.mux, txs, self.chain, self.coinbase)
 
 	self.eth.TxPool().RemoveBatch(work.failedTxs)
@@ -498,7 +580,7 @@ func (self *worker) commitUncle(work *Work, uncle *types.Header) error {
 	return nil
 }
 
-func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsByPriceAndNonce, bc *core.BlockChain, coinbase common.Address) {
+func (env *Work) commitTransactions(mux *event.TypeMux, txs TransactionSet, bc *core.BlockChain, coinbase common.Address) {
 	gp := new(core.GasPool).AddGas(env.header.GasLimit)
 
 	var coalescedLogs []*types.Log
@@ -536,6 +618,9 @@ func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsB
 			// Everything ok, collect the logs and shift in the next transaction from the same account
 			coalescedLogs = append(coalescedLogs, logs...)
 			env.tcount++
+			if tracker, ok := txs.(inclusionTracker); ok {
+				tracker.Included(tx)
+			}
 			txs.Shift()
 
 		default:
@@ -0,0 +1,59 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the recommit loop only runs while mining and is torn down whenever
+// mining stops or the interval is changed.
+func TestWorkerRecommitLifecycle(t *testing.T) {
+	w := &worker{agents: make(map[Agent]struct{})}
+
+	w.setRecommit(time.Hour)
+	if w.recommitQuit != nil {
+		t.Fatalf("recommit loop started while not mining")
+	}
+	w.start()
+	quit := w.recommitQuit
+	if quit == nil {
+		t.Fatalf("recommit loop not started with mining")
+	}
+	w.setRecommit(2 * time.Hour)
+	if !isClosed(quit) {
+		t.Fatalf("previous recommit loop not terminated on interval change")
+	}
+	quit = w.recommitQuit
+	if quit == nil {
+		t.Fatalf("recommit loop not restarted on interval change")
+	}
+	w.stop()
+	if !isClosed(quit) || w.recommitQuit != nil {
+		t.Fatalf("recommit loop not terminated on stop")
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -18,6 +18,7 @@ package node
 
 import (
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -41,11 +42,12 @@ func NewPrivateAdminAPI(node *Node) *PrivateAdminAPI {
 }
 
 // AddPeer requests connecting to a remote node, and also maintaining the new
-// connection at all times, even reconnecting if it is lost.
+// connection at all times, even reconnecting if it is lost. The node is
+// persisted into the static node list of the data directory.
 func (api *PrivateAdminAPI) AddPeer(url string) (bool, error) {
 	// Make sure the server is running, fail otherwise
-	server := api.node.Server()
-	if server == nil {
+	peers := api.node.peerManager()
+	if peers == nil {
 		return false, ErrNodeStopped
 	}
 	// Try to add the url as a static peer and return
@@ -53,15 +55,16 @@ func (api *PrivateAdminAPI) AddPeer(url string) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("invalid enode: %v", err)
 	}
-	server.AddPeer(node)
+	peers.addStatic(node)
 	return true, nil
 }
 
-// RemovePeer disconnects from a a remote node if the connection exists
+// RemovePeer disconnects from a remote node if the connection exists, and
+// removes it from the persisted static node list.
 func (api *PrivateAdminAPI) RemovePeer(url string) (bool, error) {
 	// Make sure the server is running, fail otherwise
-	server := api.node.Server()
-	if server == nil {
+	peers := api.node.peerManager()
+	if peers == nil {
 		return false, ErrNodeStopped
 	}
 	// Try to remove the url as a static peer and return
@@ -69,10 +72,144 @@ func (api *PrivateAdminAPI) RemovePeer(url string) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("invalid enode: %v", err)
 	}
-	server.RemovePeer(node)
+	peers.removeStatic(node)
+	return true, nil
+}
+
+// AddTrustedPeer allows a remote node to always connect, even if slots are
+// full. The node is persisted into the trusted node list of the data directory.
+func (api *PrivateAdminAPI) AddTrustedPeer(url string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	peers := api.node.peerManager()
+	if peers == nil {
+		return false, ErrNodeStopped
+	}
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	peers.addTrusted(node)
+	return true, nil
+}
+
+// RemoveTrustedPeer removes a remote node from the trusted peer set and from
+// the persisted trusted node list, but does not disconnect it.
+func (api *PrivateAdminAPI) RemoveTrustedPeer(url string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	peers := api.node.peerManager()
+	if peers == nil {
+		return false, ErrNodeStopped
+	}
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	peers.removeTrusted(node)
 	return true, nil
 }
 
+// BanPeer bans a remote node or a range of IP addresses from connecting, and
+// disconnects any matching peers. The target may be an enode URL, a hex node
+// ID, an IP address or a CIDR network. The optional duration (e.g. "24h")
+// defaults to the configured ban duration.
+func (api *PrivateAdminAPI) BanPeer(target string, duration *string) (bool, error) {
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	var length time.Duration
+	if duration != nil {
+		d, err := time.ParseDuration(*duration)
+		if err != nil {
+			return false, fmt.Errorf("invalid ban duration: %v", err)
+		}
+		if d <= 0 {
+			return false, fmt.Errorf("invalid ban duration: %v", d)
+		}
+		length = d
+	}
+	id, network, err := parseBanTarget(target)
+	if err != nil {
+		return false, err
+	}
+	if id != nil {
+		err = server.BanNode(*id, length)
+	} else {
+		err = server.BanNetwork(network, length)
+	}
+	return err == nil, err
+}
+
+// UnbanPeer lifts the ban of a remote node or IP range, given in any of the
+// forms accepted by BanPeer. It reports whether the target was banned.
+func (api *PrivateAdminAPI) UnbanPeer(target string) (bool, error) {
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	id, network, err := parseBanTarget(target)
+	if err != nil {
+		return false, err
+	}
+	if id != nil {
+		return server.UnbanNode(*id)
+	}
+	return server.UnbanNetwork(network)
+}
+
+// ListBans retrieves all the currently active node and IP range bans.
+func (api *PrivateAdminAPI) ListBans() ([]*p2p.BanInfo, error) {
+	server := api.node.Server()
+	if server == nil {
+		return nil, ErrNodeStopped
+	}
+	return server.BansInfo(), nil
+}
+
+// RequestRecord retrieves the current signed record of a remote node through
+// the discovery protocol, returning its text encoding. The node must have been
+// seen by discovery before.
+func (api *PrivateAdminAPI) RequestRecord(url string) (string, error) {
+	server := api.node.Server()
+	if server == nil {
+		return "", ErrNodeStopped
+	}
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return "", fmt.Errorf("invalid enode: %v", err)
+	}
+	record, err := server.RequestRecord(node)
+	if err != nil {
+		return "", err
+	}
+	return record.String(), nil
+}
+
+// parseBanTarget interprets a ban target as either a node or an IP network.
+func parseBanTarget(target string) (*discover.NodeID, *net.IPNet, error) {
+	if strings.HasPrefix(target, "enode://") {
+		node, err := discover.ParseNode(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid enode: %v", err)
+		}
+		return &node.ID, nil, nil
+	}
+	if id, err := discover.HexID(target); err == nil {
+		return &id, nil, nil
+	}
+	if _, network, err := net.ParseCIDR(target); err == nil {
+		return nil, network, nil
+	}
+	if ip := net.ParseIP(target); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return nil, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	return nil, nil, fmt.Errorf("invalid ban target %q: want enode URL, node ID, IP or CIDR", target)
+}
+
 // StartRPC starts the HTTP RPC API server.
 func (api *PrivateAdminAPI) StartRPC(host *string, port *int, cors *string, apis *string) (bool, error) {
 	api.node.lock.Lock()
@@ -0,0 +1,63 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"testing"
+
+	"github.com/immesys/bw2bc/p2p/discover"
+)
+
+// Tests that ban targets are parsed into nodes or networks as appropriate.
+func TestParseBanTarget(t *testing.T) {
+	id := "1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439"
+
+	tests := []struct {
+		target  string
+		node    bool
+		network string
+		fail    bool
+	}{
+		{target: "enode://" + id + "@127.0.0.1:30303", node: true},
+		{target: id, node: true},
+		{target: "0x" + id, node: true},
+		{target: "10.0.0.0/8", network: "10.0.0.0/8"},
+		{target: "192.168.1.7", network: "192.168.1.7/32"},
+		{target: "fe80::1", network: "fe80::1/128"},
+		{target: "enode://invalid", fail: true},
+		{target: "not a target", fail: true},
+	}
+	for i, tt := range tests {
+		node, network, err := parseBanTarget(tt.target)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure for %q", i, tt.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to parse %q: %v", i, tt.target, err)
+			continue
+		}
+		if tt.node && (node == nil || *node != discover.MustHexID(id)) {
+			t.Errorf("test %d: node mismatch: have %v", i, node)
+		}
+		if tt.network != "" && (network == nil || network.String() != tt.network) {
+			t.Errorf("test %d: network mismatch: have %v, want %s", i, network, tt.network)
+		}
+	}
+}
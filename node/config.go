@@ -331,11 +331,24 @@ func (c *Config) parsePersistentNodes(path string) []*discover.Node {
 	if _, err := os.Stat(path); err != nil {
 		return nil
 	}
+	nodes, err := loadNodeList(path)
+	if err != nil {
+		log.Error(fmt.Sprintf("Can't load node file %s: %v", path, err))
+		return nil
+	}
+	return nodes
+}
+
+// loadNodeList loads a JSON list of discovery node URLs, skipping any invalid
+// entries. A missing file is treated as an empty list.
+func loadNodeList(path string) ([]*discover.Node, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
 	// Load the nodes from the config file.
 	var nodelist []string
 	if err := common.LoadJSON(path, &nodelist); err != nil {
-		log.Error(fmt.Sprintf("Can't load node file %s: %v", path, err))
-		return nil
+		return nil, err
 	}
 	// Interpret the list as a discovery node array
 	var nodes []*discover.Node
@@ -350,7 +363,7 @@ func (c *Config) parsePersistentNodes(path string) []*discover.Node {
 		}
 		nodes = append(nodes, node)
 	}
-	return nodes
+	return nodes, nil
 }
 
 func makeAccountManager(conf *Config) (*accounts.Manager, string, error) {
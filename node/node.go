@@ -56,7 +56,8 @@ type Node struct {
 	instanceDirLock   storage.Storage // prevents concurrent use of instance directory
 
 	serverConfig p2p.Config
-	server       *p2p.Server // Currently running P2P networking layer
+	server       *p2p.Server  // Currently running P2P networking layer
+	peers        *peerManager // Runtime manager of the static and trusted peers
 
 	serviceFuncs []ServiceConstructor     // Service constructors (in dependency order)
 	services     map[reflect.Type]Service // Currently running services
@@ -233,6 +234,7 @@ func (n *Node) Start() error {
 	// Finish initializing the startup
 	n.services = services
 	n.server = running
+	n.peers = newPeerManager(running, n.config.resolvePath(datadirStaticNodes), n.config.resolvePath(datadirTrustedNodes))
 	n.stop = make(chan struct{})
 
 	return nil
@@ -508,9 +510,11 @@ func (n *Node) Stop() error {
 			failure.Services[kind] = err
 		}
 	}
+	n.peers.close()
 	n.server.Stop()
 	n.services = nil
 	n.server = nil
+	n.peers = nil
 
 	// Release instance directory lock.
 	if n.instanceDirLock != nil {
@@ -583,6 +587,15 @@ func (n *Node) Server() *p2p.Server {
 	return n.server
 }
 
+// peerManager retrieves the manager of the static and trusted peers of the
+// running node, or nil if the node is not running.
+func (n *Node) peerManager() *peerManager {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	return n.peers
+}
+
 // Service retrieves a currently running service registered of a specific type.
 func (n *Node) Service(service interface{}) error {
 	n.lock.RLock()
@@ -0,0 +1,180 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p"
+	"github.com/immesys/bw2bc/p2p/discover"
+)
+
+// peerList is a set of nodes mirrored into a JSON file of enode URLs.
+type peerList struct {
+	path  string // File backing the list, empty if not persisted
+	nodes map[discover.NodeID]*discover.Node
+}
+
+func newPeerList(path string, nodes []*discover.Node) *peerList {
+	list := &peerList{path: path, nodes: make(map[discover.NodeID]*discover.Node)}
+	for _, node := range nodes {
+		list.nodes[node.ID] = node
+	}
+	return list
+}
+
+// save writes the list to its backing file, replacing it atomically.
+func (l *peerList) save() error {
+	if l.path == "" {
+		return nil
+	}
+	urls := make([]string, 0, len(l.nodes))
+	for _, node := range l.nodes {
+		urls = append(urls, node.String())
+	}
+	blob, err := json.MarshalIndent(urls, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// peerManager keeps the static and trusted peers of a running server in sync
+// with the static-nodes.json and trusted-nodes.json files of the instance
+// directory. Peers added or removed through the admin API are persisted into
+// the files, and external edits of the files are applied to the server.
+type peerManager struct {
+	server *p2p.Server
+
+	lock    sync.Mutex
+	static  *peerList
+	trusted *peerList
+	watcher *peerWatcher
+}
+
+// newPeerManager creates a manager for the given server, seeded with the static
+// and trusted nodes the server was started with. Empty paths disable the
+// persistence of the respective list.
+func newPeerManager(server *p2p.Server, staticPath, trustedPath string) *peerManager {
+	pm := &peerManager{
+		server:  server,
+		static:  newPeerList(staticPath, server.StaticNodes),
+		trusted: newPeerList(trustedPath, server.TrustedNodes),
+	}
+	if staticPath != "" {
+		pm.watcher = newPeerWatcher(pm, filepath.Dir(staticPath))
+		pm.watcher.start()
+	}
+	return pm
+}
+
+// close stops watching the peer files.
+func (pm *peerManager) close() {
+	if pm.watcher != nil {
+		pm.watcher.close()
+	}
+}
+
+// addStatic connects to a node, maintaining the connection and persisting it
+// as a static node.
+func (pm *peerManager) addStatic(node *discover.Node) {
+	pm.server.AddPeer(node)
+	pm.update(pm.static, node, true)
+}
+
+// removeStatic disconnects from a node and removes it from the static nodes.
+func (pm *peerManager) removeStatic(node *discover.Node) {
+	pm.server.RemovePeer(node)
+	pm.update(pm.static, node, false)
+}
+
+// addTrusted marks a node trusted, persisting it as a trusted node.
+func (pm *peerManager) addTrusted(node *discover.Node) {
+	pm.server.AddTrustedPeer(node)
+	pm.update(pm.trusted, node, true)
+}
+
+// removeTrusted revokes the trust of a node and removes it from the trusted nodes.
+func (pm *peerManager) removeTrusted(node *discover.Node) {
+	pm.server.RemoveTrustedPeer(node)
+	pm.update(pm.trusted, node, false)
+}
+
+// update adds or removes a node from a list and saves it. Failing to persist
+// the list is not fatal, the server already applied the change.
+func (pm *peerManager) update(list *peerList, node *discover.Node, add bool) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	if add {
+		list.nodes[node.ID] = node
+	} else {
+		delete(list.nodes, node.ID)
+	}
+	if err := list.save(); err != nil {
+		log.Warn("Failed to persist peer list", "path", list.path, "err", err)
+	}
+}
+
+// reload re-reads both peer files and applies any differences to the server.
+func (pm *peerManager) reload() {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	pm.reloadList(pm.static, pm.server.AddPeer, pm.server.RemovePeer)
+	pm.reloadList(pm.trusted, pm.server.AddTrustedPeer, pm.server.RemoveTrustedPeer)
+}
+
+// reloadList re-reads a single peer file, calling add and remove for the nodes
+// that appeared in or disappeared from it. Unreadable files are ignored, so a
+// partially written file does not drop all the peers.
+func (pm *peerManager) reloadList(list *peerList, add, remove func(*discover.Node)) {
+	if list.path == "" {
+		return
+	}
+	nodes, err := loadNodeList(list.path)
+	if err != nil {
+		log.Warn("Failed to reload peer list", "path", list.path, "err", err)
+		return
+	}
+	fresh := newPeerList(list.path, nodes)
+	for id, node := range fresh.nodes {
+		if _, ok := list.nodes[id]; !ok {
+			log.Info("Adding peer from reloaded list", "path", list.path, "node", node)
+			add(node)
+		}
+	}
+	for id, node := range list.nodes {
+		if _, ok := fresh.nodes[id]; !ok {
+			log.Info("Removing peer dropped from list", "path", list.path, "node", node)
+			remove(node)
+		}
+	}
+	list.nodes = fresh.nodes
+}
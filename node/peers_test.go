@@ -0,0 +1,155 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/immesys/bw2bc/common"
+	"github.com/immesys/bw2bc/p2p/discover"
+)
+
+var (
+	testStaticURL  = "enode://1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439@127.0.0.1:30303"
+	testTrustedURL = "enode://2dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439@127.0.0.1:30304"
+	testReloadURL  = "enode://3dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439@127.0.0.1:30305"
+)
+
+// Tests that peers added through the admin API are persisted into the data
+// directory and restored on restart.
+func TestPeerPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testNodeConfig()
+	config.DataDir = dir
+	stack, err := New(config)
+	if err != nil {
+		t.Fatalf("failed to create protocol stack: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start protocol stack: %v", err)
+	}
+	api := NewPrivateAdminAPI(stack)
+	if ok, err := api.AddPeer(testStaticURL); !ok || err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	if ok, err := api.AddTrustedPeer(testTrustedURL); !ok || err != nil {
+		t.Fatalf("failed to add trusted peer: %v", err)
+	}
+	checkNodeFile(t, config.resolvePath(datadirStaticNodes), testStaticURL)
+	checkNodeFile(t, config.resolvePath(datadirTrustedNodes), testTrustedURL)
+
+	// Restart the node and check that the peers were restored
+	if err := stack.Restart(); err != nil {
+		t.Fatalf("failed to restart protocol stack: %v", err)
+	}
+	defer stack.Stop()
+
+	if server := stack.Server(); len(server.StaticNodes) != 1 || len(server.TrustedNodes) != 1 {
+		t.Fatalf("peers not restored: %d static, %d trusted", len(server.StaticNodes), len(server.TrustedNodes))
+	}
+	// Remove the peers again and check that the files are cleared
+	if ok, err := api.RemovePeer(testStaticURL); !ok || err != nil {
+		t.Fatalf("failed to remove peer: %v", err)
+	}
+	if ok, err := api.RemoveTrustedPeer(testTrustedURL); !ok || err != nil {
+		t.Fatalf("failed to remove trusted peer: %v", err)
+	}
+	checkNodeFile(t, config.resolvePath(datadirStaticNodes))
+	checkNodeFile(t, config.resolvePath(datadirTrustedNodes))
+}
+
+// Tests that external changes to the peer files are applied on reload, and
+// that unreadable files are ignored.
+func TestPeerReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testNodeConfig()
+	config.DataDir = dir
+	stack, err := New(config)
+	if err != nil {
+		t.Fatalf("failed to create protocol stack: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start protocol stack: %v", err)
+	}
+	defer stack.Stop()
+
+	if _, err := NewPrivateAdminAPI(stack).AddPeer(testStaticURL); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	// Replace the static node and trust a new one
+	static, trusted := config.resolvePath(datadirStaticNodes), config.resolvePath(datadirTrustedNodes)
+	if err := ioutil.WriteFile(static, []byte(`["`+testReloadURL+`"]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(trusted, []byte(`["`+testTrustedURL+`"]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stack.peers.reload()
+	checkPeerList(t, stack.peers, stack.peers.static, testReloadURL)
+	checkPeerList(t, stack.peers, stack.peers.trusted, testTrustedURL)
+
+	// Corrupt the static list and ensure the peers are retained
+	if err := ioutil.WriteFile(static, []byte(`["enode://`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stack.peers.reload()
+	checkPeerList(t, stack.peers, stack.peers.static, testReloadURL)
+}
+
+// checkNodeFile verifies that a node list file contains exactly the given URLs.
+func checkNodeFile(t *testing.T, path string, urls ...string) {
+	var have []string
+	if err := common.LoadJSON(path, &have); err != nil {
+		t.Fatalf("failed to load %s: %v", filepath.Base(path), err)
+	}
+	if len(have) != len(urls) {
+		t.Fatalf("%s: node count mismatch: have %v, want %v", filepath.Base(path), have, urls)
+	}
+	for i, url := range urls {
+		if have[i] != url {
+			t.Errorf("%s: node %d mismatch: have %s, want %s", filepath.Base(path), i, have[i], url)
+		}
+	}
+}
+
+// checkPeerList verifies that a peer list holds exactly the given nodes.
+func checkPeerList(t *testing.T, pm *peerManager, list *peerList, urls ...string) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	if len(list.nodes) != len(urls) {
+		t.Fatalf("peer count mismatch: have %d, want %d", len(list.nodes), len(urls))
+	}
+	for _, url := range urls {
+		if _, ok := list.nodes[discover.MustParseNode(url).ID]; !ok {
+			t.Errorf("peer %s missing", url)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build darwin,!ios freebsd linux,!arm64 netbsd solaris
+
+package node
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/immesys/bw2bc/log"
+	"github.com/rjeczalik/notify"
+)
+
+// peerWatcher reloads the peer files of a peer manager whenever they change.
+type peerWatcher struct {
+	pm   *peerManager
+	dir  string
+	ev   chan notify.EventInfo
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newPeerWatcher(pm *peerManager, dir string) *peerWatcher {
+	return &peerWatcher{
+		pm:   pm,
+		dir:  dir,
+		ev:   make(chan notify.EventInfo, 10),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// start launches the watcher loop in the background.
+func (w *peerWatcher) start() {
+	go w.loop()
+}
+
+// close terminates the watcher loop and waits for it to exit.
+func (w *peerWatcher) close() {
+	close(w.quit)
+	<-w.done
+}
+
+func (w *peerWatcher) loop() {
+	defer close(w.done)
+	logger := log.New("path", w.dir)
+
+	if err := notify.Watch(w.dir, w.ev, notify.All); err != nil {
+		logger.Trace("Failed to watch peer list folder", "err", err)
+		return
+	}
+	defer notify.Stop(w.ev)
+
+	logger.Trace("Started watching peer list folder")
+	defer logger.Trace("Stopped watching peer list folder")
+
+	// Wait for file system events on the peer lists and reload.
+	// When an event occurs, the reload call is delayed a bit so that
+	// multiple events arriving quickly only cause a single reload.
+	var (
+		debounce         = time.NewTimer(0)
+		debounceDuration = 500 * time.Millisecond
+		pending          bool
+	)
+	<-debounce.C
+	defer debounce.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case ev := <-w.ev:
+			switch filepath.Base(ev.Path()) {
+			case datadirStaticNodes, datadirTrustedNodes:
+				if !pending {
+					debounce.Reset(debounceDuration)
+					pending = true
+				}
+			}
+		case <-debounce.C:
+			w.pm.reload()
+			pending = false
+		}
+	}
+}
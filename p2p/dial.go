@@ -54,6 +54,7 @@ type dialstate struct {
 	maxDynDials int
 	ntab        discoverTable
 	netrestrict *netutil.Netlist
+	services    serviceSource // dialed outside of the dynamic dial budget, may be nil
 
 	lookupRunning bool
 	dialing       map[discover.NodeID]connFlag
@@ -74,6 +75,11 @@ type discoverTable interface {
 	ReadRandomNodes([]*discover.Node) int
 }
 
+// serviceSource supplies the dial candidates found by service searches.
+type serviceSource interface {
+	serviceCandidates(connected map[discover.NodeID]bool) []*discover.Node
+}
+
 // the dial history remembers recent dials.
 type dialHistory []pastDial
 
@@ -182,6 +188,21 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 			newtasks = append(newtasks, t)
 		}
 	}
+	// Create dials for nodes found by service searches. They are limited by the
+	// wanted peer counts of the services instead of the dynamic dial budget, so
+	// they are dialed even if discovery is disabled.
+	if s.services != nil {
+		connected := make(map[discover.NodeID]bool)
+		for id := range peers {
+			connected[id] = true
+		}
+		for id := range s.dialing {
+			connected[id] = true
+		}
+		for _, n := range s.services.serviceCandidates(connected) {
+			addDial(dynDialedConn, n)
+		}
+	}
 	// If we don't have any peers whatsoever, try to dial a random bootnode. This
 	// scenario is useful for the testnet (and private networks) where the discovery
 	// table might be full of mostly bad peers, making it hard to find good ones.
@@ -197,7 +218,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 	// Use random nodes from the table for half of the necessary
 	// dynamic dials.
 	randomCandidates := needDynDials / 2
-	if randomCandidates > 0 {
+	if randomCandidates > 0 && s.ntab != nil {
 		n := s.ntab.ReadRandomNodes(s.randomNodes)
 		for i := 0; i < randomCandidates && i < n; i++ {
 			if addDial(dynDialedConn, s.randomNodes[i]) {
@@ -324,7 +345,7 @@ func (t *dialTask) dial(srv *Server, dest *discover.Node) bool {
 		log.Trace("Dial error", "task", t, "err", err)
 		return false
 	}
-	mfd := newMeteredConn(fd, false)
+	mfd := srv.newMeteredConn(fd, false)
 	srv.setupConn(mfd, t.flags, dest)
 	return true
 }
@@ -342,9 +363,12 @@ func (t *discoverTask) Do(srv *Server) {
 		time.Sleep(next.Sub(now))
 	}
 	srv.lastLookup = time.Now()
-	var target discover.NodeID
-	rand.Read(target[:])
-	t.results = srv.ntab.Lookup(target)
+	if srv.ntab != nil {
+		var target discover.NodeID
+		rand.Read(target[:])
+		t.results = srv.ntab.Lookup(target)
+	}
+	t.results = append(t.results, srv.randomDNSNodes(dnsCandidates)...)
 }
 
 func (t *discoverTask) String() string {
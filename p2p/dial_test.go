@@ -402,6 +402,46 @@ func TestDialStateDynDialFromTable(t *testing.T) {
 	})
 }
 
+// This test checks that nodes found by service searches are dialed even if there
+// are no dynamic dial slots, up to the wanted number of service peers.
+func TestDialStateServiceDial(t *testing.T) {
+	sp := &ServicePeers{
+		maxPeers: 2,
+		found:    []*discover.Node{{ID: uintID(1)}, {ID: uintID(2)}},
+	}
+	srv := &Server{services: map[*ServicePeers]struct{}{sp: {}}}
+
+	dialer := newDialState(nil, nil, nil, 0, nil)
+	dialer.services = srv
+
+	runDialTest(t, dialtest{
+		init: dialer,
+		rounds: []round{
+			// Both service nodes are dialed without a discovery lookup.
+			{
+				new: []task{
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(1)}},
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(2)}},
+				},
+			},
+			// No further dials once the wanted service peers are connected.
+			{
+				peers: []*Peer{
+					{rw: &conn{flags: dynDialedConn, id: uintID(1)}},
+					{rw: &conn{flags: dynDialedConn, id: uintID(2)}},
+				},
+				done: []task{
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(1)}},
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(2)}},
+				},
+				new: []task{
+					&waitExpireTask{Duration: 30 * time.Second},
+				},
+			},
+		},
+	})
+}
+
 // This test checks that candidates that do not match the netrestrict list are not dialed.
 func TestDialStateNetRestrict(t *testing.T) {
 	// This table always returns the same random nodes
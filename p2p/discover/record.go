@@ -0,0 +1,98 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package discover
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"time"
+
+	"github.com/immesys/bw2bc/p2p/enr"
+)
+
+var errRecordMismatch = errors.New("record not signed by node")
+
+// initRecord creates the initial record of the local node, announcing its
+// discovery endpoint. The sequence number is derived from the current time so
+// that it increases across restarts.
+func (tab *Table) initRecord(priv *ecdsa.PrivateKey) error {
+	record := new(enr.Record)
+	record.SetSeq(uint64(time.Now().Unix()))
+	if !tab.self.IP.IsUnspecified() {
+		record.Set(enr.IP(tab.self.IP))
+	}
+	record.Set(enr.UDP(tab.self.UDP))
+	record.Set(enr.TCP(tab.self.TCP))
+	if err := enr.SignV4(record, priv); err != nil {
+		return err
+	}
+	tab.recordMu.Lock()
+	tab.record = record
+	tab.recordMu.Unlock()
+	return nil
+}
+
+// Record returns the signed record of the local node, as served to other nodes
+// requesting it.
+func (tab *Table) Record() *enr.Record {
+	tab.recordMu.Lock()
+	defer tab.recordMu.Unlock()
+
+	cpy := *tab.record
+	return &cpy
+}
+
+// SetRecord replaces the record of the local node. The record must be signed
+// with the node's key.
+func (tab *Table) SetRecord(record *enr.Record) error {
+	if err := checkRecord(record, tab.self.ID); err != nil {
+		return err
+	}
+	cpy := *record
+
+	tab.recordMu.Lock()
+	tab.record = &cpy
+	tab.recordMu.Unlock()
+	return nil
+}
+
+// RequestRecord retrieves the current record of the given node. The node must
+// have been bonded with before, i.e. it must be known to the table.
+func (tab *Table) RequestRecord(n *Node) (*enr.Record, error) {
+	record, err := tab.net.requestENR(n.ID, n.addr())
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRecord(record, n.ID); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// checkRecord verifies that a record is signed with the key of the given node.
+func checkRecord(record *enr.Record, id NodeID) error {
+	if !record.Signed() {
+		return errRecordMismatch
+	}
+	pubkey, err := record.Pubkey()
+	if err != nil {
+		return err
+	}
+	if PubkeyID(pubkey) != id {
+		return errRecordMismatch
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Schema layout of the reputation records. They are kept apart from the node
+// entries so that expiring unseen nodes does not lift their bans.
+var (
+	nodeDBFaultPrefix     = []byte("rep:f:") // Fault counters by node id
+	nodeDBFaultTimePrefix = []byte("rep:t:") // Time of the last fault by node id
+	nodeDBNodeBanPrefix   = []byte("rep:n:") // Ban expiries by node id
+	nodeDBNetBanPrefix    = []byte("rep:i:") // Ban expiries by IP network
+)
+
+// faultDecayInterval is the time after which a single recorded fault is forgiven,
+// counted from the last fault committed by the node.
+var faultDecayInterval = time.Hour
+
+// Ban is a single entry of the ban list, applying either to a node or to a
+// range of IP addresses.
+type Ban struct {
+	Node    *NodeID    // Banned node, nil for network bans
+	Network *net.IPNet // Banned IP network, nil for node bans
+	Expiry  time.Time  // Time at which the ban is lifted
+}
+
+// Reputation records protocol faults committed by remote nodes and the bans
+// resulting from them in the node database, so they persist across restarts.
+type Reputation struct {
+	db   *nodeDB
+	owns bool // Whether the database is closed together with the reputation
+
+	lock  sync.RWMutex
+	nodes map[NodeID]time.Time
+	nets  map[string]*Ban
+}
+
+// NewReputation opens a reputation store backed by the node database at the
+// given path, or by an in-memory database if the path is empty. It is meant for
+// servers running without discovery, which otherwise owns the node database.
+func NewReputation(path string, self NodeID) (*Reputation, error) {
+	db, err := newNodeDB(path, Version, self)
+	if err != nil {
+		return nil, err
+	}
+	rep := newReputation(db)
+	rep.owns = true
+	return rep, nil
+}
+
+// newReputation creates a reputation store on top of an open node database,
+// loading the active bans into memory.
+func newReputation(db *nodeDB) *Reputation {
+	rep := &Reputation{
+		db:    db,
+		nodes: make(map[NodeID]time.Time),
+		nets:  make(map[string]*Ban),
+	}
+	now := time.Now()
+
+	it := db.lvl.NewIterator(util.BytesPrefix(nodeDBNodeBanPrefix), nil)
+	for it.Next() {
+		var id NodeID
+		copy(id[:], it.Key()[len(nodeDBNodeBanPrefix):])
+		if expiry := time.Unix(db.fetchInt64(it.Key()), 0); expiry.After(now) {
+			rep.nodes[id] = expiry
+		} else {
+			db.lvl.Delete(it.Key(), nil)
+		}
+	}
+	it.Release()
+
+	it = db.lvl.NewIterator(util.BytesPrefix(nodeDBFaultPrefix), nil)
+	for it.Next() {
+		var id NodeID
+		copy(id[:], it.Key()[len(nodeDBFaultPrefix):])
+		if rep.faults(id, now) == 0 {
+			rep.deleteFaults(id)
+		}
+	}
+	it.Release()
+
+	it = db.lvl.NewIterator(util.BytesPrefix(nodeDBNetBanPrefix), nil)
+	for it.Next() {
+		_, network, err := net.ParseCIDR(string(it.Key()[len(nodeDBNetBanPrefix):]))
+		expiry := time.Unix(db.fetchInt64(it.Key()), 0)
+		if err != nil || !expiry.After(now) {
+			db.lvl.Delete(it.Key(), nil)
+			continue
+		}
+		rep.nets[network.String()] = &Ban{Network: network, Expiry: expiry}
+	}
+	it.Release()
+
+	return rep
+}
+
+// Close releases the node database if it was opened by NewReputation.
+func (r *Reputation) Close() {
+	if r.owns {
+		r.db.close()
+	}
+}
+
+// Faults returns the number of faults recorded against a node, less the ones
+// already forgiven since its last fault.
+func (r *Reputation) Faults(id NodeID) int {
+	return r.faults(id, time.Now())
+}
+
+// faults returns the number of faults of a node not yet forgiven at the given
+// time. One fault decays for every faultDecayInterval passed since the last one.
+func (r *Reputation) faults(id NodeID, now time.Time) int {
+	faults := r.db.fetchInt64(reputationKey(nodeDBFaultPrefix, id[:]))
+	if faults == 0 {
+		return 0
+	}
+	last := time.Unix(r.db.fetchInt64(reputationKey(nodeDBFaultTimePrefix, id[:])), 0)
+	if decayed := int64(now.Sub(last) / faultDecayInterval); decayed > 0 {
+		faults -= decayed
+	}
+	if faults < 0 {
+		return 0
+	}
+	return int(faults)
+}
+
+// AddFault records a new fault against a node, returning the updated count.
+func (r *Reputation) AddFault(id NodeID) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	faults := r.faults(id, now) + 1
+	r.db.storeInt64(reputationKey(nodeDBFaultPrefix, id[:]), int64(faults))
+	r.db.storeInt64(reputationKey(nodeDBFaultTimePrefix, id[:]), now.Unix())
+	return faults
+}
+
+// ResetFaults clears the faults recorded against a node.
+func (r *Reputation) ResetFaults(id NodeID) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.deleteFaults(id)
+}
+
+// deleteFaults removes the fault records of a node from the database.
+func (r *Reputation) deleteFaults(id NodeID) error {
+	if err := r.db.lvl.Delete(reputationKey(nodeDBFaultPrefix, id[:]), nil); err != nil {
+		return err
+	}
+	return r.db.lvl.Delete(reputationKey(nodeDBFaultTimePrefix, id[:]), nil)
+}
+
+// BanNode bans a node until the given time, replacing any previous ban.
+func (r *Reputation) BanNode(id NodeID, expiry time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.db.storeInt64(reputationKey(nodeDBNodeBanPrefix, id[:]), expiry.Unix()); err != nil {
+		return err
+	}
+	r.nodes[id] = expiry
+	return nil
+}
+
+// BanNetwork bans a range of IP addresses until the given time, replacing any
+// previous ban of the same range.
+func (r *Reputation) BanNetwork(network *net.IPNet, expiry time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := network.String()
+	if err := r.db.storeInt64(reputationKey(nodeDBNetBanPrefix, []byte(key)), expiry.Unix()); err != nil {
+		return err
+	}
+	r.nets[key] = &Ban{Network: network, Expiry: expiry}
+	return nil
+}
+
+// UnbanNode lifts the ban of a node, reporting whether it was banned.
+func (r *Reputation) UnbanNode(id NodeID) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.nodes[id]; !ok {
+		return false, nil
+	}
+	delete(r.nodes, id)
+	return true, r.db.lvl.Delete(reputationKey(nodeDBNodeBanPrefix, id[:]), nil)
+}
+
+// UnbanNetwork lifts the ban of an IP range, reporting whether it was banned.
+func (r *Reputation) UnbanNetwork(network *net.IPNet) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := network.String()
+	if _, ok := r.nets[key]; !ok {
+		return false, nil
+	}
+	delete(r.nets, key)
+	return true, r.db.lvl.Delete(reputationKey(nodeDBNetBanPrefix, []byte(key)), nil)
+}
+
+// NodeBanned reports whether a node is currently banned.
+func (r *Reputation) NodeBanned(id NodeID) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	expiry, ok := r.nodes[id]
+	return ok && time.Now().Before(expiry)
+}
+
+// IPBanned reports whether an IP address falls into a currently banned range.
+func (r *Reputation) IPBanned(ip net.IP) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	now := time.Now()
+	for _, ban := range r.nets {
+		if now.Before(ban.Expiry) && ban.Network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bans returns the currently active bans, node bans first, each group ordered
+// by expiry.
+func (r *Reputation) Bans() []Ban {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var (
+		now   = time.Now()
+		nodes []Ban
+		nets  []Ban
+	)
+	for id, expiry := range r.nodes {
+		if now.Before(expiry) {
+			id := id
+			nodes = append(nodes, Ban{Node: &id, Expiry: expiry})
+		}
+	}
+	for _, ban := range r.nets {
+		if now.Before(ban.Expiry) {
+			nets = append(nets, *ban)
+		}
+	}
+	sort.Sort(bansByExpiry(nodes))
+	sort.Sort(bansByExpiry(nets))
+	return append(nodes, nets...)
+}
+
+// bansByExpiry implements sort.Interface to order bans by expiry.
+type bansByExpiry []Ban
+
+func (b bansByExpiry) Len() int           { return len(b) }
+func (b bansByExpiry) Less(i, j int) bool { return b[i].Expiry.Before(b[j].Expiry) }
+func (b bansByExpiry) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// reputationKey generates the database key of a reputation record.
+func reputationKey(prefix []byte, item []byte) []byte {
+	return append(append([]byte{}, prefix...), item...)
+}
+
+// Reputation returns the reputation store kept in the table's node database.
+func (tab *Table) Reputation() *Reputation {
+	return tab.rep
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that faults and bans are recorded, expire and survive a restart.
+func TestReputationPersistence(t *testing.T) {
+	root, err := ioutil.TempDir("", "nodedb-")
+	if err != nil {
+		t.Fatalf("failed to create temporary data folder: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "database")
+	rep, err := NewReputation(path, NodeID{})
+	if err != nil {
+		t.Fatalf("failed to open reputation store: %v", err)
+	}
+	var (
+		banned  = MustHexID("0x1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439")
+		expired = MustHexID("0x2dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439")
+		faulty  = MustHexID("0x3dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439")
+	)
+	_, network, _ := net.ParseCIDR("10.1.0.0/16")
+
+	for i := 1; i <= 3; i++ {
+		if faults := rep.AddFault(faulty); faults != i {
+			t.Fatalf("fault count mismatch: have %d, want %d", faults, i)
+		}
+	}
+	if err := rep.BanNode(banned, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to ban node: %v", err)
+	}
+	if err := rep.BanNode(expired, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("failed to ban node: %v", err)
+	}
+	if err := rep.BanNetwork(network, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to ban network: %v", err)
+	}
+	rep.Close()
+
+	// Reopen the database and verify the records
+	rep, err = NewReputation(path, NodeID{})
+	if err != nil {
+		t.Fatalf("failed to reopen reputation store: %v", err)
+	}
+	defer rep.Close()
+
+	if faults := rep.Faults(faulty); faults != 3 {
+		t.Errorf("persisted fault count mismatch: have %d, want 3", faults)
+	}
+	if !rep.NodeBanned(banned) {
+		t.Errorf("node ban lost across restart")
+	}
+	if rep.NodeBanned(expired) {
+		t.Errorf("expired node ban still active")
+	}
+	if !rep.IPBanned(net.ParseIP("10.1.2.3")) {
+		t.Errorf("network ban lost across restart")
+	}
+	if rep.IPBanned(net.ParseIP("10.2.0.1")) {
+		t.Errorf("address outside banned network reported banned")
+	}
+	if bans := rep.Bans(); len(bans) != 2 || bans[0].Node == nil || *bans[0].Node != banned || bans[1].Network == nil {
+		t.Errorf("ban list mismatch: have %v", bans)
+	}
+	// Lift the bans and reset the faults
+	if ok, err := rep.UnbanNode(banned); !ok || err != nil {
+		t.Errorf("failed to unban node: %v, %v", ok, err)
+	}
+	if ok, err := rep.UnbanNetwork(network); !ok || err != nil {
+		t.Errorf("failed to unban network: %v, %v", ok, err)
+	}
+	if ok, _ := rep.UnbanNode(banned); ok {
+		t.Errorf("repeated unban reported success")
+	}
+	if err := rep.ResetFaults(faulty); err != nil {
+		t.Errorf("failed to reset faults: %v", err)
+	}
+	if rep.NodeBanned(banned) || rep.IPBanned(net.ParseIP("10.1.2.3")) || rep.Faults(faulty) != 0 || len(rep.Bans()) != 0 {
+		t.Errorf("reputation not cleared")
+	}
+}
+
+// Tests that recorded faults are gradually forgiven as time passes without new
+// ones, and that fully forgiven records are dropped on startup.
+func TestReputationFaultDecay(t *testing.T) {
+	rep, err := NewReputation("", NodeID{})
+	if err != nil {
+		t.Fatalf("failed to open reputation store: %v", err)
+	}
+	defer rep.Close()
+
+	faulty := MustHexID("0x3dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439")
+	for i := 0; i < 3; i++ {
+		rep.AddFault(faulty)
+	}
+	// Move the last fault back in time to have two of them decay
+	last := time.Now().Add(-2*faultDecayInterval - time.Second)
+	rep.db.storeInt64(reputationKey(nodeDBFaultTimePrefix, faulty[:]), last.Unix())
+
+	if faults := rep.Faults(faulty); faults != 1 {
+		t.Fatalf("decayed fault count mismatch: have %d, want 1", faults)
+	}
+	if faults := rep.AddFault(faulty); faults != 2 {
+		t.Fatalf("fault count after decay mismatch: have %d, want 2", faults)
+	}
+	// Let all of them decay and ensure the records are swept
+	last = time.Now().Add(-3 * faultDecayInterval)
+	rep.db.storeInt64(reputationKey(nodeDBFaultTimePrefix, faulty[:]), last.Unix())
+
+	if faults := rep.Faults(faulty); faults != 0 {
+		t.Fatalf("fully decayed fault count mismatch: have %d, want 0", faults)
+	}
+	newReputation(rep.db)
+	if _, err := rep.db.lvl.Get(reputationKey(nodeDBFaultPrefix, faulty[:]), nil); err == nil {
+		t.Fatalf("fully decayed fault record not removed")
+	}
+}
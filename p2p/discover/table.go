@@ -35,6 +35,7 @@ import (
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/crypto"
 	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p/enr"
 )
 
 const (
@@ -56,6 +57,7 @@ type Table struct {
 	buckets [nBuckets]*bucket // index of known nodes by distance
 	nursery []*Node           // bootstrap nodes
 	db      *nodeDB           // database of known nodes
+	rep     *Reputation       // faults and bans kept in the node database
 
 	refreshReq chan chan struct{}
 	closeReq   chan struct{}
@@ -69,6 +71,9 @@ type Table struct {
 
 	net  transport
 	self *Node // metadata of the local node
+
+	recordMu sync.Mutex  // protects record
+	record   *enr.Record // signed record of the local node
 }
 
 type bondproc struct {
@@ -84,6 +89,7 @@ type transport interface {
 	ping(NodeID, *net.UDPAddr) error
 	waitping(NodeID) error
 	findnode(toid NodeID, addr *net.UDPAddr, target NodeID) ([]*Node, error)
+	requestENR(toid NodeID, addr *net.UDPAddr) (*enr.Record, error)
 	close()
 }
 
@@ -100,6 +106,7 @@ func newTable(t transport, ourID NodeID, ourAddr *net.UDPAddr, nodeDBPath string
 	tab := &Table{
 		net:        t,
 		db:         db,
+		rep:        newReputation(db),
 		self:       NewNode(ourID, ourAddr.IP, uint16(ourAddr.Port), uint16(ourAddr.Port)),
 		bonding:    make(map[NodeID]*bondproc),
 		bondslots:  make(chan struct{}, maxBondingPingPongs),
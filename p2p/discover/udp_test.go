@@ -36,6 +36,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/immesys/bw2bc/common"
 	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/p2p/enr"
 	"github.com/immesys/bw2bc/rlp"
 )
 
@@ -560,3 +561,70 @@ func (c *dgramPipe) waitPacketOut() []byte {
 	c.queue = c.queue[:len(c.queue)-1]
 	return p
 }
+
+func TestUDP_enrRequest(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// enrRequest is rejected without a bond.
+	test.packetIn(errUnknownNode, enrRequestPacket, &enrRequest{Expiration: futureExp})
+
+	test.table.db.updateNode(NewNode(
+		PubkeyID(&test.remotekey.PublicKey),
+		test.remoteaddr.IP,
+		uint16(test.remoteaddr.Port),
+		99,
+	))
+	test.packetIn(nil, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.waitPacketOut(func(p *enrResponse) {
+		if !bytes.Equal(p.ReplyTok, test.sent[len(test.sent)-1][:macSize]) {
+			t.Errorf("wrong reply token")
+		}
+		if p.Record.String() != test.table.Record().String() {
+			t.Errorf("wrong record: got %v, want %v", p.Record.String(), test.table.Record().String())
+		}
+	})
+}
+
+func TestUDP_requestENR(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// Queue a pending record request.
+	remote := NewNode(PubkeyID(&test.remotekey.PublicKey), test.remoteaddr.IP, uint16(test.remoteaddr.Port), 30303)
+	resultc, errc := make(chan *enr.Record, 1), make(chan error, 1)
+	go func() {
+		record, err := test.table.RequestRecord(remote)
+		if err != nil {
+			errc <- err
+		} else {
+			resultc <- record
+		}
+	}()
+	p, _, hash, err := decodePacket(test.pipe.waitPacketOut())
+	if err != nil {
+		t.Fatalf("sent packet decode error: %v", err)
+	}
+	if _, ok := p.(*enrRequest); !ok {
+		t.Fatalf("sent packet type mismatch: got %T", p)
+	}
+	// Reply with the remote node's record.
+	var record enr.Record
+	record.Set(enr.WithEntry("bw2", []string{"router"}))
+	if err := enr.SignV4(&record, test.remotekey); err != nil {
+		t.Fatal(err)
+	}
+	test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: record})
+
+	select {
+	case result := <-resultc:
+		var caps []string
+		if err := result.Load(enr.WithEntry("bw2", &caps)); err != nil || len(caps) != 1 || caps[0] != "router" {
+			t.Errorf("record mismatch: %v %v", caps, err)
+		}
+	case err := <-errc:
+		t.Errorf("record request error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Error("record request did not return within 5 seconds")
+	}
+}
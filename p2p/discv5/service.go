@@ -0,0 +1,175 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package discv5
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/immesys/bw2bc/common/mclock"
+)
+
+const (
+	serviceFastSearchPeriod = 100 * time.Millisecond // lookup interval until the topic radius converges
+	serviceSlowSearchPeriod = time.Minute            // lookup interval once the radius has converged
+	serviceFastLookups      = 50                     // converged lookups before slowing down
+	serviceFastTimeout      = time.Minute            // maximum time spent on fast lookups after convergence
+	maxServiceNodes         = 200                    // maximum number of found nodes remembered per topic
+)
+
+// ServiceTopic returns the topic under which nodes providing the named service
+// on the given network advertise themselves, e.g. "bzz@3".
+func ServiceTopic(service string, network uint64) Topic {
+	return Topic(fmt.Sprintf("%s@%d", service, network))
+}
+
+// ServiceConfig configures the behaviour of a ServiceDiscovery.
+type ServiceConfig struct {
+	// Advertise registers the local node under the topic.
+	Advertise bool
+
+	// Search looks up other nodes registered under the topic.
+	Search bool
+
+	// Found is invoked for every newly discovered node if Search is set. It is
+	// called from the search goroutine and should not block.
+	Found func(n *Node)
+}
+
+// ServiceDiscovery is a generic service advertisement layer on top of topic
+// registration and search. Services use it to announce themselves under a
+// topic and to find other nodes providing the same service.
+type ServiceDiscovery struct {
+	net    *Network
+	topic  Topic
+	config ServiceConfig
+
+	lock  sync.Mutex
+	nodes map[NodeID]*Node
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewServiceDiscovery creates a service advertiser and finder for topic. It
+// does nothing until started.
+func NewServiceDiscovery(net *Network, topic Topic, config ServiceConfig) *ServiceDiscovery {
+	return &ServiceDiscovery{
+		net:    net,
+		topic:  topic,
+		config: config,
+		nodes:  make(map[NodeID]*Node),
+		quit:   make(chan struct{}),
+	}
+}
+
+// Topic returns the topic the service is advertised and searched under.
+func (s *ServiceDiscovery) Topic() Topic {
+	return s.topic
+}
+
+// Start begins advertising and/or searching the topic in the background.
+func (s *ServiceDiscovery) Start() {
+	if s.config.Advertise {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.net.RegisterTopic(s.topic, s.quit)
+		}()
+	}
+	if s.config.Search {
+		s.wg.Add(1)
+		go s.searchLoop()
+	}
+}
+
+// Stop terminates advertising and searching.
+func (s *ServiceDiscovery) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// Nodes returns the nodes found so far that advertise the topic.
+func (s *ServiceDiscovery) Nodes() []*Node {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	nodes := make([]*Node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// searchLoop runs the topic search, looking up quickly until the topic radius
+// has converged and settling on a slow refresh period afterwards.
+func (s *ServiceDiscovery) searchLoop() {
+	defer s.wg.Done()
+
+	var (
+		setPeriod = make(chan time.Duration, 1)
+		found     = make(chan *Node, 100)
+		lookups   = make(chan bool, 100)
+
+		fast      = true
+		lookupCnt int
+		convTime  mclock.AbsTime
+	)
+	go s.net.SearchTopic(s.topic, setPeriod, found, lookups)
+	setPeriod <- serviceFastSearchPeriod
+
+	for {
+		select {
+		case n := <-found:
+			if s.addNode(n) && s.config.Found != nil {
+				s.config.Found(n)
+			}
+
+		case conv := <-lookups:
+			if !conv || !fast {
+				continue
+			}
+			if lookupCnt == 0 {
+				convTime = mclock.Now()
+			}
+			lookupCnt++
+			if lookupCnt == serviceFastLookups || time.Duration(mclock.Now()-convTime) > serviceFastTimeout {
+				fast = false
+				setPeriod <- serviceSlowSearchPeriod
+			}
+
+		case <-s.quit:
+			close(setPeriod)
+			return
+		}
+	}
+}
+
+// addNode records a found node, reporting whether it was not known before.
+func (s *ServiceDiscovery) addNode(n *Node) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if n.ID == s.net.Self().ID {
+		return false
+	}
+	if _, ok := s.nodes[n.ID]; ok || len(s.nodes) >= maxServiceNodes {
+		return false
+	}
+	s.nodes[n.ID] = n
+	return true
+}
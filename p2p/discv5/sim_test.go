@@ -186,6 +186,62 @@ func TestSimTopicHierarchy(t *testing.T) {
 	sim.shutdown()
 }
 
+// In this test, a few nodes advertise a service topic and another
+// node searches for them. It is small enough to run in real time.
+func TestSimServiceDiscovery(t *testing.T) {
+	sim := newSimulation()
+	defer sim.shutdown()
+	bootnode := sim.launchNode(false)
+
+	var (
+		topic      = ServiceTopic("bzz", 1)
+		advertised = make(map[NodeID]bool)
+	)
+	for i := 0; i < 20; i++ {
+		net := sim.launchNode(false)
+		if err := net.SetFallbackNodes([]*Node{bootnode.Self()}); err != nil {
+			t.Fatal(err)
+		}
+		if i%4 == 0 {
+			service := NewServiceDiscovery(net, topic, ServiceConfig{Advertise: true})
+			service.Start()
+			defer service.Stop()
+			advertised[net.Self().ID] = true
+		}
+	}
+
+	net := sim.launchNode(false)
+	if err := net.SetFallbackNodes([]*Node{bootnode.Self()}); err != nil {
+		t.Fatal(err)
+	}
+	found := make(chan *Node, 100)
+	search := NewServiceDiscovery(net, topic, ServiceConfig{
+		Search: true,
+		Found:  func(n *Node) { found <- n },
+	})
+	search.Start()
+	defer search.Stop()
+
+	timeout := time.After(time.Minute)
+	for seen := make(map[NodeID]bool); len(seen) < len(advertised); {
+		select {
+		case n := <-found:
+			if !advertised[n.ID] {
+				t.Fatalf("found node %x which does not advertise the topic", n.ID[:8])
+			}
+			if seen[n.ID] {
+				t.Fatalf("node %x reported twice", n.ID[:8])
+			}
+			seen[n.ID] = true
+		case <-timeout:
+			t.Fatalf("found %d of %d advertising nodes", len(search.Nodes()), len(advertised))
+		}
+	}
+	if nodes := search.Nodes(); len(nodes) != len(advertised) {
+		t.Errorf("wrong number of known nodes: got %d, want %d", len(nodes), len(advertised))
+	}
+}
+
 func randomResolves(t *testing.T, s *simulation, net *Network) {
 	randtime := func() time.Duration {
 		return time.Duration(rand.Intn(50)+20) * time.Second
@@ -0,0 +1,101 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/dnsdisc"
+)
+
+const (
+	// DNS node lists are re-resolved periodically, and sooner if resolving
+	// them failed.
+	dnsRecheckInterval = 30 * time.Minute
+	dnsRetryInterval   = time.Minute
+
+	// Number of DNS discovered nodes handed to the dialer per lookup.
+	dnsCandidates = 8
+)
+
+// dnsLoop periodically resolves the configured DNS node lists, making their
+// nodes available to the dialer and the discovery table.
+func (srv *Server) dnsLoop() {
+	defer srv.loopWG.Done()
+
+	client := dnsdisc.NewClient(dnsdisc.Config{Resolver: srv.dnsResolver})
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	// Abort any pending lookups when the server shuts down
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-srv.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-timer.C:
+			nodes, err := client.Nodes(ctx, srv.DNSDiscovery)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Warn("Failed to resolve DNS node lists", "err", err)
+				timer.Reset(dnsRetryInterval)
+				continue
+			}
+			log.Debug("Resolved DNS node lists", "nodes", len(nodes))
+			srv.dnsLock.Lock()
+			srv.dnsNodes = nodes
+			srv.dnsLock.Unlock()
+
+			if tab, ok := srv.ntab.(*discover.Table); ok {
+				fallback := append(append([]*discover.Node(nil), srv.BootstrapNodes...), nodes...)
+				if err := tab.SetFallbackNodes(fallback); err != nil {
+					log.Warn("Failed to set DNS discovered fallback nodes", "err", err)
+				}
+			}
+			timer.Reset(dnsRecheckInterval)
+		case <-srv.quit:
+			return
+		}
+	}
+}
+
+// randomDNSNodes returns up to n random nodes found via DNS discovery.
+func (srv *Server) randomDNSNodes(n int) []*discover.Node {
+	srv.dnsLock.Lock()
+	defer srv.dnsLock.Unlock()
+
+	if n > len(srv.dnsNodes) {
+		n = len(srv.dnsNodes)
+	}
+	nodes := make([]*discover.Node, n)
+	for i, j := range rand.Perm(len(srv.dnsNodes))[:n] {
+		nodes[i] = srv.dnsNodes[j]
+	}
+	return nodes
+}
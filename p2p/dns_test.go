@@ -0,0 +1,130 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/dnsdisc"
+)
+
+type dnsTestResolver map[string]string
+
+func (r dnsTestResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txt, ok := r[name]; ok {
+		return []string{txt}, nil
+	}
+	return nil, fmt.Errorf("no such host: %s", name)
+}
+
+// Tests that nodes published in a DNS node list are dialed even when the
+// discovery table is disabled.
+func TestServerDNSDiscovery(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not setup listener: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// Publish the listener in a signed node list.
+	remid := randomID()
+	addr := listener.Addr().(*net.TCPAddr)
+	tree, err := dnsdisc.MakeTree(1, []*discover.Node{discover.NewNode(remid, addr.IP, uint16(addr.Port), uint16(addr.Port))}, nil)
+	if err != nil {
+		t.Fatalf("could not create tree: %v", err)
+	}
+	key, _ := crypto.GenerateKey()
+	url, err := tree.Sign(key, "nodes.example.org")
+	if err != nil {
+		t.Fatalf("could not sign tree: %v", err)
+	}
+	srv := &Server{
+		Config: Config{
+			PrivateKey:   newkey(),
+			MaxPeers:     10,
+			NoDiscovery:  true,
+			DNSDiscovery: []string{url},
+		},
+		dnsResolver:  dnsTestResolver(tree.ToTXT("nodes.example.org")),
+		newTransport: func(fd net.Conn) transport { return newTestTransport(remid, fd) },
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(2 * lookupInterval):
+		t.Error("server did not dial DNS discovered node")
+	}
+}
+
+// blockingResolver is a resolver whose lookups hang until they are cancelled.
+type blockingResolver chan struct{}
+
+func (r blockingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// Tests that stopping the server aborts pending DNS lookups instead of waiting
+// for them to time out.
+func TestServerDNSDiscoveryStop(t *testing.T) {
+	resolver := make(blockingResolver, 1)
+	srv := &Server{
+		Config: Config{
+			PrivateKey:   newkey(),
+			MaxPeers:     10,
+			NoDiscovery:  true,
+			NoDial:       true,
+			DNSDiscovery: []string{"enodetree://" + discover.PubkeyID(&newkey().PublicKey).String() + "@nodes.example.org"},
+		},
+		dnsResolver: resolver,
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	select {
+	case <-resolver:
+	case <-time.After(time.Second):
+		t.Fatal("server did not resolve DNS node list")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		srv.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("server stop blocked on pending DNS lookup")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package dnsdisc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p/discover"
+)
+
+const (
+	defaultTimeout    = 5 * time.Second
+	defaultCacheLimit = 1000
+
+	// maxLinkDepth is the maximum number of links followed from a tree.
+	maxLinkDepth = 4
+)
+
+// Resolver is a DNS resolver that can query TXT records.
+type Resolver interface {
+	LookupTXT(ctx context.Context, domain string) ([]string, error)
+}
+
+// Config holds Client options.
+type Config struct {
+	Timeout    time.Duration // timeout of a single DNS query, zero defaults to preset values
+	CacheLimit int           // maximum number of cached entries, zero defaults to preset values
+	Resolver   Resolver      // DNS resolver to use, nil defaults to the system resolver
+}
+
+// Client resolves and verifies node lists published in DNS.
+type Client struct {
+	cfg Config
+
+	lock  sync.Mutex
+	cache map[string]entry // verified entries, keyed by fully qualified name
+}
+
+// NewClient creates a DNS discovery client.
+func NewClient(cfg Config) *Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.CacheLimit == 0 {
+		cfg.CacheLimit = defaultCacheLimit
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = net.DefaultResolver
+	}
+	return &Client{cfg: cfg, cache: make(map[string]entry)}
+}
+
+// SyncTree downloads and verifies the complete tree at the given URL. Pending
+// lookups are aborted when the context is cancelled.
+func (c *Client) SyncTree(ctx context.Context, url string) (*Tree, error) {
+	link, err := parseLink(url)
+	if err != nil {
+		return nil, err
+	}
+	root, err := c.resolveRoot(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{root: root, entries: make(map[string]entry)}
+	if err := c.syncSubtree(ctx, t, link.domain, root.eroot, false); err != nil {
+		return nil, err
+	}
+	if err := c.syncSubtree(ctx, t, link.domain, root.lroot, true); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Nodes resolves the trees at the given URLs and returns all nodes contained in
+// them or in the trees they link to. Trees that fail to resolve are skipped,
+// the last error is returned if no nodes were found at all. Resolving stops as
+// soon as the context is cancelled.
+func (c *Client) Nodes(ctx context.Context, urls []string) ([]*discover.Node, error) {
+	var (
+		nodes   []*discover.Node
+		seen    = make(map[discover.NodeID]bool)
+		visited = make(map[string]bool)
+		lastErr error
+	)
+	for depth := 0; len(urls) > 0 && depth <= maxLinkDepth; depth++ {
+		var next []string
+		for _, url := range urls {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if visited[url] {
+				continue
+			}
+			visited[url] = true
+
+			t, err := c.SyncTree(ctx, url)
+			if err != nil {
+				log.Debug("Failed to sync DNS node tree", "url", url, "err", err)
+				lastErr = err
+				continue
+			}
+			for _, n := range t.Nodes() {
+				if !seen[n.ID] {
+					seen[n.ID] = true
+					nodes = append(nodes, n)
+				}
+			}
+			next = append(next, t.Links()...)
+		}
+		urls = next
+	}
+	if len(nodes) == 0 {
+		return nil, lastErr
+	}
+	return nodes, nil
+}
+
+// resolveRoot retrieves the root entry of a tree and verifies its signature.
+func (c *Client) resolveRoot(ctx context.Context, link *linkEntry) (*rootEntry, error) {
+	txts, err := c.lookup(ctx, link.domain)
+	if err != nil {
+		return nil, err
+	}
+	for _, txt := range txts {
+		if !strings.HasPrefix(txt, rootPrefix) {
+			continue
+		}
+		root, err := parseRoot(txt)
+		if err != nil {
+			return nil, err
+		}
+		if !root.verifySignature(link.pubkey) {
+			return nil, errInvalidSig
+		}
+		return root, nil
+	}
+	return nil, errNoRoot
+}
+
+// syncSubtree retrieves all entries below the given hash into the tree.
+func (c *Client) syncSubtree(ctx context.Context, t *Tree, domain, hash string, links bool) error {
+	if _, ok := t.entries[hash]; ok {
+		return nil
+	}
+	e, err := c.resolveEntry(ctx, domain, hash)
+	if err != nil {
+		return err
+	}
+	switch e := e.(type) {
+	case *branchEntry:
+		t.entries[hash] = e
+		for _, child := range e.children {
+			if err := c.syncSubtree(ctx, t, domain, child, links); err != nil {
+				return err
+			}
+		}
+	case *nodeEntry:
+		if links {
+			return errNodeInLinks
+		}
+		t.entries[hash] = e
+	case *linkEntry:
+		if !links {
+			return errLinkInNodes
+		}
+		t.entries[hash] = e
+	}
+	return nil
+}
+
+// resolveEntry retrieves and verifies a single entry, serving it from the cache
+// if possible.
+func (c *Client) resolveEntry(ctx context.Context, domain, hash string) (entry, error) {
+	name := hash + "." + domain
+
+	c.lock.Lock()
+	e, ok := c.cache[name]
+	c.lock.Unlock()
+	if ok {
+		return e, nil
+	}
+	txts, err := c.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, txt := range txts {
+		h := crypto.Keccak256([]byte(txt))
+		if b32format.EncodeToString(h[:16]) != hash {
+			continue
+		}
+		if e, err = parseEntry(txt); err != nil {
+			return nil, fmt.Errorf("invalid entry at %s: %v", name, err)
+		}
+		c.lock.Lock()
+		if len(c.cache) >= c.cfg.CacheLimit {
+			c.cache = make(map[string]entry)
+		}
+		c.cache[name] = e
+		c.lock.Unlock()
+		return e, nil
+	}
+	return nil, fmt.Errorf("%v at %s", errHashMismatch, name)
+}
+
+// lookup performs a single TXT query.
+func (c *Client) lookup(ctx context.Context, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	return c.cfg.Resolver.LookupTXT(ctx, name)
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package dnsdisc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/p2p/discover"
+)
+
+// mapResolver is a stub resolver serving records from a map.
+type mapResolver map[string]string
+
+func (mr mapResolver) add(records map[string]string) {
+	for name, txt := range records {
+		mr[name] = txt
+	}
+}
+
+func (mr mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txt, ok := mr[name]; ok {
+		return []string{txt}, nil
+	}
+	return nil, fmt.Errorf("no such host: %s", name)
+}
+
+func testNodes(t *testing.T, n int) []*discover.Node {
+	nodes := make([]*discover.Node, n)
+	for i := range nodes {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = discover.NewNode(discover.PubkeyID(&key.PublicKey), net.IP{10, 0, byte(i >> 8), byte(i)}, 30303, 30303)
+	}
+	return nodes
+}
+
+func signedTree(t *testing.T, key *ecdsa.PrivateKey, domain string, seq uint, nodes []*discover.Node, links []string) (*Tree, string) {
+	tree, err := MakeTree(seq, nodes, links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := tree.Sign(key, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, url
+}
+
+func TestSyncTree(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	nodes := testNodes(t, 40)
+	tree, url := signedTree(t, key, "nodes.example.org", 7, nodes, nil)
+
+	resolver := make(mapResolver)
+	resolver.add(tree.ToTXT("nodes.example.org"))
+
+	synced, err := NewClient(Config{Resolver: resolver}).SyncTree(context.Background(), url)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if synced.Seq() != 7 {
+		t.Errorf("sequence mismatch: got %d, want 7", synced.Seq())
+	}
+	if synced.Signature() != tree.Signature() {
+		t.Errorf("signature mismatch")
+	}
+	if !reflect.DeepEqual(synced.Nodes(), tree.Nodes()) {
+		t.Errorf("node mismatch: got %d nodes, want %d", len(synced.Nodes()), len(nodes))
+	}
+	if !reflect.DeepEqual(synced.ToTXT("nodes.example.org"), tree.ToTXT("nodes.example.org")) {
+		t.Errorf("record mismatch")
+	}
+	for name, txt := range tree.ToTXT("nodes.example.org") {
+		if len(txt) > 255 {
+			t.Errorf("record %s exceeds a single TXT string: %d bytes", name, len(txt))
+		}
+	}
+}
+
+func TestClientNodesCancel(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	tree, url := signedTree(t, key, "nodes.example.org", 1, testNodes(t, 5), nil)
+
+	resolver := make(mapResolver)
+	resolver.add(tree.ToTXT("nodes.example.org"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewClient(Config{Resolver: resolver}).Nodes(ctx, []string{url}); err != context.Canceled {
+		t.Errorf("error mismatch: have %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestSyncTreeBadSignature(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	tree, _ := signedTree(t, key, "nodes.example.org", 1, testNodes(t, 3), nil)
+	_, url := signedTree(t, other, "nodes.example.org", 1, nil, nil)
+
+	resolver := make(mapResolver)
+	resolver.add(tree.ToTXT("nodes.example.org"))
+
+	if _, err := NewClient(Config{Resolver: resolver}).SyncTree(context.Background(), url); err != errInvalidSig {
+		t.Errorf("wrong error for tree signed by other key: %v", err)
+	}
+}
+
+func TestSyncTreeTampered(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	nodes := testNodes(t, 3)
+	tree, url := signedTree(t, key, "nodes.example.org", 1, nodes, nil)
+
+	resolver := make(mapResolver)
+	resolver.add(tree.ToTXT("nodes.example.org"))
+	for name, txt := range resolver {
+		if txt == nodes[0].String() {
+			replaced := *nodes[0]
+			replaced.IP = net.IP{192, 168, 0, 1}
+			resolver[name] = replaced.String()
+		}
+	}
+	if _, err := NewClient(Config{Resolver: resolver}).SyncTree(context.Background(), url); err == nil {
+		t.Error("expected error for tampered entry")
+	}
+}
+
+func TestClientNodesLinks(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	nodes := testNodes(t, 20)
+
+	leaf, leafURL := signedTree(t, key, "leaf.example.org", 1, nodes[10:], nil)
+	top, topURL := signedTree(t, key, "top.example.org", 1, nodes[:12], []string{leafURL, "enodetree://" + discover.PubkeyID(&key.PublicKey).String() + "@missing.example.org"})
+
+	resolver := make(mapResolver)
+	resolver.add(leaf.ToTXT("leaf.example.org"))
+	resolver.add(top.ToTXT("top.example.org"))
+
+	if links := top.Links(); len(links) != 2 {
+		t.Fatalf("wrong number of links: %v", links)
+	}
+	found, err := NewClient(Config{Resolver: resolver}).Nodes(context.Background(), []string{topURL})
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if len(found) != len(nodes) {
+		t.Errorf("wrong number of nodes: got %d, want %d", len(found), len(nodes))
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{"enodetree-branch:", true},
+		{"enodetree-branch:AAAAAAAAAAAAAAAAAAAAAAAAAA,BBBBBBBBBBBBBBBBBBBBBBBBBB", true},
+		{"enodetree-branch:AAAA", false},
+		{"enode://a979fb575495b8d6db44f750317d0f4622bf4c2aa3365d6af7c284339968eef29b69ad0dce72a4d8db5ebb4968de0e3bec910127f134779fbcb0cb6d3331163c@52.16.188.185:30303", true},
+		{"enode://a979fb575495b8d6db44f750317d0f4622bf4c2aa3365d6af7c284339968eef29b69ad0dce72a4d8db5ebb4968de0e3bec910127f134779fbcb0cb6d3331163c", false},
+		{"enodetree://a979fb575495b8d6db44f750317d0f4622bf4c2aa3365d6af7c284339968eef29b69ad0dce72a4d8db5ebb4968de0e3bec910127f134779fbcb0cb6d3331163c@nodes.example.org", true},
+		{"enodetree://nodes.example.org", false},
+		{"v=spf1 -all", false},
+	}
+	for _, test := range tests {
+		e, err := parseEntry(test.input)
+		if test.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", test.input, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%q: expected error", test.input)
+		}
+		if err == nil && e.String() != test.input {
+			t.Errorf("%q: encoding mismatch: got %q", test.input, e.String())
+		}
+	}
+}
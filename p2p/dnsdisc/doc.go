@@ -0,0 +1,36 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+// Package dnsdisc implements node discovery via DNS.
+//
+// A node list is published as a Merkle tree of TXT records below a domain. The
+// root record at the domain itself references the roots of two subtrees, one
+// holding the enode URLs of the listed nodes and one holding links to other
+// trees, and is signed by the publisher's key:
+//
+//    enodetree-root:v1 e=<node-root> l=<link-root> seq=<sequence> sig=<signature>
+//
+// Every other entry is stored at <hash>.<domain>, where hash is the base32
+// encoding of the first 16 bytes of the entry's Keccak256 hash. Branch entries
+// list the hashes of their children, leaf entries are enode URLs or links to
+// other trees:
+//
+//    enodetree-branch:<hash>,<hash>,...
+//    enode://<hex node id>@10.3.58.6:30303
+//    enodetree://<hex public key>@nodes.example.org
+//
+// Trees are identified by a URL of the link form, which names both the domain
+// and the key the root record must be signed with.
+package dnsdisc
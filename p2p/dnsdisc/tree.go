@@ -0,0 +1,368 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package dnsdisc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/p2p/discover"
+)
+
+const (
+	rootPrefix   = "enodetree-root:v1"
+	branchPrefix = "enodetree-branch:"
+	linkPrefix   = "enodetree://"
+	nodePrefix   = "enode://"
+
+	// maxChildren is the maximum number of hashes in a branch entry, chosen so
+	// that branches fit into a single 255 byte TXT record string: the prefix
+	// and eight comma separated hashes take up 17+8*26+7 = 232 bytes.
+	maxChildren = 8
+
+	// hashLength is the length of an encoded entry hash.
+	hashLength = 26
+)
+
+var (
+	errUnknownEntry = errors.New("unknown entry type")
+	errNoPubkey     = errors.New("missing public key")
+	errBadPubkey    = errors.New("invalid public key")
+	errInvalidSig   = errors.New("invalid root signature")
+	errInvalidChild = errors.New("invalid child hash")
+	errHashMismatch = errors.New("entry hash mismatch")
+	errNoRoot       = errors.New("no valid root found")
+	errNodeInLinks  = errors.New("node entry in link tree")
+	errLinkInNodes  = errors.New("link entry in node tree")
+)
+
+var b32format = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// entry is a single node of a tree.
+type entry interface {
+	fmt.Stringer
+}
+
+type (
+	rootEntry struct {
+		eroot string
+		lroot string
+		seq   uint
+		sig   []byte
+	}
+	branchEntry struct {
+		children []string
+	}
+	nodeEntry struct {
+		node *discover.Node
+	}
+	linkEntry struct {
+		domain string
+		pubkey *ecdsa.PublicKey
+	}
+)
+
+func (e *rootEntry) String() string {
+	return e.sigless() + " sig=" + base64.RawURLEncoding.EncodeToString(e.sig)
+}
+
+func (e *rootEntry) sigless() string {
+	return fmt.Sprintf(rootPrefix+" e=%s l=%s seq=%d", e.eroot, e.lroot, e.seq)
+}
+
+// sigHash returns the hash signed by the root signature.
+func (e *rootEntry) sigHash() []byte {
+	return crypto.Keccak256([]byte(e.sigless()))
+}
+
+// verifySignature checks whether the root is signed by the given key.
+func (e *rootEntry) verifySignature(pubkey *ecdsa.PublicKey) bool {
+	if len(e.sig) != 65 {
+		return false
+	}
+	signer, err := crypto.SigToPub(e.sigHash(), e.sig)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(crypto.FromECDSAPub(signer), crypto.FromECDSAPub(pubkey))
+}
+
+func (e *branchEntry) String() string {
+	return branchPrefix + strings.Join(e.children, ",")
+}
+
+func (e *nodeEntry) String() string {
+	return e.node.String()
+}
+
+func (e *linkEntry) String() string {
+	return linkPrefix + discover.PubkeyID(e.pubkey).String() + "@" + e.domain
+}
+
+// subdomain returns the name an entry is stored under, relative to the domain
+// of its tree.
+func subdomain(e entry) string {
+	h := crypto.Keccak256([]byte(e.String()))
+	return b32format.EncodeToString(h[:16])
+}
+
+// parseEntry parses the content of a non-root TXT record.
+func parseEntry(e string) (entry, error) {
+	switch {
+	case strings.HasPrefix(e, branchPrefix):
+		return parseBranch(e[len(branchPrefix):])
+	case strings.HasPrefix(e, linkPrefix):
+		return parseLink(e)
+	case strings.HasPrefix(e, nodePrefix):
+		node, err := discover.ParseNode(e)
+		if err != nil {
+			return nil, err
+		}
+		if node.Incomplete() {
+			return nil, fmt.Errorf("incomplete node %q", e)
+		}
+		return &nodeEntry{node}, nil
+	default:
+		return nil, errUnknownEntry
+	}
+}
+
+// parseRoot parses the content of a root TXT record.
+func parseRoot(e string) (*rootEntry, error) {
+	var (
+		root   rootEntry
+		sig    string
+		fields = strings.Fields(e)
+	)
+	if len(fields) != 5 || fields[0] != rootPrefix {
+		return nil, fmt.Errorf("invalid root %q", e)
+	}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid root field %q", field)
+		}
+		switch kv[0] {
+		case "e":
+			root.eroot = kv[1]
+		case "l":
+			root.lroot = kv[1]
+		case "seq":
+			seq, err := strconv.ParseUint(kv[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid root sequence %q", kv[1])
+			}
+			root.seq = uint(seq)
+		case "sig":
+			sig = kv[1]
+		default:
+			return nil, fmt.Errorf("invalid root field %q", field)
+		}
+	}
+	if !isValidHash(root.eroot) || !isValidHash(root.lroot) {
+		return nil, errInvalidChild
+	}
+	var err error
+	if root.sig, err = base64.RawURLEncoding.DecodeString(sig); err != nil || len(root.sig) != 65 {
+		return nil, errInvalidSig
+	}
+	return &root, nil
+}
+
+func parseBranch(e string) (entry, error) {
+	if e == "" {
+		return &branchEntry{}, nil
+	}
+	hashes := strings.Split(e, ",")
+	if len(hashes) > maxChildren {
+		return nil, fmt.Errorf("too many branch children (%d)", len(hashes))
+	}
+	for _, h := range hashes {
+		if !isValidHash(h) {
+			return nil, errInvalidChild
+		}
+	}
+	return &branchEntry{hashes}, nil
+}
+
+// parseLink parses a tree URL.
+func parseLink(e string) (*linkEntry, error) {
+	if !strings.HasPrefix(e, linkPrefix) {
+		return nil, fmt.Errorf("invalid tree URL %q, want %q scheme", e, linkPrefix)
+	}
+	u, err := url.Parse(e)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil {
+		return nil, errNoPubkey
+	}
+	id, err := discover.HexID(u.User.String())
+	if err != nil {
+		return nil, errBadPubkey
+	}
+	pubkey, err := id.Pubkey()
+	if err != nil {
+		return nil, errBadPubkey
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing domain in tree URL %q", e)
+	}
+	return &linkEntry{domain: u.Host, pubkey: pubkey}, nil
+}
+
+func isValidHash(s string) bool {
+	if len(s) != hashLength {
+		return false
+	}
+	h, err := b32format.DecodeString(s)
+	return err == nil && len(h) == 16
+}
+
+// Tree is a signed node list that can be published in DNS.
+type Tree struct {
+	root    *rootEntry
+	entries map[string]entry
+}
+
+// MakeTree creates a tree containing the given nodes and links to other trees.
+func MakeTree(seq uint, nodes []*discover.Node, links []string) (*Tree, error) {
+	nodes = append([]*discover.Node(nil), nodes...)
+	sort.Sort(nodesByID(nodes))
+
+	var nodeEntries []entry
+	for _, n := range nodes {
+		if n.Incomplete() {
+			return nil, fmt.Errorf("incomplete node %v", n)
+		}
+		nodeEntries = append(nodeEntries, &nodeEntry{n})
+	}
+	links = append([]string(nil), links...)
+	sort.Strings(links)
+
+	var linkEntries []entry
+	for _, l := range links {
+		le, err := parseLink(l)
+		if err != nil {
+			return nil, err
+		}
+		linkEntries = append(linkEntries, le)
+	}
+	t := &Tree{entries: make(map[string]entry)}
+	eroot := t.build(nodeEntries)
+	lroot := t.build(linkEntries)
+	t.root = &rootEntry{eroot: subdomain(eroot), lroot: subdomain(lroot), seq: seq}
+	return t, nil
+}
+
+// build adds the given entries to the tree, grouped below branch entries, and
+// returns the root of the resulting subtree.
+func (t *Tree) build(entries []entry) entry {
+	if len(entries) == 1 {
+		t.entries[subdomain(entries[0])] = entries[0]
+		return entries[0]
+	}
+	if len(entries) <= maxChildren {
+		branch := &branchEntry{children: make([]string, len(entries))}
+		for i, e := range entries {
+			branch.children[i] = subdomain(e)
+			t.entries[branch.children[i]] = e
+		}
+		t.entries[subdomain(branch)] = branch
+		return branch
+	}
+	var subtrees []entry
+	for len(entries) > 0 {
+		n := maxChildren
+		if len(entries) < n {
+			n = len(entries)
+		}
+		subtrees = append(subtrees, t.build(entries[:n]))
+		entries = entries[n:]
+	}
+	return t.build(subtrees)
+}
+
+// Sign signs the tree with the given key and returns its URL for the domain it
+// will be published at.
+func (t *Tree) Sign(key *ecdsa.PrivateKey, domain string) (string, error) {
+	sig, err := crypto.Sign(t.root.sigHash(), key)
+	if err != nil {
+		return "", err
+	}
+	t.root.sig = sig
+	link := &linkEntry{domain: domain, pubkey: &key.PublicKey}
+	return link.String(), nil
+}
+
+// Seq returns the sequence number of the tree.
+func (t *Tree) Seq() uint {
+	return t.root.seq
+}
+
+// Signature returns the signature of the tree, encoded as it is published.
+func (t *Tree) Signature() string {
+	return base64.RawURLEncoding.EncodeToString(t.root.sig)
+}
+
+// Nodes returns all nodes contained in the tree.
+func (t *Tree) Nodes() []*discover.Node {
+	var nodes []*discover.Node
+	for _, e := range t.entries {
+		if ne, ok := e.(*nodeEntry); ok {
+			nodes = append(nodes, ne.node)
+		}
+	}
+	sort.Sort(nodesByID(nodes))
+	return nodes
+}
+
+// Links returns the URLs of all trees linked from the tree.
+func (t *Tree) Links() []string {
+	var links []string
+	for _, e := range t.entries {
+		if le, ok := e.(*linkEntry); ok {
+			links = append(links, le.String())
+		}
+	}
+	sort.Strings(links)
+	return links
+}
+
+// ToTXT returns all TXT records of the tree when published at the given
+// domain, keyed by their fully qualified names.
+func (t *Tree) ToTXT(domain string) map[string]string {
+	records := map[string]string{domain: t.root.String()}
+	for h, e := range t.entries {
+		records[h+"."+domain] = e.String()
+	}
+	return records
+}
+
+type nodesByID []*discover.Node
+
+func (s nodesByID) Len() int           { return len(s) }
+func (s nodesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s nodesByID) Less(i, j int) bool { return bytes.Compare(s[i].ID[:], s[j].ID[:]) < 0 }
@@ -0,0 +1,283 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+// Package enr implements signed node records.
+//
+// A node record holds arbitrary information about a node on the peer-to-peer
+// network as a sorted list of key/value pairs, along with a sequence number that
+// is incremented on every change and a signature made by the node's key. Node
+// information can be retrieved from a record without connecting to the node,
+// and a record can be relayed by third parties since its signature proves
+// authenticity.
+//
+// Records are transmitted in RLP encoding, or in text form as "enr:" followed by
+// the URL-safe base64 encoding of the RLP data.
+package enr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/immesys/bw2bc/rlp"
+)
+
+// SizeLimit is the maximum encoded size of a node record in bytes.
+const SizeLimit = 300
+
+// textPrefix is the prefix of the text encoding of node records.
+const textPrefix = "enr:"
+
+var (
+	errNoID           = errors.New("unknown or unspecified identity scheme")
+	errInvalidSig     = errors.New("invalid signature")
+	errNotSorted      = errors.New("record key/value pairs are not sorted by key")
+	errDuplicateKey   = errors.New("record contains duplicate key")
+	errIncompletePair = errors.New("record contains incomplete k/v pair")
+	errTooBig         = fmt.Errorf("record bigger than %d bytes", SizeLimit)
+	errEncodeUnsigned = errors.New("can't encode unsigned record")
+	errNotFound       = errors.New("no such key in record")
+	errNoPrefix       = fmt.Errorf("record text does not start with %q", textPrefix)
+)
+
+// Record represents a node record. The zero value is an empty record.
+type Record struct {
+	seq       uint64 // sequence number
+	signature []byte // the signature
+	raw       []byte // RLP encoded record
+	pairs     []pair // sorted list of all key/value pairs
+}
+
+// pair is a key/value pair in a record.
+type pair struct {
+	k string
+	v rlp.RawValue
+}
+
+// Signed reports whether the record has a valid signature.
+func (r *Record) Signed() bool {
+	return r.signature != nil
+}
+
+// Seq returns the sequence number.
+func (r *Record) Seq() uint64 {
+	return r.seq
+}
+
+// SetSeq updates the record sequence number. This invalidates any signature on
+// the record. Calling SetSeq is usually not required because Set increments
+// the sequence number of signed records.
+func (r *Record) SetSeq(s uint64) {
+	r.signature = nil
+	r.raw = nil
+	r.seq = s
+}
+
+// Signature returns the signature of the record.
+func (r *Record) Signature() []byte {
+	return r.signature
+}
+
+// Keys returns the keys of all pairs in the record, in sorted order.
+func (r *Record) Keys() []string {
+	keys := make([]string, len(r.pairs))
+	for i, p := range r.pairs {
+		keys[i] = p.k
+	}
+	return keys
+}
+
+// Load retrieves the value of a key/value pair. The given Entry must be a
+// pointer and will be set to the value of the entry in the record.
+//
+// Errors returned by Load are wrapped in KeyError. You can distinguish decoding
+// errors from missing keys using the IsNotFound function.
+func (r *Record) Load(e Entry) error {
+	i := sort.Search(len(r.pairs), func(i int) bool { return r.pairs[i].k >= e.ENRKey() })
+	if i < len(r.pairs) && r.pairs[i].k == e.ENRKey() {
+		if err := rlp.DecodeBytes(r.pairs[i].v, e); err != nil {
+			return &KeyError{Key: e.ENRKey(), Err: err}
+		}
+		return nil
+	}
+	return &KeyError{Key: e.ENRKey(), Err: errNotFound}
+}
+
+// Set adds or updates the given entry in the record. It panics if the value
+// can't be encoded. If the record is signed, Set increments the sequence
+// number and invalidates the signature.
+func (r *Record) Set(e Entry) {
+	blob, err := rlp.EncodeToBytes(e)
+	if err != nil {
+		panic(fmt.Errorf("enr: can't encode %s: %v", e.ENRKey(), err))
+	}
+	r.invalidate()
+
+	pairs := make([]pair, len(r.pairs))
+	copy(pairs, r.pairs)
+	i := sort.Search(len(pairs), func(i int) bool { return pairs[i].k >= e.ENRKey() })
+	switch {
+	case i < len(pairs) && pairs[i].k == e.ENRKey():
+		// element is present at r.pairs[i]
+		pairs[i].v = blob
+	case i < len(r.pairs):
+		// insert pair before i-th elem
+		el := pair{e.ENRKey(), blob}
+		pairs = append(pairs, pair{})
+		copy(pairs[i+1:], pairs[i:])
+		pairs[i] = el
+	default:
+		// element should be placed at the end of r.pairs
+		pairs = append(pairs, pair{e.ENRKey(), blob})
+	}
+	r.pairs = pairs
+}
+
+func (r *Record) invalidate() {
+	if r.signature != nil {
+		r.seq++
+	}
+	r.signature = nil
+	r.raw = nil
+}
+
+// EncodeRLP implements rlp.Encoder. Encoding fails if the record is unsigned.
+func (r Record) EncodeRLP(w io.Writer) error {
+	if !r.Signed() {
+		return errEncodeUnsigned
+	}
+	_, err := w.Write(r.raw)
+	return err
+}
+
+// DecodeRLP implements rlp.Decoder. Decoding verifies the signature.
+func (r *Record) DecodeRLP(s *rlp.Stream) error {
+	raw, err := s.Raw()
+	if err != nil {
+		return err
+	}
+	if len(raw) > SizeLimit {
+		return errTooBig
+	}
+
+	// Decode the RLP container.
+	dec := Record{raw: raw}
+	s = rlp.NewStream(bytes.NewReader(raw), 0)
+	if _, err := s.List(); err != nil {
+		return err
+	}
+	if err = s.Decode(&dec.signature); err != nil {
+		return err
+	}
+	if err = s.Decode(&dec.seq); err != nil {
+		return err
+	}
+	// The rest of the record contains sorted k/v pairs.
+	var prevkey string
+	for i := 0; ; i++ {
+		var kv pair
+		if err := s.Decode(&kv.k); err != nil {
+			if err == rlp.EOL {
+				break
+			}
+			return err
+		}
+		if err := s.Decode(&kv.v); err != nil {
+			if err == rlp.EOL {
+				return errIncompletePair
+			}
+			return err
+		}
+		if i > 0 {
+			if kv.k == prevkey {
+				return errDuplicateKey
+			}
+			if kv.k < prevkey {
+				return errNotSorted
+			}
+		}
+		dec.pairs = append(dec.pairs, kv)
+		prevkey = kv.k
+	}
+	if err := s.ListEnd(); err != nil {
+		return err
+	}
+
+	// Verify signature.
+	if err = dec.verifySignature(); err != nil {
+		return err
+	}
+	*r = dec
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, producing the "enr:" text
+// encoding of the record.
+func (r Record) MarshalText() ([]byte, error) {
+	if !r.Signed() {
+		return nil, errEncodeUnsigned
+	}
+	return []byte(textPrefix + base64.RawURLEncoding.EncodeToString(r.raw)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing the "enr:" text
+// encoding of a record.
+func (r *Record) UnmarshalText(text []byte) error {
+	if !strings.HasPrefix(string(text), textPrefix) {
+		return errNoPrefix
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(string(text[len(textPrefix):]))
+	if err != nil {
+		return err
+	}
+	return rlp.DecodeBytes(raw, r)
+}
+
+// String returns the text encoding of the record.
+func (r *Record) String() string {
+	text, err := r.MarshalText()
+	if err != nil {
+		return "<unsigned record>"
+	}
+	return string(text)
+}
+
+// appendPairs appends the sequence number and all pairs of the record to list.
+func (r *Record) appendPairs(list []interface{}) []interface{} {
+	list = append(list, r.seq)
+	for _, p := range r.pairs {
+		list = append(list, p.k, p.v)
+	}
+	return list
+}
+
+// setSig assigns the signature and the encoding of the signed record.
+func (r *Record) setSig(sig []byte) error {
+	list := make([]interface{}, 1, 2*len(r.pairs)+1)
+	list[0] = sig
+	raw, err := rlp.EncodeToBytes(r.appendPairs(list))
+	if err != nil {
+		return err
+	}
+	if len(raw) > SizeLimit {
+		return errTooBig
+	}
+	r.signature, r.raw = sig, raw
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package enr
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/rlp"
+)
+
+var testKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+
+func signTest(t *testing.T, r *Record, key *ecdsa.PrivateKey) {
+	if err := SignV4(r, key); err != nil {
+		t.Fatalf("signing failed: %v", err)
+	}
+}
+
+// Tests that a signed record survives an RLP roundtrip with all its entries.
+func TestRecordRoundtrip(t *testing.T) {
+	var r Record
+	r.Set(IP(net.IP{127, 0, 0, 1}))
+	r.Set(UDP(30303))
+	r.Set(TCP(30304))
+	r.Set(WithEntry("bw2", []string{"router", "swarm"}))
+	signTest(t, &r, testKey)
+
+	blob, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	var dec Record
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	var (
+		ip   IP
+		udp  UDP
+		tcp  TCP
+		caps []string
+	)
+	for _, e := range []Entry{&ip, &udp, &tcp, WithEntry("bw2", &caps)} {
+		if err := dec.Load(e); err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+	}
+	if !net.IP(ip).Equal(net.IP{127, 0, 0, 1}) || udp != 30303 || tcp != 30304 {
+		t.Errorf("endpoint mismatch: ip %v udp %d tcp %d", net.IP(ip), udp, tcp)
+	}
+	if len(caps) != 2 || caps[0] != "router" || caps[1] != "swarm" {
+		t.Errorf("capability mismatch: %v", caps)
+	}
+	pub, err := dec.Pubkey()
+	if err != nil {
+		t.Fatalf("no public key: %v", err)
+	}
+	if !bytes.Equal(crypto.FromECDSAPub(pub), crypto.FromECDSAPub(&testKey.PublicKey)) {
+		t.Errorf("public key mismatch")
+	}
+	if err := dec.Load(WithEntry("missing", new(uint))); !IsNotFound(err) {
+		t.Errorf("wrong error for missing key: %v", err)
+	}
+}
+
+// Tests that modifying a signed record invalidates the signature and bumps the
+// sequence number.
+func TestRecordSeq(t *testing.T) {
+	var r Record
+	signTest(t, &r, testKey)
+	if r.Seq() != 0 {
+		t.Fatalf("wrong initial sequence number %d", r.Seq())
+	}
+	r.Set(TCP(1))
+	if r.Signed() {
+		t.Fatal("record still signed after modification")
+	}
+	if _, err := rlp.EncodeToBytes(r); err != errEncodeUnsigned {
+		t.Errorf("wrong error encoding unsigned record: %v", err)
+	}
+	signTest(t, &r, testKey)
+	if r.Seq() != 1 {
+		t.Errorf("wrong sequence number after update: %d", r.Seq())
+	}
+}
+
+// Tests that records with invalid signatures are rejected.
+func TestRecordBadSignature(t *testing.T) {
+	var r Record
+	r.Set(UDP(30303))
+	signTest(t, &r, testKey)
+
+	blob, _ := rlp.EncodeToBytes(r)
+	blob[len(blob)-1]++ // tamper with the UDP port
+	if err := rlp.DecodeBytes(blob, new(Record)); err != errInvalidSig {
+		t.Errorf("wrong error for tampered record: %v", err)
+	}
+}
+
+// Tests that records exceeding the size limit can't be signed.
+func TestRecordTooBig(t *testing.T) {
+	var r Record
+	r.Set(WithEntry("big", make([]byte, SizeLimit)))
+	if err := SignV4(&r, testKey); err != errTooBig {
+		t.Errorf("wrong error for oversized record: %v", err)
+	}
+}
+
+// Tests the text encoding, including its use in JSON.
+func TestRecordText(t *testing.T) {
+	var r Record
+	r.Set(IP(net.ParseIP("2001:db8::1")))
+	signTest(t, &r, testKey)
+
+	text := r.String()
+	if text[:4] != "enr:" {
+		t.Fatalf("wrong text prefix: %s", text)
+	}
+	var dec Record
+	if err := dec.UnmarshalText([]byte(text)); err != nil {
+		t.Fatalf("text decoding failed: %v", err)
+	}
+	if dec.String() != text {
+		t.Errorf("text roundtrip mismatch")
+	}
+	blob, err := json.Marshal(struct{ Record Record }{r})
+	if err != nil {
+		t.Fatalf("JSON encoding failed: %v", err)
+	}
+	if want := `{"Record":"` + text + `"}`; string(blob) != want {
+		t.Errorf("JSON mismatch: got %s, want %s", blob, want)
+	}
+	if err := dec.UnmarshalText([]byte("enode://abc")); err != errNoPrefix {
+		t.Errorf("wrong error for bad prefix: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package enr
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/rlp"
+)
+
+// SignV4 signs a record using the v4 scheme, which uses a secp256k1 signature
+// over the Keccak256 hash of the record content. The "id" and "secp256k1" keys
+// are set as a side effect.
+func SignV4(r *Record, key *ecdsa.PrivateKey) error {
+	// Copy r to avoid modifying it if signing fails.
+	cpy := *r
+	cpy.Set(ID("v4"))
+	cpy.Set(Secp256k1(key.PublicKey))
+
+	h := crypto.Keccak256(rlpContent(&cpy))
+	sig, err := crypto.Sign(h, key)
+	if err != nil {
+		return err
+	}
+	sig = sig[:len(sig)-1] // remove v
+	if err = cpy.setSig(sig); err == nil {
+		*r = cpy
+	}
+	return err
+}
+
+// Pubkey returns the public key the record is signed with.
+func (r *Record) Pubkey() (*ecdsa.PublicKey, error) {
+	var key Secp256k1
+	if err := r.Load(&key); err != nil {
+		return nil, err
+	}
+	return (*ecdsa.PublicKey)(&key), nil
+}
+
+func (r *Record) verifySignature() error {
+	// Get identity scheme, public key, signature.
+	var id ID
+	if err := r.Load(&id); err != nil {
+		return err
+	} else if id != "v4" {
+		return errNoID
+	}
+	key, err := r.Pubkey()
+	if err != nil {
+		return err
+	}
+	if len(r.signature) != 64 {
+		return errInvalidSig
+	}
+	// Verify the signature by recovering the signer with both possible
+	// recovery identifiers.
+	h := crypto.Keccak256(rlpContent(r))
+	want := crypto.FromECDSAPub(key)
+	for v := byte(0); v < 2; v++ {
+		signer, err := crypto.Ecrecover(h, append(append([]byte(nil), r.signature...), v))
+		if err == nil && bytes.Equal(signer, want) {
+			return nil
+		}
+	}
+	return errInvalidSig
+}
+
+// rlpContent returns the RLP encoding of the signed content of a record.
+func rlpContent(r *Record) []byte {
+	content, err := rlp.EncodeToBytes(r.appendPairs(nil))
+	if err != nil {
+		panic(err)
+	}
+	return content
+}
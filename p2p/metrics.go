@@ -20,6 +20,9 @@ package p2p
 
 import (
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/immesys/bw2bc/metrics"
 )
@@ -31,41 +34,149 @@ var (
 	egressTrafficMeter  = metrics.NewMeter("p2p/OutboundTraffic")
 )
 
+// maxLimitedChunk is the largest amount of data read or written at once on a
+// rate limited connection, keeping the limiter delays short and smooth.
+const maxLimitedChunk = 16 * 1024
+
 // meteredConn is a wrapper around a network TCP connection that meters both the
-// inbound and outbound network traffic.
+// inbound and outbound network traffic, accounting it to the connection and
+// enforcing the configured bandwidth limits. Time spent waiting for the limiters
+// does not count against the read and write deadlines of the connection.
 type meteredConn struct {
-	*net.TCPConn // Network connection to wrap with metering
+	ingressBytes uint64 // Bytes read from the connection (atomic, keep 64 bit aligned)
+	egressBytes  uint64 // Bytes written to the connection (atomic, keep 64 bit aligned)
+
+	net.Conn // Network connection to wrap with metering
+
+	readLimits  []*tokenBucket // Ingress limiters, global and per connection
+	writeLimits []*tokenBucket // Egress limiters, global and per connection
+
+	deadlineLock  sync.Mutex // Protects the deadlines
+	readDeadline  time.Time  // Read deadline, postponed by the ingress limiter delays
+	writeDeadline time.Time  // Write deadline, postponed by the egress limiter delays
 }
 
 // newMeteredConn creates a new metered connection, also bumping the ingress or
-// egress connection meter. If the metrics system is disabled, this function
-// returns the original object.
-func newMeteredConn(conn net.Conn, ingress bool) net.Conn {
-	// Short circuit if metrics are disabled
-	if !metrics.Enabled {
-		return conn
-	}
-	// Otherwise bump the connection counters and wrap the connection
+// egress connection meter. The connection is throttled by the global limiters
+// of the server and a fresh pair of per peer limiters.
+func (srv *Server) newMeteredConn(conn net.Conn, ingress bool) net.Conn {
 	if ingress {
 		ingressConnectMeter.Mark(1)
 	} else {
 		egressConnectMeter.Mark(1)
 	}
-	return &meteredConn{conn.(*net.TCPConn)}
+	return &meteredConn{
+		Conn:        conn,
+		readLimits:  limiters(srv.ingressLimit, newTokenBucket(srv.PeerIngressRate)),
+		writeLimits: limiters(srv.egressLimit, newTokenBucket(srv.PeerEgressRate)),
+	}
+}
+
+// limiters filters the disabled (nil) token buckets out of the given ones.
+func limiters(buckets ...*tokenBucket) []*tokenBucket {
+	var active []*tokenBucket
+	for _, b := range buckets {
+		if b != nil {
+			active = append(active, b)
+		}
+	}
+	return active
 }
 
 // Read delegates a network read to the underlying connection, bumping the ingress
 // traffic meter along the way.
 func (c *meteredConn) Read(b []byte) (n int, err error) {
-	n, err = c.TCPConn.Read(b)
+	if len(c.readLimits) > 0 && len(b) > maxLimitedChunk {
+		b = b[:maxLimitedChunk]
+	}
+	n, err = c.Conn.Read(b)
+	atomic.AddUint64(&c.ingressBytes, uint64(n))
 	ingressTrafficMeter.Mark(int64(n))
+
+	var wait time.Duration
+	for _, limit := range c.readLimits {
+		wait += limit.take(n)
+	}
+	if wait > 0 {
+		c.postponeDeadline(&c.readDeadline, wait, c.Conn.SetReadDeadline)
+	}
 	return
 }
 
 // Write delegates a network write to the underlying connection, bumping the
 // egress traffic meter along the way.
 func (c *meteredConn) Write(b []byte) (n int, err error) {
-	n, err = c.TCPConn.Write(b)
-	egressTrafficMeter.Mark(int64(n))
+	if len(c.writeLimits) == 0 {
+		n, err = c.Conn.Write(b)
+		atomic.AddUint64(&c.egressBytes, uint64(n))
+		egressTrafficMeter.Mark(int64(n))
+		return
+	}
+	for len(b) > 0 && err == nil {
+		chunk := b
+		if len(chunk) > maxLimitedChunk {
+			chunk = chunk[:maxLimitedChunk]
+		}
+		var wait time.Duration
+		for _, limit := range c.writeLimits {
+			wait += limit.take(len(chunk))
+		}
+		if wait > 0 {
+			c.postponeDeadline(&c.writeDeadline, wait, c.Conn.SetWriteDeadline)
+		}
+		var written int
+		written, err = c.Conn.Write(chunk)
+		atomic.AddUint64(&c.egressBytes, uint64(written))
+		egressTrafficMeter.Mark(int64(written))
+		n, b = n+written, b[written:]
+	}
 	return
 }
+
+// SetDeadline sets the read and write deadlines of the underlying connection,
+// tracking them for the limiter delays.
+func (c *meteredConn) SetDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.deadlineLock.Unlock()
+
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection, tracking
+// it for the ingress limiter delays.
+func (c *meteredConn) SetReadDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	c.readDeadline = t
+	c.deadlineLock.Unlock()
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection, tracking
+// it for the egress limiter delays.
+func (c *meteredConn) SetWriteDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	c.writeDeadline = t
+	c.deadlineLock.Unlock()
+
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// postponeDeadline moves a deadline, if set, later by the time spent waiting for
+// the limiters, so that throttled transfers don't time out.
+func (c *meteredConn) postponeDeadline(deadline *time.Time, wait time.Duration, set func(time.Time) error) {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+
+	if deadline.IsZero() {
+		return
+	}
+	*deadline = deadline.Add(wait)
+	set(*deadline)
+}
+
+// traffic returns the number of bytes read from and written to the connection.
+func (c *meteredConn) traffic() (ingress, egress uint64) {
+	return atomic.LoadUint64(&c.ingressBytes), atomic.LoadUint64(&c.egressBytes)
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bc/rlp"
+)
+
+func TestTokenBucket(t *testing.T) {
+	if b := newTokenBucket(0); b != nil {
+		t.Fatal("expected nil bucket for unlimited rate")
+	}
+	b := newTokenBucket(100000)
+
+	// The initial burst should pass without delay.
+	start := time.Now()
+	b.take(100000)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("burst was delayed by %v", elapsed)
+	}
+	// Going into debt should delay until it is paid off.
+	start = time.Now()
+	b.take(50000)
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("wrong delay for 0.5s of debt: %v", elapsed)
+	}
+}
+
+func TestMeteredConnRateLimit(t *testing.T) {
+	const rate = 64 * 1024
+
+	srv := &Server{Config: Config{PeerEgressRate: rate}}
+	fd1, fd2 := net.Pipe()
+	defer fd1.Close()
+	defer fd2.Close()
+	mc := srv.newMeteredConn(fd1, false).(*meteredConn)
+
+	// Writing two seconds worth of data takes at least a second, as
+	// only the first second is covered by the burst allowance.
+	data := make([]byte, 2*rate)
+	read := make(chan int64)
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, io.LimitReader(fd2, int64(len(data))))
+		read <- n
+	}()
+	start := time.Now()
+	if _, err := mc.Write(data); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("write was not throttled: took %v", elapsed)
+	}
+	if n := <-read; n != int64(len(data)) {
+		t.Errorf("wrong amount read: got %d, want %d", n, len(data))
+	}
+	if ingress, egress := mc.traffic(); ingress != 0 || egress != uint64(len(data)) {
+		t.Errorf("wrong traffic: got %d/%d, want 0/%d", ingress, egress, len(data))
+	}
+}
+
+// Tests that throttled transfers don't time out if the limiters delay them past
+// the connection deadlines, as set for every rlpx frame.
+func TestMeteredConnRateLimitDeadline(t *testing.T) {
+	const (
+		rate    = 64 * 1024
+		timeout = 200 * time.Millisecond
+	)
+	// Transfer more data than the limiter lets through within the deadline
+	data := make([]byte, 2*rate)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		fd, _ := listener.Accept()
+		accepted <- fd
+	}()
+	fd1, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer fd1.Close()
+	fd2 := <-accepted
+	if fd2 == nil {
+		t.Fatal("failed to accept")
+	}
+	defer fd2.Close()
+
+	srv := &Server{Config: Config{PeerIngressRate: rate, PeerEgressRate: rate}}
+	mc1 := srv.newMeteredConn(fd1, false)
+	mc2 := srv.newMeteredConn(fd2, true)
+
+	// Throttled write
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(fd2, make([]byte, len(data)))
+		read <- err
+	}()
+	mc1.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := mc1.Write(data); err != nil {
+		t.Fatalf("throttled write failed: %v", err)
+	}
+	if err := <-read; err != nil {
+		t.Fatalf("read of throttled write failed: %v", err)
+	}
+	// Throttled read
+	go fd1.Write(data)
+
+	mc2.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := io.ReadFull(mc2, make([]byte, len(data))); err != nil {
+		t.Fatalf("throttled read failed: %v", err)
+	}
+}
+
+func TestPeerTraffic(t *testing.T) {
+	proto := Protocol{
+		Name:   "a",
+		Length: 5,
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			if err := ExpectMsg(rw, 1, []uint{1}); err != nil {
+				return err
+			}
+			return Send(rw, 2, []string{"reply"})
+		},
+	}
+	closer, rw, peer, errc := testPeer([]Protocol{proto})
+	defer closer()
+
+	if err := Send(rw, baseProtocolLength+1, []uint{1}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if err := ExpectMsg(rw, baseProtocolLength+2, []string{"reply"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != errProtocolReturned {
+			t.Fatalf("peer returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("protocol did not return")
+	}
+
+	ingress, _ := rlp.EncodeToBytes([]uint{1})
+	egress, _ := rlp.EncodeToBytes([]string{"reply"})
+	want := ProtocolTraffic{
+		IngressMessages: 1,
+		IngressBytes:    uint64(len(ingress)),
+		EgressMessages:  1,
+		EgressBytes:     uint64(len(egress)),
+	}
+	if have := peer.Info().Traffic.Protocols["a"]; have == nil || *have != want {
+		t.Errorf("wrong protocol traffic: have %+v, want %+v", have, want)
+	}
+}
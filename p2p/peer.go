@@ -22,16 +22,19 @@ import (
 	"net"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/immesys/bw2bc/common/mclock"
 	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/metrics"
 	"github.com/immesys/bw2bc/p2p/discover"
 	"github.com/immesys/bw2bc/rlp"
+	gometrics "github.com/rcrowley/go-metrics"
 )
 
 const (
-	baseProtocolVersion    = 4
+	baseProtocolVersion    = 5
 	baseProtocolLength     = uint64(16)
 	baseProtocolMaxMsgSize = 2 * 1024
 
@@ -71,6 +74,9 @@ type Peer struct {
 	protoErr chan error
 	closed   chan struct{}
 	disc     chan DiscReason
+
+	// faultHook is called by Fault to record protocol violations.
+	faultHook func(*Peer, error)
 }
 
 // NewPeer returns a peer for testing purposes.
@@ -108,6 +114,15 @@ func (p *Peer) LocalAddr() net.Addr {
 	return p.rw.fd.LocalAddr()
 }
 
+// Fault records a protocol violation committed by the peer. Protocol handlers
+// should call it before dropping a misbehaving peer; nodes accumulating too
+// many faults are banned from reconnecting for a while.
+func (p *Peer) Fault(reason error) {
+	if p.faultHook != nil {
+		p.faultHook(p, reason)
+	}
+}
+
 // Disconnect terminates the peer connection with the given reason.
 // It returns immediately and does not wait until the connection is closed.
 func (p *Peer) Disconnect(reason DiscReason) {
@@ -242,6 +257,7 @@ func (p *Peer) handle(msg Msg) error {
 		if err != nil {
 			return fmt.Errorf("msg code out of range: %v", msg.Code)
 		}
+		proto.countIngress(msg.Size)
 		select {
 		case proto.in <- msg:
 			return nil
@@ -279,7 +295,14 @@ outer:
 					offset -= old.Length
 				}
 				// Assign the new match
-				result[cap.Name] = &protoRW{Protocol: proto, offset: offset, in: make(chan Msg), w: rw}
+				result[cap.Name] = &protoRW{
+					Protocol:     proto,
+					offset:       offset,
+					in:           make(chan Msg),
+					w:            rw,
+					ingressMeter: metrics.NewMeter("p2p/" + proto.Name + "/InboundTraffic"),
+					egressMeter:  metrics.NewMeter("p2p/" + proto.Name + "/OutboundTraffic"),
+				}
 				offset += proto.Length
 
 				continue outer
@@ -323,6 +346,11 @@ func (p *Peer) getProto(code uint64) (*protoRW, error) {
 }
 
 type protoRW struct {
+	// message payload traffic of the protocol on this peer, accessed
+	// atomically and kept first for 64 bit alignment
+	ingressBytes, egressBytes uint64
+	ingressMsgs, egressMsgs   uint64
+
 	Protocol
 	in     chan Msg        // receices read messages
 	closed <-chan struct{} // receives when peer is shutting down
@@ -330,6 +358,9 @@ type protoRW struct {
 	werr   chan<- error    // for write results
 	offset uint64
 	w      MsgWriter
+
+	ingressMeter gometrics.Meter // protocol traffic over all peers
+	egressMeter  gometrics.Meter
 }
 
 func (rw *protoRW) WriteMsg(msg Msg) (err error) {
@@ -339,7 +370,11 @@ func (rw *protoRW) WriteMsg(msg Msg) (err error) {
 	msg.Code += rw.offset
 	select {
 	case <-rw.wstart:
+		size := msg.Size
 		err = rw.w.WriteMsg(msg)
+		if err == nil {
+			rw.countEgress(size)
+		}
 		// Report write status back to Peer.run. It will initiate
 		// shutdown if the error is non-nil and unblock the next write
 		// otherwise. The calling protocol code should exit for errors
@@ -361,6 +396,20 @@ func (rw *protoRW) ReadMsg() (Msg, error) {
 	}
 }
 
+// countIngress accounts a message received for the protocol.
+func (rw *protoRW) countIngress(size uint32) {
+	atomic.AddUint64(&rw.ingressMsgs, 1)
+	atomic.AddUint64(&rw.ingressBytes, uint64(size))
+	rw.ingressMeter.Mark(int64(size))
+}
+
+// countEgress accounts a message sent by the protocol.
+func (rw *protoRW) countEgress(size uint32) {
+	atomic.AddUint64(&rw.egressMsgs, 1)
+	atomic.AddUint64(&rw.egressBytes, uint64(size))
+	rw.egressMeter.Mark(int64(size))
+}
+
 // PeerInfo represents a short summary of the information known about a connected
 // peer. Sub-protocol independent fields are contained and initialized here, with
 // protocol specifics delegated to all connected sub-protocols.
@@ -373,6 +422,24 @@ type PeerInfo struct {
 		RemoteAddress string `json:"remoteAddress"` // Remote endpoint of the TCP data connection
 	} `json:"network"`
 	Protocols map[string]interface{} `json:"protocols"` // Sub-protocol specific metadata fields
+	Traffic   *TrafficInfo           `json:"traffic"`   // Traffic exchanged with the peer
+}
+
+// TrafficInfo summarises the traffic exchanged with a peer. Connection totals
+// count the encrypted bytes on the wire, including the handshakes, while the
+// per protocol numbers count uncompressed message payloads.
+type TrafficInfo struct {
+	IngressBytes uint64                      `json:"ingressBytes"` // Bytes received on the connection
+	EgressBytes  uint64                      `json:"egressBytes"`  // Bytes sent on the connection
+	Protocols    map[string]*ProtocolTraffic `json:"protocols"`    // Message traffic per sub-protocol
+}
+
+// ProtocolTraffic is the message traffic of a single sub-protocol with a peer.
+type ProtocolTraffic struct {
+	IngressMessages uint64 `json:"ingressMessages"` // Number of messages received
+	IngressBytes    uint64 `json:"ingressBytes"`    // Payload bytes received
+	EgressMessages  uint64 `json:"egressMessages"`  // Number of messages sent
+	EgressBytes     uint64 `json:"egressBytes"`     // Payload bytes sent
 }
 
 // Info gathers and returns a collection of metadata known about a peer.
@@ -404,5 +471,23 @@ func (p *Peer) Info() *PeerInfo {
 		}
 		info.Protocols[proto.Name] = protoInfo
 	}
+	info.Traffic = p.Traffic()
 	return info
 }
+
+// Traffic returns the amount of traffic exchanged with the peer.
+func (p *Peer) Traffic() *TrafficInfo {
+	traffic := &TrafficInfo{Protocols: make(map[string]*ProtocolTraffic)}
+	if fd, ok := p.rw.fd.(*meteredConn); ok {
+		traffic.IngressBytes, traffic.EgressBytes = fd.traffic()
+	}
+	for _, proto := range p.running {
+		traffic.Protocols[proto.Name] = &ProtocolTraffic{
+			IngressMessages: atomic.LoadUint64(&proto.ingressMsgs),
+			IngressBytes:    atomic.LoadUint64(&proto.ingressBytes),
+			EgressMessages:  atomic.LoadUint64(&proto.egressMsgs),
+			EgressBytes:     atomic.LoadUint64(&proto.egressBytes),
+		}
+	}
+	return traffic
+}
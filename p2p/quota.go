@@ -0,0 +1,197 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"net"
+
+	"github.com/immesys/bw2bc/metrics"
+	"github.com/immesys/bw2bc/p2p/discover"
+)
+
+var (
+	inboundPeersGauge  = metrics.NewGauge("p2p/InboundPeers")
+	outboundPeersGauge = metrics.NewGauge("p2p/OutboundPeers")
+	trustedPeersGauge  = metrics.NewGauge("p2p/TrustedPeers")
+	staticPeersGauge   = metrics.NewGauge("p2p/StaticPeers")
+
+	directionQuotaMeter   = metrics.NewMeter("p2p/QuotaRejects/Direction")
+	subnetQuotaMeter      = metrics.NewMeter("p2p/QuotaRejects/Subnet")
+	reservationQuotaMeter = metrics.NewMeter("p2p/QuotaRejects/Reservation")
+)
+
+// quotaChecks enforces the directional and per-subnet peer limits on a
+// connection that passed the encryption handshake.
+func (srv *Server) quotaChecks(peers map[discover.NodeID]*Peer, c *conn) error {
+	if c.is(trustedConn | staticDialedConn) {
+		return nil
+	}
+	inbound := c.is(inboundConn)
+	if limit := srv.directionLimit(inbound); limit > 0 {
+		count := 0
+		for _, p := range peers {
+			if p.rw.is(inboundConn) == inbound {
+				count++
+			}
+		}
+		if count >= limit {
+			directionQuotaMeter.Mark(1)
+			return DiscTooManyPeers
+		}
+	}
+	if srv.MaxSubnetPeers > 0 {
+		if subnet := srv.subnetOf(c.fd.RemoteAddr()); subnet != nil {
+			count := 0
+			for _, p := range peers {
+				if tcp, ok := p.RemoteAddr().(*net.TCPAddr); ok && subnet.Contains(tcp.IP) {
+					count++
+				}
+			}
+			if count >= srv.MaxSubnetPeers {
+				subnetQuotaMeter.Mark(1)
+				return DiscTooManyPeers
+			}
+		}
+	}
+	return nil
+}
+
+// reservationChecks ensures that a connection which passed the protocol
+// handshake does not take slots reserved for protocols it doesn't run.
+func (srv *Server) reservationChecks(peers map[discover.NodeID]*Peer, c *conn) error {
+	if c.is(trustedConn|staticDialedConn) || len(srv.ReservedPeers) == 0 {
+		return nil
+	}
+	reserved := 0
+	for name, slots := range srv.ReservedPeers {
+		if srv.runsProtocol(c.caps, name) {
+			continue
+		}
+		for _, p := range peers {
+			if p.running[name] != nil {
+				slots--
+			}
+		}
+		if slots > 0 {
+			reserved += slots
+		}
+	}
+	if len(peers)+reserved >= srv.MaxPeers {
+		reservationQuotaMeter.Mark(1)
+		return DiscTooManyPeers
+	}
+	return nil
+}
+
+// directionLimit returns the maximum number of inbound or dialed peers, or zero
+// if their number is not limited.
+func (srv *Server) directionLimit(inbound bool) int {
+	ratio := srv.OutboundRatio
+	if inbound {
+		ratio = srv.InboundRatio
+	}
+	if ratio <= 0 || ratio >= 100 {
+		return 0
+	}
+	if limit := srv.MaxPeers * ratio / 100; limit > 0 {
+		return limit
+	}
+	return 1
+}
+
+// maxDialedConns returns the number of dynamic peers the dialer should keep
+// connected, which is the dialed share of MaxPeers if limited, or half of the
+// peer slots otherwise.
+func (srv *Server) maxDialedConns() int {
+	if limit := srv.directionLimit(false); limit > 0 {
+		return limit
+	}
+	return (srv.MaxPeers + 1) / 2
+}
+
+// subnetOf returns the subnet a remote address belongs to for the purpose of
+// the per-subnet peer limit, or nil for non-IP addresses.
+func (srv *Server) subnetOf(addr net.Addr) *net.IPNet {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	if ip := tcp.IP.To4(); ip != nil {
+		bits := srv.SubnetPrefixV4
+		if bits <= 0 || bits > 32 {
+			bits = defaultSubnetPrefixV4
+		}
+		mask := net.CIDRMask(bits, 32)
+		return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+	bits := srv.SubnetPrefixV6
+	if bits <= 0 || bits > 128 {
+		bits = defaultSubnetPrefixV6
+	}
+	mask := net.CIDRMask(bits, 128)
+	return &net.IPNet{IP: tcp.IP.Mask(mask), Mask: mask}
+}
+
+// runsProtocol reports whether a remote node with the given capabilities will
+// run the named local protocol.
+func (srv *Server) runsProtocol(caps []Cap, name string) bool {
+	for _, proto := range srv.Protocols {
+		if proto.Name != name {
+			continue
+		}
+		for _, cap := range caps {
+			if cap.Name == proto.Name && cap.Version == proto.Version {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// updatePeerMetrics refreshes the per-category peer count gauges.
+func (srv *Server) updatePeerMetrics(peers map[discover.NodeID]*Peer) {
+	if !metrics.Enabled {
+		return
+	}
+	var inbound, outbound, trusted, static int64
+	protos := make(map[string]int64)
+	for _, proto := range srv.Protocols {
+		protos[proto.Name] = 0
+	}
+	for _, p := range peers {
+		if p.rw.is(inboundConn) {
+			inbound++
+		} else {
+			outbound++
+		}
+		if p.rw.is(trustedConn) {
+			trusted++
+		}
+		if p.rw.is(staticDialedConn) {
+			static++
+		}
+		for name := range p.running {
+			protos[name]++
+		}
+	}
+	inboundPeersGauge.Update(inbound)
+	outboundPeersGauge.Update(outbound)
+	trustedPeersGauge.Update(trusted)
+	staticPeersGauge.Update(static)
+	for name, count := range protos {
+		metrics.NewGauge("p2p/Peers/" + name).Update(count)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"net"
+	"testing"
+)
+
+// quotaConn is a network connection with a configurable remote address.
+type quotaConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *quotaConn) RemoteAddr() net.Addr { return c.addr }
+
+func startQuotaServer(t *testing.T, config Config) *Server {
+	config.PrivateKey = newkey()
+	config.NoDial = true
+	srv := &Server{Config: config}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	return srv
+}
+
+func newQuotaConn(ip string, flags connFlag, caps ...Cap) *conn {
+	id := randomID()
+	fd, _ := net.Pipe()
+	qfd := &quotaConn{Conn: fd, addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 30303}}
+	return &conn{fd: qfd, transport: newTestTransport(id, fd), flags: flags, id: id, caps: caps, cont: make(chan error)}
+}
+
+// Tests that inbound and dialed connections are limited to their share of the
+// peer slots.
+func TestServerDirectionQuota(t *testing.T) {
+	srv := startQuotaServer(t, Config{MaxPeers: 10, InboundRatio: 30, OutboundRatio: 50})
+	defer srv.Stop()
+
+	for i := 0; i < 3; i++ {
+		if err := srv.checkpoint(newQuotaConn("10.0.0.1", inboundConn), srv.addpeer); err != nil {
+			t.Fatalf("could not add inbound conn %d: %v", i, err)
+		}
+	}
+	if err := srv.checkpoint(newQuotaConn("10.0.0.1", inboundConn), srv.posthandshake); err != DiscTooManyPeers {
+		t.Errorf("wrong error for inbound conn above quota: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := srv.checkpoint(newQuotaConn("10.0.0.1", dynDialedConn), srv.addpeer); err != nil {
+			t.Fatalf("could not add dialed conn %d: %v", i, err)
+		}
+	}
+	if err := srv.checkpoint(newQuotaConn("10.0.0.1", dynDialedConn), srv.posthandshake); err != DiscTooManyPeers {
+		t.Errorf("wrong error for dialed conn above quota: %v", err)
+	}
+	if err := srv.checkpoint(newQuotaConn("10.0.0.1", staticDialedConn), srv.posthandshake); err != nil {
+		t.Errorf("unexpected error for static conn: %v", err)
+	}
+}
+
+// Tests that the dialer targets the dialed share of the peer slots.
+func TestServerDialedQuota(t *testing.T) {
+	tests := []struct {
+		ratio int
+		want  int
+	}{
+		{0, 13},   // unlimited, half of the slots
+		{100, 13}, // unlimited, half of the slots
+		{20, 5},   // low ratio, below half
+		{80, 20},  // high ratio, above half
+		{1, 1},    // at least a single slot
+	}
+	for _, test := range tests {
+		srv := &Server{Config: Config{MaxPeers: 25, OutboundRatio: test.ratio}}
+		if have := srv.maxDialedConns(); have != test.want {
+			t.Errorf("ratio %d: dialed peers mismatch: have %d, want %d", test.ratio, have, test.want)
+		}
+	}
+}
+
+// Tests that the number of peers from within the same subnet is limited.
+func TestServerSubnetQuota(t *testing.T) {
+	srv := startQuotaServer(t, Config{MaxPeers: 10, MaxSubnetPeers: 2, SubnetPrefixV6: 48})
+	defer srv.Stop()
+
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2", "2001:db8::1", "2001:db8:0:1::1"} {
+		if err := srv.checkpoint(newQuotaConn(ip, inboundConn), srv.addpeer); err != nil {
+			t.Fatalf("could not add conn from %s: %v", ip, err)
+		}
+	}
+	tests := []struct {
+		ip  string
+		err error
+	}{
+		{"10.0.1.3", DiscTooManyPeers},
+		{"10.0.2.1", nil},
+		{"2001:db8:0:2::1", DiscTooManyPeers},
+		{"2001:db9::1", nil},
+	}
+	for _, test := range tests {
+		if err := srv.checkpoint(newQuotaConn(test.ip, inboundConn), srv.posthandshake); err != test.err {
+			t.Errorf("conn from %s: error mismatch: got %v, want %v", test.ip, err, test.err)
+		}
+	}
+}
+
+// Tests that slots reserved for a protocol are only taken by peers running it.
+func TestServerReservedPeers(t *testing.T) {
+	run := func(p *Peer, rw MsgReadWriter) error {
+		for {
+			if _, err := rw.ReadMsg(); err != nil {
+				return err
+			}
+		}
+	}
+	srv := startQuotaServer(t, Config{
+		MaxPeers:      4,
+		ReservedPeers: map[string]int{"les": 2},
+		Protocols: []Protocol{
+			{Name: "eth", Version: 63, Length: 1, Run: run},
+			{Name: "les", Version: 1, Length: 1, Run: run},
+		},
+	})
+	defer srv.Stop()
+
+	full, light := Cap{Name: "eth", Version: 63}, Cap{Name: "les", Version: 1}
+	for i := 0; i < 2; i++ {
+		if err := srv.checkpoint(newQuotaConn("10.0.0.1", inboundConn, full), srv.addpeer); err != nil {
+			t.Fatalf("could not add full conn %d: %v", i, err)
+		}
+	}
+	if err := srv.checkpoint(newQuotaConn("10.0.0.1", inboundConn, full), srv.addpeer); err != DiscTooManyPeers {
+		t.Errorf("wrong error for full conn in reserved slot: %v", err)
+	}
+	if err := srv.checkpoint(newQuotaConn("10.0.0.1", inboundConn, light), srv.addpeer); err != nil {
+		t.Fatalf("could not add light conn: %v", err)
+	}
+	if err := srv.checkpoint(newQuotaConn("10.0.0.1", inboundConn, full), srv.addpeer); err != DiscTooManyPeers {
+		t.Errorf("wrong error for full conn in reserved slot: %v", err)
+	}
+	if err := srv.checkpoint(newQuotaConn("10.0.0.1", inboundConn, full, light), srv.addpeer); err != nil {
+		t.Errorf("unexpected error for light conn: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a byte rate limiter. The bucket fills up at rate tokens per
+// second, holding at most one second worth of tokens for bursts. Taking more
+// tokens than available puts the bucket into debt, and the caller is delayed
+// until the debt has been paid off.
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64 // tokens added per second
+	capacity float64 // maximum number of tokens saved up
+	tokens   float64 // currently available tokens, negative when in debt
+	last     time.Time
+}
+
+// newTokenBucket creates a limiter for rate bytes per second. It returns nil
+// if rate is not positive, i.e. the traffic is unlimited.
+func newTokenBucket(rate int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:     float64(rate),
+		capacity: float64(rate),
+		tokens:   float64(rate),
+		last:     time.Now(),
+	}
+}
+
+// take removes n tokens from the bucket, blocking while the bucket is in debt.
+// It returns the time the caller was delayed.
+func (b *tokenBucket) take(n int) time.Duration {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+	b.tokens -= float64(n)
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return wait
+}
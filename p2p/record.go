@@ -0,0 +1,125 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/enr"
+)
+
+var errDiscoveryDisabled = errors.New("node discovery disabled")
+
+// Capabilities is the "bw2" node record entry, listing the BOSSWAVE specific
+// services offered by a node.
+type Capabilities []string
+
+// ENRKey implements enr.Entry.
+func (Capabilities) ENRKey() string { return "bw2" }
+
+// setupRecord creates and signs the record of the local node, announcing its
+// endpoints, capabilities and protocol attributes. The record is also handed
+// to the discovery table, which serves it to other nodes.
+func (srv *Server) setupRecord() error {
+	record := new(enr.Record)
+	record.SetSeq(uint64(time.Now().Unix()))
+
+	self := srv.makeSelf(srv.listener, srv.ntab)
+	if !self.IP.IsUnspecified() {
+		record.Set(enr.IP(self.IP))
+	}
+	if srv.listener != nil {
+		record.Set(enr.TCP(srv.listener.Addr().(*net.TCPAddr).Port))
+	}
+	if self.UDP != 0 {
+		record.Set(enr.UDP(self.UDP))
+	}
+	if len(srv.Capabilities) > 0 {
+		record.Set(Capabilities(srv.Capabilities))
+	}
+	for _, proto := range srv.Protocols {
+		for _, attr := range proto.Attributes {
+			record.Set(attr)
+		}
+	}
+	if err := enr.SignV4(record, srv.PrivateKey); err != nil {
+		return err
+	}
+	if tab, ok := srv.ntab.(*discover.Table); ok {
+		if err := tab.SetRecord(record); err != nil {
+			return err
+		}
+	}
+	srv.record = record
+	return nil
+}
+
+// Record returns the signed record of the local node, or nil if the server is
+// not running.
+func (srv *Server) Record() *enr.Record {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if !srv.running || srv.record == nil {
+		return nil
+	}
+	cpy := *srv.record
+	return &cpy
+}
+
+// SetRecordEntry adds or updates an entry of the local node record, e.g. a
+// protocol attribute which changed, and re-signs the record with an increased
+// sequence number.
+func (srv *Server) SetRecordEntry(entry enr.Entry) error {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if !srv.running || srv.record == nil {
+		return errServerStopped
+	}
+	record := *srv.record
+	record.Set(entry)
+	if err := enr.SignV4(&record, srv.PrivateKey); err != nil {
+		return err
+	}
+	if tab, ok := srv.ntab.(*discover.Table); ok {
+		if err := tab.SetRecord(&record); err != nil {
+			return err
+		}
+	}
+	srv.record = &record
+	return nil
+}
+
+// RequestRecord retrieves the current record of a remote node through the
+// discovery protocol.
+func (srv *Server) RequestRecord(n *discover.Node) (*enr.Record, error) {
+	srv.lock.Lock()
+	running := srv.running
+	tab, ok := srv.ntab.(*discover.Table)
+	srv.lock.Unlock()
+
+	switch {
+	case !running:
+		return nil, errServerStopped
+	case !ok:
+		return nil, errDiscoveryDisabled
+	}
+	return tab.RequestRecord(n)
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/enr"
+)
+
+// Tests that the server announces its endpoint, capabilities and protocol
+// attributes in its node record.
+func TestServerRecord(t *testing.T) {
+	srv := &Server{
+		Config: Config{
+			PrivateKey:   newkey(),
+			MaxPeers:     10,
+			NoDiscovery:  true,
+			NoDial:       true,
+			ListenAddr:   "127.0.0.1:0",
+			Capabilities: []string{"router", "lightserver"},
+			Protocols: []Protocol{{
+				Name:       "test",
+				Version:    1,
+				Attributes: []enr.Entry{enr.WithEntry("test", uint(42))},
+			}},
+		},
+	}
+	if srv.Record() != nil {
+		t.Fatal("stopped server has a record")
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	record := srv.Record()
+	if record == nil || !record.Signed() {
+		t.Fatal("server has no signed record")
+	}
+	pubkey, err := record.Pubkey()
+	if err != nil {
+		t.Fatalf("record has no public key: %v", err)
+	}
+	if discover.PubkeyID(pubkey) != srv.Self().ID {
+		t.Errorf("record key mismatch")
+	}
+	var (
+		ip   enr.IP
+		tcp  enr.TCP
+		caps Capabilities
+		attr uint
+	)
+	for _, e := range []enr.Entry{&ip, &tcp, &caps, enr.WithEntry("test", &attr)} {
+		if err := record.Load(e); err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+	}
+	if laddr := srv.listener.Addr().(*net.TCPAddr); !net.IP(ip).Equal(laddr.IP) || int(tcp) != laddr.Port {
+		t.Errorf("endpoint mismatch: got %v:%d, want %v", net.IP(ip), tcp, laddr)
+	}
+	if !reflect.DeepEqual(caps, Capabilities{"router", "lightserver"}) {
+		t.Errorf("capabilities mismatch: %v", caps)
+	}
+	if attr != 42 {
+		t.Errorf("protocol attribute mismatch: %d", attr)
+	}
+	if info := srv.NodeInfo(); info.ENR != record.String() {
+		t.Errorf("node info record mismatch: got %s, want %s", info.ENR, record.String())
+	}
+}
+
+// Tests that updating an entry re-signs the record with a higher sequence number.
+func TestServerSetRecordEntry(t *testing.T) {
+	srv := &Server{
+		Config: Config{
+			PrivateKey:  newkey(),
+			MaxPeers:    10,
+			NoDiscovery: true,
+			NoDial:      true,
+		},
+	}
+	if err := srv.SetRecordEntry(enr.WithEntry("test", uint(1))); err != errServerStopped {
+		t.Errorf("wrong error for stopped server: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	old := srv.Record()
+	if err := srv.SetRecordEntry(enr.WithEntry("test", uint(42))); err != nil {
+		t.Fatalf("could not set entry: %v", err)
+	}
+	record := srv.Record()
+	if !record.Signed() || record.Seq() <= old.Seq() {
+		t.Errorf("record not re-signed: signed %v, seq %d, old seq %d", record.Signed(), record.Seq(), old.Seq())
+	}
+	var attr uint
+	if err := record.Load(enr.WithEntry("test", &attr)); err != nil || attr != 42 {
+		t.Errorf("entry mismatch: %d, %v", attr, err)
+	}
+	if err := old.Load(enr.WithEntry("test", &attr)); err == nil {
+		t.Errorf("previous record copy modified")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"time"
+
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p/discover"
+)
+
+// BanInfo represents a short summary of an active ban.
+type BanInfo struct {
+	Node    string    `json:"node,omitempty"`    // Banned node identifier
+	Network string    `json:"network,omitempty"` // Banned IP network in CIDR notation
+	Expiry  time.Time `json:"expiry"`            // Time at which the ban is lifted
+}
+
+// peerFault records a protocol fault against a peer, banning and dropping it
+// once it exceeded the tolerated number of faults.
+func (srv *Server) peerFault(p *Peer, reason error) {
+	faults := srv.rep.AddFault(p.ID())
+	p.log.Debug("Recorded p2p peer fault", "faults", faults, "err", reason)
+
+	limit := srv.MaxFaults
+	if limit <= 0 {
+		limit = defaultMaxFaults
+	}
+	if faults < limit {
+		return
+	}
+	if err := srv.rep.BanNode(p.ID(), time.Now().Add(srv.banDuration(0))); err != nil {
+		p.log.Warn("Failed to ban misbehaving peer", "err", err)
+		return
+	}
+	srv.rep.ResetFaults(p.ID())
+	p.log.Info("Banned misbehaving peer", "faults", faults, "duration", srv.banDuration(0))
+	p.Disconnect(DiscUselessPeer)
+}
+
+// banDuration returns the given ban duration, or the configured one if zero.
+func (srv *Server) banDuration(duration time.Duration) time.Duration {
+	switch {
+	case duration > 0:
+		return duration
+	case srv.BanDuration > 0:
+		return srv.BanDuration
+	default:
+		return defaultBanDuration
+	}
+}
+
+// reputation returns the reputation store of the running server.
+func (srv *Server) reputation() (*discover.Reputation, error) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if !srv.running || srv.rep == nil {
+		return nil, errServerStopped
+	}
+	return srv.rep, nil
+}
+
+// BanNode bans a node for the given duration, or the configured one if zero,
+// disconnecting it if currently connected.
+func (srv *Server) BanNode(id discover.NodeID, duration time.Duration) error {
+	rep, err := srv.reputation()
+	if err != nil {
+		return err
+	}
+	if err := rep.BanNode(id, time.Now().Add(srv.banDuration(duration))); err != nil {
+		return err
+	}
+	log.Info("Banned p2p node", "id", id, "duration", srv.banDuration(duration))
+	for _, p := range srv.Peers() {
+		if p.ID() == id {
+			p.Disconnect(DiscRequested)
+		}
+	}
+	return nil
+}
+
+// BanNetwork bans a range of IP addresses for the given duration, or the
+// configured one if zero, disconnecting all peers connected from within it.
+func (srv *Server) BanNetwork(network *net.IPNet, duration time.Duration) error {
+	rep, err := srv.reputation()
+	if err != nil {
+		return err
+	}
+	if err := rep.BanNetwork(network, time.Now().Add(srv.banDuration(duration))); err != nil {
+		return err
+	}
+	log.Info("Banned p2p network", "network", network, "duration", srv.banDuration(duration))
+	for _, p := range srv.Peers() {
+		if tcp, ok := p.RemoteAddr().(*net.TCPAddr); ok && network.Contains(tcp.IP) {
+			p.Disconnect(DiscRequested)
+		}
+	}
+	return nil
+}
+
+// UnbanNode lifts the ban of a node, reporting whether it was banned.
+func (srv *Server) UnbanNode(id discover.NodeID) (bool, error) {
+	rep, err := srv.reputation()
+	if err != nil {
+		return false, err
+	}
+	return rep.UnbanNode(id)
+}
+
+// UnbanNetwork lifts the ban of an IP range, reporting whether it was banned.
+func (srv *Server) UnbanNetwork(network *net.IPNet) (bool, error) {
+	rep, err := srv.reputation()
+	if err != nil {
+		return false, err
+	}
+	return rep.UnbanNetwork(network)
+}
+
+// BansInfo returns metadata about all the currently active bans.
+func (srv *Server) BansInfo() []*BanInfo {
+	rep, err := srv.reputation()
+	if err != nil {
+		return nil
+	}
+	bans := rep.Bans()
+	infos := make([]*BanInfo, 0, len(bans))
+	for _, ban := range bans {
+		info := &BanInfo{Expiry: ban.Expiry}
+		if ban.Node != nil {
+			info.Node = ban.Node.String()
+		}
+		if ban.Network != nil {
+			info.Network = ban.Network.String()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// Tests that peers committing too many faults get banned and that bans are
+// enforced on subsequent connection attempts.
+func TestServerPeerFaultBan(t *testing.T) {
+	connected := make(chan *Peer, 1)
+	remid := randomID()
+	srv := startTestServer(t, remid, func(p *Peer) {
+		// Faults are reported by running protocols, not before the peer starts
+		go func() {
+			for i := 0; i < defaultMaxFaults; i++ {
+				p.Fault(errors.New("test fault"))
+			}
+			connected <- p
+		}()
+	})
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("server did not accept within one second")
+	}
+	bans := srv.BansInfo()
+	if len(bans) != 1 || bans[0].Node != remid.String() {
+		t.Fatalf("ban list mismatch: have %v, want node %x", bans, remid[:8])
+	}
+	if expiry := time.Until(bans[0].Expiry); expiry <= 0 || expiry > defaultBanDuration {
+		t.Errorf("ban expiry out of range: %v", expiry)
+	}
+	// Reconnecting must be rejected while banned
+	expectRejected(t, srv, connected)
+
+	// Lifting the ban must allow the node back in
+	if ok, err := srv.UnbanNode(remid); !ok || err != nil {
+		t.Fatalf("failed to unban node: %v, %v", ok, err)
+	}
+	if len(srv.BansInfo()) != 0 {
+		t.Errorf("ban list not empty after unban")
+	}
+}
+
+// Tests that banning an IP range rejects connections from within it.
+func TestServerNetworkBan(t *testing.T) {
+	connected := make(chan *Peer, 1)
+	srv := startTestServer(t, randomID(), func(p *Peer) { connected <- p })
+	defer srv.Stop()
+
+	_, network, _ := net.ParseCIDR("127.0.0.0/8")
+	if err := srv.BanNetwork(network, time.Minute); err != nil {
+		t.Fatalf("failed to ban network: %v", err)
+	}
+	expectRejected(t, srv, connected)
+
+	if ok, err := srv.UnbanNetwork(network); !ok || err != nil {
+		t.Fatalf("failed to unban network: %v, %v", ok, err)
+	}
+	conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("server did not accept within one second after unban")
+	}
+}
+
+// expectRejected dials the server and checks that the connection is closed
+// without a peer being started.
+func expectRejected(t *testing.T, srv *Server, connected chan *Peer) {
+	conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("banned connection not closed")
+	}
+	select {
+	case <-connected:
+		t.Errorf("banned peer accepted")
+	default:
+	}
+}
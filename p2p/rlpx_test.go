@@ -19,6 +19,7 @@ package p2p
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -146,25 +147,31 @@ func testEncHandshake(token []byte) error {
 	return nil
 }
 
-func TestProtocolHandshake(t *testing.T) {
+func TestProtocolHandshake(t *testing.T)   { testProtocolHandshake(t, 5, 5) }
+func TestProtocolHandshakeV4(t *testing.T) { testProtocolHandshake(t, 5, 4) }
+
+func testProtocolHandshake(t *testing.T, version0, version1 uint64) {
 	var (
 		prv0, _ = crypto.GenerateKey()
 		node0   = &discover.Node{ID: discover.PubkeyID(&prv0.PublicKey), IP: net.IP{1, 2, 3, 4}, TCP: 33}
-		hs0     = &protoHandshake{Version: 3, ID: node0.ID, Caps: []Cap{{"a", 0}, {"b", 2}}}
+		hs0     = &protoHandshake{Version: version0, ID: node0.ID, Caps: []Cap{{"a", 0}, {"b", 2}}}
 
 		prv1, _ = crypto.GenerateKey()
 		node1   = &discover.Node{ID: discover.PubkeyID(&prv1.PublicKey), IP: net.IP{5, 6, 7, 8}, TCP: 44}
-		hs1     = &protoHandshake{Version: 3, ID: node1.ID, Caps: []Cap{{"c", 1}, {"d", 3}}}
+		hs1     = &protoHandshake{Version: version1, ID: node1.ID, Caps: []Cap{{"c", 1}, {"d", 3}}}
 
 		fd0, fd1 = net.Pipe()
 		wg       sync.WaitGroup
+
+		// compression is only used if both sides support it
+		wantSnappy = version0 >= snappyProtocolVersion && version1 >= snappyProtocolVersion
 	)
 
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
 		defer fd1.Close()
-		rlpx := newRLPX(fd0)
+		rlpx := newRLPX(fd0).(*rlpx)
 		remid, err := rlpx.doEncHandshake(prv0, node1)
 		if err != nil {
 			t.Errorf("dial side enc handshake failed: %v", err)
@@ -185,12 +192,15 @@ func TestProtocolHandshake(t *testing.T) {
 			t.Errorf("dial side proto handshake mismatch:\ngot: %s\nwant: %s\n", spew.Sdump(phs), spew.Sdump(hs1))
 			return
 		}
+		if rlpx.rw.snappy != wantSnappy {
+			t.Errorf("dial side snappy mismatch: got %t, want %t", rlpx.rw.snappy, wantSnappy)
+		}
 		rlpx.close(DiscQuitting)
 	}()
 	go func() {
 		defer wg.Done()
 		defer fd1.Close()
-		rlpx := newRLPX(fd1)
+		rlpx := newRLPX(fd1).(*rlpx)
 		remid, err := rlpx.doEncHandshake(prv1, nil)
 		if err != nil {
 			t.Errorf("listen side enc handshake failed: %v", err)
@@ -211,6 +221,9 @@ func TestProtocolHandshake(t *testing.T) {
 			t.Errorf("listen side proto handshake mismatch:\ngot: %s\nwant: %s\n", spew.Sdump(phs), spew.Sdump(hs0))
 			return
 		}
+		if rlpx.rw.snappy != wantSnappy {
+			t.Errorf("listen side snappy mismatch: got %t, want %t", rlpx.rw.snappy, wantSnappy)
+		}
 
 		if err := ExpectMsg(rlpx, discMsg, []DiscReason{DiscQuitting}); err != nil {
 			t.Errorf("error receiving disconnect: %v", err)
@@ -317,7 +330,61 @@ func (fakeHash) BlockSize() int              { return 0 }
 func (h fakeHash) Size() int           { return len(h) }
 func (h fakeHash) Sum(b []byte) []byte { return append(b, h...) }
 
-func TestRLPXFrameRW(t *testing.T) {
+func TestRLPXFrameRW(t *testing.T)       { testRLPXFrameRW(t, false) }
+func TestRLPXFrameRWSnappy(t *testing.T) { testRLPXFrameRW(t, true) }
+
+func testRLPXFrameRW(t *testing.T, snappy bool) {
+	rw1, rw2 := newTestFrameRWPair()
+	rw1.snappy, rw2.snappy = snappy, snappy
+
+	// send some messages
+	for i := 0; i < 10; i++ {
+		// write message into conn buffer
+		wmsg := []interface{}{"foo", "bar", strings.Repeat("test", i)}
+		err := Send(rw1, uint64(i), wmsg)
+		if err != nil {
+			t.Fatalf("WriteMsg error (i=%d): %v", i, err)
+		}
+
+		// read message that rw1 just wrote
+		msg, err := rw2.ReadMsg()
+		if err != nil {
+			t.Fatalf("ReadMsg error (i=%d): %v", i, err)
+		}
+		if msg.Code != uint64(i) {
+			t.Fatalf("msg code mismatch: got %d, want %d", msg.Code, i)
+		}
+		payload, _ := ioutil.ReadAll(msg.Payload)
+		wantPayload, _ := rlp.EncodeToBytes(wmsg)
+		if !bytes.Equal(payload, wantPayload) {
+			t.Fatalf("msg payload mismatch:\ngot  %x\nwant %x", payload, wantPayload)
+		}
+		if msg.Size != uint32(len(wantPayload)) {
+			t.Fatalf("msg size mismatch: got %d, want %d", msg.Size, len(wantPayload))
+		}
+	}
+}
+
+// Tests that a compressed payload announcing a decompressed length above
+// the frame size limit is rejected before it is inflated.
+func TestRLPXFrameRWSnappyBomb(t *testing.T) {
+	rw1, rw2 := newTestFrameRWPair()
+	rw2.snappy = true
+
+	// A snappy block starts with the varint encoded decompressed length.
+	bomb := make([]byte, binary.MaxVarintLen32)
+	bomb = bomb[:binary.PutUvarint(bomb, uint64(maxUint24)+1)]
+	if err := rw1.WriteMsg(Msg{Code: 1, Size: uint32(len(bomb)), Payload: bytes.NewReader(bomb)}); err != nil {
+		t.Fatalf("WriteMsg error: %v", err)
+	}
+	if _, err := rw2.ReadMsg(); err != errPlainMessageTooLarge {
+		t.Fatalf("wrong error: got %v, want %v", err, errPlainMessageTooLarge)
+	}
+}
+
+// newTestFrameRWPair creates two frame codecs sharing a buffer, with
+// the first one writing messages readable by the second one.
+func newTestFrameRWPair() (*rlpxFrameRW, *rlpxFrameRW) {
 	var (
 		aesSecret      = make([]byte, 16)
 		macSecret      = make([]byte, 16)
@@ -348,30 +415,7 @@ func TestRLPXFrameRW(t *testing.T) {
 	s2.EgressMAC.Write(ingressMACinit)
 	s2.IngressMAC.Write(egressMACinit)
 	rw2 := newRLPXFrameRW(conn, s2)
-
-	// send some messages
-	for i := 0; i < 10; i++ {
-		// write message into conn buffer
-		wmsg := []interface{}{"foo", "bar", strings.Repeat("test", i)}
-		err := Send(rw1, uint64(i), wmsg)
-		if err != nil {
-			t.Fatalf("WriteMsg error (i=%d): %v", i, err)
-		}
-
-		// read message that rw1 just wrote
-		msg, err := rw2.ReadMsg()
-		if err != nil {
-			t.Fatalf("ReadMsg error (i=%d): %v", i, err)
-		}
-		if msg.Code != uint64(i) {
-			t.Fatalf("msg code mismatch: got %d, want %d", msg.Code, i)
-		}
-		payload, _ := ioutil.ReadAll(msg.Payload)
-		wantPayload, _ := rlp.EncodeToBytes(wmsg)
-		if !bytes.Equal(payload, wantPayload) {
-			t.Fatalf("msg payload mismatch:\ngot  %x\nwant %x", payload, wantPayload)
-		}
-	}
+	return rw1, rw2
 }
 
 type handshakeAuthTest struct {
@@ -30,6 +30,8 @@ import (
 	"github.com/immesys/bw2bc/log"
 	"github.com/immesys/bw2bc/p2p/discover"
 	"github.com/immesys/bw2bc/p2p/discv5"
+	"github.com/immesys/bw2bc/p2p/dnsdisc"
+	"github.com/immesys/bw2bc/p2p/enr"
 	"github.com/immesys/bw2bc/p2p/nat"
 	"github.com/immesys/bw2bc/p2p/netutil"
 )
@@ -51,9 +53,22 @@ const (
 
 	// Maximum amount of time allowed for writing a complete message.
 	frameWriteTimeout = 20 * time.Second
+
+	// Default time a node or IP range stays banned.
+	defaultBanDuration = time.Hour
+
+	// Default number of protocol faults tolerated before banning a node.
+	defaultMaxFaults = 3
+
+	// Default subnet sizes for the per-subnet peer limit.
+	defaultSubnetPrefixV4 = 24
+	defaultSubnetPrefixV6 = 64
 )
 
-var errServerStopped = errors.New("server stopped")
+var (
+	errServerStopped = errors.New("server stopped")
+	errBannedPeer    = errors.New("banned peer")
+)
 
 // Config holds Server options.
 type Config struct {
@@ -134,6 +149,59 @@ type Config struct {
 
 	// If NoDial is true, the server will not dial any peers.
 	NoDial bool `toml:",omitempty"`
+
+	// BanDuration is the time a node or IP range stays banned after exceeding
+	// MaxFaults or being banned without an explicit duration.
+	// Zero defaults to preset values.
+	BanDuration time.Duration `toml:",omitempty"`
+
+	// MaxFaults is the number of protocol faults a node may commit before it
+	// is banned. Zero defaults to preset values.
+	MaxFaults int `toml:",omitempty"`
+
+	// InboundRatio and OutboundRatio limit the share of MaxPeers, in percent,
+	// that inbound and dialed connections may occupy respectively. Trusted and
+	// static peers are exempt. Zero disables the limit.
+	InboundRatio  int `toml:",omitempty"`
+	OutboundRatio int `toml:",omitempty"`
+
+	// MaxSubnetPeers is the maximum number of peers that may be connected from
+	// within the same IP subnet. The subnet sizes are given by SubnetPrefixV4
+	// and SubnetPrefixV6. Trusted and static peers are exempt. Zero disables the
+	// limit.
+	MaxSubnetPeers int `toml:",omitempty"`
+
+	// SubnetPrefixV4 and SubnetPrefixV6 are the prefix lengths used to group
+	// peers by subnet. Zero defaults to preset values.
+	SubnetPrefixV4 int `toml:",omitempty"`
+	SubnetPrefixV6 int `toml:",omitempty"`
+
+	// ReservedPeers maps protocol names to the number of peer slots that are
+	// kept free for peers running that protocol. Peers not running it may only
+	// use the remaining slots.
+	ReservedPeers map[string]int `toml:",omitempty"`
+
+	// DNSDiscovery contains the URLs of node lists published in DNS. Nodes
+	// found in them are dialed alongside the ones found by the discovery
+	// table, and are used to bootstrap the table in place of BootstrapNodes.
+	DNSDiscovery []string `toml:",omitempty"`
+
+	// Capabilities lists the BOSSWAVE specific services offered by this node,
+	// such as "router", "lightserver" or "swarm". They are advertised in the
+	// "bw2" entry of the node record.
+	Capabilities []string `toml:",omitempty"`
+
+	// MaxIngressRate and MaxEgressRate limit the combined inbound and outbound
+	// traffic of all peer connections, in bytes per second. Zero means
+	// unlimited.
+	MaxIngressRate int `toml:",omitempty"`
+	MaxEgressRate  int `toml:",omitempty"`
+
+	// PeerIngressRate and PeerEgressRate limit the inbound and outbound traffic
+	// of every single peer connection, in bytes per second. Zero means
+	// unlimited.
+	PeerIngressRate int `toml:",omitempty"`
+	PeerEgressRate  int `toml:",omitempty"`
 }
 
 // Server manages all peer connections.
@@ -145,16 +213,29 @@ type Server struct {
 	// the whole protocol stack.
 	newTransport func(net.Conn) transport
 	newPeerHook  func(*Peer)
+	dnsResolver  dnsdisc.Resolver
 
 	lock    sync.Mutex // protects running
 	running bool
 
 	ntab         discoverTable
+	rep          *discover.Reputation
 	listener     net.Listener
 	ourHandshake *protoHandshake
 	lastLookup   time.Time
 	DiscV5       *discv5.Network
 
+	ingressLimit *tokenBucket // global inbound traffic limiter, nil if unlimited
+	egressLimit  *tokenBucket // global outbound traffic limiter, nil if unlimited
+
+	record *enr.Record // signed record of the local node
+
+	dnsLock  sync.Mutex       // protects dnsNodes
+	dnsNodes []*discover.Node // nodes found via DNS discovery
+
+	servicesLock sync.Mutex                 // protects services
+	services     map[*ServicePeers]struct{} // running service searches feeding the dialer
+
 	// These are for Peers, PeerCount (and nothing else).
 	peerOp     chan peerOpFunc
 	peerOpDone chan struct{}
@@ -162,10 +243,12 @@ type Server struct {
 	quit          chan struct{}
 	addstatic     chan *discover.Node
 	removestatic  chan *discover.Node
+	addtrusted    chan *discover.Node
+	removetrusted chan *discover.Node
 	posthandshake chan *conn
 	addpeer       chan *conn
 	delpeer       chan peerDrop
-	loopWG        sync.WaitGroup // loop, listenLoop
+	loopWG        sync.WaitGroup // loop, listenLoop, dnsLoop
 }
 
 type peerOpFunc func(map[discover.NodeID]*Peer)
@@ -291,6 +374,24 @@ func (srv *Server) RemovePeer(node *discover.Node) {
 	}
 }
 
+// AddTrustedPeer adds the given node to the trusted set, allowing it to always
+// connect, even above the peer limit.
+func (srv *Server) AddTrustedPeer(node *discover.Node) {
+	select {
+	case srv.addtrusted <- node:
+	case <-srv.quit:
+	}
+}
+
+// RemoveTrustedPeer removes the given node from the trusted set. An existing
+// connection is kept, but counts against the peer limit from then on.
+func (srv *Server) RemoveTrustedPeer(node *discover.Node) {
+	select {
+	case srv.removetrusted <- node:
+	case <-srv.quit:
+	}
+}
+
 // Self returns the local node's endpoint information.
 func (srv *Server) Self() *discover.Node {
 	srv.lock.Lock()
@@ -337,6 +438,9 @@ func (srv *Server) Stop() {
 	}
 	close(srv.quit)
 	srv.loopWG.Wait()
+	if srv.rep != nil {
+		srv.rep.Close()
+	}
 }
 
 // Start starts running the server.
@@ -366,8 +470,12 @@ func (srv *Server) Start() (err error) {
 	srv.posthandshake = make(chan *conn)
 	srv.addstatic = make(chan *discover.Node)
 	srv.removestatic = make(chan *discover.Node)
+	srv.addtrusted = make(chan *discover.Node)
+	srv.removetrusted = make(chan *discover.Node)
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
+	srv.ingressLimit = newTokenBucket(srv.MaxIngressRate)
+	srv.egressLimit = newTokenBucket(srv.MaxEgressRate)
 
 	// node table
 	if !srv.NoDiscovery {
@@ -379,6 +487,13 @@ func (srv *Server) Start() (err error) {
 			return err
 		}
 		srv.ntab = ntab
+		srv.rep = ntab.Reputation()
+	} else {
+		rep, err := discover.NewReputation(srv.NodeDatabase, discover.PubkeyID(&srv.PrivateKey.PublicKey))
+		if err != nil {
+			return err
+		}
+		srv.rep = rep
 	}
 
 	if srv.DiscoveryV5 {
@@ -392,11 +507,12 @@ func (srv *Server) Start() (err error) {
 		srv.DiscV5 = ntab
 	}
 
-	dynPeers := (srv.MaxPeers + 1) / 2
-	if srv.NoDiscovery {
+	dynPeers := srv.maxDialedConns()
+	if srv.NoDiscovery && len(srv.DNSDiscovery) == 0 {
 		dynPeers = 0
 	}
 	dialer := newDialState(srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict)
+	dialer.services = srv
 
 	// handshake
 	srv.ourHandshake = &protoHandshake{Version: baseProtocolVersion, Name: srv.Name, ID: discover.PubkeyID(&srv.PrivateKey.PublicKey)}
@@ -413,6 +529,13 @@ func (srv *Server) Start() (err error) {
 		log.Warn("P2P server will be useless, neither dialing nor listening")
 	}
 
+	if err := srv.setupRecord(); err != nil {
+		return err
+	}
+	if len(srv.DNSDiscovery) > 0 {
+		srv.loopWG.Add(1)
+		go srv.dnsLoop()
+	}
 	srv.loopWG.Add(1)
 	go srv.run(dialer)
 	srv.running = true
@@ -458,8 +581,8 @@ func (srv *Server) run(dialstate dialer) {
 		queuedTasks  []task // tasks that can't run yet
 	)
 	// Put trusted nodes into a map to speed up checks.
-	// Trusted peers are loaded on startup and cannot be
-	// modified while the server is running.
+	// Trusted peers are loaded on startup and can be
+	// modified through AddTrustedPeer and RemoveTrustedPeer.
 	for _, n := range srv.TrustedNodes {
 		trusted[n.ID] = true
 	}
@@ -517,6 +640,16 @@ running:
 			if p, ok := peers[n.ID]; ok {
 				p.Disconnect(DiscRequested)
 			}
+		case n := <-srv.addtrusted:
+			// This channel is used by AddTrustedPeer to add a node
+			// to the trusted set, exempting it from the peer limit.
+			log.Debug("Adding trusted node", "node", n)
+			trusted[n.ID] = true
+		case n := <-srv.removetrusted:
+			// This channel is used by RemoveTrustedPeer to remove a
+			// node from the trusted set.
+			log.Debug("Removing trusted node", "node", n)
+			delete(trusted, n.ID)
 		case op := <-srv.peerOp:
 			// This channel is used by Peers and PeerCount.
 			op(peers)
@@ -544,9 +677,11 @@ running:
 			if err == nil {
 				// The handshakes are done and it passed all checks.
 				p := newPeer(c, srv.Protocols)
+				p.faultHook = srv.peerFault
 				name := truncateName(c.name)
 				log.Debug("Adding p2p peer", "id", c.id, "name", name, "addr", c.fd.RemoteAddr(), "peers", len(peers)+1)
 				peers[c.id] = p
+				srv.updatePeerMetrics(peers)
 				go srv.runPeer(p)
 			}
 			// The dialer logic relies on the assumption that
@@ -558,6 +693,7 @@ running:
 			d := common.PrettyDuration(mclock.Now() - pd.created)
 			pd.log.Debug("Removing p2p peer", "duration", d, "peers", len(peers)-1, "req", pd.requested, "err", pd.err)
 			delete(peers, pd.ID())
+			srv.updatePeerMetrics(peers)
 		}
 	}
 
@@ -591,7 +727,10 @@ func (srv *Server) protoHandshakeChecks(peers map[discover.NodeID]*Peer, c *conn
 	}
 	// Repeat the encryption handshake checks because the
 	// peer set might have changed between the handshakes.
-	return srv.encHandshakeChecks(peers, c)
+	if err := srv.encHandshakeChecks(peers, c); err != nil {
+		return err
+	}
+	return srv.reservationChecks(peers, c)
 }
 
 func (srv *Server) encHandshakeChecks(peers map[discover.NodeID]*Peer, c *conn) error {
@@ -602,8 +741,10 @@ func (srv *Server) encHandshakeChecks(peers map[discover.NodeID]*Peer, c *conn)
 		return DiscAlreadyConnected
 	case c.id == srv.Self().ID:
 		return DiscSelf
+	case srv.rep != nil && srv.rep.NodeBanned(c.id):
+		return errBannedPeer
 	default:
-		return nil
+		return srv.quotaChecks(peers, c)
 	}
 }
 
@@ -659,7 +800,7 @@ func (srv *Server) listenLoop() {
 			}
 		}
 
-		fd = newMeteredConn(fd, true)
+		fd = srv.newMeteredConn(fd, true)
 		log.Trace("Accepted connection", "addr", fd.RemoteAddr())
 
 		// Spawn the handler. It will give the slot back when the connection
@@ -684,6 +825,12 @@ func (srv *Server) setupConn(fd net.Conn, flags connFlag, dialDest *discover.Nod
 		c.close(errServerStopped)
 		return
 	}
+	// Reject connections from and to banned IP ranges.
+	if tcp, ok := fd.RemoteAddr().(*net.TCPAddr); ok && srv.rep != nil && srv.rep.IPBanned(tcp.IP) {
+		log.Trace("Rejected conn (banned IP range)", "addr", fd.RemoteAddr(), "conn", flags)
+		c.close(errBannedPeer)
+		return
+	}
 	// Run the encryption handshake.
 	var err error
 	if c.id, err = c.doEncHandshake(srv.PrivateKey, dialDest); err != nil {
@@ -766,6 +913,7 @@ type NodeInfo struct {
 	ID    string `json:"id"`    // Unique node identifier (also the encryption key)
 	Name  string `json:"name"`  // Name of the node, including client type, version, OS, custom data
 	Enode string `json:"enode"` // Enode URL for adding this peer from remote peers
+	ENR   string `json:"enr"`   // Signed node record of this peer
 	IP    string `json:"ip"`    // IP address of the node
 	Ports struct {
 		Discovery int `json:"discovery"` // UDP listening port for discovery protocol
@@ -788,6 +936,9 @@ func (srv *Server) NodeInfo() *NodeInfo {
 		ListenAddr: srv.ListenAddr,
 		Protocols:  make(map[string]interface{}),
 	}
+	if record := srv.Record(); record != nil {
+		info.ENR = record.String()
+	}
 	info.Ports.Discovery = int(node.UDP)
 	info.Ports.Listener = int(node.TCP)
 
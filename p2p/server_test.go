@@ -359,6 +359,49 @@ func TestServerAtCap(t *testing.T) {
 
 }
 
+// Tests that the trusted set can be modified while the server is running.
+func TestServerTrustedPeerUpdates(t *testing.T) {
+	srv := &Server{
+		Config: Config{
+			PrivateKey: newkey(),
+			MaxPeers:   1,
+			NoDial:     true,
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	newconn := func(id discover.NodeID) *conn {
+		fd, _ := net.Pipe()
+		tx := newTestTransport(id, fd)
+		return &conn{fd: fd, transport: tx, flags: inboundConn, id: id, cont: make(chan error)}
+	}
+	// Fill up the peer set, rejecting any further untrusted connection.
+	if err := srv.checkpoint(newconn(randomID()), srv.addpeer); err != nil {
+		t.Fatalf("could not add conn: %v", err)
+	}
+	node := &discover.Node{ID: randomID()}
+	if err := srv.checkpoint(newconn(node.ID), srv.posthandshake); err != DiscTooManyPeers {
+		t.Errorf("wrong error for untrusted conn: %v", err)
+	}
+	// Trust the node and check that it's let in.
+	srv.AddTrustedPeer(node)
+	c := newconn(node.ID)
+	if err := srv.checkpoint(c, srv.posthandshake); err != nil {
+		t.Errorf("unexpected error for trusted conn: %v", err)
+	}
+	if !c.is(trustedConn) {
+		t.Error("server did not set trusted flag")
+	}
+	// Revoke the trust and check that it's rejected again.
+	srv.RemoveTrustedPeer(node)
+	if err := srv.checkpoint(newconn(node.ID), srv.posthandshake); err != DiscTooManyPeers {
+		t.Errorf("wrong error for revoked conn: %v", err)
+	}
+}
+
 func TestServerSetupConn(t *testing.T) {
 	id := randomID()
 	srvkey := newkey()
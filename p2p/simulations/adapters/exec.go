@@ -0,0 +1,347 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/node"
+	"github.com/immesys/bw2bc/p2p"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/rpc"
+)
+
+// execCommandName is the argv[0] the exec adapter runs its child processes
+// with, signalling RegisterServices to run a node instead of the caller's code.
+const execCommandName = "p2p-node"
+
+const (
+	execStartTimeout = 30 * time.Second      // Time allowed for a child process to boot up
+	execStopTimeout  = 10 * time.Second      // Time allowed for a child process to shut down
+	execPeerTimeout  = 10 * time.Second      // Time allowed for peer connections to be set up or torn down
+	execPeerPoll     = 50 * time.Millisecond // Interval at which peer connections are polled
+)
+
+var errExecTimeout = errors.New("timed out waiting for node")
+
+// ExecAdapter runs every simulated node in a separate child process, which
+// executes the current binary. Nodes listen on the loopback interface and are
+// controlled over their IPC endpoints, so the simulation stays on one machine.
+type ExecAdapter struct {
+	BaseDir string // Directory holding the data directories of the nodes
+}
+
+// NewExecAdapter creates an adapter keeping node data in the given directory.
+func NewExecAdapter(baseDir string) *ExecAdapter {
+	return &ExecAdapter{BaseDir: baseDir}
+}
+
+// Name implements NodeAdapter.
+func (e *ExecAdapter) Name() string {
+	return "exec-adapter"
+}
+
+// NewNode implements NodeAdapter, creating a node backed by a child process.
+func (e *ExecAdapter) NewNode(config *NodeConfig) (Node, error) {
+	if config.PrivateKey == nil {
+		return nil, fmt.Errorf("node %q has no private key", config.Name)
+	}
+	dir := filepath.Join(e.BaseDir, fmt.Sprintf("%x", config.ID[:8]))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &ExecNode{config: config, dir: dir}, nil
+}
+
+// ExecNode is a simulated node running in a child process.
+type ExecNode struct {
+	config *NodeConfig
+	dir    string
+
+	lock   sync.RWMutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	client *rpc.Client
+	info   *p2p.NodeInfo
+}
+
+// ID implements Node.
+func (n *ExecNode) ID() discover.NodeID {
+	return n.config.ID
+}
+
+// Start implements Node, spawning the child process and waiting until its node
+// is up and reachable over IPC.
+func (n *ExecNode) Start() (err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.cmd != nil {
+		return errNodeRunning
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	config, err := json.Marshal(n.config)
+	if err != nil {
+		return err
+	}
+	logfile, err := os.OpenFile(filepath.Join(n.dir, "node.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logfile.Close()
+
+	cmd := &exec.Cmd{
+		Path:   self,
+		Args:   []string{execCommandName, string(config), n.dir},
+		Stderr: logfile,
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			stdin.Close()
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}()
+	// Wait for the child to report its node info, signalling readiness
+	infoc, errc := make(chan *p2p.NodeInfo, 1), make(chan error, 1)
+	go func() {
+		info := new(p2p.NodeInfo)
+		line, err := bufio.NewReader(stdout).ReadBytes('\n')
+		if err == nil {
+			err = json.Unmarshal(line, info)
+		}
+		if err != nil {
+			errc <- fmt.Errorf("node %s failed to start: %v", n.config.Name, err)
+			return
+		}
+		infoc <- info
+	}()
+	var info *p2p.NodeInfo
+	select {
+	case info = <-infoc:
+	case err := <-errc:
+		return err
+	case <-time.After(execStartTimeout):
+		return errExecTimeout
+	}
+	client, err := rpc.Dial(filepath.Join(n.dir, "node.ipc"))
+	if err != nil {
+		return err
+	}
+	n.cmd, n.stdin, n.client, n.info = cmd, stdin, client, info
+	log.Debug("Started exec node", "id", n.config.ID.TerminalString(), "name", n.config.Name, "pid", cmd.Process.Pid)
+	return nil
+}
+
+// Stop implements Node, asking the child process to shut down by closing its
+// standard input and killing it if it fails to exit in time.
+func (n *ExecNode) Stop() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.cmd == nil {
+		return errNodeNotRunning
+	}
+	n.client.Close()
+	n.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- n.cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(execStopTimeout):
+		n.cmd.Process.Kill()
+		err = <-done
+	}
+	n.cmd, n.stdin, n.client, n.info = nil, nil, nil, nil
+	log.Debug("Stopped exec node", "id", n.config.ID.TerminalString(), "name", n.config.Name)
+	return err
+}
+
+// Running implements Node.
+func (n *ExecNode) Running() bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.cmd != nil
+}
+
+// Client implements Node, returning an RPC client connected over IPC.
+func (n *ExecNode) Client() (*rpc.Client, error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	if n.client == nil {
+		return nil, errNodeNotRunning
+	}
+	return n.client, nil
+}
+
+// NodeInfo implements Node.
+func (n *ExecNode) NodeInfo() *p2p.NodeInfo {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	if n.info == nil {
+		return &p2p.NodeInfo{ID: n.config.ID.String(), Name: n.config.Name}
+	}
+	return n.info
+}
+
+// Connect implements Node, instructing the child to dial the given peer.
+func (n *ExecNode) Connect(peer Node) error {
+	remote, ok := peer.(*ExecNode)
+	if !ok {
+		return errAdapterMixed
+	}
+	client, err := n.Client()
+	if err != nil {
+		return err
+	}
+	if _, err := remote.Client(); err != nil {
+		return err
+	}
+	if err := client.Call(nil, "admin_addPeer", remote.NodeInfo().Enode); err != nil {
+		return err
+	}
+	return n.waitPeer(remote.ID(), true)
+}
+
+// Disconnect implements Node, removing the peer on both sides so neither of
+// them redials the other.
+func (n *ExecNode) Disconnect(peer Node) error {
+	remote, ok := peer.(*ExecNode)
+	if !ok {
+		return errAdapterMixed
+	}
+	for _, pair := range [][2]*ExecNode{{n, remote}, {remote, n}} {
+		client, err := pair[0].Client()
+		if err != nil {
+			return err
+		}
+		if err := client.Call(nil, "admin_removePeer", pair[1].NodeInfo().Enode); err != nil {
+			return err
+		}
+	}
+	return n.waitPeer(remote.ID(), false)
+}
+
+// waitPeer polls the peer set of the node until the given peer is present or
+// absent, as requested.
+func (n *ExecNode) waitPeer(id discover.NodeID, present bool) error {
+	client, err := n.Client()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(execPeerTimeout)
+	for time.Now().Before(deadline) {
+		var peers []*p2p.PeerInfo
+		if err := client.Call(&peers, "admin_peers"); err != nil {
+			return err
+		}
+		found := false
+		for _, peer := range peers {
+			if peer.ID == id.String() {
+				found = true
+				break
+			}
+		}
+		if found == present {
+			return nil
+		}
+		time.Sleep(execPeerPoll)
+	}
+	return errExecTimeout
+}
+
+// execP2PNode is the entry point of the child processes spawned by the exec
+// adapter. It runs the node described by its arguments until stdin is closed.
+func execP2PNode() {
+	if len(os.Args) != 3 {
+		fatalf("usage: %s <config> <datadir>", execCommandName)
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	var config NodeConfig
+	if err := json.Unmarshal([]byte(os.Args[1]), &config); err != nil {
+		fatalf("invalid node config: %v", err)
+	}
+	stack, err := node.New(&node.Config{
+		Name:    config.Name,
+		DataDir: os.Args[2],
+		IPCPath: "node.ipc",
+		P2P: p2p.Config{
+			PrivateKey:  config.PrivateKey,
+			ListenAddr:  "127.0.0.1:0",
+			MaxPeers:    50,
+			NoDiscovery: true,
+		},
+	})
+	if err != nil {
+		fatalf("failed to create node: %v", err)
+	}
+	constructors, err := serviceConstructors(&config, nil)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	for _, constructor := range constructors {
+		if err := stack.Register(constructor); err != nil {
+			fatalf("failed to register service: %v", err)
+		}
+	}
+	if err := stack.Start(); err != nil {
+		fatalf("failed to start node: %v", err)
+	}
+	// Report readiness to the parent and run until it closes our stdin
+	if err := json.NewEncoder(os.Stdout).Encode(stack.Server().NodeInfo()); err != nil {
+		fatalf("failed to report node info: %v", err)
+	}
+	io.Copy(ioutil.Discard, os.Stdin)
+	stack.Stop()
+}
+
+// fatalf reports a fatal error of a child process and terminates it.
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Fatal: "+format+"\n", args...)
+	os.Exit(1)
+}
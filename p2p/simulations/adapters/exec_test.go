@@ -0,0 +1,126 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/immesys/bw2bc/node"
+	"github.com/immesys/bw2bc/p2p"
+	"github.com/immesys/bw2bc/rpc"
+)
+
+func TestMain(m *testing.M) {
+	RegisterServices(Services{"idle": newIdleService})
+	os.Exit(m.Run())
+}
+
+// idleService runs a protocol which keeps its peers connected without sending
+// anything.
+type idleService struct{}
+
+func newIdleService(ctx *ServiceContext) (node.Service, error) {
+	return idleService{}, nil
+}
+
+func (idleService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    "idle",
+		Version: 1,
+		Length:  1,
+		Run: func(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+			}
+		},
+	}}
+}
+
+func (idleService) APIs() []rpc.API                { return nil }
+func (idleService) Start(server *p2p.Server) error { return nil }
+func (idleService) Stop() error                    { return nil }
+
+// Tests that exec nodes run in child processes which can be controlled over RPC,
+// connected to each other and restarted.
+func TestExecAdapter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2p-exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	adapter := NewExecAdapter(dir)
+	if _, err := adapter.NewNode(&NodeConfig{Name: "keyless"}); err == nil {
+		t.Fatalf("node without private key accepted")
+	}
+	nodes := make([]Node, 2)
+	for i := range nodes {
+		config := RandomNodeConfig()
+		config.Services = []string{"idle"}
+		if nodes[i], err = adapter.NewNode(config); err != nil {
+			t.Fatalf("node %d: failed to create: %v", i, err)
+		}
+		if err := nodes[i].Start(); err != nil {
+			t.Fatalf("node %d: failed to start: %v", i, err)
+		}
+		defer nodes[i].Stop()
+
+		if err := nodes[i].Start(); err != errNodeRunning {
+			t.Fatalf("node %d: double start error mismatch: have %v, want %v", i, err, errNodeRunning)
+		}
+		client, err := nodes[i].Client()
+		if err != nil {
+			t.Fatalf("node %d: failed to get client: %v", i, err)
+		}
+		var info p2p.NodeInfo
+		if err := client.Call(&info, "admin_nodeInfo"); err != nil {
+			t.Fatalf("node %d: failed to query node info: %v", i, err)
+		}
+		if info.ID != config.ID.String() || info.Enode != nodes[i].NodeInfo().Enode {
+			t.Fatalf("node %d: info mismatch: have %s, want %s", i, info.Enode, nodes[i].NodeInfo().Enode)
+		}
+	}
+	if err := nodes[0].Connect(nodes[1]); err != nil {
+		t.Fatalf("failed to connect nodes: %v", err)
+	}
+	if err := nodes[0].Disconnect(nodes[1]); err != nil {
+		t.Fatalf("failed to disconnect nodes: %v", err)
+	}
+	// Restart a node and ensure it comes back up
+	if err := nodes[1].Stop(); err != nil {
+		t.Fatalf("failed to stop node: %v", err)
+	}
+	if nodes[1].Running() {
+		t.Fatalf("node running after stop")
+	}
+	if err := nodes[1].Stop(); err != errNodeNotRunning {
+		t.Fatalf("double stop error mismatch: have %v, want %v", err, errNodeNotRunning)
+	}
+	if err := nodes[1].Start(); err != nil {
+		t.Fatalf("failed to restart node: %v", err)
+	}
+	// Dial from the restarted node, the other one doesn't redial recent peers
+	if err := nodes[1].Connect(nodes[0]); err != nil {
+		t.Fatalf("failed to reconnect nodes: %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/immesys/bw2bc/event"
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/node"
+	"github.com/immesys/bw2bc/p2p"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/rpc"
+)
+
+// SimAdapter runs simulated nodes inside the current process. The nodes run a
+// real node stack without any network listener, and connections between them
+// are established over in-memory message pipes.
+type SimAdapter struct {
+	mu    sync.Mutex // protects the peer sets of all the adapter's nodes
+	drops event.Feed // reports connections torn down by a terminating protocol
+}
+
+// NewSimAdapter creates an in-memory node adapter.
+func NewSimAdapter() *SimAdapter {
+	return &SimAdapter{}
+}
+
+// Name implements NodeAdapter.
+func (s *SimAdapter) Name() string {
+	return "sim-adapter"
+}
+
+// SubscribeDrops implements DropReporter.
+func (s *SimAdapter) SubscribeDrops(ch chan<- *ConnDrop) event.Subscription {
+	return s.drops.Subscribe(ch)
+}
+
+// NewNode implements NodeAdapter, creating a new in-memory node.
+func (s *SimAdapter) NewNode(config *NodeConfig) (Node, error) {
+	if config.PrivateKey == nil {
+		return nil, fmt.Errorf("node %q has no private key", config.Name)
+	}
+	return &SimNode{
+		adapter: s,
+		config:  config,
+		peers:   make(map[discover.NodeID]*simConn),
+	}, nil
+}
+
+// SimNode is an in-memory simulated node.
+type SimNode struct {
+	adapter *SimAdapter
+	config  *NodeConfig
+
+	lock     sync.RWMutex
+	node     *node.Node
+	services []node.Service
+	client   *rpc.Client
+
+	peers map[discover.NodeID]*simConn // guarded by the adapter lock
+}
+
+// simConn is an in-memory connection between two simulated nodes.
+type simConn struct {
+	one, other *SimNode
+	pipes      []*p2p.MsgPipeRW
+	once       sync.Once
+	wg         sync.WaitGroup
+}
+
+// detach removes the connection from the peer sets of both of its nodes,
+// returning whether it was still attached. The adapter lock must be held.
+func (c *simConn) detach() bool {
+	attached := false
+	if c.one.peers[c.other.ID()] == c {
+		delete(c.one.peers, c.other.ID())
+		attached = true
+	}
+	if c.other.peers[c.one.ID()] == c {
+		delete(c.other.peers, c.one.ID())
+		attached = true
+	}
+	return attached
+}
+
+// close terminates all the protocol pipes of the connection.
+func (c *simConn) close() {
+	c.once.Do(func() {
+		for _, pipe := range c.pipes {
+			pipe.Close()
+		}
+	})
+}
+
+// ID implements Node.
+func (n *SimNode) ID() discover.NodeID {
+	return n.config.ID
+}
+
+// Start implements Node, booting the node stack with the configured services.
+func (n *SimNode) Start() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.node != nil {
+		return errNodeRunning
+	}
+	stack, err := node.New(&node.Config{
+		Name: n.config.Name,
+		P2P: p2p.Config{
+			PrivateKey:  n.config.PrivateKey,
+			MaxPeers:    0,
+			NoDiscovery: true,
+			NoDial:      true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	var services []node.Service
+	constructors, err := serviceConstructors(n.config, func(service node.Service) {
+		services = append(services, service)
+	})
+	if err != nil {
+		return err
+	}
+	for _, constructor := range constructors {
+		if err := stack.Register(constructor); err != nil {
+			return err
+		}
+	}
+	if err := stack.Start(); err != nil {
+		return err
+	}
+	client, err := stack.Attach()
+	if err != nil {
+		stack.Stop()
+		return err
+	}
+	n.node, n.services, n.client = stack, services, client
+	log.Debug("Started simulated node", "id", n.config.ID.TerminalString(), "name", n.config.Name)
+	return nil
+}
+
+// Stop implements Node, dropping all peers and tearing down the node stack.
+func (n *SimNode) Stop() error {
+	n.adapter.mu.Lock()
+	var conns []*simConn
+	for _, conn := range n.peers {
+		conns = append(conns, conn)
+		conn.detach()
+	}
+	n.adapter.mu.Unlock()
+	for _, conn := range conns {
+		conn.close()
+		conn.wg.Wait()
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.node == nil {
+		return errNodeNotRunning
+	}
+	n.client.Close()
+	err := n.node.Stop()
+	n.node, n.services, n.client = nil, nil, nil
+	log.Debug("Stopped simulated node", "id", n.config.ID.TerminalString(), "name", n.config.Name)
+	return err
+}
+
+// Running implements Node.
+func (n *SimNode) Running() bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.node != nil
+}
+
+// Client implements Node, returning an in-process RPC client.
+func (n *SimNode) Client() (*rpc.Client, error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	if n.client == nil {
+		return nil, errNodeNotRunning
+	}
+	return n.client, nil
+}
+
+// NodeInfo implements Node.
+func (n *SimNode) NodeInfo() *p2p.NodeInfo {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	if n.node == nil {
+		return &p2p.NodeInfo{ID: n.config.ID.String(), Name: n.config.Name}
+	}
+	return n.node.Server().NodeInfo()
+}
+
+// protocols returns the protocols run by the services of the node.
+func (n *SimNode) protocols() []p2p.Protocol {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	var protos []p2p.Protocol
+	for _, service := range n.services {
+		protos = append(protos, service.Protocols()...)
+	}
+	return protos
+}
+
+// Connect implements Node, running every protocol shared by the two nodes over
+// a dedicated message pipe.
+func (n *SimNode) Connect(peer Node) error {
+	remote, ok := peer.(*SimNode)
+	if !ok || remote.adapter != n.adapter {
+		return errAdapterMixed
+	}
+	if !n.Running() || !remote.Running() {
+		return errNodeNotRunning
+	}
+	n.adapter.mu.Lock()
+	defer n.adapter.mu.Unlock()
+
+	if _, ok := n.peers[remote.ID()]; ok {
+		return fmt.Errorf("already connected to %s", remote.ID().TerminalString())
+	}
+	local, other := n.protocols(), remote.protocols()
+	localPeer := p2p.NewPeer(remote.ID(), remote.config.Name, protocolCaps(other))
+	remotePeer := p2p.NewPeer(n.ID(), n.config.Name, protocolCaps(local))
+
+	conn := &simConn{one: n, other: remote}
+	for _, lp := range local {
+		for _, rp := range other {
+			if lp.Name != rp.Name || lp.Version != rp.Version {
+				continue
+			}
+			lrw, rrw := p2p.MsgPipe()
+			conn.pipes = append(conn.pipes, lrw, rrw)
+			n.runProtocol(conn, remote, lp, localPeer, lrw)
+			remote.runProtocol(conn, n, rp, remotePeer, rrw)
+		}
+	}
+	n.peers[remote.ID()] = conn
+	remote.peers[n.ID()] = conn
+	return nil
+}
+
+// runProtocol runs a single protocol of the node for the given connection,
+// tearing down the whole connection once it terminates. Connections dropped this
+// way are reported to the subscribers of the adapter.
+func (n *SimNode) runProtocol(conn *simConn, remote *SimNode, proto p2p.Protocol, peer *p2p.Peer, rw p2p.MsgReadWriter) {
+	conn.wg.Add(1)
+	go func() {
+		defer conn.wg.Done()
+
+		err := proto.Run(peer, rw)
+		log.Trace("Simulated protocol terminated", "node", n.config.Name, "peer", remote.config.Name, "proto", proto.Name, "err", err)
+		conn.close()
+
+		n.adapter.mu.Lock()
+		dropped := conn.detach()
+		n.adapter.mu.Unlock()
+
+		if dropped {
+			n.adapter.drops.Send(&ConnDrop{One: conn.one.ID(), Other: conn.other.ID()})
+		}
+	}()
+}
+
+// Disconnect implements Node.
+func (n *SimNode) Disconnect(peer Node) error {
+	n.adapter.mu.Lock()
+	conn, ok := n.peers[peer.ID()]
+	if ok {
+		conn.detach()
+	}
+	n.adapter.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("not connected to %s", peer.ID().TerminalString())
+	}
+	conn.close()
+	conn.wg.Wait()
+	return nil
+}
+
+// Peers returns the identifiers of the nodes currently connected to this one.
+func (n *SimNode) Peers() []discover.NodeID {
+	n.adapter.mu.Lock()
+	defer n.adapter.mu.Unlock()
+
+	ids := make([]discover.NodeID, 0, len(n.peers))
+	for id := range n.peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// protocolCaps converts a list of protocols into the capabilities advertised.
+func protocolCaps(protos []p2p.Protocol) []p2p.Cap {
+	caps := make([]p2p.Cap, 0, len(protos))
+	for _, proto := range protos {
+		caps = append(caps, p2p.Cap{Name: proto.Name, Version: proto.Version})
+	}
+	return caps
+}
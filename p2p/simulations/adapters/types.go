@@ -0,0 +1,218 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package adapters implements the node backends used by the p2p network
+// simulation framework. A node adapter knows how to create, start and stop a
+// simulated node and how to connect it to other nodes of the same kind.
+package adapters
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/immesys/bw2bc/crypto"
+	"github.com/immesys/bw2bc/event"
+	"github.com/immesys/bw2bc/node"
+	"github.com/immesys/bw2bc/p2p"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/rpc"
+)
+
+var (
+	errNodeRunning    = errors.New("node already running")
+	errNodeNotRunning = errors.New("node not running")
+	errAdapterMixed   = errors.New("cannot connect nodes of different adapters")
+)
+
+// Node represents a single simulated node managed by a NodeAdapter.
+type Node interface {
+	// ID returns the identifier of the node.
+	ID() discover.NodeID
+
+	// Start boots up the node and all of its configured services.
+	Start() error
+
+	// Stop tears down the node, dropping all of its connections.
+	Stop() error
+
+	// Running reports whether the node is currently up.
+	Running() bool
+
+	// Client returns an RPC client attached to the running node.
+	Client() (*rpc.Client, error)
+
+	// NodeInfo returns the p2p level information about the running node.
+	NodeInfo() *p2p.NodeInfo
+
+	// Connect establishes a connection to the given peer, returning once the
+	// protocols of both sides are running.
+	Connect(peer Node) error
+
+	// Disconnect drops the connection to the given peer, returning once the
+	// protocols of both sides have terminated.
+	Disconnect(peer Node) error
+}
+
+// NodeAdapter creates nodes of a particular backend kind.
+type NodeAdapter interface {
+	// Name returns the name of the adapter for logging purposes.
+	Name() string
+
+	// NewNode creates a new, stopped node with the given configuration.
+	NewNode(config *NodeConfig) (Node, error)
+}
+
+// ConnDrop reports a connection between two nodes which terminated on its own,
+// e.g. because a protocol returned, instead of through Node.Disconnect or Node.Stop.
+type ConnDrop struct {
+	One   discover.NodeID // Node which initiated the connection
+	Other discover.NodeID // Node which was connected to
+}
+
+// DropReporter is implemented by node adapters able to report connections which
+// dropped on their own, so the owner of the nodes can track them.
+type DropReporter interface {
+	// SubscribeDrops registers a subscription for the dropped connections.
+	SubscribeDrops(ch chan<- *ConnDrop) event.Subscription
+}
+
+// NodeConfig is the configuration of a simulated node.
+type NodeConfig struct {
+	ID         discover.NodeID
+	PrivateKey *ecdsa.PrivateKey
+	Name       string
+	Services   []string // names of the registered services to run
+}
+
+// nodeConfigJSON is the serialisation format of a NodeConfig.
+type nodeConfigJSON struct {
+	ID         string   `json:"id"`
+	PrivateKey string   `json:"privateKey"`
+	Name       string   `json:"name"`
+	Services   []string `json:"services"`
+}
+
+// MarshalJSON implements json.Marshaler, encoding the private key in hex.
+func (n *NodeConfig) MarshalJSON() ([]byte, error) {
+	enc := nodeConfigJSON{
+		ID:       n.ID.String(),
+		Name:     n.Name,
+		Services: n.Services,
+	}
+	if n.PrivateKey != nil {
+		enc.PrivateKey = hex.EncodeToString(crypto.FromECDSA(n.PrivateKey))
+	}
+	return json.Marshal(enc)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *NodeConfig) UnmarshalJSON(data []byte) error {
+	var dec nodeConfigJSON
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	if dec.ID != "" {
+		id, err := discover.HexID(dec.ID)
+		if err != nil {
+			return err
+		}
+		n.ID = id
+	}
+	if dec.PrivateKey != "" {
+		key, err := crypto.HexToECDSA(dec.PrivateKey)
+		if err != nil {
+			return err
+		}
+		n.PrivateKey = key
+	}
+	n.Name = dec.Name
+	n.Services = dec.Services
+	return nil
+}
+
+// RandomNodeConfig returns a node configuration with a freshly generated key.
+func RandomNodeConfig() *NodeConfig {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		panic(fmt.Sprintf("unable to generate key: %v", err))
+	}
+	id := discover.PubkeyID(&key.PublicKey)
+	return &NodeConfig{
+		ID:         id,
+		PrivateKey: key,
+		Name:       fmt.Sprintf("node_%x", id[:4]),
+	}
+}
+
+// ServiceContext is passed to service constructors, giving them access to
+// both the simulation level node configuration and the underlying node.
+type ServiceContext struct {
+	Config      *NodeConfig
+	NodeContext *node.ServiceContext
+}
+
+// ServiceFunc constructs a named service for a simulated node.
+type ServiceFunc func(ctx *ServiceContext) (node.Service, error)
+
+// Services is a collection of named service constructors.
+type Services map[string]ServiceFunc
+
+// serviceFuncs is the registry of services available to simulated nodes.
+var serviceFuncs = make(Services)
+
+// RegisterServices makes the given services available to simulated nodes. It
+// must be called from an init function or TestMain, since processes spawned by
+// the exec adapter run the node in place of the caller's main code path.
+func RegisterServices(services Services) {
+	for name, f := range services {
+		if _, exists := serviceFuncs[name]; exists {
+			panic(fmt.Sprintf("node service already exists: %q", name))
+		}
+		serviceFuncs[name] = f
+	}
+	if os.Args[0] == execCommandName {
+		execP2PNode()
+		os.Exit(0)
+	}
+}
+
+// serviceConstructors resolves the configured services of a node into node
+// level constructors. The optional hook is invoked with every constructed
+// service.
+func serviceConstructors(config *NodeConfig, hook func(node.Service)) ([]node.ServiceConstructor, error) {
+	constructors := make([]node.ServiceConstructor, 0, len(config.Services))
+	for _, name := range config.Services {
+		f, ok := serviceFuncs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown node service %q", name)
+		}
+		constructors = append(constructors, func(ctx *node.ServiceContext) (node.Service, error) {
+			service, err := f(&ServiceContext{Config: config, NodeContext: ctx})
+			if err != nil {
+				return nil, err
+			}
+			if hook != nil {
+				hook(service)
+			}
+			return service, nil
+		})
+	}
+	return constructors, nil
+}
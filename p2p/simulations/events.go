@@ -0,0 +1,81 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType is the kind of change a network event describes.
+type EventType string
+
+const (
+	// EventTypeNode is the type of events describing a node being created,
+	// started or stopped.
+	EventTypeNode EventType = "node"
+
+	// EventTypeConn is the type of events describing a connection between two
+	// nodes going up or down.
+	EventTypeConn EventType = "conn"
+)
+
+// Event is a change in the state of a simulated network.
+type Event struct {
+	// Type is the kind of the event.
+	Type EventType `json:"type"`
+
+	// Time is the time at which the event happened.
+	Time time.Time `json:"time"`
+
+	// Node is a snapshot of the node the event is about, if a node event.
+	Node *Node `json:"node,omitempty"`
+
+	// Conn is a snapshot of the connection the event is about, if a
+	// connection event.
+	Conn *Conn `json:"conn,omitempty"`
+}
+
+// NewEvent creates an event describing the current state of the given node or
+// connection, which must be either a *Node or a *Conn.
+func NewEvent(v interface{}) *Event {
+	event := &Event{Time: time.Now()}
+	switch v := v.(type) {
+	case *Node:
+		event.Type = EventTypeNode
+		event.Node = v.copy()
+	case *Conn:
+		event.Type = EventTypeConn
+		conn := *v
+		event.Conn = &conn
+	default:
+		panic(fmt.Sprintf("invalid event value: %T", v))
+	}
+	return event
+}
+
+// String implements fmt.Stringer.
+func (e *Event) String() string {
+	switch e.Type {
+	case EventTypeNode:
+		return fmt.Sprintf("<node-event> id: %s up: %t", e.Node.ID().TerminalString(), e.Node.Up)
+	case EventTypeConn:
+		return fmt.Sprintf("<conn-event> nodes: %s->%s up: %t", e.Conn.One.TerminalString(), e.Conn.Other.TerminalString(), e.Conn.Up)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/simulations/adapters"
+)
+
+// Server is an HTTP control endpoint for a simulated network. It exposes the
+// following routes, where nodes may be referenced by hex ID or by name:
+//
+//	GET    /                         summary of the network
+//	GET    /nodes                    list of all nodes
+//	POST   /nodes                    create a node (optional JSON NodeConfig body)
+//	GET    /nodes/<node>             details of a node
+//	POST   /nodes/<node>/start       start a node
+//	POST   /nodes/<node>/stop        stop a node
+//	POST   /nodes/<node>/conn/<peer> connect two nodes
+//	DELETE /nodes/<node>/conn/<peer> disconnect two nodes
+//	GET    /events                   stream of network events as JSON lines
+type Server struct {
+	network *Network
+}
+
+// NewServer creates an HTTP control endpoint for the given network.
+func NewServer(network *Network) *Server {
+	return &Server{network: network}
+}
+
+// networkInfo is the summary of a network returned by the server.
+type networkInfo struct {
+	Adapter string  `json:"adapter"`
+	Nodes   []*Node `json:"nodes"`
+	Conns   []*Conn `json:"conns"`
+}
+
+// ServeHTTP implements http.Handler, dispatching requests to their routes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "" && req.Method == "GET":
+		s.sendJSON(w, http.StatusOK, &networkInfo{
+			Adapter: s.network.Adapter().Name(),
+			Nodes:   s.network.snapshotNodes(),
+			Conns:   s.network.snapshotConns(),
+		})
+
+	case len(parts) == 1 && parts[0] == "events" && req.Method == "GET":
+		s.streamEvents(w, req)
+
+	case len(parts) == 1 && parts[0] == "nodes" && req.Method == "GET":
+		s.sendJSON(w, http.StatusOK, s.network.snapshotNodes())
+
+	case len(parts) == 1 && parts[0] == "nodes" && req.Method == "POST":
+		s.createNode(w, req)
+
+	case len(parts) >= 2 && parts[0] == "nodes":
+		node := s.lookupNode(parts[1])
+		if node == nil {
+			http.Error(w, ErrNodeNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		s.serveNode(w, req, node, parts[2:])
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveNode handles the routes of a single node.
+func (s *Server) serveNode(w http.ResponseWriter, req *http.Request, node *Node, parts []string) {
+	var err error
+	switch {
+	case len(parts) == 0 && req.Method == "GET":
+		s.sendJSON(w, http.StatusOK, s.network.snapshotNode(node.ID()))
+		return
+
+	case len(parts) == 1 && parts[0] == "start" && req.Method == "POST":
+		err = s.network.Start(node.ID())
+
+	case len(parts) == 1 && parts[0] == "stop" && req.Method == "POST":
+		err = s.network.Stop(node.ID())
+
+	case len(parts) == 2 && parts[0] == "conn" && (req.Method == "POST" || req.Method == "DELETE"):
+		peer := s.lookupNode(parts[1])
+		if peer == nil {
+			http.Error(w, ErrNodeNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if req.Method == "POST" {
+			err = s.network.Connect(node.ID(), peer.ID())
+		} else {
+			err = s.network.Disconnect(node.ID(), peer.ID())
+		}
+		if err == nil {
+			s.sendJSON(w, http.StatusOK, s.network.snapshotConn(node.ID(), peer.ID()))
+			return
+		}
+
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.sendJSON(w, http.StatusOK, s.network.snapshotNode(node.ID()))
+}
+
+// createNode creates a new node from an optional configuration in the body.
+func (s *Server) createNode(w http.ResponseWriter, req *http.Request) {
+	config := adapters.RandomNodeConfig()
+	config.Name = ""
+	if req.ContentLength != 0 {
+		custom := new(adapters.NodeConfig)
+		if err := json.NewDecoder(req.Body).Decode(custom); err != nil {
+			http.Error(w, fmt.Sprintf("invalid node config: %v", err), http.StatusBadRequest)
+			return
+		}
+		if custom.PrivateKey == nil {
+			custom.ID, custom.PrivateKey = config.ID, config.PrivateKey
+		} else {
+			custom.ID = discover.PubkeyID(&custom.PrivateKey.PublicKey)
+		}
+		config = custom
+	}
+	node, err := s.network.NewNode(config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.sendJSON(w, http.StatusCreated, s.network.snapshotNode(node.ID()))
+}
+
+// streamEvents sends the events of the network as JSON lines until the client
+// goes away.
+func (s *Server) streamEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	events := make(chan *Event, 64)
+	sub := s.network.SubscribeEvents(events)
+	defer sub.Unsubscribe()
+
+	var gone <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		gone = notifier.CloseNotify()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case ev := <-events:
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-sub.Err():
+			return
+		case <-gone:
+			return
+		}
+	}
+}
+
+// lookupNode resolves a node reference given either as a hex ID or a name.
+func (s *Server) lookupNode(ref string) *Node {
+	if id, err := discover.HexID(ref); err == nil {
+		return s.network.GetNode(id)
+	}
+	return s.network.GetNodeByName(ref)
+}
+
+// sendJSON writes v to the response as JSON with the given status code.
+func (s *Server) sendJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("Failed to encode simulation response", "err", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/immesys/bw2bc/p2p/simulations/adapters"
+)
+
+// Tests that a network can be driven and observed through the HTTP server.
+func TestHTTPServer(t *testing.T) {
+	net := NewNetwork(adapters.NewSimAdapter())
+	defer net.Shutdown()
+
+	srv := httptest.NewServer(NewServer(net))
+	defer srv.Close()
+
+	// Subscribe to the event stream before changing the network
+	stream, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatalf("failed to subscribe to events: %v", err)
+	}
+	defer stream.Body.Close()
+
+	// Create and start a pair of nodes, then connect them by name
+	for _, name := range []string{"alice", "bob"} {
+		body := `{"name": "` + name + `", "services": ["test"]}`
+		var node Node
+		post(t, srv.URL+"/nodes", body, http.StatusCreated, &node)
+		if node.Config.Name != name || node.Up {
+			t.Fatalf("created node mismatch: have %s/%v, want %s/false", node.Config.Name, node.Up, name)
+		}
+		post(t, srv.URL+"/nodes/"+name+"/start", "", http.StatusOK, &node)
+		if !node.Up {
+			t.Fatalf("node %s not up after start", name)
+		}
+	}
+	var conn Conn
+	post(t, srv.URL+"/nodes/alice/conn/bob", "", http.StatusOK, &conn)
+	if !conn.Up {
+		t.Fatalf("connection not up")
+	}
+	post(t, srv.URL+"/nodes/alice/conn/bob", "", http.StatusBadRequest, nil)
+	post(t, srv.URL+"/nodes/carol/start", "", http.StatusNotFound, nil)
+
+	// Check the network summary and the streamed events
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to fetch network: %v", err)
+	}
+	var info networkInfo
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to decode network: %v", err)
+	}
+	if len(info.Nodes) != 2 || len(info.Conns) != 1 || !info.Conns[0].Up {
+		t.Fatalf("network mismatch: have %d nodes, %d conns", len(info.Nodes), len(info.Conns))
+	}
+	reader := bufio.NewReader(stream.Body)
+	for i, want := range []EventType{EventTypeNode, EventTypeNode, EventTypeNode, EventTypeNode, EventTypeConn} {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("event %d: failed to read: %v", i, err)
+		}
+		var ev Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			t.Fatalf("event %d: failed to decode: %v", i, err)
+		}
+		if ev.Type != want {
+			t.Errorf("event %d: type mismatch: have %s, want %s", i, ev.Type, want)
+		}
+	}
+}
+
+// post sends a POST request, checking the response status and decoding the
+// result into v if non-nil.
+func post(t *testing.T, url, body string, status int, v interface{}) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		t.Fatalf("POST %s status mismatch: have %d, want %d", url, resp.StatusCode, status)
+	}
+	if v != nil {
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("POST %s content type mismatch: have %q, want %q", url, ct, "application/json")
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("POST %s: failed to decode response: %v", url, err)
+		}
+	}
+}
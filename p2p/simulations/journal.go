@@ -0,0 +1,167 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/immesys/bw2bc/event"
+)
+
+// Journal records the events of a simulated network, allowing a scenario to be
+// saved and later replayed against a fresh network.
+type Journal struct {
+	lock   sync.RWMutex
+	events []*Event
+
+	sub  event.Subscription
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewJournal creates a journal recording all subsequent events of the network.
+func NewJournal(net *Network) *Journal {
+	ch := make(chan *Event, 64)
+	j := &Journal{
+		sub:  net.SubscribeEvents(ch),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go j.loop(ch)
+	return j
+}
+
+// loop appends the events of the network to the journal until stopped.
+func (j *Journal) loop(ch chan *Event) {
+	defer close(j.done)
+	defer j.sub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-ch:
+			j.lock.Lock()
+			j.events = append(j.events, ev)
+			j.lock.Unlock()
+
+		case <-j.sub.Err():
+			return
+
+		case <-j.quit:
+			// Drain any events already delivered before returning
+			for {
+				select {
+				case ev := <-ch:
+					j.lock.Lock()
+					j.events = append(j.events, ev)
+					j.lock.Unlock()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stop terminates the recording of events.
+func (j *Journal) Stop() {
+	select {
+	case <-j.quit:
+	default:
+		close(j.quit)
+	}
+	<-j.done
+}
+
+// Events returns the events recorded so far.
+func (j *Journal) Events() []*Event {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	events := make([]*Event, len(j.events))
+	copy(events, j.events)
+	return events
+}
+
+// Save writes the recorded events to w as JSON.
+func (j *Journal) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(j.Events())
+}
+
+// LoadEvents reads a list of events previously written by Journal.Save.
+func LoadEvents(r io.Reader) ([]*Event, error) {
+	var events []*Event
+	if err := json.NewDecoder(r).Decode(&events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Replay applies the given events in order to the network, recreating the
+// nodes and connections they describe. Events are applied back to back, the
+// original timing is not reproduced.
+func Replay(net *Network, events []*Event) error {
+	for i, ev := range events {
+		if err := replayEvent(net, ev); err != nil {
+			return fmt.Errorf("event %d (%v): %v", i, ev, err)
+		}
+	}
+	return nil
+}
+
+// replayEvent applies a single event to the network.
+func replayEvent(net *Network, ev *Event) error {
+	switch ev.Type {
+	case EventTypeNode:
+		if ev.Node == nil || ev.Node.Config == nil {
+			return fmt.Errorf("missing node")
+		}
+		node := net.snapshotNode(ev.Node.ID())
+		if node == nil {
+			config := *ev.Node.Config
+			if _, err := net.NewNode(&config); err != nil {
+				return err
+			}
+			node = net.snapshotNode(config.ID)
+		}
+		switch {
+		case ev.Node.Up && !node.Up:
+			return net.Start(node.ID())
+		case !ev.Node.Up && node.Up:
+			return net.Stop(node.ID())
+		}
+		return nil
+
+	case EventTypeConn:
+		if ev.Conn == nil {
+			return fmt.Errorf("missing connection")
+		}
+		conn := net.snapshotConn(ev.Conn.One, ev.Conn.Other)
+		switch {
+		case ev.Conn.Up && (conn == nil || !conn.Up):
+			return net.Connect(ev.Conn.One, ev.Conn.Other)
+		case !ev.Conn.Up && conn != nil && conn.Up:
+			return net.Disconnect(ev.Conn.One, ev.Conn.Other)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown event type %q", ev.Type)
+	}
+}
@@ -0,0 +1,456 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package simulations implements a controller for networks of simulated p2p
+// nodes running on a single machine. Nodes are backed by a pluggable adapter,
+// every change to the network is published as an event which can be journaled
+// and replayed, and the network can be driven remotely over HTTP.
+package simulations
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/immesys/bw2bc/event"
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/simulations/adapters"
+)
+
+var (
+	ErrNodeNotFound = errors.New("node not found")
+	ErrNodeExists   = errors.New("node already exists")
+	ErrNodeUp       = errors.New("node already up")
+	ErrNodeDown     = errors.New("node not up")
+	ErrConnUp       = errors.New("connection already up")
+	ErrConnDown     = errors.New("connection not up")
+	ErrSelfConn     = errors.New("cannot connect node to itself")
+)
+
+// Node is a node of a simulated network.
+type Node struct {
+	adapters.Node `json:"-"`
+
+	// Config is the configuration the node was created with.
+	Config *adapters.NodeConfig `json:"config"`
+
+	// Up tracks whether the node is running.
+	Up bool `json:"up"`
+}
+
+// ID returns the identifier of the node.
+func (n *Node) ID() discover.NodeID {
+	return n.Config.ID
+}
+
+// String implements fmt.Stringer.
+func (n *Node) String() string {
+	return fmt.Sprintf("Node %v", n.ID().TerminalString())
+}
+
+// copy returns a snapshot of the node's state, detached from its backend.
+func (n *Node) copy() *Node {
+	return &Node{Config: n.Config, Up: n.Up}
+}
+
+// Conn is a connection between two nodes of a simulated network.
+type Conn struct {
+	// One is the node which initiated the connection.
+	One discover.NodeID `json:"one"`
+
+	// Other is the node which was connected to.
+	Other discover.NodeID `json:"other"`
+
+	// Up tracks whether the connection is established.
+	Up bool `json:"up"`
+}
+
+// String implements fmt.Stringer.
+func (c *Conn) String() string {
+	return fmt.Sprintf("Conn %v->%v", c.One.TerminalString(), c.Other.TerminalString())
+}
+
+// Network is a collection of simulated nodes and the connections between them,
+// all backed by a single node adapter.
+type Network struct {
+	adapter adapters.NodeAdapter
+
+	lock    sync.RWMutex
+	nodes   []*Node
+	nodeMap map[discover.NodeID]int
+	conns   []*Conn
+	connMap map[string]int
+
+	events event.Feed
+	drops  event.Subscription // Subscription to the dropped connections, if reported
+}
+
+// NewNetwork creates an empty network backed by the given adapter.
+func NewNetwork(adapter adapters.NodeAdapter) *Network {
+	net := &Network{
+		adapter: adapter,
+		nodeMap: make(map[discover.NodeID]int),
+		connMap: make(map[string]int),
+	}
+	if reporter, ok := adapter.(adapters.DropReporter); ok {
+		drops := make(chan *adapters.ConnDrop)
+		net.drops = reporter.SubscribeDrops(drops)
+		go net.dropLoop(drops, net.drops)
+	}
+	return net
+}
+
+// Adapter returns the node adapter backing the network.
+func (net *Network) Adapter() adapters.NodeAdapter {
+	return net.adapter
+}
+
+// SubscribeEvents registers a subscription for the events of the network.
+func (net *Network) SubscribeEvents(ch chan<- *Event) event.Subscription {
+	return net.events.Subscribe(ch)
+}
+
+// NewNode creates a new stopped node with the given configuration, generating
+// a random one if nil.
+func (net *Network) NewNode(config *adapters.NodeConfig) (*Node, error) {
+	if config == nil {
+		config = adapters.RandomNodeConfig()
+	}
+	net.lock.Lock()
+	if _, exists := net.nodeMap[config.ID]; exists {
+		net.lock.Unlock()
+		return nil, ErrNodeExists
+	}
+	if config.Name == "" {
+		config.Name = fmt.Sprintf("node%02d", len(net.nodes)+1)
+	}
+	if net.getNodeByName(config.Name) != nil {
+		net.lock.Unlock()
+		return nil, fmt.Errorf("node name %q already exists", config.Name)
+	}
+	backend, err := net.adapter.NewNode(config)
+	if err != nil {
+		net.lock.Unlock()
+		return nil, err
+	}
+	node := &Node{Node: backend, Config: config}
+	net.nodeMap[config.ID] = len(net.nodes)
+	net.nodes = append(net.nodes, node)
+	event := NewEvent(node)
+	net.lock.Unlock()
+
+	log.Trace("Created simulated node", "id", config.ID.TerminalString(), "name", config.Name, "adapter", net.adapter.Name())
+	net.events.Send(event)
+	return node, nil
+}
+
+// Start boots up the node with the given identifier.
+func (net *Network) Start(id discover.NodeID) error {
+	net.lock.Lock()
+	node := net.getNode(id)
+	if node == nil {
+		net.lock.Unlock()
+		return ErrNodeNotFound
+	}
+	if node.Up {
+		net.lock.Unlock()
+		return ErrNodeUp
+	}
+	if err := node.Start(); err != nil {
+		net.lock.Unlock()
+		log.Warn("Failed to start simulated node", "id", id.TerminalString(), "err", err)
+		return err
+	}
+	node.Up = true
+	event := NewEvent(node)
+	net.lock.Unlock()
+
+	log.Trace("Started simulated node", "id", id.TerminalString())
+	net.events.Send(event)
+	return nil
+}
+
+// Stop tears down the node with the given identifier, taking all of its
+// connections down with it.
+func (net *Network) Stop(id discover.NodeID) error {
+	net.lock.Lock()
+	node := net.getNode(id)
+	if node == nil {
+		net.lock.Unlock()
+		return ErrNodeNotFound
+	}
+	if !node.Up {
+		net.lock.Unlock()
+		return ErrNodeDown
+	}
+	err := node.Stop()
+	node.Up = false
+
+	events := []*Event{NewEvent(node)}
+	for _, conn := range net.conns {
+		if conn.Up && (conn.One == id || conn.Other == id) {
+			conn.Up = false
+			events = append(events, NewEvent(conn))
+		}
+	}
+	net.lock.Unlock()
+
+	if err != nil {
+		log.Warn("Failed to stop simulated node cleanly", "id", id.TerminalString(), "err", err)
+	}
+	for _, event := range events {
+		net.events.Send(event)
+	}
+	return err
+}
+
+// Connect establishes a connection from one node to another.
+func (net *Network) Connect(one, other discover.NodeID) error {
+	net.lock.Lock()
+	conn, err := net.getOrCreateConn(one, other)
+	if err != nil {
+		net.lock.Unlock()
+		return err
+	}
+	if conn.Up {
+		net.lock.Unlock()
+		return ErrConnUp
+	}
+	oneNode, otherNode := net.getNode(one), net.getNode(other)
+	if !oneNode.Up || !otherNode.Up {
+		net.lock.Unlock()
+		return ErrNodeDown
+	}
+	if err := oneNode.Connect(otherNode.Node); err != nil {
+		net.lock.Unlock()
+		return err
+	}
+	conn.Up = true
+	event := NewEvent(conn)
+	net.lock.Unlock()
+
+	log.Trace("Connected simulated nodes", "one", one.TerminalString(), "other", other.TerminalString())
+	net.events.Send(event)
+	return nil
+}
+
+// Disconnect drops the connection between two nodes.
+func (net *Network) Disconnect(one, other discover.NodeID) error {
+	net.lock.Lock()
+	conn := net.getConn(one, other)
+	if conn == nil || !conn.Up {
+		net.lock.Unlock()
+		return ErrConnDown
+	}
+	if err := net.getNode(conn.One).Disconnect(net.getNode(conn.Other).Node); err != nil {
+		net.lock.Unlock()
+		return err
+	}
+	conn.Up = false
+	event := NewEvent(conn)
+	net.lock.Unlock()
+
+	log.Trace("Disconnected simulated nodes", "one", one.TerminalString(), "other", other.TerminalString())
+	net.events.Send(event)
+	return nil
+}
+
+// dropLoop marks the connections reported dropped by the adapter as down until
+// the subscription is terminated.
+func (net *Network) dropLoop(drops chan *adapters.ConnDrop, sub event.Subscription) {
+	for {
+		select {
+		case drop := <-drops:
+			net.lock.Lock()
+			conn := net.getConn(drop.One, drop.Other)
+			if conn == nil || !conn.Up {
+				net.lock.Unlock()
+				continue
+			}
+			conn.Up = false
+			event := NewEvent(conn)
+			net.lock.Unlock()
+
+			log.Trace("Simulated connection dropped", "one", drop.One.TerminalString(), "other", drop.Other.TerminalString())
+			net.events.Send(event)
+
+		case <-sub.Err():
+			return
+		}
+	}
+}
+
+// GetNode returns the node with the given identifier, or nil if not found.
+func (net *Network) GetNode(id discover.NodeID) *Node {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+	return net.getNode(id)
+}
+
+// GetNodeByName returns the node with the given name, or nil if not found.
+func (net *Network) GetNodeByName(name string) *Node {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+	return net.getNodeByName(name)
+}
+
+// GetNodes returns all the nodes of the network, in order of creation.
+func (net *Network) GetNodes() []*Node {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+
+	nodes := make([]*Node, len(net.nodes))
+	copy(nodes, net.nodes)
+	return nodes
+}
+
+// GetConn returns the connection between the two nodes in either direction,
+// or nil if they were never connected.
+func (net *Network) GetConn(one, other discover.NodeID) *Conn {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+	return net.getConn(one, other)
+}
+
+// GetConns returns all the connections ever established within the network.
+func (net *Network) GetConns() []*Conn {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+
+	conns := make([]*Conn, len(net.conns))
+	copy(conns, net.conns)
+	return conns
+}
+
+// Shutdown stops all the running nodes of the network.
+func (net *Network) Shutdown() {
+	for _, node := range net.GetNodes() {
+		if node.Running() {
+			if err := net.Stop(node.ID()); err != nil {
+				log.Warn("Failed to stop simulated node", "id", node.ID().TerminalString(), "err", err)
+			}
+		}
+	}
+	if net.drops != nil {
+		net.drops.Unsubscribe()
+	}
+}
+
+// snapshotNodes returns detached copies of all the nodes, safe for encoding
+// while the network keeps changing.
+func (net *Network) snapshotNodes() []*Node {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+
+	nodes := make([]*Node, len(net.nodes))
+	for i, node := range net.nodes {
+		nodes[i] = node.copy()
+	}
+	return nodes
+}
+
+// snapshotNode returns a detached copy of the node with the given identifier.
+func (net *Network) snapshotNode(id discover.NodeID) *Node {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+
+	if node := net.getNode(id); node != nil {
+		return node.copy()
+	}
+	return nil
+}
+
+// snapshotConn returns a detached copy of the connection between two nodes.
+func (net *Network) snapshotConn(one, other discover.NodeID) *Conn {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+
+	if conn := net.getConn(one, other); conn != nil {
+		c := *conn
+		return &c
+	}
+	return nil
+}
+
+// snapshotConns returns detached copies of all the connections.
+func (net *Network) snapshotConns() []*Conn {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+
+	conns := make([]*Conn, len(net.conns))
+	for i, conn := range net.conns {
+		c := *conn
+		conns[i] = &c
+	}
+	return conns
+}
+
+func (net *Network) getNode(id discover.NodeID) *Node {
+	i, found := net.nodeMap[id]
+	if !found {
+		return nil
+	}
+	return net.nodes[i]
+}
+
+func (net *Network) getNodeByName(name string) *Node {
+	for _, node := range net.nodes {
+		if node.Config.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+func (net *Network) getConn(one, other discover.NodeID) *Conn {
+	i, found := net.connMap[connLabel(one, other)]
+	if !found {
+		return nil
+	}
+	return net.conns[i]
+}
+
+// getOrCreateConn returns the connection between the two nodes, creating a
+// new one directed from one to other if they were never connected.
+func (net *Network) getOrCreateConn(one, other discover.NodeID) (*Conn, error) {
+	if one == other {
+		return nil, ErrSelfConn
+	}
+	if conn := net.getConn(one, other); conn != nil {
+		return conn, nil
+	}
+	if net.getNode(one) == nil || net.getNode(other) == nil {
+		return nil, ErrNodeNotFound
+	}
+	conn := &Conn{One: one, Other: other}
+	net.connMap[connLabel(one, other)] = len(net.conns)
+	net.conns = append(net.conns, conn)
+	return conn, nil
+}
+
+// connLabel generates a direction independent key for a pair of nodes.
+func connLabel(one, other discover.NodeID) string {
+	for i := range one {
+		if one[i] != other[i] {
+			if one[i] > other[i] {
+				one, other = other, one
+			}
+			break
+		}
+	}
+	return fmt.Sprintf("%x-%x", one[:], other[:])
+}
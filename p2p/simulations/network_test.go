@@ -0,0 +1,309 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bc/node"
+	"github.com/immesys/bw2bc/p2p"
+	"github.com/immesys/bw2bc/p2p/simulations/adapters"
+	"github.com/immesys/bw2bc/rpc"
+)
+
+func TestMain(m *testing.M) {
+	adapters.RegisterServices(adapters.Services{
+		"test":    newTestService,
+		"oneshot": newOneshotService,
+	})
+	os.Exit(m.Run())
+}
+
+// testService is a node service running a protocol which greets every peer
+// with a single message and counts the greetings received. Oneshot services
+// terminate the protocol after the first greeting.
+type testService struct {
+	received int64
+	oneshot  bool
+}
+
+func newTestService(ctx *adapters.ServiceContext) (node.Service, error) {
+	return new(testService), nil
+}
+
+func newOneshotService(ctx *adapters.ServiceContext) (node.Service, error) {
+	return &testService{oneshot: true}, nil
+}
+
+func (t *testService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    "test",
+		Version: 1,
+		Length:  1,
+		Run:     t.run,
+	}}
+}
+
+func (t *testService) APIs() []rpc.API {
+	return []rpc.API{{
+		Namespace: "test",
+		Version:   "1.0",
+		Service:   &TestAPI{t},
+	}}
+}
+
+func (t *testService) Start(server *p2p.Server) error { return nil }
+func (t *testService) Stop() error                    { return nil }
+
+func (t *testService) run(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+	go p2p.Send(rw, 0, "hello")
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&t.received, 1)
+		msg.Discard()
+
+		if t.oneshot {
+			return nil
+		}
+	}
+}
+
+// TestAPI exposes the greeting counter of the test service over RPC.
+type TestAPI struct {
+	service *testService
+}
+
+// Received returns the number of greetings received from peers.
+func (api *TestAPI) Received() int64 {
+	return atomic.LoadInt64(&api.service.received)
+}
+
+// waitReceived polls a node until it received the given number of greetings.
+func waitReceived(t *testing.T, node *Node, want int64) {
+	client, err := node.Client()
+	if err != nil {
+		t.Fatalf("%v: failed to get client: %v", node, err)
+	}
+	var have int64
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if err := client.Call(&have, "test_received"); err != nil {
+			t.Fatalf("%v: failed to query greetings: %v", node, err)
+		}
+		if have == want {
+			return
+		}
+	}
+	t.Fatalf("%v: greetings mismatch: have %d, want %d", node, have, want)
+}
+
+// startRing creates, starts and connects a ring of nodes running the test service.
+func startRing(t *testing.T, net *Network, n int) []*Node {
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		config := adapters.RandomNodeConfig()
+		config.Name = fmt.Sprintf("node%02d", i)
+		config.Services = []string{"test"}
+
+		node, err := net.NewNode(config)
+		if err != nil {
+			t.Fatalf("node %d: failed to create: %v", i, err)
+		}
+		if err := net.Start(node.ID()); err != nil {
+			t.Fatalf("node %d: failed to start: %v", i, err)
+		}
+		nodes[i] = node
+	}
+	for i, node := range nodes {
+		if n == 2 && i == 1 {
+			break // a ring of two is a single connection
+		}
+		if err := net.Connect(node.ID(), nodes[(i+1)%n].ID()); err != nil {
+			t.Fatalf("node %d: failed to connect: %v", i, err)
+		}
+	}
+	return nodes
+}
+
+// Tests that a ring of in-memory nodes can be set up, runs its protocols and
+// can be torn down again.
+func TestNetworkSimAdapter(t *testing.T) {
+	net := NewNetwork(adapters.NewSimAdapter())
+	defer net.Shutdown()
+
+	nodes := startRing(t, net, 10)
+	for _, node := range nodes {
+		waitReceived(t, node, 2)
+	}
+	if err := net.Connect(nodes[0].ID(), nodes[1].ID()); err != ErrConnUp {
+		t.Errorf("duplicate connection error mismatch: have %v, want %v", err, ErrConnUp)
+	}
+	if err := net.Connect(nodes[0].ID(), nodes[0].ID()); err != ErrSelfConn {
+		t.Errorf("self connection error mismatch: have %v, want %v", err, ErrSelfConn)
+	}
+	// Stop a node and ensure its connections are dropped
+	if err := net.Stop(nodes[0].ID()); err != nil {
+		t.Fatalf("failed to stop node: %v", err)
+	}
+	for _, conn := range net.GetConns() {
+		involved := conn.One == nodes[0].ID() || conn.Other == nodes[0].ID()
+		if conn.Up == involved {
+			t.Errorf("%v: up mismatch: have %v, want %v", conn, conn.Up, !involved)
+		}
+	}
+	if peers := nodes[1].Node.(*adapters.SimNode).Peers(); len(peers) != 1 {
+		t.Errorf("peer count mismatch after stop: have %d, want 1", len(peers))
+	}
+	// Disconnect and reconnect a pair of live nodes
+	if err := net.Disconnect(nodes[2].ID(), nodes[3].ID()); err != nil {
+		t.Fatalf("failed to disconnect nodes: %v", err)
+	}
+	if err := net.Connect(nodes[3].ID(), nodes[2].ID()); err != nil {
+		t.Fatalf("failed to reconnect nodes: %v", err)
+	}
+	waitReceived(t, nodes[2], 3)
+	waitReceived(t, nodes[3], 3)
+}
+
+// Tests that a pair of nodes running in child processes can be connected.
+func TestNetworkExecAdapter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2p-sim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	net := NewNetwork(adapters.NewExecAdapter(dir))
+	defer net.Shutdown()
+
+	nodes := startRing(t, net, 2)
+	for _, node := range nodes {
+		waitReceived(t, node, 1)
+	}
+	if err := net.Disconnect(nodes[0].ID(), nodes[1].ID()); err != nil {
+		t.Fatalf("failed to disconnect nodes: %v", err)
+	}
+	if err := net.Stop(nodes[1].ID()); err != nil {
+		t.Fatalf("failed to stop node: %v", err)
+	}
+}
+
+// Tests that a journaled scenario can be saved and replayed onto a new network.
+func TestJournalReplay(t *testing.T) {
+	net := NewNetwork(adapters.NewSimAdapter())
+	defer net.Shutdown()
+
+	journal := NewJournal(net)
+	nodes := startRing(t, net, 5)
+	if err := net.Disconnect(nodes[1].ID(), nodes[2].ID()); err != nil {
+		t.Fatalf("failed to disconnect nodes: %v", err)
+	}
+	if err := net.Stop(nodes[4].ID()); err != nil {
+		t.Fatalf("failed to stop node: %v", err)
+	}
+	journal.Stop()
+
+	// 5 creations, 5 starts, 5 connections, 1 disconnection, 1 stop dropping 2 connections
+	if have, want := len(journal.Events()), 19; have != want {
+		t.Fatalf("event count mismatch: have %d, want %d", have, want)
+	}
+	buf := new(bytes.Buffer)
+	if err := journal.Save(buf); err != nil {
+		t.Fatalf("failed to save journal: %v", err)
+	}
+	events, err := LoadEvents(buf)
+	if err != nil {
+		t.Fatalf("failed to load journal: %v", err)
+	}
+	replayed := NewNetwork(adapters.NewSimAdapter())
+	defer replayed.Shutdown()
+
+	if err := Replay(replayed, events); err != nil {
+		t.Fatalf("failed to replay journal: %v", err)
+	}
+	for _, node := range net.snapshotNodes() {
+		have := replayed.snapshotNode(node.ID())
+		if have == nil {
+			t.Errorf("%v: missing after replay", node)
+			continue
+		}
+		if have.Up != node.Up || have.Config.Name != node.Config.Name {
+			t.Errorf("%v: state mismatch: have %s/%v, want %s/%v", node, have.Config.Name, have.Up, node.Config.Name, node.Up)
+		}
+	}
+	for _, conn := range net.snapshotConns() {
+		have := replayed.snapshotConn(conn.One, conn.Other)
+		if have == nil || have.Up != conn.Up {
+			t.Errorf("%v: state mismatch: have %v, want %v", conn, have, conn)
+		}
+	}
+}
+
+// Tests that connections torn down by a terminating protocol are reported as
+// down and that the nodes can be connected again afterwards.
+func TestNetworkSimAdapterProtocolExit(t *testing.T) {
+	net := NewNetwork(adapters.NewSimAdapter())
+	defer net.Shutdown()
+
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		config := adapters.RandomNodeConfig()
+		config.Services = []string{"oneshot"}
+
+		node, err := net.NewNode(config)
+		if err != nil {
+			t.Fatalf("node %d: failed to create: %v", i, err)
+		}
+		if err := net.Start(node.ID()); err != nil {
+			t.Fatalf("node %d: failed to start: %v", i, err)
+		}
+		nodes[i] = node
+	}
+	events := make(chan *Event, 16)
+	sub := net.SubscribeEvents(events)
+	defer sub.Unsubscribe()
+
+	one, other := nodes[0].ID(), nodes[1].ID()
+	for round := int64(1); round <= 3; round++ {
+		if err := net.Connect(one, other); err != nil {
+			t.Fatalf("round %d: failed to connect: %v", round, err)
+		}
+		// Wait for the connection to be reported down after the greeting
+		for down := false; !down; {
+			select {
+			case ev := <-events:
+				down = ev.Type == EventTypeConn && !ev.Conn.Up
+			case <-time.After(5 * time.Second):
+				t.Fatalf("round %d: connection drop not reported", round)
+			}
+		}
+		if conn := net.GetConn(one, other); conn == nil || conn.Up {
+			t.Fatalf("round %d: connection still up: %v", round, conn)
+		}
+	}
+	if err := net.Disconnect(one, other); err != ErrConnDown {
+		t.Fatalf("disconnect of dropped connection: have %v, want %v", err, ErrConnDown)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/immesys/bw2bc/log"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/discv5"
+)
+
+var errDiscoveryV5Disabled = errors.New("discovery v5 disabled")
+
+// ServicePeers advertises a service topic over discovery v5 and offers nodes
+// found advertising the same topic to the dialer of the server. They are dialed
+// independently of the dynamic dial slots, but connect as dynamic peers, so they
+// count against the regular peer limits.
+type ServicePeers struct {
+	srv      *Server
+	disc     *discv5.ServiceDiscovery
+	maxPeers int
+
+	lock  sync.Mutex
+	found []*discover.Node
+}
+
+// DiscoverService starts a discovery v5 service advertisement for topic. If
+// advertise is set, the local node is registered under the topic. If maxPeers
+// is positive, the topic is searched and the dialer is kept supplied with found
+// nodes until up to maxPeers of them are connected.
+func (srv *Server) DiscoverService(topic discv5.Topic, advertise bool, maxPeers int) (*ServicePeers, error) {
+	srv.lock.Lock()
+	running, net := srv.running, srv.DiscV5
+	srv.lock.Unlock()
+
+	if !running {
+		return nil, errServerStopped
+	}
+	if net == nil {
+		return nil, errDiscoveryV5Disabled
+	}
+	sp := &ServicePeers{
+		srv:      srv,
+		maxPeers: maxPeers,
+	}
+	sp.disc = discv5.NewServiceDiscovery(net, topic, discv5.ServiceConfig{
+		Advertise: advertise,
+		Search:    maxPeers > 0,
+		Found:     sp.foundNode,
+	})
+	srv.servicesLock.Lock()
+	if srv.services == nil {
+		srv.services = make(map[*ServicePeers]struct{})
+	}
+	srv.services[sp] = struct{}{}
+	srv.servicesLock.Unlock()
+
+	sp.disc.Start()
+	return sp, nil
+}
+
+// foundNode is called by the service discovery for every new node advertising
+// the topic, recording it as a dial candidate.
+func (sp *ServicePeers) foundNode(n *discv5.Node) {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	node := discover.NewNode(discover.NodeID(n.ID), n.IP, n.UDP, n.TCP)
+	sp.found = append(sp.found, node)
+	log.Debug("Found service node", "topic", sp.disc.Topic(), "id", node.ID)
+}
+
+// candidates returns random found nodes which are not connected yet, as many as
+// are missing to reach the wanted number of service peers.
+func (sp *ServicePeers) candidates(connected map[discover.NodeID]bool) []*discover.Node {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	need := sp.maxPeers
+	for _, n := range sp.found {
+		if connected[n.ID] {
+			need--
+		}
+	}
+	var nodes []*discover.Node
+	for _, i := range rand.Perm(len(sp.found)) {
+		if len(nodes) >= need {
+			break
+		}
+		if n := sp.found[i]; !connected[n.ID] {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// Nodes returns the nodes found advertising the topic.
+func (sp *ServicePeers) Nodes() []*discover.Node {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	return append([]*discover.Node(nil), sp.found...)
+}
+
+// Stop terminates the advertisement and search, and stops offering the found
+// nodes to the dialer. Already connected peers are kept.
+func (sp *ServicePeers) Stop() {
+	sp.srv.servicesLock.Lock()
+	delete(sp.srv.services, sp)
+	sp.srv.servicesLock.Unlock()
+
+	sp.disc.Stop()
+}
+
+// serviceCandidates returns the dial candidates of all running service searches,
+// skipping the given connected or dialed nodes.
+func (srv *Server) serviceCandidates(connected map[discover.NodeID]bool) []*discover.Node {
+	srv.servicesLock.Lock()
+	services := make([]*ServicePeers, 0, len(srv.services))
+	for sp := range srv.services {
+		services = append(services, sp)
+	}
+	srv.servicesLock.Unlock()
+
+	var nodes []*discover.Node
+	for _, sp := range services {
+		nodes = append(nodes, sp.candidates(connected)...)
+	}
+	return nodes
+}
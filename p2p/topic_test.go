@@ -0,0 +1,92 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package p2p
+
+import (
+	"net"
+	"testing"
+
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/discv5"
+)
+
+func TestServerDiscoverService(t *testing.T) {
+	topic := discv5.ServiceTopic("test", 1)
+
+	srv := &Server{Config: Config{PrivateKey: newkey(), MaxPeers: 10, NoDial: true}}
+	if _, err := srv.DiscoverService(topic, true, 1); err != errServerStopped {
+		t.Fatalf("wrong error for stopped server: got %v, want %v", err, errServerStopped)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	if _, err := srv.DiscoverService(topic, true, 1); err != errDiscoveryV5Disabled {
+		t.Errorf("wrong error without discovery v5: got %v, want %v", err, errDiscoveryV5Disabled)
+	}
+	srv.Stop()
+
+	srv = &Server{Config: Config{
+		PrivateKey:      newkey(),
+		MaxPeers:        10,
+		NoDial:          true,
+		DiscoveryV5:     true,
+		DiscoveryV5Addr: "127.0.0.1:0",
+	}}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	defer srv.Stop()
+	sp, err := srv.DiscoverService(topic, true, 1)
+	if err != nil {
+		t.Fatalf("could not discover service: %v", err)
+	}
+	if nodes := sp.Nodes(); len(nodes) != 0 {
+		t.Errorf("unexpected service nodes: %v", nodes)
+	}
+	// Found nodes must be offered to the dialer, up to the requested count
+	for i := 0; i < 3; i++ {
+		sp.foundNode(discv5.NewNode(discv5.NodeID(randomID()), net.IP{127, 0, 0, 1}, 30303, 30303))
+	}
+	if nodes := srv.serviceCandidates(nil); len(nodes) != 1 {
+		t.Errorf("dial candidate count mismatch: have %d, want 1", len(nodes))
+	}
+	sp.Stop()
+	if nodes := srv.serviceCandidates(nil); len(nodes) != 0 {
+		t.Errorf("dial candidates offered after stop: %v", nodes)
+	}
+}
+
+func TestServicePeersCandidates(t *testing.T) {
+	sp := &ServicePeers{maxPeers: 2}
+	for i := 0; i < 4; i++ {
+		sp.found = append(sp.found, discover.NewNode(randomID(), net.IP{127, 0, 0, 1}, 30303, 30303))
+	}
+	// Nothing connected, candidates up to the limit
+	if nodes := sp.candidates(nil); len(nodes) != 2 {
+		t.Errorf("candidate count mismatch: have %d, want 2", len(nodes))
+	}
+	// One connected, only the rest offered, excluding the connected one
+	connected := map[discover.NodeID]bool{sp.found[0].ID: true}
+	nodes := sp.candidates(connected)
+	if len(nodes) != 1 || nodes[0].ID == sp.found[0].ID {
+		t.Errorf("candidate mismatch with one connected: %v", nodes)
+	}
+	// Limit reached, nothing offered
+	connected[sp.found[1].ID] = true
+	if nodes := sp.candidates(connected); len(nodes) != 0 {
+		t.Errorf("candidates offered beyond limit: %v", nodes)
+	}
+}
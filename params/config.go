@@ -86,8 +86,8 @@ var (
 	// means that all fields must be set at all times. This forces
 	// anyone adding flags to the config to also have to set these
 	// fields.
-	AllProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(math.MaxInt64) /*disabled*/, new(EthashConfig), nil}
-	TestChainConfig    = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), nil, new(EthashConfig), nil}
+	AllProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(math.MaxInt64) /*disabled*/, nil, new(EthashConfig), nil}
+	TestChainConfig    = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
 	TestRules          = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -112,23 +112,239 @@ type ChainConfig struct {
 
 	MetropolisBlock *big.Int `json:"metropolisBlock,omitempty"` // Metropolis switch block (nil = no fork, 0 = alraedy on homestead)
 
+	// CliqueTransitionBlock switches the chain from ethash to clique sealing. Both
+	// engine configs need to be present, the clique one listing the initial signers.
+	CliqueTransitionBlock *big.Int `json:"cliqueTransitionBlock,omitempty"` // Proof-of-authority switch block (nil = no switch)
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
 }
 
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
-type EthashConfig struct{}
+type EthashConfig struct {
+	Rewards []*EthashReward `json:"rewards,omitempty"` // Reward schedule ordered by activation block, mainnet rewards if empty
+
+	BlockTime       uint64   `json:"blockTime,omitempty"`       // Target block time in seconds to adjust the difficulty towards (default 10)
+	BoundDivisor    *big.Int `json:"boundDivisor,omitempty"`    // Bound divisor of the difficulty adjustment (default 2048)
+	DifficultyBlock *big.Int `json:"difficultyBlock,omitempty"` // Block the block time and bound divisor apply from (nil = genesis)
+	Bomb            bool     `json:"bomb,omitempty"`            // Whether the exponential difficulty bomb is enabled
+	BombDelay       *big.Int `json:"bombDelay,omitempty"`       // Number of blocks to delay the difficulty bomb by
+}
+
+// DifficultyBlockTime returns the target block time the difficulty adjustment
+// aims for at the given block, defaulting to the Homestead value.
+func (c *EthashConfig) DifficultyBlockTime(num *big.Int) uint64 {
+	if c == nil || !c.isDifficultyAdjusted(num) {
+		return 10
+	}
+	return c.blockTime()
+}
+
+// DifficultyBoundDivisor returns the bound divisor of the difficulty adjustment
+// at the given block, defaulting to the Ethereum protocol value.
+func (c *EthashConfig) DifficultyBoundDivisor(num *big.Int) *big.Int {
+	if c == nil || !c.isDifficultyAdjusted(num) {
+		return DifficultyBoundDivisor
+	}
+	return c.boundDivisor()
+}
+
+// isDifficultyAdjusted returns whether the configured block time and bound
+// divisor are in effect at the given block.
+func (c *EthashConfig) isDifficultyAdjusted(num *big.Int) bool {
+	return c.DifficultyBlock == nil || isForked(c.DifficultyBlock, num)
+}
+
+// blockTime returns the configured target block time, or the default if unset.
+func (c *EthashConfig) blockTime() uint64 {
+	if c == nil || c.BlockTime == 0 {
+		return 10
+	}
+	return c.BlockTime
+}
+
+// boundDivisor returns the configured difficulty bound divisor, or the default
+// if unset.
+func (c *EthashConfig) boundDivisor() *big.Int {
+	if c == nil || c.BoundDivisor == nil || c.BoundDivisor.Sign() <= 0 {
+		return DifficultyBoundDivisor
+	}
+	return c.BoundDivisor
+}
+
+// difficultyBlock returns the first block number the configured difficulty
+// adjustment parameters apply to, or nil if they match the defaults. Without an
+// explicit activation block that's the first block after genesis.
+func (c *EthashConfig) difficultyBlock() *big.Int {
+	if c.blockTime() == 10 && c.boundDivisor().Cmp(DifficultyBoundDivisor) == 0 {
+		return nil
+	}
+	if c.DifficultyBlock == nil {
+		return big.NewInt(1)
+	}
+	return c.DifficultyBlock
+}
+
+// difficultyBombPeriod is the number of blocks the exponential difficulty bomb
+// doubles its contribution after (expDiffPeriod in the ethash engine).
+const difficultyBombPeriod = 100000
+
+// difficultyBombBlock returns the first block number the exponential difficulty
+// bomb adds to the difficulty at, or nil if the bomb is disabled.
+func (c *EthashConfig) difficultyBombBlock() *big.Int {
+	if c == nil || !c.Bomb {
+		return nil
+	}
+	block := big.NewInt(2 * difficultyBombPeriod)
+	if c.BombDelay != nil {
+		block.Add(block, c.BombDelay)
+	}
+	return block
+}
+
+// EthashReward is a single entry of the proof-of-work reward schedule, active
+// from its block number until the next entry's.
+type EthashReward struct {
+	Block           *big.Int        `json:"block"`                     // First block the reward applies to
+	BlockReward     *big.Int        `json:"blockReward"`               // Reward in wei for sealing a block
+	UncleDivisor    uint64          `json:"uncleDivisor"`              // Uncle reward is (8 - depth) * BlockReward / UncleDivisor, none if zero
+	NephewDivisor   uint64          `json:"nephewDivisor"`             // Inclusion reward per uncle is BlockReward / NephewDivisor, none if zero
+	Treasury        *common.Address `json:"treasury,omitempty"`        // Account receiving a fixed payment for every block
+	TreasuryPayment *big.Int        `json:"treasuryPayment,omitempty"` // Payment in wei credited to the treasury per block
+}
+
+// DefaultEthashReward is the reward schedule inherited from the Ethereum mainnet,
+// used if no explicit schedule is configured.
+var DefaultEthashReward = &EthashReward{
+	Block:         big.NewInt(0),
+	BlockReward:   big.NewInt(5e+18),
+	UncleDivisor:  8,
+	NephewDivisor: 32,
+}
+
+// Reward returns the reward schedule entry active at the given block number.
+func (c *EthashConfig) Reward(num *big.Int) *EthashReward {
+	reward := DefaultEthashReward
+	if c == nil {
+		return reward
+	}
+	for _, entry := range c.Rewards {
+		if entry == nil || entry.BlockReward == nil {
+			continue
+		}
+		if !isForked(entry.Block, num) {
+			break
+		}
+		reward = entry
+	}
+	return reward
+}
+
+// CheckRewards verifies that every entry of the reward schedule is complete and
+// that the entries are strictly ordered by their activation block.
+func (c *EthashConfig) CheckRewards() error {
+	if c == nil {
+		return nil
+	}
+	var last *big.Int
+	for i, entry := range c.Rewards {
+		switch {
+		case entry == nil:
+			return fmt.Errorf("ethash reward %d: missing entry", i)
+		case entry.Block == nil:
+			return fmt.Errorf("ethash reward %d: missing activation block", i)
+		case entry.BlockReward == nil:
+			return fmt.Errorf("ethash reward %d: missing block reward", i)
+		case entry.Block.Sign() < 0 || entry.BlockReward.Sign() < 0:
+			return fmt.Errorf("ethash reward %d: negative block or reward", i)
+		case last != nil && entry.Block.Cmp(last) <= 0:
+			return fmt.Errorf("ethash reward %d: activation block %v not after %v", i, entry.Block, last)
+		}
+		last = entry.Block
+	}
+	return nil
+}
+
+// schedule returns the effective reward schedule, skipping the entries Reward
+// ignores and starting with the default rewards unless overridden at genesis.
+func (c *EthashConfig) schedule() []*EthashReward {
+	rewards := []*EthashReward{DefaultEthashReward}
+	if c == nil {
+		return rewards
+	}
+	for _, entry := range c.Rewards {
+		if entry == nil || entry.Block == nil || entry.BlockReward == nil {
+			continue
+		}
+		if entry.Block.Sign() == 0 {
+			rewards = rewards[:0]
+		}
+		rewards = append(rewards, entry)
+	}
+	return rewards
+}
+
+// rewardEqual returns whether two reward schedule entries pay out the same.
+func rewardEqual(x, y *EthashReward) bool {
+	if !configNumEqual(x.Block, y.Block) || !configNumEqual(x.BlockReward, y.BlockReward) {
+		return false
+	}
+	if x.UncleDivisor != y.UncleDivisor || x.NephewDivisor != y.NephewDivisor {
+		return false
+	}
+	if (x.Treasury == nil) != (y.Treasury == nil) || (x.Treasury != nil && *x.Treasury != *y.Treasury) {
+		return false
+	}
+	return configNumEqual(x.TreasuryPayment, y.TreasuryPayment)
+}
+
+// rewardIncompatible returns the activation blocks of the first diverging entries
+// of two reward schedules and whether the divergence is at or below head.
+func rewardIncompatible(c1, c2 *EthashConfig, head *big.Int) (s1, s2 *big.Int, bad bool) {
+	r1, r2 := c1.schedule(), c2.schedule()
+	for i := 0; i < len(r1) || i < len(r2); i++ {
+		if i < len(r1) {
+			s1 = r1[i].Block
+		} else {
+			s1 = nil
+		}
+		if i < len(r2) {
+			s2 = r2[i].Block
+		} else {
+			s2 = nil
+		}
+		if s1 != nil && s2 != nil && rewardEqual(r1[i], r2[i]) {
+			continue
+		}
+		return s1, s2, isForked(s1, head) || isForked(s2, head)
+	}
+	return nil, nil, false
+}
 
 // String implements the stringer interface, returning the consensus engine details.
 func (c *EthashConfig) String() string {
-	return "ethash"
+	if c == nil || (c.BlockTime == 0 && c.BoundDivisor == nil && !c.Bomb) {
+		return "ethash"
+	}
+	bomb := "off"
+	if c.Bomb {
+		bomb = "on"
+		if c.BombDelay != nil {
+			bomb = fmt.Sprintf("delayed %v", c.BombDelay)
+		}
+	}
+	if c.DifficultyBlock != nil {
+		return fmt.Sprintf("ethash {blocktime: %ds boundDivisor: %v from: %v bomb: %s}", c.blockTime(), c.boundDivisor(), c.DifficultyBlock, bomb)
+	}
+	return fmt.Sprintf("ethash {blocktime: %ds boundDivisor: %v bomb: %s}", c.blockTime(), c.boundDivisor(), bomb)
 }
 
 // CliqueConfig is the consensus engine configs for proof-of-authority based sealing.
 type CliqueConfig struct {
-	Period uint64 `json:"period"` // Number of seconds between blocks to enforce
-	Epoch  uint64 `json:"epoch"`  // Epoch length to reset votes and checkpoint
+	Period  uint64           `json:"period"`            // Number of seconds between blocks to enforce
+	Epoch   uint64           `json:"epoch"`             // Epoch length to reset votes and checkpoint
+	Signers []common.Address `json:"signers,omitempty"` // Initial signers when transitioning from proof-of-work
 }
 
 // String implements the stringer interface, returning the consensus engine details.
@@ -140,6 +356,8 @@ func (c *CliqueConfig) String() string {
 func (c *ChainConfig) String() string {
 	var engine interface{}
 	switch {
+	case c.Ethash != nil && c.Clique != nil && c.CliqueTransitionBlock != nil:
+		engine = fmt.Sprintf("%v->%v@%v", c.Ethash, c.Clique, c.CliqueTransitionBlock)
 	case c.Ethash != nil:
 		engine = c.Ethash
 	case c.Clique != nil:
@@ -186,6 +404,12 @@ func (c *ChainConfig) IsMetropolis(num *big.Int) bool {
 	return isForked(c.MetropolisBlock, num)
 }
 
+// IsCliqueTransition returns whether num is sealed by clique after switching
+// from ethash.
+func (c *ChainConfig) IsCliqueTransition(num *big.Int) bool {
+	return isForked(c.CliqueTransitionBlock, num)
+}
+
 // GasTable returns the gas table corresponding to the current phase (homestead or homestead reprice).
 //
 // The returned GasTable's fields shouldn't, under any circumstances, be changed.
@@ -203,6 +427,12 @@ func (c *ChainConfig) GasTable(num *big.Int) GasTable {
 	}
 }
 
+// CheckConfig verifies the consistency of the chain configuration's consensus
+// engine settings.
+func (c *ChainConfig) CheckConfig() error {
+	return c.Ethash.CheckRewards()
+}
+
 // CheckCompatible checks whether scheduled fork transitions have been imported
 // with a mismatching chain configuration.
 func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
@@ -246,6 +476,26 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.MetropolisBlock, newcfg.MetropolisBlock, head) {
 		return newCompatError("Metropolis fork block", c.MetropolisBlock, newcfg.MetropolisBlock)
 	}
+	if isForkIncompatible(c.CliqueTransitionBlock, newcfg.CliqueTransitionBlock, head) {
+		return newCompatError("Clique transition block", c.CliqueTransitionBlock, newcfg.CliqueTransitionBlock)
+	}
+	if s1, s2, bad := rewardIncompatible(c.Ethash, newcfg.Ethash, head); bad {
+		return newCompatError("ethash reward schedule", s1, s2)
+	}
+	if d1, d2 := c.Ethash.difficultyBlock(), newcfg.Ethash.difficultyBlock(); isForked(d1, head) || isForked(d2, head) {
+		if !configNumEqual(d1, d2) {
+			return newCompatError("ethash difficulty block", d1, d2)
+		}
+		if c.Ethash.blockTime() != newcfg.Ethash.blockTime() {
+			return newCompatError("ethash difficulty block time", d1, d2)
+		}
+		if c.Ethash.boundDivisor().Cmp(newcfg.Ethash.boundDivisor()) != 0 {
+			return newCompatError("ethash difficulty bound divisor", d1, d2)
+		}
+	}
+	if isForkIncompatible(c.Ethash.difficultyBombBlock(), newcfg.Ethash.difficultyBombBlock(), head) {
+		return newCompatError("ethash difficulty bomb block", c.Ethash.difficultyBombBlock(), newcfg.Ethash.difficultyBombBlock())
+	}
 	return nil
 }
 
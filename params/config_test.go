@@ -70,6 +70,161 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     9,
 			},
 		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{Rewards: []*EthashReward{
+				{Block: big.NewInt(0), BlockReward: big.NewInt(3)},
+				{Block: big.NewInt(10), BlockReward: big.NewInt(2)},
+			}}},
+			new: &ChainConfig{Ethash: &EthashConfig{Rewards: []*EthashReward{
+				{Block: big.NewInt(0), BlockReward: big.NewInt(3)},
+				{Block: big.NewInt(20), BlockReward: big.NewInt(2)},
+			}}},
+			head:    9,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{Rewards: []*EthashReward{
+				{Block: big.NewInt(0), BlockReward: big.NewInt(3)},
+				{Block: big.NewInt(10), BlockReward: big.NewInt(2)},
+			}}},
+			new: &ChainConfig{Ethash: &EthashConfig{Rewards: []*EthashReward{
+				{Block: big.NewInt(0), BlockReward: big.NewInt(3)},
+				{Block: big.NewInt(20), BlockReward: big.NewInt(2)},
+			}}},
+			head: 15,
+			wantErr: &ConfigCompatError{
+				What:         "ethash reward schedule",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(20),
+				RewindTo:     9,
+			},
+		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{}},
+			new: &ChainConfig{Ethash: &EthashConfig{Rewards: []*EthashReward{
+				{Block: big.NewInt(5), BlockReward: big.NewInt(2)},
+			}}},
+			head: 5,
+			wantErr: &ConfigCompatError{
+				What:         "ethash reward schedule",
+				StoredConfig: nil,
+				NewConfig:    big.NewInt(5),
+				RewindTo:     4,
+			},
+		},
+		{
+			stored:  &ChainConfig{},
+			new:     &ChainConfig{Ethash: &EthashConfig{Rewards: []*EthashReward{DefaultEthashReward}}},
+			head:    100,
+			wantErr: nil,
+		},
+		{
+			stored:  &ChainConfig{Ethash: &EthashConfig{BlockTime: 15}},
+			new:     &ChainConfig{Ethash: &EthashConfig{BlockTime: 10}},
+			head:    0,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{BlockTime: 15}},
+			new:    &ChainConfig{Ethash: &EthashConfig{BlockTime: 10}},
+			head:   1,
+			wantErr: &ConfigCompatError{
+				What:         "ethash difficulty block",
+				StoredConfig: big.NewInt(1),
+				NewConfig:    nil,
+				RewindTo:     0,
+			},
+		},
+		{
+			stored:  &ChainConfig{Ethash: &EthashConfig{BlockTime: 15, DifficultyBlock: big.NewInt(1000)}},
+			new:     &ChainConfig{Ethash: &EthashConfig{BlockTime: 20, DifficultyBlock: big.NewInt(1000)}},
+			head:    999,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{BlockTime: 15, DifficultyBlock: big.NewInt(1000)}},
+			new:    &ChainConfig{Ethash: &EthashConfig{BlockTime: 20, DifficultyBlock: big.NewInt(1000)}},
+			head:   1000,
+			wantErr: &ConfigCompatError{
+				What:         "ethash difficulty block time",
+				StoredConfig: big.NewInt(1000),
+				NewConfig:    big.NewInt(1000),
+				RewindTo:     999,
+			},
+		},
+		{
+			stored:  &ChainConfig{},
+			new:     &ChainConfig{Ethash: &EthashConfig{BlockTime: 15, DifficultyBlock: big.NewInt(500)}},
+			head:    499,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{},
+			new:    &ChainConfig{Ethash: &EthashConfig{BlockTime: 15, DifficultyBlock: big.NewInt(500)}},
+			head:   600,
+			wantErr: &ConfigCompatError{
+				What:         "ethash difficulty block",
+				StoredConfig: nil,
+				NewConfig:    big.NewInt(500),
+				RewindTo:     499,
+			},
+		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{BoundDivisor: big.NewInt(1024), DifficultyBlock: big.NewInt(800)}},
+			new:    &ChainConfig{Ethash: &EthashConfig{BoundDivisor: big.NewInt(1024), DifficultyBlock: big.NewInt(500)}},
+			head:   600,
+			wantErr: &ConfigCompatError{
+				What:         "ethash difficulty block",
+				StoredConfig: big.NewInt(800),
+				NewConfig:    big.NewInt(500),
+				RewindTo:     499,
+			},
+		},
+		{
+			stored:  &ChainConfig{},
+			new:     &ChainConfig{Ethash: &EthashConfig{BlockTime: 10, BoundDivisor: big.NewInt(2048)}},
+			head:    100,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{BoundDivisor: big.NewInt(1024)}},
+			new:    &ChainConfig{},
+			head:   100,
+			wantErr: &ConfigCompatError{
+				What:         "ethash difficulty block",
+				StoredConfig: big.NewInt(1),
+				NewConfig:    nil,
+				RewindTo:     0,
+			},
+		},
+		{
+			stored:  &ChainConfig{Ethash: &EthashConfig{Bomb: true}},
+			new:     &ChainConfig{Ethash: &EthashConfig{Bomb: true, BombDelay: big.NewInt(50000)}},
+			head:    199999,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{Bomb: true}},
+			new:    &ChainConfig{Ethash: &EthashConfig{Bomb: true, BombDelay: big.NewInt(50000)}},
+			head:   200000,
+			wantErr: &ConfigCompatError{
+				What:         "ethash difficulty bomb block",
+				StoredConfig: big.NewInt(200000),
+				NewConfig:    big.NewInt(250000),
+				RewindTo:     199999,
+			},
+		},
+		{
+			stored: &ChainConfig{Ethash: &EthashConfig{Bomb: true, BombDelay: big.NewInt(50000)}},
+			new:    &ChainConfig{Ethash: &EthashConfig{Bomb: false}},
+			head:   300000,
+			wantErr: &ConfigCompatError{
+				What:         "ethash difficulty bomb block",
+				StoredConfig: big.NewInt(250000),
+				NewConfig:    nil,
+				RewindTo:     249999,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -79,3 +234,30 @@ func TestCheckCompatible(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckRewards(t *testing.T) {
+	tests := []struct {
+		rewards []*EthashReward
+		wantErr string
+	}{
+		{rewards: nil},
+		{rewards: []*EthashReward{{Block: big.NewInt(0), BlockReward: big.NewInt(1)}, {Block: big.NewInt(10), BlockReward: big.NewInt(0)}}},
+		{rewards: []*EthashReward{nil}, wantErr: "ethash reward 0: missing entry"},
+		{rewards: []*EthashReward{{BlockReward: big.NewInt(1)}}, wantErr: "ethash reward 0: missing activation block"},
+		{rewards: []*EthashReward{{Block: big.NewInt(0)}}, wantErr: "ethash reward 0: missing block reward"},
+		{rewards: []*EthashReward{{Block: big.NewInt(0), BlockReward: big.NewInt(-1)}}, wantErr: "ethash reward 0: negative block or reward"},
+		{
+			rewards: []*EthashReward{{Block: big.NewInt(10), BlockReward: big.NewInt(1)}, {Block: big.NewInt(10), BlockReward: big.NewInt(2)}},
+			wantErr: "ethash reward 1: activation block 10 not after 10",
+		},
+	}
+	for i, test := range tests {
+		err := (&ChainConfig{Ethash: &EthashConfig{Rewards: test.rewards}}).CheckConfig()
+		switch {
+		case err == nil && test.wantErr != "":
+			t.Errorf("test %d: no error, want %q", i, test.wantErr)
+		case err != nil && err.Error() != test.wantErr:
+			t.Errorf("test %d: error %q, want %q", i, err, test.wantErr)
+		}
+	}
+}
@@ -187,12 +187,18 @@ func (self *bzz) Drop() {
 }
 
 // one cycle of the main forever loop that handles and dispatches incoming messages
-func (self *bzz) handle() error {
+func (self *bzz) handle() (err error) {
 	msg, err := self.rw.ReadMsg()
 	log.Debug(fmt.Sprintf("<- %v", msg))
 	if err != nil {
 		return err
 	}
+	// any failure past reading the message is a protocol violation by the peer
+	defer func() {
+		if err != nil {
+			self.peer.Fault(err)
+		}
+	}()
 	if msg.Size > ProtocolMaxMsgSize {
 		return fmt.Errorf("message too long: %v > %v", msg.Size, ProtocolMaxMsgSize)
 	}
@@ -33,6 +33,7 @@ import (
 	"github.com/immesys/bw2bc/node"
 	"github.com/immesys/bw2bc/p2p"
 	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/discv5"
 	"github.com/immesys/bw2bc/rpc"
 	"github.com/immesys/bw2bc/swarm/api"
 	httpapi "github.com/immesys/bw2bc/swarm/api/http"
@@ -58,8 +59,12 @@ type Swarm struct {
 	swapEnabled bool
 	lstore      *storage.LocalStore // local store, needs to store for releasing resources after node stopped
 	sfs         *fuse.SwarmFS       // need this to cleanup all the active mounts on node exit
+	bzzPeers    *p2p.ServicePeers   // bzz topic advertisement and search over discovery v5
 }
 
+// maximum number of bzz peers added from discovery v5 topic search
+const maxBzzTopicPeers = 10
+
 type SwarmAPI struct {
 	Api     *api.Api
 	Backend chequebook.Backend
@@ -196,6 +201,17 @@ func (self *Swarm) Start(srv *p2p.Server) error {
 	)
 	log.Info(fmt.Sprintf("Swarm network started on bzz address: %v", self.hive.Addr()))
 
+	// advertise and find bzz nodes by topic if discovery v5 is enabled
+	if srv.DiscV5 != nil {
+		topic := discv5.ServiceTopic("bzz", self.config.NetworkId)
+		bzzPeers, err := srv.DiscoverService(topic, true, maxBzzTopicPeers)
+		if err != nil {
+			return fmt.Errorf("Unable to advertise bzz topic: %v", err)
+		}
+		self.bzzPeers = bzzPeers
+		log.Debug(fmt.Sprintf("Swarm advertising topic %v", topic))
+	}
+
 	self.dpa.Start()
 	log.Debug(fmt.Sprintf("Swarm DPA started"))
 
@@ -219,6 +235,9 @@ func (self *Swarm) Start(srv *p2p.Server) error {
 // implements the node.Service interface
 // stops all component services.
 func (self *Swarm) Stop() error {
+	if self.bzzPeers != nil {
+		self.bzzPeers.Stop()
+	}
 	self.dpa.Stop()
 	self.hive.Stop()
 	if ch := self.config.Swap.Chequebook(); ch != nil {
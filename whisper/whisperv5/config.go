@@ -19,11 +19,13 @@ package whisperv5
 type Config struct {
 	MaxMessageSize     uint32  `toml:",omitempty"`
 	MinimumAcceptedPOW float64 `toml:",omitempty"`
+	MailServerPeers    int     `toml:",omitempty"` // mail servers to connect to via discovery v5, 0 disables the search
 }
 
 var DefaultConfig = Config{
 	MaxMessageSize:     DefaultMaxMessageSize,
 	MinimumAcceptedPOW: DefaultMinimumPoW,
+	MailServerPeers:    DefaultMailServerPeers,
 }
 
 var ()
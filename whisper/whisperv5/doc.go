@@ -59,6 +59,9 @@ const (
 	DefaultMaxMessageSize = uint32(1024 * 1024)
 	DefaultMinimumPoW     = 0.2
 
+	mailServerService      = "shh-mail" // service name of mail servers in discovery v5 topics
+	DefaultMailServerPeers = 2          // number of mail servers to connect to via discovery v5
+
 	padSizeLimit      = 256 // just an arbitrary number, could be changed without breaking the protocol (must not exceed 2^24)
 	messageQueueLimit = 1024
 
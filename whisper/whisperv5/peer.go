@@ -95,6 +95,13 @@ func (p *Peer) handshake() error {
 	return nil
 }
 
+// fault records a protocol violation against the remote peer's reputation and
+// returns the error, for use when dropping the peer.
+func (p *Peer) fault(err error) error {
+	p.peer.Fault(err)
+	return err
+}
+
 // update executes periodic operations on the peer, including message transmission
 // and expiration.
 func (p *Peer) update() {
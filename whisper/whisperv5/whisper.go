@@ -30,6 +30,8 @@ import (
 	"github.com/immesys/bw2bc/crypto"
 	"github.com/immesys/bw2bc/log"
 	"github.com/immesys/bw2bc/p2p"
+	"github.com/immesys/bw2bc/p2p/discover"
+	"github.com/immesys/bw2bc/p2p/discv5"
 	"github.com/immesys/bw2bc/rpc"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"golang.org/x/crypto/pbkdf2"
@@ -77,7 +79,9 @@ type Whisper struct {
 	statsMu sync.Mutex // guard stats
 	stats   Statistics // Statistics of whisper node
 
-	mailServer MailServer // MailServer interface
+	mailServer      MailServer        // MailServer interface
+	mailServerPeers int               // Number of mail servers to find via discovery v5
+	mailServerDisc  *p2p.ServicePeers // Mail server advertisement and search over discovery v5
 }
 
 // New creates a Whisper client ready to communicate through the Ethereum P2P network.
@@ -95,6 +99,8 @@ func New(cfg *Config) *Whisper {
 		messageQueue: make(chan *Envelope, messageQueueLimit),
 		p2pMsgQueue:  make(chan *Envelope, messageQueueLimit),
 		quit:         make(chan struct{}),
+
+		mailServerPeers: cfg.MailServerPeers,
 	}
 
 	whisper.filters = NewFilters(whisper)
@@ -156,6 +162,20 @@ func (w *Whisper) RegisterServer(server MailServer) {
 	w.mailServer = server
 }
 
+// MailServerTopic returns the discovery v5 topic mail servers advertise
+// themselves under.
+func MailServerTopic() discv5.Topic {
+	return discv5.ServiceTopic(mailServerService, uint64(ProtocolVersion))
+}
+
+// MailServers returns the mail servers found via discovery v5.
+func (w *Whisper) MailServers() []*discover.Node {
+	if w.mailServerDisc == nil {
+		return nil
+	}
+	return w.mailServerDisc.Nodes()
+}
+
 // Protocols returns the whisper sub-protocols ran by this particular client.
 func (w *Whisper) Protocols() []p2p.Protocol {
 	return []p2p.Protocol{w.protocol}
@@ -447,8 +467,18 @@ func (w *Whisper) Send(envelope *Envelope) error {
 
 // Start implements node.Service, starting the background data propagation thread
 // of the Whisper protocol.
-func (w *Whisper) Start(*p2p.Server) error {
+func (w *Whisper) Start(srv *p2p.Server) error {
 	log.Info("started whisper v." + ProtocolVersionStr)
+
+	// Mail servers advertise themselves and clients look for them if the
+	// server runs discovery v5.
+	if srv != nil && srv.DiscV5 != nil && (w.mailServer != nil || w.mailServerPeers > 0) {
+		disc, err := srv.DiscoverService(MailServerTopic(), w.mailServer != nil, w.mailServerPeers)
+		if err != nil {
+			return err
+		}
+		w.mailServerDisc = disc
+	}
 	go w.update()
 
 	numCPU := runtime.NumCPU()
@@ -462,6 +492,9 @@ func (w *Whisper) Start(*p2p.Server) error {
 // Stop implements node.Service, stopping the background data propagation thread
 // of the Whisper protocol.
 func (w *Whisper) Stop() error {
+	if w.mailServerDisc != nil {
+		w.mailServerDisc.Stop()
+	}
 	close(w.quit)
 	log.Info("whisper stopped")
 	return nil
@@ -504,7 +537,7 @@ func (wh *Whisper) runMessageLoop(p *Peer, rw p2p.MsgReadWriter) error {
 		}
 		if packet.Size > wh.MaxMessageSize() {
 			log.Warn("oversized message received", "peer", p.peer.ID())
-			return errors.New("oversized message received")
+			return p.fault(errors.New("oversized message received"))
 		}
 
 		switch packet.Code {
@@ -516,12 +549,12 @@ func (wh *Whisper) runMessageLoop(p *Peer, rw p2p.MsgReadWriter) error {
 			var envelope Envelope
 			if err := packet.Decode(&envelope); err != nil {
 				log.Warn("failed to decode envelope, peer will be disconnected", "peer", p.peer.ID(), "err", err)
-				return errors.New("invalid envelope")
+				return p.fault(errors.New("invalid envelope"))
 			}
 			cached, err := wh.add(&envelope)
 			if err != nil {
 				log.Warn("bad envelope received, peer will be disconnected", "peer", p.peer.ID(), "err", err)
-				return errors.New("invalid envelope")
+				return p.fault(errors.New("invalid envelope"))
 			}
 			if cached {
 				p.mark(&envelope)
@@ -535,7 +568,7 @@ func (wh *Whisper) runMessageLoop(p *Peer, rw p2p.MsgReadWriter) error {
 				var envelope Envelope
 				if err := packet.Decode(&envelope); err != nil {
 					log.Warn("failed to decode direct message, peer will be disconnected", "peer", p.peer.ID(), "err", err)
-					return errors.New("invalid direct message")
+					return p.fault(errors.New("invalid direct message"))
 				}
 				wh.postEvent(&envelope, true)
 			}
@@ -545,7 +578,7 @@ func (wh *Whisper) runMessageLoop(p *Peer, rw p2p.MsgReadWriter) error {
 				var request Envelope
 				if err := packet.Decode(&request); err != nil {
 					log.Warn("failed to decode p2p request message, peer will be disconnected", "peer", p.peer.ID(), "err", err)
-					return errors.New("invalid p2p request")
+					return p.fault(errors.New("invalid p2p request"))
 				}
 				wh.mailServer.DeliverMail(p, &request)
 			}